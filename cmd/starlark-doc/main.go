@@ -0,0 +1,619 @@
+// The starlark-doc command prints the documentation of Starlark modules.
+//
+// Usage:
+//
+//	starlark-doc [-json] [-predeclared name,...] module...
+//
+// Each argument is either the name of a Starlark file, or the name of
+// one of the modules json, math, proto, and time defined in Go.
+//
+// A Starlark file is parsed and resolved, but not executed, so its
+// top-level statements have no effect on its documentation. Besides
+// the Go modules and the other universal names, the names listed by
+// the -predeclared flag are predeclared in each file; any other name
+// the file uses without defining it is reported as undefined.
+//
+// The documentation of a file consists of the doc string of the file,
+// that is, a string literal that is its first statement, and the doc
+// strings and parameters of each of its public functions, in order of
+// definition. A parameter's default value is shown as written in the
+// file. Other public globals are listed as values, with their type if
+// it is evident from their syntax. A global assigned the name of a
+// function loaded by a load statement is documented as that function;
+// the file that defines it is found relative to the current directory,
+// and is not itself documented. The documentation of a parameter is
+// taken from the "Args:" section of the function's doc string, if any:
+//
+//	def greet(name, greeting = "Hello"):
+//	    """Returns a greeting.
+//
+//	    Args:
+//	      name: the name of the person to greet.
+//	      greeting: the word to greet them with.
+//	    """
+//
+// The documentation of a module defined in Go is obtained from the
+// metadata of its built-in functions; see starlark.Builtin.Doc.
+//
+// The output is in Markdown, or JSON if the -json flag is set.
+package main // import "go.starlark.net/cmd/starlark-doc"
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	starjson "go.starlark.net/lib/json"
+	"go.starlark.net/lib/math"
+	"go.starlark.net/lib/proto"
+	"go.starlark.net/lib/time"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+var (
+	jsonOutput  = flag.Bool("json", false, "print the documentation as JSON rather than Markdown")
+	predeclared = flag.String("predeclared", "", "comma-separated `names` predeclared by the host application")
+)
+
+// The file options accept all dialect features, as for starlark -fmt.
+var opts = &syntax.FileOptions{
+	Set:             true,
+	Catch:           true,
+	While:           true,
+	TopLevelControl: true,
+	GlobalReassign:  true,
+	Recursion:       true,
+	FStrings:        true,
+}
+
+// modules are the Go-defined modules, which are also predeclared in
+// each documented file.
+var modules = map[string]*starlarkstruct.Module{
+	"json":  starjson.Module,
+	"math":  math.Module,
+	"proto": proto.Module,
+	"time":  time.Module,
+}
+
+// A Module is the documentation of a Starlark file or Go module.
+type Module struct {
+	Name      string     `json:"name"`
+	Doc       string     `json:"doc,omitempty"`
+	Functions []Function `json:"functions,omitempty"`
+	Values    []Value    `json:"values,omitempty"`
+}
+
+// A Function is the documentation of a function.
+type Function struct {
+	Name      string  `json:"name"`
+	Signature string  `json:"signature"`
+	Doc       string  `json:"doc,omitempty"`
+	Params    []Param `json:"params"`
+}
+
+// A Param is the documentation of a parameter. Its name may be one of
+// the markers "/" and "*", or have a "*" or "**" prefix, as for a
+// starlark.BuiltinParam.
+type Param struct {
+	Name    string `json:"name"`
+	Default string `json:"default,omitempty"` // in Starlark notation
+	Doc     string `json:"doc,omitempty"`
+}
+
+// A Value is the documentation of a module member that is not a
+// function.
+type Value struct {
+	Name  string `json:"name"`
+	Type  string `json:"type,omitempty"`
+	Value string `json:"value,omitempty"` // in Starlark notation, if short
+}
+
+func main() {
+	log.SetPrefix("starlark-doc: ")
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: starlark-doc [flags] file.star|module...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	for name, m := range modules {
+		starlark.Universe[name] = m
+	}
+
+	names := make(map[string]bool)
+	for _, name := range strings.Split(*predeclared, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names[name] = true
+		}
+	}
+	d := newDocumenter(func(name string) bool { return names[name] })
+	docs, err := d.document(flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := write(os.Stdout, docs, *jsonOutput); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newDocumenter returns a documenter of files in which the names
+// reported by isPredeclared are predeclared, and whose loaded modules
+// are files named relative to the current directory.
+func newDocumenter(isPredeclared func(name string) bool) *documenter {
+	return &documenter{
+		isPredeclared: isPredeclared,
+		moduleFile:    func(module, from string) string { return module },
+		files:         make(map[string]*file),
+	}
+}
+
+// document returns the documentation of each module named by args,
+// which are the names of Starlark files or Go modules.
+func (d *documenter) document(args []string) ([]*Module, error) {
+	var docs []*Module
+	for _, arg := range args {
+		var (
+			doc *Module
+			err error
+		)
+		if m, ok := modules[arg]; ok {
+			doc = documentModule(m)
+		} else {
+			doc, err = d.documentFile(arg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// write writes the documentation of the modules in Markdown,
+// or in JSON if jsonOutput is set.
+func write(out io.Writer, docs []*Module, jsonOutput bool) error {
+	if jsonOutput {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "\t")
+		return enc.Encode(docs)
+	}
+	for i, doc := range docs {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		writeMarkdown(out, doc)
+	}
+	return nil
+}
+
+// A documenter extracts the documentation of Starlark files from
+// their syntax trees, without executing them.
+type documenter struct {
+	// isPredeclared reports whether a name is predeclared in each
+	// file by the host application. The Go modules and the other
+	// universal names are always predeclared.
+	isPredeclared func(name string) bool
+
+	// moduleFile returns the name of the file of the module loaded
+	// by the load statement load(module, ...) in the file from.
+	moduleFile func(module, from string) string
+
+	files map[string]*file // files documented so far; nil while in progress
+}
+
+// A file is the documentation of a Starlark file, together with that
+// of the function, if any, that each of its globals denotes,
+// whether public or not.
+type file struct {
+	doc   *Module
+	funcs map[string]*Function
+}
+
+// documentFile returns the documentation of the named Starlark file.
+func (d *documenter) documentFile(filename string) (*Module, error) {
+	f, err := d.file(filename)
+	if err != nil {
+		return nil, err
+	}
+	return f.doc, nil
+}
+
+// file parses, resolves, and documents the named Starlark file, or
+// returns its documentation from a previous call.
+func (d *documenter) file(filename string) (*file, error) {
+	if f, ok := d.files[filename]; ok {
+		if f == nil {
+			return nil, fmt.Errorf("cycle in load graph involving %s", filename)
+		}
+		return f, nil
+	}
+	d.files[filename] = nil // mark as in progress
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	syntaxFile, err := opts.Parse(filename, data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := resolve.File(syntaxFile, d.isPredeclared, starlark.Universe.Has); err != nil {
+		return nil, err
+	}
+
+	f := &file{
+		doc:   &Module{Name: filename, Doc: docString(syntaxFile.Stmts)},
+		funcs: make(map[string]*Function),
+	}
+	type loadName struct {
+		stmt  *syntax.LoadStmt
+		index int
+	}
+	loads := make(map[string]loadName) // file-local names bound by load
+
+	// Document the public globals in order of their first definition
+	// at top level, whether by def or by assignment.
+	seen := make(map[string]bool)
+	define := func(id *syntax.Ident, fn *Function, x syntax.Expr) {
+		name := id.Name
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		if fn != nil {
+			f.funcs[name] = fn
+		}
+		if strings.HasPrefix(name, "_") {
+			return
+		}
+		if fn != nil {
+			f.doc.Functions = append(f.doc.Functions, fn.rename(name))
+			return
+		}
+		val := Value{Name: name, Type: typeOf(x)}
+		if s := source(x); len(s) <= 40 {
+			val.Value = s
+		}
+		f.doc.Values = append(f.doc.Values, val)
+	}
+	for _, stmt := range syntaxFile.Stmts {
+		switch stmt := stmt.(type) {
+		case *syntax.LoadStmt:
+			for i, id := range stmt.To {
+				loads[id.Name] = loadName{stmt, i}
+			}
+		case *syntax.DefStmt:
+			fn := newFunction(stmt.Name.Name, docString(stmt.Body), params(stmt.Params))
+			define(stmt.Name, &fn, nil)
+		case *syntax.AssignStmt:
+			id, ok := stmt.LHS.(*syntax.Ident)
+			if !ok || stmt.Op != syntax.EQ {
+				continue
+			}
+			var fn *Function
+			switch rhs := stmt.RHS.(type) {
+			case *syntax.LambdaExpr:
+				lambda := newFunction(id.Name, "", params(rhs.Params))
+				fn = &lambda
+			case *syntax.Ident:
+				// A name for a function defined earlier in this file,
+				// or re-exported from a loaded module.
+				if load, ok := loads[rhs.Name]; ok {
+					module := load.stmt.Module.Value.(string)
+					loaded, err := d.file(d.moduleFile(module, filename))
+					if err != nil {
+						return nil, fmt.Errorf("%s: in load(%q): %v", load.stmt.Load, module, err)
+					}
+					fn = loaded.funcs[load.stmt.From[load.index].Name]
+				} else {
+					fn = f.funcs[rhs.Name]
+				}
+			}
+			define(id, fn, stmt.RHS)
+		}
+	}
+	d.files[filename] = f
+	return f, nil
+}
+
+// docString returns the doc string of a file or function body, that
+// is, the value of a string literal that is its first statement.
+func docString(stmts []syntax.Stmt) string {
+	if len(stmts) > 0 {
+		if expr, ok := stmts[0].(*syntax.ExprStmt); ok {
+			if lit, ok := expr.X.(*syntax.Literal); ok && lit.Token == syntax.STRING {
+				return dedent(lit.Value.(string))
+			}
+		}
+	}
+	return ""
+}
+
+// params returns the documentation, without descriptions, of the
+// parameters of a def statement or lambda expression.
+func params(list []syntax.Expr) []Param {
+	var params []Param
+	for _, param := range list {
+		switch param := param.(type) {
+		case *syntax.Ident:
+			params = append(params, Param{Name: param.Name})
+		case *syntax.BinaryExpr: // name=default
+			params = append(params, Param{
+				Name:    param.X.(*syntax.Ident).Name,
+				Default: source(param.Y),
+			})
+		case *syntax.UnaryExpr: // *, *args, or **kwargs
+			name := param.Op.String()
+			if param.X != nil {
+				name += param.X.(*syntax.Ident).Name
+			}
+			params = append(params, Param{Name: name})
+		}
+	}
+	return params
+}
+
+// source returns the source code of an expression, in canonical form.
+func source(x syntax.Expr) string {
+	out, err := syntax.Format(&syntax.File{Stmts: []syntax.Stmt{&syntax.ExprStmt{X: x}}})
+	if err != nil {
+		return "..." // unreachable for a file without syntax errors
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// typeOf returns the type of the value of an expression, or "" if it
+// is not apparent from its syntax.
+func typeOf(x syntax.Expr) string {
+	switch x := x.(type) {
+	case *syntax.Literal:
+		switch x.Token {
+		case syntax.STRING:
+			return "string"
+		case syntax.BYTES:
+			return "bytes"
+		case syntax.INT:
+			return "int"
+		case syntax.FLOAT:
+			return "float"
+		}
+	case *syntax.FStringExpr:
+		return "string"
+	case *syntax.ListExpr:
+		return "list"
+	case *syntax.DictExpr:
+		return "dict"
+	case *syntax.TupleExpr:
+		return "tuple"
+	case *syntax.Comprehension:
+		if !x.Curly {
+			return "list"
+		} else if _, ok := x.Body.(*syntax.DictEntry); ok {
+			return "dict"
+		}
+		return "set"
+	case *syntax.ParenExpr:
+		return typeOf(x.X)
+	case *syntax.Ident:
+		if b, ok := x.Binding.(*resolve.Binding); ok && b.Scope == resolve.Universal {
+			switch x.Name {
+			case "True", "False":
+				return "bool"
+			case "None":
+				return "NoneType"
+			}
+		}
+	}
+	return ""
+}
+
+// documentModule returns the documentation of a Go-defined module.
+func documentModule(m *starlarkstruct.Module) *Module {
+	doc := &Module{Name: m.Name}
+	names := make([]string, 0, len(m.Members))
+	for name := range m.Members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		doc.add(m.Name+"."+name, m.Members[name])
+	}
+	return doc
+}
+
+// add adds to the documentation of a Go-defined module that of its
+// member v, under the specified name.
+func (doc *Module) add(name string, v starlark.Value) {
+	b, ok := v.(*starlark.Builtin)
+	if !ok {
+		val := Value{Name: name, Type: v.Type()}
+		if s := v.String(); len(s) <= 40 {
+			val.Value = s
+		}
+		doc.Values = append(doc.Values, val)
+		return
+	}
+	if b.Params() == nil {
+		// undocumented
+		doc.Functions = append(doc.Functions, Function{
+			Name:      name,
+			Signature: name + "(...)",
+			Doc:       b.Doc(),
+		})
+		return
+	}
+	var params []Param
+	for _, p := range b.Params() {
+		params = append(params, Param{Name: p.Name, Default: p.Default})
+	}
+	doc.Functions = append(doc.Functions, newFunction(name, b.Doc(), params))
+}
+
+// newFunction returns the documentation of a function with the
+// specified name, doc string, and parameters, whose descriptions it
+// takes from the doc string.
+func newFunction(name, text string, params []Param) Function {
+	argDocs := paramDocs(text)
+	for i := range params {
+		params[i].Doc = argDocs[strings.TrimLeft(params[i].Name, "*")]
+	}
+	return Function{Name: name, Signature: signature(name, params), Doc: text, Params: params}
+}
+
+// rename returns the documentation of the function under another name.
+func (fn *Function) rename(name string) Function {
+	copy := *fn
+	copy.Name = name
+	copy.Signature = signature(name, fn.Params)
+	return copy
+}
+
+// signature returns the signature of a function with the specified
+// name and parameters, as for starlark.Builtin.Signature.
+func signature(name string, params []Param) string {
+	var buf strings.Builder
+	buf.WriteString(name)
+	buf.WriteByte('(')
+	for i, p := range params {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(p.Name)
+		if p.Default != "" {
+			buf.WriteByte('=')
+			buf.WriteString(p.Default)
+		}
+	}
+	buf.WriteByte(')')
+	return buf.String()
+}
+
+// paramDocs returns the documentation of each parameter described in
+// the "Args:" section of a doc string, indexed by name without any
+// "*" prefix. Each entry of the section starts with the name and a
+// colon, and may continue on more deeply indented lines.
+func paramDocs(doc string) map[string]string {
+	docs := make(map[string]string)
+	lines := strings.Split(doc, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "Args:" {
+			continue
+		}
+		var (
+			name   string
+			indent = -1
+		)
+		for _, line := range lines[i+1:] {
+			text := strings.TrimSpace(line)
+			if text == "" {
+				break // end of section
+			}
+			n := len(line) - len(strings.TrimLeft(line, " \t"))
+			if indent < 0 {
+				indent = n
+			}
+			if n < indent {
+				break // end of section
+			}
+			if n == indent {
+				before, after, ok := strings.Cut(text, ":")
+				if !ok {
+					break
+				}
+				name = strings.TrimLeft(strings.TrimSpace(before), "*")
+				docs[name] = strings.TrimSpace(after)
+			} else if name != "" {
+				docs[name] += " " + text
+			}
+		}
+		break
+	}
+	return docs
+}
+
+// dedent removes from a doc string the indentation common to its
+// lines after the first, and any leading and trailing blank lines.
+func dedent(doc string) string {
+	lines := strings.Split(doc, "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		n := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	for i := 1; i < len(lines); i++ {
+		if len(lines[i]) >= indent && indent > 0 {
+			lines[i] = lines[i][indent:]
+		} else {
+			lines[i] = strings.TrimSpace(lines[i])
+		}
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// writeMarkdown writes the documentation of a module in Markdown.
+func writeMarkdown(out io.Writer, doc *Module) {
+	fmt.Fprintf(out, "# %s\n", doc.Name)
+	if doc.Doc != "" {
+		fmt.Fprintf(out, "\n%s\n", doc.Doc)
+	}
+	if len(doc.Functions) > 0 {
+		fmt.Fprintf(out, "\n## Functions\n")
+	}
+	for _, fn := range doc.Functions {
+		fmt.Fprintf(out, "\n### %s\n\n```python\n%s\n```\n", fn.Name, fn.Signature)
+		if fn.Doc != "" {
+			fmt.Fprintf(out, "\n%s\n", fn.Doc)
+		}
+		var params []Param
+		for _, p := range fn.Params {
+			if p.Name != "/" && p.Name != "*" {
+				params = append(params, p)
+			}
+		}
+		if len(params) > 0 {
+			fmt.Fprintf(out, "\n| Parameter | Default | Description |\n|---|---|---|\n")
+			for _, p := range params {
+				dflt := "required"
+				if p.Default != "" {
+					dflt = "`" + p.Default + "`"
+				} else if strings.HasPrefix(p.Name, "*") {
+					dflt = "" // variadic
+				}
+				fmt.Fprintf(out, "| `%s` | %s | %s |\n", p.Name, dflt, cell(p.Doc))
+			}
+		}
+	}
+	if len(doc.Values) > 0 {
+		fmt.Fprintf(out, "\n## Values\n\n| Name | Type | Value |\n|---|---|---|\n")
+		for _, v := range doc.Values {
+			value := ""
+			if v.Value != "" {
+				value = "`" + v.Value + "`"
+			}
+			fmt.Fprintf(out, "| `%s` | %s | %s |\n", v.Name, v.Type, cell(value))
+		}
+	}
+}
+
+// cell escapes text for use in a cell of a Markdown table.
+func cell(text string) string {
+	return strings.ReplaceAll(text, "|", `\|`)
+}
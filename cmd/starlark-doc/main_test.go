@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// TestGolden checks the Markdown and JSON documentation of a Starlark
+// file, which loads another, and of a Go module against the golden
+// files in testdata.
+func TestGolden(t *testing.T) {
+	for name, m := range modules {
+		starlark.Universe[name] = m
+	}
+	d := newDocumenter(func(name string) bool { return name == "native" })
+	d.moduleFile = func(module, from string) string {
+		// a label such as "//dir:file.star"
+		return strings.Replace(strings.TrimPrefix(module, "//"), ":", "/", 1)
+	}
+	docs, err := d.document([]string{"testdata/greet.star", "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		golden     string
+		jsonOutput bool
+	}{
+		{"testdata/golden.md", false},
+		{"testdata/golden.json", true},
+	} {
+		var buf bytes.Buffer
+		if err := write(&buf, docs, test.jsonOutput); err != nil {
+			t.Fatal(err)
+		}
+		if *update {
+			if err := os.WriteFile(test.golden, buf.Bytes(), 0666); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(test.golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != string(want) {
+			t.Errorf("output does not match %s (run with -update to update):\n%s", test.golden, got)
+		}
+	}
+}
+
+// TestUndefined checks that a name that is neither defined nor
+// predeclared is reported, even if it is used only within a function.
+func TestUndefined(t *testing.T) {
+	d := newDocumenter(func(name string) bool { return false })
+	_, err := d.document([]string{"testdata/greet.star"})
+	if err == nil || !strings.Contains(err.Error(), "undefined: native") {
+		t.Errorf("got error %v, want undefined: native", err)
+	}
+}
@@ -0,0 +1,73 @@
+// The starlark-lsp command is a language server for Starlark files.
+// It speaks the Language Server Protocol over its standard input and
+// output, and is typically started by an editor.
+//
+// Usage:
+//
+//	starlark-lsp [-predeclared name,...] [dialect flags]
+//
+// The predeclared environment of the files consists of the json, time,
+// and math modules, and the names listed by the -predeclared flag,
+// which are otherwise reported as undefined.
+package main // import "go.starlark.net/cmd/starlark-lsp"
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"go.starlark.net/lib/json"
+	"go.starlark.net/lib/math"
+	"go.starlark.net/lib/time"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarklsp"
+	"go.starlark.net/syntax"
+)
+
+var (
+	predeclared = flag.String("predeclared", "", "comma-separated `names` predeclared by the host application")
+	fstrings    = flag.Bool("fstrings", false, "allow f-string literals such as f\"{x}\"")
+)
+
+func init() {
+	// non-standard dialect flags, as for the starlark command
+	flag.BoolVar(&resolve.AllowSet, "set", resolve.AllowSet, "allow set data type")
+	flag.BoolVar(&resolve.AllowRecursion, "recursion", resolve.AllowRecursion, "allow while statements and recursive functions")
+	flag.BoolVar(&resolve.AllowGlobalReassign, "globalreassign", resolve.AllowGlobalReassign, "allow reassignment of globals, and if/for/while statements at top level")
+}
+
+func main() {
+	log.SetPrefix("starlark-lsp: ")
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: starlark-lsp [flags]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	env := starlark.StringDict{
+		"json": json.Module,
+		"time": time.Module,
+		"math": math.Module,
+	}
+	for _, name := range strings.Split(*predeclared, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			env[name] = starlark.None
+		}
+	}
+
+	server := starlarklsp.NewServer(os.Stdin, os.Stdout)
+	server.Options = syntax.LegacyFileOptions()
+	server.Options.FStrings = *fstrings
+	server.Predeclared = env
+	if err := server.Serve(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -7,6 +7,7 @@
 package main // import "go.starlark.net/cmd/starlark"
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"log"
@@ -22,6 +23,8 @@ import (
 	"go.starlark.net/repl"
 	"go.starlark.net/resolve"
 	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkdap"
+	"go.starlark.net/syntax"
 	"golang.org/x/term"
 )
 
@@ -32,6 +35,10 @@ var (
 	profile    = flag.String("profile", "", "gather Starlark time profile in this file")
 	showenv    = flag.Bool("showenv", false, "on success, print final global environment")
 	execprog   = flag.String("c", "", "execute program `prog`")
+	format     = flag.Bool("fmt", false, "format the Starlark files in place")
+	dap        = flag.Bool("dap", false, "debug the Starlark file using the Debug Adapter Protocol over stdin and stdout")
+	optimize   = flag.Bool("O", false, "optimize bytecode: fold constants and form superinstructions")
+	fstrings   = flag.Bool("fstrings", false, "allow f-string literals such as f\"{x}\"")
 )
 
 func init() {
@@ -90,7 +97,11 @@ func doMain() int {
 		}()
 	}
 
-	thread := &starlark.Thread{Load: repl.MakeLoad()}
+	opts := syntax.LegacyFileOptions()
+	opts.Optimize = *optimize
+	opts.FStrings = *fstrings
+
+	thread := &starlark.Thread{Load: repl.MakeLoadOptions(opts)}
 	globals := make(starlark.StringDict)
 
 	// Ideally this statement would update the predeclared environment.
@@ -100,6 +111,20 @@ func doMain() int {
 	starlark.Universe["math"] = math.Module
 
 	switch {
+	case *format:
+		return formatFiles(flag.Args())
+	case *dap:
+		// Debug the specified file, or the one named by the launch request.
+		server := starlarkdap.NewServer(os.Stdin, os.Stdout)
+		server.Program = flag.Arg(0)
+		server.Options = opts
+		server.Load = repl.MakeLoadOptions(opts)
+		ok, err := server.Serve()
+		check(err)
+		if !ok {
+			return 1
+		}
+		return 0
 	case flag.NArg() == 1 || *execprog != "":
 		var (
 			filename string
@@ -115,7 +140,7 @@ func doMain() int {
 			filename = flag.Arg(0)
 		}
 		thread.Name = "exec " + filename
-		globals, err = starlark.ExecFile(thread, filename, src, nil)
+		globals, err = starlark.ExecFileOptions(opts, thread, filename, src, nil)
 		if err != nil {
 			repl.PrintError(err)
 			return 1
@@ -126,7 +151,7 @@ func doMain() int {
 			fmt.Println("Welcome to Starlark (go.starlark.net)")
 		}
 		thread.Name = "REPL"
-		repl.REPL(thread, globals)
+		repl.REPLOptions(opts, thread, globals)
 		if stdinIsTerminal {
 			fmt.Println()
 		}
@@ -147,6 +172,46 @@ func doMain() int {
 	return 0
 }
 
+// formatFiles rewrites each file in canonical format.
+func formatFiles(filenames []string) int {
+	// Accept all dialect features.
+	opts := &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true, Recursion: true, FStrings: true}
+	status := 0
+	for _, filename := range filenames {
+		info, err := os.Stat(filename)
+		if err != nil {
+			log.Print(err)
+			status = 1
+			continue
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			log.Print(err)
+			status = 1
+			continue
+		}
+		f, err := opts.Parse(filename, data, syntax.RetainComments)
+		if err != nil {
+			log.Print(err)
+			status = 1
+			continue
+		}
+		out, err := syntax.Format(f)
+		if err != nil {
+			log.Print(err)
+			status = 1
+			continue
+		}
+		if !bytes.Equal(out, data) {
+			if err := os.WriteFile(filename, out, info.Mode().Perm()); err != nil {
+				log.Print(err)
+				status = 1
+			}
+		}
+	}
+	return status
+}
+
 func check(err error) {
 	if err != nil {
 		log.Fatal(err)
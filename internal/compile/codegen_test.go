@@ -72,6 +72,84 @@ func TestPlusFolding(t *testing.T) {
 	}
 }
 
+// TestMethodCalls ensures that the compiler generates METHOD and
+// CALL_METHOD for method calls without *args or **kwargs.
+func TestMethodCalls(t *testing.T) {
+	isPredeclared := func(name string) bool { return name == "x" }
+	isUniversal := func(name string) bool { return false }
+	for i, test := range []struct {
+		src  string // source expression
+		want string // disassembled code
+	}{
+		{`x.f()`, `predeclared x; method<0>; call_method<0>; return`},
+		{`x.f(1, k=2)`, `predeclared x; method<0>; constant 1; constant "k"; constant 2; call_method<257>; return`},
+		{`(x.f)(1)`, `predeclared x; method<0>; constant 1; call_method<256>; return`},
+		{`x.f.g(x.h())`, `predeclared x; attr<1>; method<0>; predeclared x; method<1>; call_method<0>; call_method<256>; return`},
+		{`x.f(*x)`, `predeclared x; attr<1>; predeclared x; call_var<0>; return`},
+	} {
+		expr, err := syntax.ParseExpr("in.star", test.src, 0)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		locals, err := resolve.Expr(expr, isPredeclared, isUniversal)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		got := disassemble(Expr(syntax.LegacyFileOptions(), expr, "<expr>", locals).Toplevel)
+		if test.want != got {
+			t.Errorf("expression <<%s>> generated <<%s>>, want <<%s>>",
+				test.src, got, test.want)
+		}
+	}
+}
+
+// TestOptimizer ensures that the peephole optimizer folds constants,
+// simplifies jumps, and forms superinstructions.
+func TestOptimizer(t *testing.T) {
+	opts := syntax.LegacyFileOptions()
+	opts.Optimize = true
+	isPredeclared := func(name string) bool { return name == "x" }
+	isUniversal := func(name string) bool { return false }
+	for i, test := range []struct {
+		src  string // source expression
+		want string // disassembled code
+	}{
+		{`1 + 2 * 3`, `constant 7; return`},
+		{`-1`, `constant -1; return`},
+		{`not (1 < 2)`, `false; return`},
+		{`"a" < "b"`, `true; return`},
+		{`4611686018427387904 * 4`, `constant 4611686018427387904; constant 4; star; return`}, // overflow: not folded
+		{`x + 1`, `predeclared x; constant_plus<0>; return`},
+		{`x.f()`, `predeclared x; attr_call<0>; return`},
+		{`x.f(1)`, `predeclared x; method<0>; constant 1; call_method<256>; return`},
+		{`[y + z for y, z in x]`, `makelist<0>; predeclared x; iterpush; ` +
+			`iterjmp<28>; nop; nop; nop; unpack<2>; setlocal<0>; setlocal<1>; dup; local_local<65536>; plus; append; jmp<5>; nop; nop; nop; ` +
+			`iterpop; return`},
+		{`[y for y in x if not y]`, `makelist<0>; predeclared x; iterpush; ` +
+			`iterjmp<28>; nop; nop; nop; setlocal<0>; local y; cjmp<5>; nop; nop; nop; dup; local y; append; jmp<5>; nop; nop; nop; ` +
+			`iterpop; return`},
+		{`1 if 2 > 1 else 2`, `constant 1; return`},
+	} {
+		expr, err := syntax.ParseExpr("in.star", test.src, 0)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		locals, err := resolve.Expr(expr, isPredeclared, isUniversal)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		got := disassemble(Expr(opts, expr, "<expr>", locals).Toplevel)
+		if test.want != got {
+			t.Errorf("expression <<%s>> generated <<%s>>, want <<%s>>",
+				test.src, got, test.want)
+		}
+	}
+}
+
 // disassemble is a trivial disassembler tailored to the accumulator test.
 func disassemble(f *Funcode) string {
 	out := new(bytes.Buffer)
@@ -31,9 +31,11 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"go.starlark.net/resolve"
 	"go.starlark.net/syntax"
@@ -46,7 +48,7 @@ var Disassemble = false
 const debug = false // make code generation verbose, for debugging the compiler
 
 // Increment this to force recompilation of saved bytecode files.
-const Version = 14
+const Version = 19
 
 type Opcode uint8
 
@@ -112,6 +114,7 @@ const (
 	INPLACE_ADD  //            x y INPLACE_ADD  z      where z is x+y or x.extend(y)
 	INPLACE_PIPE //            x y INPLACE_PIPE z      where z is x|y
 	MAKEDICT     //              - MAKEDICT     dict
+	ENDSEGMENT   //              - ENDSEGMENT   -    [completes the active top-level segment, if any]
 
 	// --- opcodes with an argument must go below this line ---
 
@@ -120,6 +123,7 @@ const (
 	CJMP    //         cond CJMP<addr>    -
 	ITERJMP //            - ITERJMP<addr> elem   (and fall through) [acts on topmost iterator]
 	//       or:          - ITERJMP<addr> -      (and jump)
+	SEGMENT //            - SEGMENT<addr> -      (jump if memoized, else fall through) [begins a top-level segment]
 
 	CONSTANT     //                 - CONSTANT<constant>  value
 	MAKETUPLE    //         x1 ... xn MAKETUPLE<n>        tuple
@@ -137,10 +141,19 @@ const (
 	PREDECLARED  //                 - PREDECLARED<name>   value
 	UNIVERSAL    //                 - UNIVERSAL<name>     value
 	ATTR         //                 x ATTR<name>          y           y = x.name
+	METHOD       //                 x METHOD<site>        fn recv     fn = x.name, or unbound method with recv = x
 	SETFIELD     //               x y SETFIELD<name>      -           x.name = y
 	UNPACK       //          iterable UNPACK<n>           vn ... v1
+	FORMAT       //                 x FORMAT<spec>        str         str = x formatted by f-string field spec (see FormatArg)
+	CONCAT       //         s1 ... sn CONCAT<n>           str         str = s1 + ... + sn, for strings
+
+	// superinstructions, formed only by the optimizer (see syntax.FileOptions.Optimize)
+	LOCAL_LOCAL   //                 - LOCAL_LOCAL<a|b<<16> x y    x = local a, y = local b
+	CONSTANT_PLUS //                 x CONSTANT_PLUS<constant> z   z = x + constant
+	ATTR_CALL     //                 x ATTR_CALL<site>     z       z = x.name()
 
 	// n>>8 is #positional args and n&0xff is #named args (pairs).
+	CALL_METHOD // fn recv positional named           CALL_METHOD<n> result
 	CALL        // fn positional named                CALL<n>        result
 	CALL_VAR    // fn positional named *args          CALL_VAR<n>    result
 	CALL_KW     // fn positional named       **kwargs CALL_KW<n>     result
@@ -153,74 +166,83 @@ const (
 // TODO(adonovan): add dynamic checks for missing opcodes in the tables below.
 
 var opcodeNames = [...]string{
-	AMP:          "amp",
-	APPEND:       "append",
-	ATTR:         "attr",
-	CALL:         "call",
-	CALL_KW:      "call_kw ",
-	CALL_VAR:     "call_var",
-	CALL_VAR_KW:  "call_var_kw",
-	CIRCUMFLEX:   "circumflex",
-	CJMP:         "cjmp",
-	CONSTANT:     "constant",
-	DUP2:         "dup2",
-	DUP:          "dup",
-	EQL:          "eql",
-	EXCH:         "exch",
-	FALSE:        "false",
-	FREE:         "free",
-	FREECELL:     "freecell",
-	GE:           "ge",
-	GLOBAL:       "global",
-	GT:           "gt",
-	GTGT:         "gtgt",
-	IN:           "in",
-	INDEX:        "index",
-	INPLACE_ADD:  "inplace_add",
-	INPLACE_PIPE: "inplace_pipe",
-	ITERJMP:      "iterjmp",
-	ITERPOP:      "iterpop",
-	ITERPUSH:     "iterpush",
-	JMP:          "jmp",
-	LE:           "le",
-	LOAD:         "load",
-	LOCAL:        "local",
-	LOCALCELL:    "localcell",
-	LT:           "lt",
-	LTLT:         "ltlt",
-	MAKEDICT:     "makedict",
-	MAKEFUNC:     "makefunc",
-	MAKELIST:     "makelist",
-	MAKETUPLE:    "maketuple",
-	MANDATORY:    "mandatory",
-	MINUS:        "minus",
-	NEQ:          "neq",
-	NONE:         "none",
-	NOP:          "nop",
-	NOT:          "not",
-	PERCENT:      "percent",
-	PIPE:         "pipe",
-	PLUS:         "plus",
-	POP:          "pop",
-	PREDECLARED:  "predeclared",
-	RETURN:       "return",
-	SETDICT:      "setdict",
-	SETDICTUNIQ:  "setdictuniq",
-	SETFIELD:     "setfield",
-	SETGLOBAL:    "setglobal",
-	SETINDEX:     "setindex",
-	SETLOCAL:     "setlocal",
-	SETLOCALCELL: "setlocalcell",
-	SLASH:        "slash",
-	SLASHSLASH:   "slashslash",
-	SLICE:        "slice",
-	STAR:         "star",
-	TILDE:        "tilde",
-	TRUE:         "true",
-	UMINUS:       "uminus",
-	UNIVERSAL:    "universal",
-	UNPACK:       "unpack",
-	UPLUS:        "uplus",
+	AMP:           "amp",
+	APPEND:        "append",
+	ATTR:          "attr",
+	ATTR_CALL:     "attr_call",
+	CALL:          "call",
+	CALL_METHOD:   "call_method",
+	CALL_KW:       "call_kw ",
+	CALL_VAR:      "call_var",
+	CALL_VAR_KW:   "call_var_kw",
+	CIRCUMFLEX:    "circumflex",
+	CONCAT:        "concat",
+	CJMP:          "cjmp",
+	CONSTANT:      "constant",
+	CONSTANT_PLUS: "constant_plus",
+	DUP2:          "dup2",
+	DUP:           "dup",
+	ENDSEGMENT:    "endsegment",
+	EQL:           "eql",
+	EXCH:          "exch",
+	FALSE:         "false",
+	FORMAT:        "format",
+	FREE:          "free",
+	FREECELL:      "freecell",
+	GE:            "ge",
+	GLOBAL:        "global",
+	GT:            "gt",
+	GTGT:          "gtgt",
+	IN:            "in",
+	INDEX:         "index",
+	INPLACE_ADD:   "inplace_add",
+	INPLACE_PIPE:  "inplace_pipe",
+	ITERJMP:       "iterjmp",
+	ITERPOP:       "iterpop",
+	ITERPUSH:      "iterpush",
+	JMP:           "jmp",
+	LE:            "le",
+	LOAD:          "load",
+	LOCAL:         "local",
+	LOCAL_LOCAL:   "local_local",
+	LOCALCELL:     "localcell",
+	LT:            "lt",
+	LTLT:          "ltlt",
+	MAKEDICT:      "makedict",
+	MAKEFUNC:      "makefunc",
+	MAKELIST:      "makelist",
+	MAKETUPLE:     "maketuple",
+	METHOD:        "method",
+	MANDATORY:     "mandatory",
+	MINUS:         "minus",
+	NEQ:           "neq",
+	NONE:          "none",
+	NOP:           "nop",
+	NOT:           "not",
+	PERCENT:       "percent",
+	PIPE:          "pipe",
+	PLUS:          "plus",
+	POP:           "pop",
+	PREDECLARED:   "predeclared",
+	RETURN:        "return",
+	SEGMENT:       "segment",
+	SETDICT:       "setdict",
+	SETDICTUNIQ:   "setdictuniq",
+	SETFIELD:      "setfield",
+	SETGLOBAL:     "setglobal",
+	SETINDEX:      "setindex",
+	SETLOCAL:      "setlocal",
+	SETLOCALCELL:  "setlocalcell",
+	SLASH:         "slash",
+	SLASHSLASH:    "slashslash",
+	SLICE:         "slice",
+	STAR:          "star",
+	TILDE:         "tilde",
+	TRUE:          "true",
+	UMINUS:        "uminus",
+	UNIVERSAL:     "universal",
+	UNPACK:        "unpack",
+	UPLUS:         "uplus",
 }
 
 const variableStackEffect = 0x7f
@@ -228,72 +250,81 @@ const variableStackEffect = 0x7f
 // stackEffect records the effect on the size of the operand stack of
 // each kind of instruction. For some instructions this requires computation.
 var stackEffect = [...]int8{
-	AMP:          -1,
-	APPEND:       -2,
-	ATTR:         0,
-	CALL:         variableStackEffect,
-	CALL_KW:      variableStackEffect,
-	CALL_VAR:     variableStackEffect,
-	CALL_VAR_KW:  variableStackEffect,
-	CIRCUMFLEX:   -1,
-	CJMP:         -1,
-	CONSTANT:     +1,
-	DUP2:         +2,
-	DUP:          +1,
-	EQL:          -1,
-	FALSE:        +1,
-	FREE:         +1,
-	FREECELL:     +1,
-	GE:           -1,
-	GLOBAL:       +1,
-	GT:           -1,
-	GTGT:         -1,
-	IN:           -1,
-	INDEX:        -1,
-	INPLACE_ADD:  -1,
-	INPLACE_PIPE: -1,
-	ITERJMP:      variableStackEffect,
-	ITERPOP:      0,
-	ITERPUSH:     -1,
-	JMP:          0,
-	LE:           -1,
-	LOAD:         -1,
-	LOCAL:        +1,
-	LOCALCELL:    +1,
-	LT:           -1,
-	LTLT:         -1,
-	MAKEDICT:     +1,
-	MAKEFUNC:     0,
-	MAKELIST:     variableStackEffect,
-	MAKETUPLE:    variableStackEffect,
-	MANDATORY:    +1,
-	MINUS:        -1,
-	NEQ:          -1,
-	NONE:         +1,
-	NOP:          0,
-	NOT:          0,
-	PERCENT:      -1,
-	PIPE:         -1,
-	PLUS:         -1,
-	POP:          -1,
-	PREDECLARED:  +1,
-	RETURN:       -1,
-	SETLOCALCELL: -1,
-	SETDICT:      -3,
-	SETDICTUNIQ:  -3,
-	SETFIELD:     -2,
-	SETGLOBAL:    -1,
-	SETINDEX:     -3,
-	SETLOCAL:     -1,
-	SLASH:        -1,
-	SLASHSLASH:   -1,
-	SLICE:        -3,
-	STAR:         -1,
-	TRUE:         +1,
-	UMINUS:       0,
-	UNIVERSAL:    +1,
-	UNPACK:       variableStackEffect,
-	UPLUS:        0,
+	AMP:           -1,
+	APPEND:        -2,
+	ATTR:          0,
+	ATTR_CALL:     0,
+	CALL:          variableStackEffect,
+	CALL_METHOD:   variableStackEffect,
+	CALL_KW:       variableStackEffect,
+	CALL_VAR:      variableStackEffect,
+	CALL_VAR_KW:   variableStackEffect,
+	CIRCUMFLEX:    -1,
+	CONCAT:        variableStackEffect,
+	CJMP:          -1,
+	CONSTANT:      +1,
+	CONSTANT_PLUS: 0,
+	DUP2:          +2,
+	DUP:           +1,
+	ENDSEGMENT:    0,
+	EQL:           -1,
+	FALSE:         +1,
+	FORMAT:        0,
+	FREE:          +1,
+	FREECELL:      +1,
+	GE:            -1,
+	GLOBAL:        +1,
+	GT:            -1,
+	GTGT:          -1,
+	IN:            -1,
+	INDEX:         -1,
+	INPLACE_ADD:   -1,
+	INPLACE_PIPE:  -1,
+	ITERJMP:       variableStackEffect,
+	ITERPOP:       0,
+	ITERPUSH:      -1,
+	JMP:           0,
+	LE:            -1,
+	LOAD:          -1,
+	LOCAL:         +1,
+	LOCAL_LOCAL:   +2,
+	LOCALCELL:     +1,
+	LT:            -1,
+	LTLT:          -1,
+	MAKEDICT:      +1,
+	MAKEFUNC:      0,
+	MAKELIST:      variableStackEffect,
+	MAKETUPLE:     variableStackEffect,
+	METHOD:        +1,
+	MANDATORY:     +1,
+	MINUS:         -1,
+	NEQ:           -1,
+	NONE:          +1,
+	NOP:           0,
+	NOT:           0,
+	PERCENT:       -1,
+	PIPE:          -1,
+	PLUS:          -1,
+	POP:           -1,
+	PREDECLARED:   +1,
+	RETURN:        -1,
+	SEGMENT:       0,
+	SETLOCALCELL:  -1,
+	SETDICT:       -3,
+	SETDICTUNIQ:   -3,
+	SETFIELD:      -2,
+	SETGLOBAL:     -1,
+	SETINDEX:      -3,
+	SETLOCAL:      -1,
+	SLASH:         -1,
+	SLASHSLASH:    -1,
+	SLICE:         -3,
+	STAR:          -1,
+	TRUE:          +1,
+	UMINUS:        0,
+	UNIVERSAL:     +1,
+	UNPACK:        variableStackEffect,
+	UPLUS:         0,
 }
 
 func (op Opcode) String() string {
@@ -310,13 +341,14 @@ func (op Opcode) String() string {
 // Programs are serialized by the Program.Encode method,
 // which must be updated whenever this declaration is changed.
 type Program struct {
-	Loads     []Binding     // name (really, string) and position of each load stmt
-	Names     []string      // names of attributes and predeclared variables
-	Constants []interface{} // = string | int64 | float64 | *big.Int | Bytes
-	Functions []*Funcode
-	Globals   []Binding // for error messages and tracing
-	Toplevel  *Funcode  // module initialization function
-	Recursion bool      // disable recursion check for functions in this file
+	Loads      []Binding     // name (really, string) and position of each load stmt
+	Names      []string      // names of attributes and predeclared variables
+	Constants  []interface{} // = string | int64 | float64 | *big.Int | Bytes
+	Functions  []*Funcode
+	Globals    []Binding // for error messages and tracing
+	Toplevel   *Funcode  // module initialization function
+	Recursion  bool      // disable recursion check for functions in this file
+	CheckTypes bool      // check annotated parameter and result types of calls
 }
 
 // The type of a bytes literal value, to distinguish from text string.
@@ -340,6 +372,10 @@ type Funcode struct {
 	NumParams             int
 	NumKwonlyParams       int
 	HasVarargs, HasKwargs bool
+	ParamTypes            []*resolve.Type // annotated types of parameters (see resolve.Function), or nil
+	ResultType            *resolve.Type   // annotated result type, or nil
+	Segments              []Segment       // memoizable top-level statements, ordered by Pc
+	MethodSites           []MethodSite    // method call sites, indexed by METHOD and ATTR_CALL
 
 	// -- transient state --
 
@@ -347,6 +383,42 @@ type Funcode struct {
 	lnt     []pclinecol // decoded line number table
 }
 
+// A Segment describes a top-level statement that the interpreter may
+// memoize as a unit, so that re-executing a module for incremental
+// evaluation reruns only the statements whose dependencies changed.
+//
+// The statement's code is bracketed by SEGMENT and ENDSEGMENT
+// instructions. The global variable indices are informational;
+// the interpreter discovers the precise dependencies dynamically.
+type Segment struct {
+	Pc     uint32   // address of the SEGMENT instruction
+	Locals []uint32 // indices of toplevel locals read by the statement, such as load bindings
+	Defs   []uint32 // indices of globals assigned by the statement
+	Uses   []uint32 // indices of globals read by the statement
+}
+
+// SegmentAt returns the segment whose SEGMENT instruction is at pc,
+// or nil if there is none.
+func (fn *Funcode) SegmentAt(pc uint32) *Segment {
+	i := sort.Search(len(fn.Segments), func(i int) bool { return fn.Segments[i].Pc >= pc })
+	if i < len(fn.Segments) && fn.Segments[i].Pc == pc {
+		return &fn.Segments[i]
+	}
+	return nil
+}
+
+// A MethodSite is a call x.f(...) of a method f, compiled to a METHOD
+// instruction followed by CALL_METHOD. Because the operand x of a
+// given site tends to have the same type on every execution, the
+// interpreter keeps an inline cache of the method of that type.
+type MethodSite struct {
+	Name uint32 // index of the method name in Prog.Names
+
+	// -- transient state --
+
+	Cache atomic.Value // the interpreter's inline cache
+}
+
 type pclinecol struct {
 	pc        uint32
 	line, col int32
@@ -360,7 +432,8 @@ type Binding struct {
 
 // A pcomp holds the compiler state for a Program.
 type pcomp struct {
-	prog *Program // what we're building
+	prog     *Program // what we're building
+	optimize bool     // apply the peephole optimizer to each function
 
 	names     map[string]uint32
 	constants map[interface{}]uint32
@@ -375,6 +448,9 @@ type fcomp struct {
 	pos   syntax.Position // current position of generated code
 	loops []loop
 	block *block
+
+	seg      *Segment            // segment of the statement being compiled, if any
+	segments map[*block]*Segment // segments by the block that ends with their SEGMENT
 }
 
 type loop struct {
@@ -490,27 +566,36 @@ func bindings(bindings []*resolve.Binding) []Binding {
 func Expr(opts *syntax.FileOptions, expr syntax.Expr, name string, locals []*resolve.Binding) *Program {
 	pos := syntax.Start(expr)
 	stmts := []syntax.Stmt{&syntax.ReturnStmt{Result: expr}}
-	return File(opts, stmts, pos, name, locals, nil)
+	return file(opts, stmts, pos, name, locals, nil, false)
 }
 
 // File compiles the statements of a file into a program.
 // The options must be consistent with those used when parsing stmts.
+//
+// Each top-level statement other than a load is compiled as a
+// memoizable segment (see Segment).
 func File(opts *syntax.FileOptions, stmts []syntax.Stmt, pos syntax.Position, name string, locals, globals []*resolve.Binding) *Program {
+	return file(opts, stmts, pos, name, locals, globals, true)
+}
+
+func file(opts *syntax.FileOptions, stmts []syntax.Stmt, pos syntax.Position, name string, locals, globals []*resolve.Binding, segmented bool) *Program {
 	pcomp := &pcomp{
 		prog: &Program{
-			Globals:   bindings(globals),
-			Recursion: opts.Recursion,
+			Globals:    bindings(globals),
+			Recursion:  opts.Recursion,
+			CheckTypes: opts.CheckTypes,
 		},
+		optimize:  opts.Optimize,
 		names:     make(map[string]uint32),
 		constants: make(map[interface{}]uint32),
 		functions: make(map[*Funcode]uint32),
 	}
-	pcomp.prog.Toplevel = pcomp.function(name, pos, stmts, locals, nil)
+	pcomp.prog.Toplevel = pcomp.function(name, pos, stmts, locals, nil, segmented)
 
 	return pcomp.prog
 }
 
-func (pcomp *pcomp) function(name string, pos syntax.Position, stmts []syntax.Stmt, locals, freevars []*resolve.Binding) *Funcode {
+func (pcomp *pcomp) function(name string, pos syntax.Position, stmts []syntax.Stmt, locals, freevars []*resolve.Binding, segmented bool) *Funcode {
 	fcomp := &fcomp{
 		pcomp: pcomp,
 		pos:   pos,
@@ -538,12 +623,23 @@ func (pcomp *pcomp) function(name string, pos syntax.Position, stmts []syntax.St
 	// Convert AST to a CFG of instructions.
 	entry := fcomp.newBlock()
 	fcomp.block = entry
-	fcomp.stmts(stmts)
+	if segmented {
+		fcomp.segments = make(map[*block]*Segment)
+		for _, stmt := range stmts {
+			fcomp.segmentStmt(stmt)
+		}
+	} else {
+		fcomp.stmts(stmts)
+	}
 	if fcomp.block != nil {
 		fcomp.emit(NONE)
 		fcomp.emit(RETURN)
 	}
 
+	if fcomp.pcomp.optimize {
+		fcomp.optimize(entry)
+	}
+
 	var oops bool // something bad happened
 
 	setinitialstack := func(b *block, depth int) {
@@ -586,7 +682,7 @@ func (pcomp *pcomp) function(name string, pos syntax.Position, stmts []syntax.St
 				case ITERJMP:
 					isiterjmp = 1
 					fallthrough
-				case CJMP:
+				case CJMP, SEGMENT:
 					cjmpAddr = &b.insns[i].arg
 					pc += 4
 				default:
@@ -649,7 +745,7 @@ func (pcomp *pcomp) function(name string, pos syntax.Position, stmts []syntax.St
 			setinitialstack(b.cjmp, stack)
 			visit(b.cjmp)
 
-			// Patch the CJMP/ITERJMP, if present.
+			// Patch the CJMP/ITERJMP/SEGMENT, if present.
 			if cjmpAddr != nil {
 				*cjmpAddr = b.cjmp.addr
 			}
@@ -706,10 +802,10 @@ func (insn *insn) stackeffect() int {
 	if se == variableStackEffect {
 		arg := int(insn.arg)
 		switch insn.op {
-		case CALL, CALL_KW, CALL_VAR, CALL_VAR_KW:
+		case CALL, CALL_KW, CALL_VAR, CALL_VAR_KW, CALL_METHOD:
 			se = -int(2*(insn.arg&0xff) + insn.arg>>8)
 			if insn.op != CALL {
-				se--
+				se-- // *args, **kwargs, or receiver
 			}
 			if insn.op == CALL_VAR_KW {
 				se--
@@ -720,7 +816,7 @@ func (insn *insn) stackeffect() int {
 			//  0 for cjmp/true/exhausted
 			// Handled specially in caller.
 			se = 0
-		case MAKELIST, MAKETUPLE:
+		case MAKELIST, MAKETUPLE, CONCAT:
 			se = 1 - arg
 		case UNPACK:
 			se = arg - 1
@@ -791,10 +887,13 @@ func (fcomp *fcomp) generate(blocks []*block, codelen uint32) {
 			if Disassemble {
 				PrintOp(fcomp.fn, pc, insn.op, insn.arg)
 			}
+			if insn.op == SEGMENT {
+				fcomp.segments[b].Pc = pc
+			}
 			code = append(code, byte(insn.op))
 			pc++
 			if insn.op >= OpcodeArgMin {
-				if insn.op == CJMP || insn.op == ITERJMP {
+				if insn.op == CJMP || insn.op == ITERJMP || insn.op == SEGMENT {
 					code = addUint32(code, insn.arg, 4) // pad arg to 4 bytes
 				} else {
 					code = addUint32(code, insn.arg, 0)
@@ -819,6 +918,13 @@ func (fcomp *fcomp) generate(blocks []*block, codelen uint32) {
 
 	fcomp.fn.pclinetab = pclinetab
 	fcomp.fn.Code = code
+
+	for _, seg := range fcomp.segments {
+		fcomp.fn.Segments = append(fcomp.fn.Segments, *seg)
+	}
+	sort.Slice(fcomp.fn.Segments, func(i, j int) bool {
+		return fcomp.fn.Segments[i].Pc < fcomp.fn.Segments[j].Pc
+	})
 }
 
 // clip returns the value nearest x in the range [min...max],
@@ -882,16 +988,28 @@ func PrintOp(fn *Funcode, pc uint32, op Opcode, arg uint32) {
 		comment = fn.Prog.Functions[arg].Name
 	case SETLOCAL, LOCAL:
 		comment = fn.Locals[arg].Name
+	case LOCAL_LOCAL:
+		comment = fn.Locals[arg&0xffff].Name + ", " + fn.Locals[arg>>16].Name
+	case CONSTANT_PLUS:
+		comment = fmt.Sprintf("+ %v", fn.Prog.Constants[arg])
+	case FORMAT:
+		conv, spec := FormatArg(arg)
+		comment = fmt.Sprintf(":%v", fn.Prog.Constants[spec])
+		if conv != 0 {
+			comment = "!" + string(conv) + comment
+		}
 	case SETGLOBAL, GLOBAL:
 		comment = fn.Prog.Globals[arg].Name
 	case ATTR, SETFIELD, PREDECLARED, UNIVERSAL:
 		comment = fn.Prog.Names[arg]
+	case METHOD, ATTR_CALL:
+		comment = fn.Prog.Names[fn.MethodSites[arg].Name]
 	case FREE:
 		comment = fn.FreeVars[arg].Name
-	case CALL, CALL_VAR, CALL_KW, CALL_VAR_KW:
+	case CALL, CALL_VAR, CALL_KW, CALL_VAR_KW, CALL_METHOD:
 		comment = fmt.Sprintf("%d pos, %d named", arg>>8, arg&0xff)
 	default:
-		// JMP, CJMP, ITERJMP, MAKETUPLE, MAKELIST, LOAD, UNPACK:
+		// JMP, CJMP, ITERJMP, SEGMENT, MAKETUPLE, MAKELIST, LOAD, UNPACK, CONCAT:
 		// arg is just a number
 	}
 	var buf bytes.Buffer
@@ -928,6 +1046,27 @@ func (fcomp *fcomp) emit1(op Opcode, arg uint32) {
 	fcomp.block.insns = append(fcomp.block.insns, insn)
 	fcomp.pos.Line = 0
 	fcomp.pos.Col = 0
+
+	if seg := fcomp.seg; seg != nil {
+		switch op {
+		case LOCAL, LOCALCELL:
+			seg.Locals = addIndex(seg.Locals, arg)
+		case GLOBAL:
+			seg.Uses = addIndex(seg.Uses, arg)
+		case SETGLOBAL:
+			seg.Defs = addIndex(seg.Defs, arg)
+		}
+	}
+}
+
+// addIndex adds x to the set of indices represented by the slice.
+func addIndex(indices []uint32, x uint32) []uint32 {
+	for _, y := range indices {
+		if x == y {
+			return indices
+		}
+	}
+	return append(indices, x)
 }
 
 // jump emits a jump to the specified block.
@@ -940,12 +1079,13 @@ func (fcomp *fcomp) jump(b *block) {
 	fcomp.block = nil
 }
 
-// condjump emits a conditional jump (CJMP or ITERJMP)
+// condjump emits a conditional jump (CJMP, ITERJMP or SEGMENT)
 // to the specified true/false blocks.
-// (For ITERJMP, the cases are jmp/f/ok and cjmp/t/exhausted.)
+// (For ITERJMP, the cases are jmp/f/ok and cjmp/t/exhausted;
+// for SEGMENT, they are jmp/f/execute and cjmp/t/memoized.)
 // On return, the current block is unset.
 func (fcomp *fcomp) condjump(op Opcode, t, f *block) {
-	if !(op == CJMP || op == ITERJMP) {
+	if !(op == CJMP || op == ITERJMP || op == SEGMENT) {
 		panic("not a conditional jump: " + op.String())
 	}
 	fcomp.emit1(op, 0) // fill in address later
@@ -1041,6 +1181,41 @@ func (fcomp *fcomp) lookup(id *syntax.Ident) {
 	}
 }
 
+// segmentStmt compiles a top-level statement as a memoizable segment:
+//
+//	SEGMENT<done> stmt done: ENDSEGMENT
+//
+// Load statements and statements that generate no code are compiled
+// directly, since there is nothing to be gained by memoizing them.
+func (fcomp *fcomp) segmentStmt(stmt syntax.Stmt) {
+	switch stmt := stmt.(type) {
+	case *syntax.LoadStmt:
+		fcomp.stmt(stmt)
+		return
+	case *syntax.ExprStmt:
+		if _, ok := stmt.X.(*syntax.Literal); ok {
+			return // doc comment
+		}
+	case *syntax.BranchStmt:
+		return // pass
+	}
+
+	seg := new(Segment)
+	fcomp.segments[fcomp.block] = seg
+	body := fcomp.newBlock()
+	done := fcomp.newBlock()
+	fcomp.condjump(SEGMENT, done, body)
+
+	fcomp.block = body
+	fcomp.seg = seg
+	fcomp.stmt(stmt)
+	fcomp.seg = nil
+	fcomp.jump(done)
+
+	fcomp.block = done
+	fcomp.emit(ENDSEGMENT)
+}
+
 func (fcomp *fcomp) stmts(stmts []syntax.Stmt) {
 	for _, stmt := range stmts {
 		fcomp.stmt(stmt)
@@ -1307,6 +1482,9 @@ func (fcomp *fcomp) expr(e syntax.Expr) {
 		}
 		fcomp.emit1(CONSTANT, fcomp.pcomp.constantIndex(v))
 
+	case *syntax.FStringExpr:
+		fcomp.fstring(e)
+
 	case *syntax.ListExpr:
 		for _, x := range e.List {
 			fcomp.expr(x)
@@ -1542,6 +1720,45 @@ func (fcomp *fcomp) plus(e *syntax.BinaryExpr) {
 	// fcomp.emit(ACCEND)
 }
 
+// fstring emits code for an f-string: the concatenation
+// of its non-empty literal parts and its formatted fields.
+func (fcomp *fcomp) fstring(e *syntax.FStringExpr) {
+	n := 0
+	for i, lit := range e.Literals {
+		if lit != "" {
+			fcomp.emit1(CONSTANT, fcomp.pcomp.constantIndex(lit))
+			n++
+		}
+		if i < len(e.Fields) {
+			field := e.Fields[i]
+			fcomp.expr(field.X)
+			fcomp.setPos(field.Lbrace)
+			spec := fcomp.pcomp.constantIndex(field.Spec)
+			fcomp.emit1(FORMAT, spec<<2|formatConv[field.Conv])
+			n++
+		}
+	}
+	switch n {
+	case 0:
+		fcomp.emit1(CONSTANT, fcomp.pcomp.constantIndex(""))
+	case 1:
+		// A single string needs no concatenation.
+	default:
+		fcomp.emit1(CONCAT, uint32(n))
+	}
+}
+
+// formatConv encodes the conversion of an f-string field
+// in the low bits of the argument of a FORMAT instruction.
+var formatConv = map[byte]uint32{0: 0, 's': 1, 'r': 2}
+
+// FormatArg decodes the argument of a FORMAT instruction into the
+// conversion of the f-string field ('s', 'r', or 0 for none)
+// and the index of the constant holding its format specification.
+func FormatArg(arg uint32) (conv byte, spec uint32) {
+	return "\x00sr"[arg&3], arg >> 2
+}
+
 // addable reports whether e is a statically addable
 // expression: a [s]tring, [b]ytes, [l]ist, or [t]uple.
 func addable(e syntax.Expr) rune {
@@ -1648,14 +1865,19 @@ func (fcomp *fcomp) binop(pos syntax.Position, op syntax.Token) {
 }
 
 func (fcomp *fcomp) call(call *syntax.CallExpr) {
-	// TODO(adonovan): opt: Use optimized path for calling methods
-	// of built-ins: x.f(...) to avoid materializing a closure.
-	// if dot, ok := call.Fcomp.(*syntax.DotExpr); ok {
-	// 	fcomp.expr(dot.X)
-	// 	fcomp.args(call)
-	// 	fcomp.emit1(CALL_ATTR, fcomp.name(dot.Name.Name))
-	// 	return
-	// }
+	// Use the optimized path for calling methods: x.f(...)
+	// pushes an unbound method and its receiver, to avoid
+	// materializing a closure. Calls with *args or **kwargs
+	// take the usual path.
+	if dot, ok := unparen(call.Fn).(*syntax.DotExpr); ok && !hasStarArgs(call) {
+		fcomp.expr(dot.X)
+		fcomp.setPos(dot.Dot)
+		fcomp.emit1(METHOD, fcomp.methodSite(dot.Name.Name))
+		_, arg := fcomp.args(call)
+		fcomp.setPos(call.Lparen)
+		fcomp.emit1(CALL_METHOD, arg)
+		return
+	}
 
 	// usual case
 	fcomp.expr(call.Fn)
@@ -1664,6 +1886,22 @@ func (fcomp *fcomp) call(call *syntax.CallExpr) {
 	fcomp.emit1(op, arg)
 }
 
+// hasStarArgs reports whether call has a *args or **kwargs argument.
+func hasStarArgs(call *syntax.CallExpr) bool {
+	for _, arg := range call.Args {
+		if unary, ok := arg.(*syntax.UnaryExpr); ok && (unary.Op == syntax.STAR || unary.Op == syntax.STARSTAR) {
+			return true
+		}
+	}
+	return false
+}
+
+// methodSite returns the index of a new method call site for the named method.
+func (fcomp *fcomp) methodSite(name string) uint32 {
+	fcomp.fn.MethodSites = append(fcomp.fn.MethodSites, MethodSite{Name: fcomp.pcomp.nameIndex(name)})
+	return uint32(len(fcomp.fn.MethodSites) - 1)
+}
+
 // args emits code to push a tuple of positional arguments
 // and a tuple of named arguments containing alternating keys and values.
 // Either or both tuples may be empty (TODO(adonovan): optimize).
@@ -1849,7 +2087,7 @@ func (fcomp *fcomp) function(f *resolve.Function) {
 
 	fcomp.emit1(MAKETUPLE, uint32(ndefaults+len(f.FreeVars)))
 
-	funcode := fcomp.pcomp.function(f.Name, f.Pos, f.Body, f.Locals, f.FreeVars)
+	funcode := fcomp.pcomp.function(f.Name, f.Pos, f.Body, f.Locals, f.FreeVars, false)
 
 	if debug {
 		// TODO(adonovan): do compilations sequentially not as a tree,
@@ -1868,6 +2106,8 @@ func (fcomp *fcomp) function(f *resolve.Function) {
 	funcode.NumKwonlyParams = f.NumKwonlyParams
 	funcode.HasVarargs = f.HasVarargs
 	funcode.HasKwargs = f.HasKwargs
+	funcode.ParamTypes = f.ParamTypes
+	funcode.ResultType = f.ResultType
 	fcomp.emit1(MAKEFUNC, fcomp.pcomp.functionIndex(funcode))
 }
 
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
 )
 
 // TestSerialization verifies that a serialized program can be loaded,
@@ -62,6 +63,57 @@ Error: unknown binary op: string * NoneType`
 	}
 }
 
+// TestSerializationTypes verifies that the annotated types of
+// functions survive serialization.
+func TestSerializationTypes(t *testing.T) {
+	const src = `
+def f(x: list[str] | None, *args: int, **kwargs) -> dict[str, int]:
+    return {}
+
+def g(x, y: int):
+    pass
+`
+	opts := &syntax.FileOptions{CheckTypes: true}
+	_, oldProg, err := starlark.SourceProgramOptions(opts, "types.star", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := oldProg.Write(buf); err != nil {
+		t.Fatalf("oldProg.WriteTo: %v", err)
+	}
+	newProg, err := starlark.CompiledProgram(buf)
+	if err != nil {
+		t.Fatalf("CompiledProgram: %v", err)
+	}
+
+	thread := new(starlark.Thread)
+	globals, err := newProg.Init(thread, nil)
+	if err != nil {
+		t.Fatalf("newProg.Init: %v", err)
+	}
+	for _, test := range []struct {
+		fn   string
+		args starlark.Tuple
+		want string
+	}{
+		{"f", starlark.Tuple{starlark.None, starlark.MakeInt(1)}, ""},
+		{"f", starlark.Tuple{starlark.None, starlark.String("a")}, "function f: for parameter args: got string element, want int"},
+		{"f", starlark.Tuple{starlark.MakeInt(1)}, "function f: for parameter x: got int, want list[string] | NoneType"},
+		{"g", starlark.Tuple{starlark.None, starlark.MakeInt(1)}, ""},
+		{"g", starlark.Tuple{starlark.None, starlark.None}, "function g: for parameter y: got NoneType, want int"},
+	} {
+		_, err := starlark.Call(thread, globals[test.fn], test.args, nil)
+		got := ""
+		if err != nil {
+			got = err.(*starlark.EvalError).Msg
+		}
+		if got != test.want {
+			t.Errorf("%s%v: got error %q, want %q", test.fn, test.args, got, test.want)
+		}
+	}
+}
+
 func TestGarbage(t *testing.T) {
 	const garbage = "This is not a compiled Starlark program."
 	_, err := starlark.CompiledProgram(strings.NewReader(garbage))
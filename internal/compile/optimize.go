@@ -0,0 +1,283 @@
+package compile
+
+// This file defines the optional peephole optimizer.
+//
+// The optimizer rewrites the instructions of each basic block of the
+// control-flow graph before it is linearized, so jump targets need no
+// adjustment and the line number table remains consistent.
+// It performs three kinds of transformation:
+//
+//   - constant folding of operations on constants whose results
+//     are exactly those the interpreter would compute, and that
+//     cannot fail (for example, int64 arithmetic without overflow);
+//   - simplification of conditional jumps whose condition is a
+//     constant or a negation, which makes dead blocks unreachable;
+//   - fusion of common instruction sequences into superinstructions
+//     (LOCAL_LOCAL, CONSTANT_PLUS, ATTR_CALL), saving the cost of
+//     decoding and dispatching the second instruction.
+
+import (
+	"math"
+	"strings"
+)
+
+// The optimizer is enabled for a file by its Optimize option. It is
+// disabled by default: its superinstructions have not been shown to
+// speed up the benchmarks beyond their run-to-run variance.
+
+// optimize applies the peephole optimizer to every block reachable from entry.
+func (fcomp *fcomp) optimize(entry *block) {
+	seen := make(map[*block]bool)
+	var visit func(b *block)
+	visit = func(b *block) {
+		if b == nil || seen[b] {
+			return
+		}
+		seen[b] = true
+		for fcomp.peephole(b) {
+		}
+		visit(b.jmp)
+		visit(b.cjmp)
+	}
+	visit(entry)
+}
+
+// peephole applies one rewrite to block b, and reports whether it did so.
+func (fcomp *fcomp) peephole(b *block) bool {
+	insns := b.insns
+	n := len(insns)
+
+	// Simplify the conditional jump that ends the block.
+	if n >= 1 && insns[n-1].op == CJMP && b.cjmp != nil {
+		if b.jmp == b.cjmp {
+			// Both successors are the same: discard the condition.
+			insns[n-1] = insn{op: POP, line: insns[n-1].line, col: insns[n-1].col}
+			b.cjmp = nil
+			return true
+		}
+		if n >= 2 {
+			switch insns[n-2].op {
+			case TRUE:
+				b.insns = insns[:n-2]
+				b.jmp, b.cjmp = b.cjmp, nil
+				return true
+			case FALSE, NONE:
+				b.insns = insns[:n-2]
+				b.cjmp = nil
+				return true
+			case NOT:
+				insns[n-2] = insns[n-1]
+				b.insns = insns[:n-1]
+				b.jmp, b.cjmp = b.cjmp, b.jmp
+				return true
+			}
+		}
+	}
+
+	for i := 0; i+1 < n; i++ {
+		x, y := insns[i], insns[i+1]
+
+		// Fold a unary operation on a constant.
+		if folded, ok := fcomp.foldUnary(x, y); ok {
+			b.replace(i, 2, folded)
+			return true
+		}
+
+		// Fold a binary operation on two constants.
+		if i+2 < n {
+			if folded, ok := fcomp.foldBinary(x, y, insns[i+2]); ok {
+				b.replace(i, 3, folded)
+				return true
+			}
+		}
+
+		// Form superinstructions.
+		switch {
+		case x.op == LOCAL && y.op == LOCAL && x.arg < 1<<16 && y.arg < 1<<16:
+			b.replace(i, 2, fuse(LOCAL_LOCAL, x.arg|y.arg<<16, x, y))
+			return true
+		case x.op == CONSTANT && y.op == PLUS:
+			b.replace(i, 2, fuse(CONSTANT_PLUS, x.arg, x, y))
+			return true
+		case x.op == METHOD && y.op == CALL_METHOD && y.arg == 0:
+			b.replace(i, 2, fuse(ATTR_CALL, x.arg, x, y))
+			return true
+		}
+	}
+	return false
+}
+
+// replace replaces the n instructions of b starting at i by insn.
+func (b *block) replace(i, n int, insn insn) {
+	b.insns[i] = insn
+	b.insns = append(b.insns[:i+1], b.insns[i+n:]...)
+}
+
+// fuse returns an instruction that replaces the sequence x y.
+// It takes the position of y, the operation that may fail,
+// unless y has none.
+func fuse(op Opcode, arg uint32, x, y insn) insn {
+	pos := y
+	if pos.line == 0 {
+		pos = x
+	}
+	return insn{op: op, arg: arg, line: pos.line, col: pos.col}
+}
+
+// foldUnary folds the sequence "x op" where x pushes a constant.
+func (fcomp *fcomp) foldUnary(x, op insn) (insn, bool) {
+	if op.op == NOT {
+		switch x.op {
+		case TRUE:
+			return fuse(FALSE, 0, x, op), true
+		case FALSE, NONE:
+			return fuse(TRUE, 0, x, op), true
+		}
+		return insn{}, false
+	}
+	if x.op != CONSTANT {
+		return insn{}, false
+	}
+	var v interface{}
+	switch c := fcomp.pcomp.prog.Constants[x.arg].(type) {
+	case int64:
+		switch op.op {
+		case UPLUS:
+			v = c
+		case UMINUS:
+			if c == math.MinInt64 {
+				return insn{}, false // overflow
+			}
+			v = -c
+		case TILDE:
+			v = ^c
+		}
+	case float64:
+		// The constant pool can't distinguish -0.0 from +0.0.
+		if c == 0 {
+			return insn{}, false
+		}
+		switch op.op {
+		case UPLUS:
+			v = c
+		case UMINUS:
+			v = -c
+		}
+	}
+	if v == nil {
+		return insn{}, false
+	}
+	return fuse(CONSTANT, fcomp.pcomp.constantIndex(v), x, op), true
+}
+
+// foldBinary folds the sequence "x y op" where x and y push constants.
+func (fcomp *fcomp) foldBinary(x, y, op insn) (insn, bool) {
+	if x.op != CONSTANT || y.op != CONSTANT {
+		return insn{}, false
+	}
+	constants := fcomp.pcomp.prog.Constants
+	var v interface{}
+	switch a := constants[x.arg].(type) {
+	case int64:
+		b, ok := constants[y.arg].(int64)
+		if !ok {
+			return insn{}, false
+		}
+		switch op.op {
+		case PLUS:
+			if r := a + b; (r > a) == (b > 0) {
+				v = r
+			}
+		case MINUS:
+			if r := a - b; (r < a) == (b > 0) {
+				v = r
+			}
+		case STAR:
+			if a == 0 || b == 0 {
+				v = int64(0)
+			} else if r := a * b; r/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64) {
+				v = r
+			}
+		case AMP:
+			v = a & b
+		case PIPE:
+			v = a | b
+		case CIRCUMFLEX:
+			v = a ^ b
+		default:
+			v = compareOp(op.op, compareInts(a, b))
+		}
+	case float64:
+		b, ok := constants[y.arg].(float64)
+		if !ok {
+			return insn{}, false
+		}
+		var r float64
+		switch op.op {
+		case PLUS:
+			r = a + b
+		case MINUS:
+			r = a - b
+		case STAR:
+			r = a * b
+		default:
+			return insn{}, false
+		}
+		// The constant pool can't distinguish -0.0 from +0.0.
+		if r != 0 {
+			v = r
+		}
+	case string:
+		b, ok := constants[y.arg].(string)
+		if !ok {
+			return insn{}, false
+		}
+		if op.op == PLUS {
+			v = a + b
+		} else {
+			v = compareOp(op.op, strings.Compare(a, b))
+		}
+	}
+	switch v := v.(type) {
+	case nil:
+		return insn{}, false
+	case bool:
+		if v {
+			return fuse(TRUE, 0, x, op), true
+		}
+		return fuse(FALSE, 0, x, op), true
+	default:
+		return fuse(CONSTANT, fcomp.pcomp.constantIndex(v), x, op), true
+	}
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return +1
+	}
+	return 0
+}
+
+// compareOp returns the result of the comparison op given the
+// three-way comparison cmp of its operands, or nil if op is not a
+// comparison.
+func compareOp(op Opcode, cmp int) interface{} {
+	switch op {
+	case EQL:
+		return cmp == 0
+	case NEQ:
+		return cmp != 0
+	case LT:
+		return cmp < 0
+	case LE:
+		return cmp <= 0
+	case GT:
+		return cmp > 0
+	case GE:
+		return cmp >= 0
+	}
+	return nil
+}
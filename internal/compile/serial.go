@@ -26,6 +26,7 @@ package compile
 //	numfuncs	varint
 //	funcs		[]Funcode
 //	recursion	varint (0 or 1)
+//	checktypes	varint (0 or 1)
 //	<strings>	[]byte		# concatenation of all referenced strings
 //	EOF
 //
@@ -45,6 +46,27 @@ package compile
 //	numkwonlyparams	varint
 //	hasvarargs	varint (0 or 1)
 //	haskwargs	varint (0 or 1)
+//	numparamtypes	varint
+//	paramtypes	[]Type
+//	resulttype	Type
+//	numsegments	varint
+//	segments	[]Segment
+//	nummethods	varint
+//	methods		[]varint	# name index of each MethodSite
+//
+// Segment:
+//	pc		varint
+//	numlocals	varint
+//	locals		[]varint
+//	numdefs		varint
+//	defs		[]varint
+//	numuses		varint
+//	uses		[]varint
+//
+// Type:				# a nil Type has an empty name
+//	name		string
+//	numargs		varint
+//	args		[]Type
 //
 // Ident:
 //	filename	string
@@ -88,6 +110,7 @@ import (
 	debugpkg "runtime/debug"
 	"unsafe"
 
+	"go.starlark.net/resolve"
 	"go.starlark.net/syntax"
 )
 
@@ -132,6 +155,7 @@ func (prog *Program) Encode() []byte {
 		e.function(fn)
 	}
 	e.int(b2i(prog.Recursion))
+	e.int(b2i(prog.CheckTypes))
 
 	// Patch in the offset of the string data section.
 	binary.LittleEndian.PutUint32(e.p[4:8], uint32(len(e.p)))
@@ -201,6 +225,41 @@ func (e *encoder) function(fn *Funcode) {
 	e.int(fn.NumKwonlyParams)
 	e.int(b2i(fn.HasVarargs))
 	e.int(b2i(fn.HasKwargs))
+	e.int(len(fn.ParamTypes))
+	for _, t := range fn.ParamTypes {
+		e.typ(t)
+	}
+	e.typ(fn.ResultType)
+	e.int(len(fn.Segments))
+	for _, seg := range fn.Segments {
+		e.int(int(seg.Pc))
+		e.uint32s(seg.Locals)
+		e.uint32s(seg.Defs)
+		e.uint32s(seg.Uses)
+	}
+	e.int(len(fn.MethodSites))
+	for i := range fn.MethodSites {
+		e.int(int(fn.MethodSites[i].Name))
+	}
+}
+
+func (e *encoder) typ(t *resolve.Type) {
+	if t == nil {
+		e.string("")
+		return
+	}
+	e.string(t.Name)
+	e.int(len(t.Args))
+	for _, arg := range t.Args {
+		e.typ(arg)
+	}
+}
+
+func (e *encoder) uint32s(xs []uint32) {
+	e.int(len(xs))
+	for _, x := range xs {
+		e.int(int(x))
+	}
 }
 
 func b2i(b bool) int {
@@ -273,15 +332,17 @@ func DecodeProgram(data []byte) (_ *Program, err error) {
 		funcs[i] = d.function()
 	}
 	recursion := d.int() != 0
+	checkTypes := d.int() != 0
 
 	prog := &Program{
-		Loads:     loads,
-		Names:     names,
-		Constants: constants,
-		Globals:   globals,
-		Functions: funcs,
-		Toplevel:  toplevel,
-		Recursion: recursion,
+		Loads:      loads,
+		Names:      names,
+		Constants:  constants,
+		Globals:    globals,
+		Functions:  funcs,
+		Toplevel:   toplevel,
+		Recursion:  recursion,
+		CheckTypes: checkTypes,
 	}
 	toplevel.Prog = prog
 	for _, f := range funcs {
@@ -362,6 +423,33 @@ func (d *decoder) ints() []int {
 	return ints
 }
 
+func (d *decoder) uint32s() []uint32 {
+	n := d.int()
+	if n == 0 {
+		return nil
+	}
+	xs := make([]uint32, n)
+	for i := range xs {
+		xs[i] = uint32(d.int())
+	}
+	return xs
+}
+
+func (d *decoder) typ() *resolve.Type {
+	name := d.string()
+	if name == "" {
+		return nil
+	}
+	t := &resolve.Type{Name: name}
+	if n := d.int(); n > 0 {
+		t.Args = make([]*resolve.Type, n)
+		for i := range t.Args {
+			t.Args[i] = d.typ()
+		}
+	}
+	return t
+}
+
 func (d *decoder) bool() bool { return d.int() != 0 }
 
 func (d *decoder) function() *Funcode {
@@ -380,6 +468,31 @@ func (d *decoder) function() *Funcode {
 	numKwonlyParams := d.int()
 	hasVarargs := d.int() != 0
 	hasKwargs := d.int() != 0
+	var paramTypes []*resolve.Type
+	if n := d.int(); n > 0 {
+		paramTypes = make([]*resolve.Type, n)
+		for i := range paramTypes {
+			paramTypes[i] = d.typ()
+		}
+	}
+	resultType := d.typ()
+	var segments []Segment
+	if n := d.int(); n > 0 {
+		segments = make([]Segment, n)
+		for i := range segments {
+			segments[i].Pc = uint32(d.int())
+			segments[i].Locals = d.uint32s()
+			segments[i].Defs = d.uint32s()
+			segments[i].Uses = d.uint32s()
+		}
+	}
+	var methodSites []MethodSite
+	if n := d.int(); n > 0 {
+		methodSites = make([]MethodSite, n)
+		for i := range methodSites {
+			methodSites[i].Name = uint32(d.int())
+		}
+	}
 	return &Funcode{
 		// Prog is filled in later.
 		Pos:             id.Pos,
@@ -395,5 +508,9 @@ func (d *decoder) function() *Funcode {
 		NumKwonlyParams: numKwonlyParams,
 		HasVarargs:      hasVarargs,
 		HasKwargs:       hasKwargs,
+		ParamTypes:      paramTypes,
+		ResultType:      resultType,
+		Segments:        segments,
+		MethodSites:     methodSites,
 	}
 }
@@ -76,9 +76,12 @@ import (
 var Module = &starlarkstruct.Module{
 	Name: "json",
 	Members: starlark.StringDict{
-		"encode": starlark.NewBuiltin("json.encode", encode),
-		"decode": starlark.NewBuiltin("json.decode", decode),
-		"indent": starlark.NewBuiltin("json.indent", indent),
+		"encode": starlark.NewBuiltin("json.encode", encode).WithDoc("encode(x, /)",
+			"encode returns the JSON encoding of x."),
+		"decode": starlark.NewBuiltin("json.decode", decode).WithDoc("decode(x, /, default=...)",
+			"decode returns the Starlark value denoted by the JSON string x, or default if x is not valid JSON and default is specified."),
+		"indent": starlark.NewBuiltin("json.indent", indent).WithDoc(`indent(str, /, *, prefix="", indent="\t")`,
+			"indent returns the indented form of the valid JSON encoding str, with each new line starting with prefix, and each level of nesting indented by indent."),
 	},
 }
 
@@ -113,7 +116,7 @@ func encode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 		// cases did not show significant improvement on the benchmarks.
 		if ptr := pointer(x); ptr != nil {
 			if pathContains(path, ptr) {
-				return fmt.Errorf("cycle in JSON structure")
+				return starlark.Errorf(starlark.ValueError, "cycle in JSON structure")
 			}
 
 			path = append(path, ptr)
@@ -145,7 +148,7 @@ func encode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 
 		case starlark.Float:
 			if !isFinite(float64(x)) {
-				return fmt.Errorf("cannot encode non-finite float %v", x)
+				return starlark.Errorf(starlark.ValueError, "cannot encode non-finite float %v", x)
 			}
 			// Float.String always contains a decimal point. (%g does not!)
 			buf.WriteString(x.String())
@@ -159,7 +162,7 @@ func encode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 			items := x.Items()
 			for _, item := range items {
 				if _, ok := item[0].(starlark.String); !ok {
-					return fmt.Errorf("%s has %s key, want string", x.Type(), item[0].Type())
+					return starlark.Errorf(starlark.TypeError, "%s has %s key, want string", x.Type(), item[0].Type())
 				}
 			}
 			sort.Slice(items, func(i, j int) bool {
@@ -173,7 +176,7 @@ func encode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 				quote(k)
 				buf.WriteByte(':')
 				if err := emit(item[1]); err != nil {
-					return fmt.Errorf("in %s key %s: %v", x.Type(), item[0], err)
+					return fmt.Errorf("in %s key %s: %w", x.Type(), item[0], err)
 				}
 			}
 			buf.WriteByte('}')
@@ -189,7 +192,7 @@ func encode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 					buf.WriteByte(',')
 				}
 				if err := emit(elem); err != nil {
-					return fmt.Errorf("at %s index %d: %v", x.Type(), i, err)
+					return fmt.Errorf("at %s index %d: %w", x.Type(), i, err)
 				}
 			}
 			buf.WriteByte(']')
@@ -208,7 +211,7 @@ func encode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 				if v == nil {
 					// x.AttrNames() returned name, but x.Attr(name) returned nil, stating
 					// that the field doesn't exist.
-					return fmt.Errorf("missing attribute %s.%s (despite %q appearing in dir()", x.Type(), name, name)
+					return starlark.Errorf(starlark.AttributeError, "missing attribute %s.%s (despite %q appearing in dir()", x.Type(), name, name)
 				}
 				if i > 0 {
 					buf.WriteByte(',')
@@ -216,19 +219,19 @@ func encode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 				quote(name)
 				buf.WriteByte(':')
 				if err := emit(v); err != nil {
-					return fmt.Errorf("in field .%s: %v", name, err)
+					return fmt.Errorf("in field .%s: %w", name, err)
 				}
 			}
 			buf.WriteByte('}')
 
 		default:
-			return fmt.Errorf("cannot encode %s as JSON", x.Type())
+			return starlark.Errorf(starlark.TypeError, "cannot encode %s as JSON", x.Type())
 		}
 		return nil
 	}
 
 	if err := emit(x); err != nil {
-		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
 	}
 	return starlark.String(buf.String()), nil
 }
@@ -286,7 +289,7 @@ func indent(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 
 	buf := new(bytes.Buffer)
 	if err := json.Indent(buf, []byte(str), prefix, indent); err != nil {
-		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+		return nil, starlark.Errorf(starlark.ValueError, "%s: %v", b.Name(), err)
 	}
 	return starlark.String(buf.String()), nil
 }
@@ -300,7 +303,7 @@ func decode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 	if len(args) < 1 {
 		// "x" parameter is positional only; UnpackArgs does not allow us to
 		// directly express "def decode(x, *, default)"
-		return nil, fmt.Errorf("%s: unexpected keyword argument x", b.Name())
+		return nil, starlark.Errorf(starlark.TypeError, "%s: unexpected keyword argument x", b.Name())
 	}
 
 	// The decoder necessarily makes certain representation choices
@@ -319,6 +322,16 @@ func decode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 		panic(failure(fmt.Sprintf(format, args...)))
 	}
 
+	// alloc records an allocation of n bytes with the thread.
+	// Exceeding the thread's allocation limit is not a failure
+	// to decode, so it is reported through a different type.
+	type allocFailure struct{ err error }
+	alloc := func(n int) {
+		if err := thread.AddAllocs(int64(n)); err != nil {
+			panic(allocFailure{err})
+		}
+	}
+
 	i := 0
 
 	// skipSpace consumes leading spaces, and reports whether there is more input.
@@ -384,6 +397,7 @@ func decode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 			} else if err := json.Unmarshal([]byte(r), &r); err != nil {
 				fail("%s", err)
 			}
+			alloc(len(r))
 			return starlark.String(r)
 
 		case 'n':
@@ -413,6 +427,7 @@ func decode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 			if b != ']' {
 				for {
 					elem := parse()
+					alloc(valueSize)
 					elems = append(elems, elem)
 					b = next()
 					if b != ',' {
@@ -445,6 +460,7 @@ func decode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 					}
 					i++ // ':'
 					value := parse()
+					alloc(entrySize)
 					values = append(values, [2]starlark.Value{key, value})
 					b = next()
 					if b != ',' {
@@ -518,8 +534,10 @@ func decode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 			if d != nil {
 				v = d
 			} else {
-				err = fmt.Errorf("json.decode: at offset %d, %s", i, x)
+				err = starlark.Errorf(starlark.ValueError, "json.decode: at offset %d, %s", i, x)
 			}
+		case allocFailure:
+			err = fmt.Errorf("json.decode: %w", x.err)
 		case nil:
 			// nop
 		default:
@@ -533,6 +551,13 @@ func decode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, k
 	return v, nil
 }
 
+// Approximate sizes of an element of a list and an entry of a dict,
+// in bytes, for thread.AddAllocs.
+const (
+	valueSize = 16
+	entrySize = 64
+)
+
 func isdigit(b byte) bool {
 	return b >= '0' && b <= '9'
 }
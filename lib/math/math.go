@@ -6,8 +6,6 @@
 package math // import "go.starlark.net/lib/math"
 
 import (
-	"errors"
-	"fmt"
 	"math"
 
 	"go.starlark.net/starlark"
@@ -66,40 +64,40 @@ import (
 var Module = &starlarkstruct.Module{
 	Name: "math",
 	Members: starlark.StringDict{
-		"ceil":      starlark.NewBuiltin("ceil", ceil),
-		"copysign":  newBinaryBuiltin("copysign", math.Copysign),
-		"fabs":      newUnaryBuiltin("fabs", math.Abs),
-		"floor":     starlark.NewBuiltin("floor", floor),
-		"mod":       newBinaryBuiltin("mod", math.Mod),
-		"pow":       newBinaryBuiltin("pow", math.Pow),
-		"remainder": newBinaryBuiltin("remainder", math.Remainder),
-		"round":     newUnaryBuiltin("round", math.Round),
-
-		"exp":  newUnaryBuiltin("exp", math.Exp),
-		"sqrt": newUnaryBuiltin("sqrt", math.Sqrt),
-
-		"acos":  newUnaryBuiltin("acos", math.Acos),
-		"asin":  newUnaryBuiltin("asin", math.Asin),
-		"atan":  newUnaryBuiltin("atan", math.Atan),
-		"atan2": newBinaryBuiltin("atan2", math.Atan2),
-		"cos":   newUnaryBuiltin("cos", math.Cos),
-		"hypot": newBinaryBuiltin("hypot", math.Hypot),
-		"sin":   newUnaryBuiltin("sin", math.Sin),
-		"tan":   newUnaryBuiltin("tan", math.Tan),
-
-		"degrees": newUnaryBuiltin("degrees", degrees),
-		"radians": newUnaryBuiltin("radians", radians),
-
-		"acosh": newUnaryBuiltin("acosh", math.Acosh),
-		"asinh": newUnaryBuiltin("asinh", math.Asinh),
-		"atanh": newUnaryBuiltin("atanh", math.Atanh),
-		"cosh":  newUnaryBuiltin("cosh", math.Cosh),
-		"sinh":  newUnaryBuiltin("sinh", math.Sinh),
-		"tanh":  newUnaryBuiltin("tanh", math.Tanh),
-
-		"log": starlark.NewBuiltin("log", log),
-
-		"gamma": newUnaryBuiltin("gamma", math.Gamma),
+		"ceil":      starlark.NewBuiltin("ceil", ceil).WithDoc("ceil(x, /)", "ceil returns the ceiling of x, the smallest integer greater than or equal to x."),
+		"copysign":  newBinaryBuiltin("copysign", math.Copysign).WithDoc("copysign(x, y, /)", "copysign returns a value with the magnitude of x and the sign of y."),
+		"fabs":      newUnaryBuiltin("fabs", math.Abs).WithDoc("fabs(x, /)", "fabs returns the absolute value of x as float."),
+		"floor":     starlark.NewBuiltin("floor", floor).WithDoc("floor(x, /)", "floor returns the floor of x, the largest integer less than or equal to x."),
+		"mod":       newBinaryBuiltin("mod", math.Mod).WithDoc("mod(x, y, /)", "mod returns the floating-point remainder of x/y. The magnitude of the result is less than y and its sign agrees with that of x."),
+		"pow":       newBinaryBuiltin("pow", math.Pow).WithDoc("pow(x, y, /)", "pow returns x**y, the base-x exponential of y."),
+		"remainder": newBinaryBuiltin("remainder", math.Remainder).WithDoc("remainder(x, y, /)", "remainder returns the IEEE 754 floating-point remainder of x/y."),
+		"round":     newUnaryBuiltin("round", math.Round).WithDoc("round(x, /)", "round returns the nearest integer, rounding half away from zero."),
+
+		"exp":  newUnaryBuiltin("exp", math.Exp).WithDoc("exp(x, /)", "exp returns e raised to the power x, where e = 2.718281… is the base of natural logarithms."),
+		"sqrt": newUnaryBuiltin("sqrt", math.Sqrt).WithDoc("sqrt(x, /)", "sqrt returns the square root of x."),
+
+		"acos":  newUnaryBuiltin("acos", math.Acos).WithDoc("acos(x, /)", "acos returns the arc cosine of x, in radians."),
+		"asin":  newUnaryBuiltin("asin", math.Asin).WithDoc("asin(x, /)", "asin returns the arc sine of x, in radians."),
+		"atan":  newUnaryBuiltin("atan", math.Atan).WithDoc("atan(x, /)", "atan returns the arc tangent of x, in radians."),
+		"atan2": newBinaryBuiltin("atan2", math.Atan2).WithDoc("atan2(y, x, /)", "atan2 returns atan(y / x), in radians. The result is between -pi and pi."),
+		"cos":   newUnaryBuiltin("cos", math.Cos).WithDoc("cos(x, /)", "cos returns the cosine of x, in radians."),
+		"hypot": newBinaryBuiltin("hypot", math.Hypot).WithDoc("hypot(x, y, /)", "hypot returns the Euclidean norm, sqrt(x*x + y*y). This is the length of the vector from the origin to point (x, y)."),
+		"sin":   newUnaryBuiltin("sin", math.Sin).WithDoc("sin(x, /)", "sin returns the sine of x, in radians."),
+		"tan":   newUnaryBuiltin("tan", math.Tan).WithDoc("tan(x, /)", "tan returns the tangent of x, in radians."),
+
+		"degrees": newUnaryBuiltin("degrees", degrees).WithDoc("degrees(x, /)", "degrees converts angle x from radians to degrees."),
+		"radians": newUnaryBuiltin("radians", radians).WithDoc("radians(x, /)", "radians converts angle x from degrees to radians."),
+
+		"acosh": newUnaryBuiltin("acosh", math.Acosh).WithDoc("acosh(x, /)", "acosh returns the inverse hyperbolic cosine of x."),
+		"asinh": newUnaryBuiltin("asinh", math.Asinh).WithDoc("asinh(x, /)", "asinh returns the inverse hyperbolic sine of x."),
+		"atanh": newUnaryBuiltin("atanh", math.Atanh).WithDoc("atanh(x, /)", "atanh returns the inverse hyperbolic tangent of x."),
+		"cosh":  newUnaryBuiltin("cosh", math.Cosh).WithDoc("cosh(x, /)", "cosh returns the hyperbolic cosine of x."),
+		"sinh":  newUnaryBuiltin("sinh", math.Sinh).WithDoc("sinh(x, /)", "sinh returns the hyperbolic sine of x."),
+		"tanh":  newUnaryBuiltin("tanh", math.Tanh).WithDoc("tanh(x, /)", "tanh returns the hyperbolic tangent of x."),
+
+		"log": starlark.NewBuiltin("log", log).WithDoc("log(x, base=e, /)", "log returns the logarithm of x in the given base, or natural logarithm by default."),
+
+		"gamma": newUnaryBuiltin("gamma", math.Gamma).WithDoc("gamma(x, /)", "gamma returns the Gamma function of x."),
 
 		"e":  starlark.Float(math.E),
 		"pi": starlark.Float(math.Pi),
@@ -118,7 +116,7 @@ func (p *floatOrInt) Unpack(v starlark.Value) error {
 		*p = floatOrInt(v)
 		return nil
 	}
-	return fmt.Errorf("got %s, want float or int", v.Type())
+	return starlark.Errorf(starlark.TypeError, "got %s, want float or int", v.Type())
 }
 
 // newUnaryBuiltin wraps a unary floating-point Go function
@@ -157,7 +155,7 @@ func log(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwar
 		return nil, err
 	}
 	if base == 1 {
-		return nil, errors.New("division by zero")
+		return nil, starlark.Errorf(starlark.ZeroDivision, "division by zero")
 	}
 	return starlark.Float(math.Log(float64(x)) / math.Log(float64(base))), nil
 }
@@ -176,7 +174,7 @@ func ceil(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwa
 		return starlark.NumberToInt(starlark.Float(math.Ceil(float64(t))))
 	}
 
-	return nil, fmt.Errorf("got %s, want float or int", x.Type())
+	return nil, starlark.Errorf(starlark.TypeError, "got %s, want float or int", x.Type())
 }
 
 func floor(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
@@ -193,7 +191,7 @@ func floor(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kw
 		return starlark.NumberToInt(starlark.Float(math.Floor(float64(t))))
 	}
 
-	return nil, fmt.Errorf("got %s, want float or int", x.Type())
+	return nil, starlark.Errorf(starlark.TypeError, "got %s, want float or int", x.Type())
 }
 
 func degrees(x float64) float64 {
@@ -0,0 +1,237 @@
+package proto
+
+// This file defines the comparison, copying, and merging of messages.
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+var _ starlark.Comparable = (*Message)(nil)
+
+// CompareSameType implements the == and != operators. Two messages are
+// equal if they have the same type and the same fields, as defined by
+// proto.Equal. Messages are not ordered.
+func (m *Message) CompareSameType(op syntax.Token, y starlark.Value, depth int) (bool, error) {
+	n := y.(*Message)
+	switch op {
+	case syntax.EQL:
+		return equal(m, n), nil
+	case syntax.NEQ:
+		return !equal(m, n), nil
+	default:
+		return false, starlark.Errorf(starlark.TypeError, "%s %s %s not implemented", m.Type(), op, n.Type())
+	}
+}
+
+// equal reports whether two messages have the same type and fields.
+func equal(x, y *Message) bool {
+	return x.desc() == y.desc() && proto.Equal(x.Message(), y.Message())
+}
+
+// unpackMessages unpacks the arguments of a built-in function that
+// accepts two messages of the same type.
+func unpackMessages(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (x, y *Message, err error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &x, &y); err != nil {
+		return nil, nil, err
+	}
+	if x.desc() != y.desc() {
+		return nil, nil, starlark.Errorf(starlark.TypeError, "%s: got messages of types %s and %s, want the same type", fn.Name(), x.desc().FullName(), y.desc().FullName())
+	}
+	return x, y, nil
+}
+
+// equals(x, y) reports whether two messages are equal, like x == y.
+func equals(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x, y *Message
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &x, &y); err != nil {
+		return nil, err
+	}
+	return starlark.Bool(equal(x, y)), nil
+}
+
+// clone(msg) returns a new, unfrozen deep copy of a message.
+func clone(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var m *Message
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &m); err != nil {
+		return nil, err
+	}
+	return Wrap(proto.Clone(m.Message())), nil
+}
+
+// merge(base, override) returns a new message, a copy of base into
+// which the fields of override are merged, as if by proto.Merge: each
+// scalar field set in override replaces that of base, the elements of
+// repeated fields are appended, the entries of map fields are
+// replaced, and message fields are merged recursively.
+// Neither argument is modified.
+func merge(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	base, override, err := unpackMessages(fn, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	result := proto.Clone(base.Message())
+	proto.Merge(result, override.Message())
+	return Wrap(result), nil
+}
+
+// diff(x, y) returns a report of the differences between two messages
+// of the same type, or "" if they are equal. Each line of the report
+// describes the difference at one field path, such as
+// "a.b[2].c: 1 -> 2", or the addition or removal of a field, element,
+// or map entry, such as `m["k"]: added "v"`.
+func diff(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	x, y, err := unpackMessages(fn, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	diffMessages(&buf, "", x.msg, y.msg)
+	return starlark.String(buf.String()), nil
+}
+
+// diffMessages writes to buf the differences between the fields of two
+// messages of the same type, whose field path is prefix.
+func diffMessages(buf *bytes.Buffer, prefix string, x, y protoreflect.Message) {
+	// Visit the fields (including extensions) set in either message,
+	// in order of field number.
+	var fields []protoreflect.FieldDescriptor
+	seen := make(map[protoreflect.FieldNumber]bool)
+	visit := func(fdesc protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		if !seen[fdesc.Number()] {
+			seen[fdesc.Number()] = true
+			fields = append(fields, fdesc)
+		}
+		return true
+	}
+	x.Range(visit)
+	y.Range(visit)
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Number() < fields[j].Number()
+	})
+
+	for _, fdesc := range fields {
+		path := fieldPathName(fdesc)
+		if prefix != "" && !fdesc.IsExtension() {
+			path = "." + path
+		}
+		path = prefix + path
+		hasX, hasY := x.Has(fdesc), y.Has(fdesc)
+		if fdesc.HasPresence() && hasX != hasY {
+			if hasX {
+				fmt.Fprintf(buf, "%s: removed %s\n", path, valueString(fdesc, x.Get(fdesc)))
+			} else {
+				fmt.Fprintf(buf, "%s: added %s\n", path, valueString(fdesc, y.Get(fdesc)))
+			}
+			continue
+		}
+		switch {
+		case fdesc.IsList():
+			diffLists(buf, path, fdesc, x.Get(fdesc).List(), y.Get(fdesc).List())
+		case fdesc.IsMap():
+			diffMaps(buf, path, fdesc, x.Get(fdesc).Map(), y.Get(fdesc).Map())
+		default:
+			diffValues(buf, path, fdesc, x.Get(fdesc), y.Get(fdesc))
+		}
+	}
+}
+
+// diffLists writes to buf the differences between two repeated fields.
+func diffLists(buf *bytes.Buffer, path string, fdesc protoreflect.FieldDescriptor, x, y protoreflect.List) {
+	for i := 0; i < x.Len() || i < y.Len(); i++ {
+		elem := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case i >= y.Len():
+			fmt.Fprintf(buf, "%s: removed %s\n", elem, valueString(fdesc, x.Get(i)))
+		case i >= x.Len():
+			fmt.Fprintf(buf, "%s: added %s\n", elem, valueString(fdesc, y.Get(i)))
+		default:
+			diffValues(buf, elem, fdesc, x.Get(i), y.Get(i))
+		}
+	}
+}
+
+// diffMaps writes to buf the differences between two map fields,
+// in order of their keys.
+func diffMaps(buf *bytes.Buffer, path string, fdesc protoreflect.FieldDescriptor, x, y protoreflect.Map) {
+	var keys []protoreflect.MapKey
+	seen := make(map[interface{}]bool)
+	visit := func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+		if !seen[k.Interface()] {
+			seen[k.Interface()] = true
+			keys = append(keys, k)
+		}
+		return true
+	}
+	x.Range(visit)
+	y.Range(visit)
+	sort.Slice(keys, func(i, j int) bool { return lessMapKey(keys[i], keys[j]) })
+
+	kdesc, vdesc := fdesc.MapKey(), fdesc.MapValue()
+	for _, k := range keys {
+		entry := path + "[" + valueString(kdesc, k.Value()) + "]"
+		switch {
+		case !y.Has(k):
+			fmt.Fprintf(buf, "%s: removed %s\n", entry, valueString(vdesc, x.Get(k)))
+		case !x.Has(k):
+			fmt.Fprintf(buf, "%s: added %s\n", entry, valueString(vdesc, y.Get(k)))
+		default:
+			diffValues(buf, entry, vdesc, x.Get(k), y.Get(k))
+		}
+	}
+}
+
+// diffValues writes to buf the difference, if any, between two values
+// of a singular field or of elements of a repeated or map field.
+func diffValues(buf *bytes.Buffer, path string, fdesc protoreflect.FieldDescriptor, x, y protoreflect.Value) {
+	switch fdesc.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		diffMessages(buf, path, x.Message(), y.Message())
+		return
+	case protoreflect.BytesKind:
+		if bytes.Equal(x.Bytes(), y.Bytes()) {
+			return
+		}
+	default:
+		if x.Interface() == y.Interface() {
+			return
+		}
+	}
+	fmt.Fprintf(buf, "%s: %s -> %s\n", path, valueString(fdesc, x), valueString(fdesc, y))
+}
+
+// fieldPathName returns the name of a field as it appears in a field
+// path: its name, or its full name in brackets if it is an extension.
+func fieldPathName(fdesc protoreflect.FieldDescriptor) string {
+	if fdesc.IsExtension() {
+		return "[" + string(fdesc.FullName()) + "]"
+	}
+	return string(fdesc.Name())
+}
+
+// valueString returns the Starlark notation for a field value.
+func valueString(fdesc protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	var buf bytes.Buffer
+	writeString(&buf, fdesc, v)
+	return buf.String()
+}
+
+// lessMapKey defines the order of the keys of a map field.
+func lessMapKey(x, y protoreflect.MapKey) bool {
+	switch k := x.Interface().(type) {
+	case bool:
+		return !k && y.Bool()
+	case int32, int64:
+		return x.Int() < y.Int()
+	case uint32, uint64:
+		return x.Uint() < y.Uint()
+	}
+	return x.String() < y.String()
+}
@@ -72,7 +72,8 @@
 //
 // To construct a Message from encoded binary or text data, call
 // Unmarshal or UnmarshalText.  These two functions are exposed to
-// Starlark programs as proto.unmarshal{,_text}.
+// Starlark programs as proto.unmarshal{,_text}. Starlark programs may
+// also decode JSON data using proto.unmarshal_json.
 //
 // To construct a Message from an existing Go proto.Message instance,
 // you must first encode the Go message to binary, then decode it using
@@ -84,8 +85,7 @@
 package proto
 
 // TODO(adonovan): Go and Starlark API improvements:
-// - Make Message and RepeatedField comparable.
-//   (NOTE: proto.Equal works only with generated message types.)
+// - Make RepeatedField comparable.
 // - Support oneof, any. But not messageset if we can avoid it.
 // - Support "well-known types".
 // - Defend against cycles in object graph.
@@ -96,9 +96,9 @@ import (
 	"fmt"
 	"sort"
 	"strings"
-	"unsafe"
 	_ "unsafe" // for linkname hack
 
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
@@ -130,10 +130,11 @@ func Pool(thread *starlark.Thread) DescriptorPool {
 
 const contextKey = "proto.DescriptorPool"
 
-// A DescriptorPool loads FileDescriptors by path name or package name,
-// possibly on demand.
+// A DescriptorPool loads FileDescriptors by path name, and descriptors
+// by full name, possibly on demand. The latter is needed to resolve
+// the type of an Any message.
 //
-// It is a superinterface of protodesc.Resolver, so any Resolver
+// It is the same interface as protodesc.Resolver, so any Resolver
 // implementation is a valid pool. For example.
 // protoregistry.GlobalFiles, which loads FileDescriptors from the
 // compressed binary information in all the *.pb.go files linked into
@@ -141,25 +142,40 @@ const contextKey = "proto.DescriptorPool"
 // FileDescriptorSet messages. See star2proto for example usage.
 type DescriptorPool interface {
 	FindFileByPath(string) (protoreflect.FileDescriptor, error)
+	FindDescriptorByName(protoreflect.FullName) (protoreflect.Descriptor, error)
 }
 
 var Module = &starlarkstruct.Module{
 	Name: "proto",
 	Members: starlark.StringDict{
-		"file":           starlark.NewBuiltin("proto.file", file),
-		"has":            starlark.NewBuiltin("proto.has", has),
-		"marshal":        starlark.NewBuiltin("proto.marshal", marshal),
-		"marshal_text":   starlark.NewBuiltin("proto.marshal_text", marshal),
-		"set_field":      starlark.NewBuiltin("proto.set_field", setFieldStarlark),
-		"get_field":      starlark.NewBuiltin("proto.get_field", getFieldStarlark),
-		"unmarshal":      starlark.NewBuiltin("proto.unmarshal", unmarshal),
-		"unmarshal_text": starlark.NewBuiltin("proto.unmarshal_text", unmarshal_text),
-
-		// TODO(adonovan):
-		// - merge(msg, msg) -> msg
-		// - equals(msg, msg) -> bool
-		// - diff(msg, msg) -> string
-		// - clone(msg) -> msg
+		"file": starlark.NewBuiltin("proto.file", file).WithDoc("file(filename, /)",
+			"file returns the FileDescriptor of the named .proto file, loaded from the thread's descriptor pool."),
+		"has": starlark.NewBuiltin("proto.has", has).WithDoc("has(msg, field, /)",
+			"has reports whether the specified field of msg, identified by name or descriptor, is set."),
+		"marshal": starlark.NewBuiltin("proto.marshal", marshal).WithDoc("marshal(msg, /)",
+			"marshal returns the binary encoding of msg, as bytes."),
+		"marshal_text": starlark.NewBuiltin("proto.marshal_text", marshalText).WithDoc(`marshal_text(msg, /, *, indent="  ")`,
+			"marshal_text returns the text encoding of msg, as a string. Each field is on a separate line, indented by indent, unless indent is empty."),
+		"marshal_json": starlark.NewBuiltin("proto.marshal_json", marshalJSON).WithDoc(`marshal_json(msg, /, *, indent="", proto_names=False, emit_defaults=False)`,
+			"marshal_json returns the JSON encoding of msg, as a string. Each field is on a separate line, indented by indent, unless indent is empty. Fields are named by their proto names if proto_names, or by their JSON names otherwise. Fields that are not set are emitted with their default values if emit_defaults. Any messages are resolved using the thread's descriptor pool."),
+		"set_field": starlark.NewBuiltin("proto.set_field", setFieldStarlark).WithDoc("set_field(msg, field, value, /)",
+			"set_field sets the field of msg with the specified descriptor. It is typically used for extensions."),
+		"get_field": starlark.NewBuiltin("proto.get_field", getFieldStarlark).WithDoc("get_field(msg, field, /)",
+			"get_field returns the value of the field of msg with the specified descriptor. It is typically used for extensions."),
+		"unmarshal": starlark.NewBuiltin("proto.unmarshal", unmarshal).WithDoc("unmarshal(desc, data, /)",
+			"unmarshal decodes the binary encoding data, a bytes, as a message of the type described by desc."),
+		"unmarshal_text": starlark.NewBuiltin("proto.unmarshal_text", unmarshal_text).WithDoc("unmarshal_text(desc, data, /)",
+			"unmarshal_text decodes the text encoding data, a string, as a message of the type described by desc."),
+		"unmarshal_json": starlark.NewBuiltin("proto.unmarshal_json", unmarshalJSON).WithDoc("unmarshal_json(desc, data, /)",
+			"unmarshal_json decodes the JSON encoding data, a string, as a message of the type described by desc. Fields may be named by their proto or JSON names. Any messages are resolved using the thread's descriptor pool."),
+		"merge": starlark.NewBuiltin("proto.merge", merge).WithDoc("merge(base, override, /)",
+			"merge returns a new message, a copy of base into which the fields set in override, a message of the same type, are merged."),
+		"equals": starlark.NewBuiltin("proto.equals", equals).WithDoc("equals(x, y, /)",
+			"equals reports whether two messages have the same type and fields, like x == y."),
+		"diff": starlark.NewBuiltin("proto.diff", diff).WithDoc("diff(x, y, /)",
+			"diff returns a report of the differences between two messages of the same type, one field path per line, or \"\" if they are equal."),
+		"clone": starlark.NewBuiltin("proto.clone", clone).WithDoc("clone(msg, /)",
+			"clone returns a new, unfrozen deep copy of msg."),
 	},
 }
 
@@ -201,7 +217,7 @@ func has(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwa
 	}
 	msg, ok := x.(*Message)
 	if !ok {
-		return nil, fmt.Errorf("%s: got %s, want proto.Message", fn.Name(), x.Type())
+		return nil, starlark.Errorf(starlark.TypeError, "%s: got %s, want proto.Message", fn.Name(), x.Type())
 	}
 
 	var fdesc protoreflect.FieldDescriptor
@@ -215,7 +231,7 @@ func has(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwa
 
 	case FieldDescriptor:
 		if field.Desc.ContainingMessage() != msg.desc() {
-			return nil, fmt.Errorf("%s: %v does not have field %v", fn.Name(), msg.desc().FullName(), field)
+			return nil, starlark.Errorf(starlark.AttributeError, "%s: %v does not have field %v", fn.Name(), msg.desc().FullName(), field)
 		}
 		fdesc = field.Desc
 		if fdesc.IsExtension() {
@@ -231,31 +247,66 @@ func has(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwa
 		}
 
 	default:
-		return nil, fmt.Errorf("%s: for field argument, got %s, want string or proto.FieldDescriptor", fn.Name(), field.Type())
+		return nil, starlark.Errorf(starlark.TypeError, "%s: for field argument, got %s, want string or proto.FieldDescriptor", fn.Name(), field.Type())
 	}
 
 	return starlark.Bool(msg.msg.Has(fdesc)), nil
 }
 
-// marshal{,_text}(msg) encodes a Message value to binary or text form.
+// marshal(msg) encodes a Message value to binary form.
 func marshal(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var m *Message
 	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &m); err != nil {
 		return nil, err
 	}
-	if fn.Name() == "proto.marshal" {
-		data, err := proto.Marshal(m.Message())
-		if err != nil {
-			return nil, fmt.Errorf("%s: %v", fn.Name(), err)
-		}
-		return starlark.Bytes(data), nil
-	} else {
-		text, err := prototext.MarshalOptions{Indent: "  "}.Marshal(m.Message())
-		if err != nil {
-			return nil, fmt.Errorf("%s: %v", fn.Name(), err)
-		}
-		return starlark.String(text), nil
+	data, err := proto.Marshal(m.Message())
+	if err != nil {
+		return nil, starlark.Errorf(starlark.ValueError, "%s: %w", fn.Name(), err)
 	}
+	return starlark.Bytes(data), nil
+}
+
+// marshal_text(msg, *, indent) encodes a Message value to text form.
+func marshalText(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var m *Message
+	indent := "  "
+	if err := unpackMarshalArgs(fn, args, kwargs, &m, "indent?", &indent); err != nil {
+		return nil, err
+	}
+	text, err := prototext.MarshalOptions{Multiline: indent != "", Indent: indent}.Marshal(m.Message())
+	if err != nil {
+		return nil, starlark.Errorf(starlark.ValueError, "%s: %w", fn.Name(), err)
+	}
+	return starlark.String(text), nil
+}
+
+// marshal_json(msg, *, indent, proto_names, emit_defaults) encodes a
+// Message value to JSON form.
+func marshalJSON(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var m *Message
+	opts := protojson.MarshalOptions{Resolver: typeResolver(thread)}
+	if err := unpackMarshalArgs(fn, args, kwargs, &m,
+		"indent?", &opts.Indent,
+		"proto_names?", &opts.UseProtoNames,
+		"emit_defaults?", &opts.EmitUnpopulated,
+	); err != nil {
+		return nil, err
+	}
+	opts.Multiline = opts.Indent != ""
+	data, err := opts.Marshal(m.Message())
+	if err != nil {
+		return nil, starlark.Errorf(starlark.ValueError, "%s: %w", fn.Name(), err)
+	}
+	return starlark.String(data), nil
+}
+
+// unpackMarshalArgs unpacks the arguments of a marshal function: a
+// positional message, followed by the keyword-only options pairs.
+func unpackMarshalArgs(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple, m **Message, pairs ...interface{}) error {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, nil, 1, m); err != nil {
+		return err
+	}
+	return starlark.UnpackArgs(fn.Name(), nil, kwargs, pairs...)
 }
 
 // unmarshal(msg) decodes a binary protocol message to a Message.
@@ -278,6 +329,85 @@ func unmarshal_text(thread *starlark.Thread, fn *starlark.Builtin, args starlark
 	return unmarshalData(desc.Desc, []byte(data), false)
 }
 
+// unmarshal_json(desc, data) decodes a JSON protocol message to a Message.
+func unmarshalJSON(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var desc MessageDescriptor
+	var data string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &desc, &data); err != nil {
+		return nil, err
+	}
+	m := &Message{
+		msg:    newMessage(desc.Desc),
+		frozen: new(bool),
+	}
+	opts := protojson.UnmarshalOptions{Resolver: typeResolver(thread)}
+	if err := opts.Unmarshal([]byte(data), m.Message()); err != nil {
+		return nil, starlark.Errorf(starlark.ValueError, "unmarshalling %s failed: %v", desc.Desc.FullName(), err)
+	}
+	return m, nil
+}
+
+// typeResolver returns the resolver of the message types of Any
+// messages for the thread, which creates dynamic types for the
+// descriptors of its pool.
+func typeResolver(thread *starlark.Thread) poolTypes {
+	return poolTypes{Pool(thread)}
+}
+
+// poolTypes resolves message and extension types from the descriptors
+// of a pool, like dynamicpb.Types. It cannot find extensions by number.
+type poolTypes struct {
+	pool DescriptorPool // nil if the thread has no pool
+}
+
+var (
+	_ protoregistry.MessageTypeResolver   = poolTypes{}
+	_ protoregistry.ExtensionTypeResolver = poolTypes{}
+)
+
+func (t poolTypes) find(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	if t.pool == nil {
+		return nil, fmt.Errorf("cannot resolve type %s: no descriptor pool", name)
+	}
+	return t.pool.FindDescriptorByName(name)
+}
+
+func (t poolTypes) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	d, err := t.find(name)
+	if err != nil {
+		return nil, err
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message type", name)
+	}
+	return dynamicpb.NewMessageType(md), nil
+}
+
+func (t poolTypes) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	name := url
+	if i := strings.LastIndexByte(url, '/'); i >= 0 {
+		name = url[i+1:]
+	}
+	return t.FindMessageByName(protoreflect.FullName(name))
+}
+
+func (t poolTypes) FindExtensionByName(name protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	d, err := t.find(name)
+	if err != nil {
+		return nil, err
+	}
+	xd, ok := d.(protoreflect.FieldDescriptor)
+	if !ok || !xd.IsExtension() {
+		return nil, fmt.Errorf("%s is not an extension", name)
+	}
+	return dynamicpb.NewExtensionType(xd), nil
+}
+
+func (t poolTypes) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	return nil, protoregistry.NotFound
+}
+
 // set_field(msg, field, value) updates the value of a field.
 // It is typically used for extensions, which cannot be updated using msg.field = v notation.
 func setFieldStarlark(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
@@ -294,7 +424,7 @@ func setFieldStarlark(thread *starlark.Thread, fn *starlark.Builtin, args starla
 	}
 
 	if field.Desc.ContainingMessage() != m.desc() {
-		return nil, fmt.Errorf("%s: %v does not have field %v", fn.Name(), m.desc().FullName(), field)
+		return nil, starlark.Errorf(starlark.AttributeError, "%s: %v does not have field %v", fn.Name(), m.desc().FullName(), field)
 	}
 
 	return starlark.None, setField(m.msg, field.Desc, v)
@@ -311,7 +441,7 @@ func getFieldStarlark(thread *starlark.Thread, fn *starlark.Builtin, args starla
 	}
 
 	if field.Desc.ContainingMessage() != msg.desc() {
-		return nil, fmt.Errorf("%s: %v does not have field %v", fn.Name(), msg.desc().FullName(), field)
+		return nil, starlark.Errorf(starlark.AttributeError, "%s: %v does not have field %v", fn.Name(), msg.desc().FullName(), field)
 	}
 
 	return msg.getField(field.Desc), nil
@@ -333,10 +463,10 @@ func (d MessageDescriptor) CallInternal(thread *starlark.Thread, args starlark.T
 	// Single positional argument?
 	if len(args) > 0 {
 		if len(kwargs) > 0 {
-			return nil, fmt.Errorf("%s: got both positional and named arguments", d.Desc.Name())
+			return nil, starlark.Errorf(starlark.TypeError, "%s: got both positional and named arguments", d.Desc.Name())
 		}
 		if len(args) > 1 {
-			return nil, fmt.Errorf("%s: got %d positional arguments, want at most 1", d.Desc.Name(), len(args))
+			return nil, starlark.Errorf(starlark.TypeError, "%s: got %d positional arguments, want at most 1", d.Desc.Name(), len(args))
 		}
 
 		// Keep consistent with MessageKind case of toProto.
@@ -344,7 +474,7 @@ func (d MessageDescriptor) CallInternal(thread *starlark.Thread, args starlark.T
 		switch src := args[0].(type) {
 		case *Message:
 			if dest.desc() != src.desc() {
-				return nil, fmt.Errorf("%s: got message of type %s, want type %s", d.Desc.Name(), src.desc().FullName(), dest.desc().FullName())
+				return nil, starlark.Errorf(starlark.TypeError, "%s: got message of type %s, want type %s", d.Desc.Name(), src.desc().FullName(), dest.desc().FullName())
 			}
 
 			// Make shallow copy of message.
@@ -360,7 +490,7 @@ func (d MessageDescriptor) CallInternal(thread *starlark.Thread, args starlark.T
 			// fall through
 
 		default:
-			return nil, fmt.Errorf("%s: got %s, want dict or message", d.Desc.Name(), src.Type())
+			return nil, starlark.Errorf(starlark.TypeError, "%s: got %s, want dict or message", d.Desc.Name(), src.Type())
 		}
 	}
 
@@ -374,7 +504,7 @@ func setFields(msg protoreflect.Message, items []starlark.Tuple) error {
 	for _, item := range items {
 		name, ok := starlark.AsString(item[0])
 		if !ok {
-			return fmt.Errorf("got %s, want string", item[0].Type())
+			return starlark.Errorf(starlark.TypeError, "got %s, want string", item[0].Type())
 		}
 		fdesc, err := fieldDesc(msg.Descriptor(), name)
 		if err != nil {
@@ -406,7 +536,7 @@ func setField(msg protoreflect.Message, fdesc protoreflect.FieldDescriptor, valu
 	if fdesc.IsList() {
 		iter := starlark.Iterate(value)
 		if iter == nil {
-			return fmt.Errorf("got %s for .%s field, want iterable", value.Type(), fdesc.Name())
+			return starlark.Errorf(starlark.TypeError, "got %s for .%s field, want iterable", value.Type(), fdesc.Name())
 		}
 		defer iter.Done()
 
@@ -416,7 +546,7 @@ func setField(msg protoreflect.Message, fdesc protoreflect.FieldDescriptor, valu
 		for i := 0; iter.Next(&x); i++ {
 			v, err := toProto(fdesc, x)
 			if err != nil {
-				return fmt.Errorf("index %d: %v", i, err)
+				return fmt.Errorf("index %d: %w", i, err)
 			}
 			list.Append(v)
 		}
@@ -426,7 +556,7 @@ func setField(msg protoreflect.Message, fdesc protoreflect.FieldDescriptor, valu
 	if fdesc.IsMap() {
 		mapping, ok := value.(starlark.IterableMapping)
 		if !ok {
-			return fmt.Errorf("in map field %s: expected mappable type, but got %s", fdesc.Name(), value.Type())
+			return starlark.Errorf(starlark.TypeError, "in map field %s: expected mappable type, but got %s", fdesc.Name(), value.Type())
 		}
 
 		iter := mapping.Iterate()
@@ -465,7 +595,7 @@ func setField(msg protoreflect.Message, fdesc protoreflect.FieldDescriptor, valu
 
 	v, err := toProto(fdesc, value)
 	if err != nil {
-		return fmt.Errorf("in field %s: %v", fdesc.Name(), err)
+		return fmt.Errorf("in field %s: %w", fdesc.Name(), err)
 	}
 
 	if fdesc.IsExtension() {
@@ -500,7 +630,7 @@ func toProto(fdesc protoreflect.FieldDescriptor, v starlark.Value) (protoreflect
 			if u, ok := i.Uint64(); ok && uint64(uint32(u)) == u {
 				return protoreflect.ValueOfUint32(uint32(u)), nil
 			}
-			return noValue, fmt.Errorf("invalid %s: %v", typeString(fdesc), i)
+			return noValue, starlark.Errorf(starlark.Overflow, "invalid %s: %v", typeString(fdesc), i)
 		}
 
 	case protoreflect.Int32Kind,
@@ -511,7 +641,7 @@ func toProto(fdesc protoreflect.FieldDescriptor, v starlark.Value) (protoreflect
 			if i, ok := i.Int64(); ok && int64(int32(i)) == i {
 				return protoreflect.ValueOfInt32(int32(i)), nil
 			}
-			return noValue, fmt.Errorf("invalid %s: %v", typeString(fdesc), i)
+			return noValue, starlark.Errorf(starlark.Overflow, "invalid %s: %v", typeString(fdesc), i)
 		}
 
 	case protoreflect.Uint64Kind,
@@ -521,7 +651,7 @@ func toProto(fdesc protoreflect.FieldDescriptor, v starlark.Value) (protoreflect
 			if u, ok := i.Uint64(); ok {
 				return protoreflect.ValueOfUint64(u), nil
 			}
-			return noValue, fmt.Errorf("invalid %s: %v", typeString(fdesc), i)
+			return noValue, starlark.Errorf(starlark.Overflow, "invalid %s: %v", typeString(fdesc), i)
 		}
 
 	case protoreflect.Int64Kind,
@@ -532,7 +662,7 @@ func toProto(fdesc protoreflect.FieldDescriptor, v starlark.Value) (protoreflect
 			if i, ok := i.Int64(); ok {
 				return protoreflect.ValueOfInt64(i), nil
 			}
-			return noValue, fmt.Errorf("invalid %s: %v", typeString(fdesc), i)
+			return noValue, starlark.Errorf(starlark.Overflow, "invalid %s: %v", typeString(fdesc), i)
 		}
 
 	case protoreflect.StringKind:
@@ -576,7 +706,7 @@ func toProto(fdesc protoreflect.FieldDescriptor, v starlark.Value) (protoreflect
 		switch v := v.(type) {
 		case *Message:
 			if desc != v.desc() {
-				return noValue, fmt.Errorf("got %s, want %s", v.desc().FullName(), desc.FullName())
+				return noValue, starlark.Errorf(starlark.TypeError, "got %s, want %s", v.desc().FullName(), desc.FullName())
 			}
 			return protoreflect.ValueOfMessage(v.msg), nil // alias it directly
 
@@ -594,7 +724,7 @@ func toProto(fdesc protoreflect.FieldDescriptor, v starlark.Value) (protoreflect
 		return protoreflect.ValueOfEnum(enumval.Number()), nil
 	}
 
-	return noValue, fmt.Errorf("got %s, want %s", v.Type(), typeString(fdesc))
+	return noValue, starlark.Errorf(starlark.TypeError, "got %s, want %s", v.Type(), typeString(fdesc))
 }
 
 var noValue protoreflect.Value
@@ -671,7 +801,11 @@ func toStarlark1(typ protoreflect.FieldDescriptor, x protoreflect.Value, frozen
 
 // A Message is a Starlark value that wraps a protocol message.
 //
-// Two Messages are equivalent if and only if they are identical.
+// Two Messages are equal if they have the same type and fields. Only a
+// frozen Message is hashable, and its hash depends on its type and
+// fields, so that equal messages have equal hashes. An unfrozen
+// Message cannot be used as a dict key or set element, even though
+// earlier versions of this package hashed it by its type alone.
 //
 // When a Message value becomes frozen, a Starlark program may
 // not modify the underlying protocol message, nor any Message
@@ -686,6 +820,13 @@ func (m *Message) Message() protoreflect.ProtoMessage { return m.msg.Interface()
 
 func (m *Message) desc() protoreflect.MessageDescriptor { return m.msg.Descriptor() }
 
+// Wrap returns a new Starlark message value that wraps the Go
+// protocol message msg. The two alias each other: a Starlark program
+// that updates the message changes msg, unless the message is frozen.
+func Wrap(msg protoreflect.ProtoMessage) *Message {
+	return &Message{msg: msg.ProtoReflect(), frozen: new(bool)}
+}
+
 var _ starlark.HasSetField = (*Message)(nil)
 
 // Unmarshal parses the data as a binary protocol message of the specified type,
@@ -713,7 +854,7 @@ func unmarshalData(desc protoreflect.MessageDescriptor, data []byte, binary bool
 		err = prototext.Unmarshal(data, m.Message())
 	}
 	if err != nil {
-		return nil, fmt.Errorf("unmarshalling %s failed: %v", desc.FullName(), err)
+		return nil, starlark.Errorf(starlark.ValueError, "unmarshalling %s failed: %v", desc.FullName(), err)
 	}
 	return m, nil
 }
@@ -762,10 +903,25 @@ func (m *Message) String() string {
 	return buf.String()
 }
 
-func (m *Message) Type() string                { return "proto.Message" }
-func (m *Message) Truth() starlark.Bool        { return true }
-func (m *Message) Freeze()                     { *m.frozen = true }
-func (m *Message) Hash() (h uint32, err error) { return uint32(uintptr(unsafe.Pointer(m))), nil } // identity hash
+func (m *Message) Type() string         { return "proto.Message" }
+func (m *Message) Truth() starlark.Bool { return true }
+func (m *Message) Freeze()              { *m.frozen = true }
+
+// Hash returns a hash of the message's type and fields, which is
+// consistent with CompareSameType. Since the fields of an unfrozen
+// message may change, only frozen messages are hashable.
+func (m *Message) Hash() (uint32, error) {
+	if !*m.frozen {
+		return 0, starlark.Errorf(starlark.TypeError, "unhashable type: unfrozen %s", m.Type())
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(m.Message())
+	if err != nil {
+		return 0, err
+	}
+	h, _ := starlark.String(m.desc().FullName()).Hash()
+	d, _ := starlark.String(data).Hash()
+	return h ^ d, nil
+}
 
 // Attr returns the value of this message's field of the specified name.
 // Extension fields are not accessible this way as their names are not unique.
@@ -970,7 +1126,7 @@ func repeatedFieldAppend(_ *starlark.Thread, b *starlark.Builtin, args starlark.
 
 	po, err := toProto(rf.typ, object)
 	if err != nil {
-		return nil, fmt.Errorf("appending to repeated field: %v", err)
+		return nil, fmt.Errorf("appending to repeated field: %w", err)
 	}
 	rf.list.Append(po)
 
@@ -990,7 +1146,7 @@ func (rf *RepeatedField) SetIndex(i int, v starlark.Value) error {
 		// The repeated field value cannot know which field it
 		// belongs to---it might be shared by several of the
 		// same type---so the error message is suboptimal.
-		return fmt.Errorf("setting element of repeated field: %v", err)
+		return fmt.Errorf("setting element of repeated field: %w", err)
 	}
 	rf.list.Set(i, x)
 	return nil
@@ -1008,8 +1164,10 @@ func (rf *RepeatedField) checkMutable(verb string) error {
 	return nil
 }
 
-func (rf *RepeatedField) Freeze()               { *rf.frozen = true }
-func (rf *RepeatedField) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable: %s", rf.Type()) }
+func (rf *RepeatedField) Freeze() { *rf.frozen = true }
+func (rf *RepeatedField) Hash() (uint32, error) {
+	return 0, starlark.Errorf(starlark.TypeError, "unhashable: %s", rf.Type())
+}
 func (rf *RepeatedField) Index(i int) starlark.Value {
 	return toStarlark1(rf.typ, rf.list.Get(i), rf.frozen)
 }
@@ -1086,7 +1244,7 @@ func (mf *MapField) checkKeyType() error {
 		protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind:
 		return nil
 	default:
-		return fmt.Errorf("map key is %s, want string, int, or bool", mf.typ.MapKey().Kind())
+		return starlark.Errorf(starlark.TypeError, "map key is %s, want string, int, or bool", mf.typ.MapKey().Kind())
 	}
 }
 
@@ -1100,11 +1258,11 @@ func (mf *MapField) SetKey(k, v starlark.Value) error {
 
 	kx, err := toProto(mf.typ.MapKey(), k)
 	if err != nil {
-		return fmt.Errorf("converting map key: %v", err)
+		return fmt.Errorf("converting map key: %w", err)
 	}
 	vx, err := toProto(mf.typ.MapValue(), v)
 	if err != nil {
-		return fmt.Errorf("converting map value: %v", err)
+		return fmt.Errorf("converting map value: %w", err)
 	}
 
 	mf.mp.Set(kx.MapKey(), vx)
@@ -1129,7 +1287,7 @@ func (mf *MapField) Get(k starlark.Value) (starlark.Value, bool, error) {
 	}
 	pk, err := toProto(mf.typ.MapKey(), k)
 	if err != nil {
-		return nil, false, fmt.Errorf("converting map key: %v", err)
+		return nil, false, fmt.Errorf("converting map key: %w", err)
 	}
 
 	v := mf.mp.Get(pk.MapKey())
@@ -1140,8 +1298,10 @@ func (mf *MapField) Get(k starlark.Value) (starlark.Value, bool, error) {
 	return toStarlark1(mf.typ.MapValue(), v, mf.frozen), true, nil
 }
 
-func (mf *MapField) Freeze()               { *mf.frozen = true }
-func (mf *MapField) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable: %s", mf.Type()) }
+func (mf *MapField) Freeze() { *mf.frozen = true }
+func (mf *MapField) Hash() (uint32, error) {
+	return 0, starlark.Errorf(starlark.TypeError, "unhashable: %s", mf.Type())
+}
 
 func (mf *MapField) Iterate() starlark.Iterator {
 	if !*mf.frozen {
@@ -1438,7 +1598,7 @@ func (e EnumDescriptor) CallInternal(_ *starlark.Thread, args starlark.Tuple, kw
 	}
 	v, err := enumValueOf(e.Desc, x)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %v", e.Desc.Name(), err)
+		return nil, fmt.Errorf("%s: %w", e.Desc.Name(), err)
 	}
 	return EnumValueDescriptor{Desc: v}, nil
 }
@@ -1449,11 +1609,11 @@ func enumValueOf(enum protoreflect.EnumDescriptor, x starlark.Value) (protorefle
 	case starlark.Int:
 		i, err := starlark.AsInt32(x)
 		if err != nil {
-			return nil, fmt.Errorf("invalid number %s for %s enum", x, enum.Name())
+			return nil, starlark.Errorf(starlark.ValueError, "invalid number %s for %s enum", x, enum.Name())
 		}
 		desc := enum.Values().ByNumber(protoreflect.EnumNumber(i))
 		if desc == nil {
-			return nil, fmt.Errorf("invalid number %d for %s enum", i, enum.Name())
+			return nil, starlark.Errorf(starlark.ValueError, "invalid number %d for %s enum", i, enum.Name())
 		}
 		return desc, nil
 
@@ -1461,19 +1621,19 @@ func enumValueOf(enum protoreflect.EnumDescriptor, x starlark.Value) (protorefle
 		name := protoreflect.Name(x)
 		desc := enum.Values().ByName(name)
 		if desc == nil {
-			return nil, fmt.Errorf("invalid name %q for %s enum", name, enum.Name())
+			return nil, starlark.Errorf(starlark.ValueError, "invalid name %q for %s enum", name, enum.Name())
 		}
 		return desc, nil
 
 	case EnumValueDescriptor:
 		if parent := x.Desc.Parent(); parent != enum {
-			return nil, fmt.Errorf("invalid value %s.%s for %s enum",
+			return nil, starlark.Errorf(starlark.ValueError, "invalid value %s.%s for %s enum",
 				parent.Name(), x.Desc.Name(), enum.Name())
 		}
 		return x.Desc, nil
 	}
 
-	return nil, fmt.Errorf("cannot convert %s to %s enum", x.Type(), enum.Name())
+	return nil, starlark.Errorf(starlark.TypeError, "cannot convert %s to %s enum", x.Type(), enum.Name())
 }
 
 // An EnumValueDescriptor is an immutable Starlark value that represents one value of an enumeration.
@@ -1530,6 +1690,6 @@ func (x EnumValueDescriptor) CompareSameType(op syntax.Token, y_ starlark.Value,
 	case syntax.NEQ:
 		return x.Desc != y.Desc, nil
 	default:
-		return false, fmt.Errorf("%s %s %s not implemented", x.Type(), op, y_.Type())
+		return false, starlark.Errorf(starlark.TypeError, "%s %s %s not implemented", x.Type(), op, y_.Type())
 	}
 }
@@ -7,7 +7,6 @@ package time // import "go.starlark.net/lib/time"
 
 import (
 	"errors"
-	"fmt"
 	"sort"
 	"time"
 
@@ -53,12 +52,18 @@ import (
 var Module = &starlarkstruct.Module{
 	Name: "time",
 	Members: starlark.StringDict{
-		"from_timestamp":    starlark.NewBuiltin("from_timestamp", fromTimestamp),
-		"is_valid_timezone": starlark.NewBuiltin("is_valid_timezone", isValidTimezone),
-		"now":               starlark.NewBuiltinWithEffects("now", now),
-		"parse_duration":    starlark.NewBuiltin("parse_duration", parseDuration),
-		"parse_time":        starlark.NewBuiltin("parse_time", parseTime),
-		"time":              starlark.NewBuiltin("time", newTime),
+		"from_timestamp": starlark.NewBuiltin("from_timestamp", fromTimestamp).WithDoc("from_timestamp(sec, nsec=0, /)",
+			"from_timestamp returns the Time corresponding to the Unix time sec seconds and nsec nanoseconds since January 1, 1970 UTC."),
+		"is_valid_timezone": starlark.NewBuiltin("is_valid_timezone", isValidTimezone).WithDoc("is_valid_timezone(loc, /)",
+			"is_valid_timezone reports whether loc is a valid time zone name."),
+		"now": starlark.NewBuiltinWithEffects("now", now).WithCapability("time.now").WithDoc("now()",
+			"now returns the current local time."),
+		"parse_duration": starlark.NewBuiltin("parse_duration", parseDuration).WithDoc("parse_duration(d, /)",
+			"parse_duration returns the Duration denoted by the duration string d, such as \"1h30m\"."),
+		"parse_time": starlark.NewBuiltin("parse_time", parseTime).WithDoc(`parse_time(x, format="2006-01-02T15:04:05Z07:00", location="UTC")`,
+			"parse_time returns the Time denoted by the string x, which is parsed using format and, if x has no time zone, location."),
+		"time": starlark.NewBuiltin("time", newTime).WithDoc(`time(year=0, month=0, day=0, hour=0, minute=0, second=0, nanosecond=0, location="")`,
+			"time returns the Time corresponding to the specified date and time of day in location, or in UTC if location is empty."),
 
 		"nanosecond":  Duration(time.Nanosecond),
 		"microsecond": Duration(time.Microsecond),
@@ -178,14 +183,14 @@ func (d *Duration) Unpack(v starlark.Value) error {
 	case starlark.String:
 		dur, err := time.ParseDuration(string(x))
 		if err != nil {
-			return err
+			return starlark.Errorf(starlark.ValueError, "%w", err)
 		}
 
 		*d = Duration(dur)
 		return nil
 	}
 
-	return fmt.Errorf("got %s, want a duration, string, or int", v.Type())
+	return starlark.Errorf(starlark.TypeError, "got %s, want a duration, string, or int", v.Type())
 }
 
 // String implements the Stringer interface.
@@ -224,7 +229,7 @@ func (d Duration) Attr(name string) (starlark.Value, error) {
 	case "nanoseconds":
 		return starlark.MakeInt64(time.Duration(d).Nanoseconds()), nil
 	}
-	return nil, fmt.Errorf("unrecognized %s attribute %q", d.Type(), name)
+	return nil, starlark.Errorf(starlark.AttributeError, "unrecognized %s attribute %q", d.Type(), name)
 }
 
 // AttrNames lists available dot expression strings. required by
@@ -284,25 +289,25 @@ func (d Duration) Binary(op syntax.Token, y starlark.Value, side starlark.Side)
 		switch y := y.(type) {
 		case Duration:
 			if y == 0 {
-				return nil, fmt.Errorf("%s division by zero", d.Type())
+				return nil, starlark.Errorf(starlark.ZeroDivision, "%s division by zero", d.Type())
 			}
 			return starlark.Float(x.Nanoseconds()) / starlark.Float(time.Duration(y).Nanoseconds()), nil
 		case starlark.Int:
 			if side == starlark.Right {
-				return nil, fmt.Errorf("unsupported operation")
+				return nil, starlark.Errorf(starlark.TypeError, "unsupported operation")
 			}
 			i, ok := y.Int64()
 			if !ok {
-				return nil, fmt.Errorf("int value out of range (want signed 64-bit value)")
+				return nil, starlark.Errorf(starlark.Overflow, "int value out of range (want signed 64-bit value)")
 			}
 			if i == 0 {
-				return nil, fmt.Errorf("%s division by zero", d.Type())
+				return nil, starlark.Errorf(starlark.ZeroDivision, "%s division by zero", d.Type())
 			}
 			return d / Duration(i), nil
 		case starlark.Float:
 			f := float64(y)
 			if f == 0 {
-				return nil, fmt.Errorf("%s division by zero", d.Type())
+				return nil, starlark.Errorf(starlark.ZeroDivision, "%s division by zero", d.Type())
 			}
 			return Duration(float64(x.Nanoseconds()) / f), nil
 		}
@@ -311,7 +316,7 @@ func (d Duration) Binary(op syntax.Token, y starlark.Value, side starlark.Side)
 		switch y := y.(type) {
 		case Duration:
 			if y == 0 {
-				return nil, fmt.Errorf("%s division by zero", d.Type())
+				return nil, starlark.Errorf(starlark.ZeroDivision, "%s division by zero", d.Type())
 			}
 			return starlark.MakeInt64(x.Nanoseconds() / time.Duration(y).Nanoseconds()), nil
 		}
@@ -321,7 +326,7 @@ func (d Duration) Binary(op syntax.Token, y starlark.Value, side starlark.Side)
 		case starlark.Int:
 			i, ok := y.Int64()
 			if !ok {
-				return nil, fmt.Errorf("int value out of range (want signed 64-bit value)")
+				return nil, starlark.Errorf(starlark.Overflow, "int value out of range (want signed 64-bit value)")
 			}
 			return d * Duration(i), nil
 		}
@@ -351,7 +356,7 @@ func newTime(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple,
 		return nil, err
 	}
 	if len(args) > 0 {
-		return nil, fmt.Errorf("time: unexpected positional arguments")
+		return nil, starlark.Errorf(starlark.TypeError, "time: unexpected positional arguments")
 	}
 	location, err := time.LoadLocation(loc)
 	if err != nil {
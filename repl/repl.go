@@ -10,6 +10,9 @@
 // expression. If the input still cannot be parsed as an expression,
 // the REPL parses and executes it as a file (a list of statements),
 // for side effects.
+//
+// Unless the globals define it, the REPL predeclares a help function
+// that prints the signature and documentation of a function.
 package repl // import "go.starlark.net/repl"
 
 import (
@@ -18,6 +21,7 @@ import (
 	"io"
 	"os"
 	"os/signal"
+	"strings"
 
 	"github.com/chzyer/readline"
 	"go.starlark.net/starlark"
@@ -26,6 +30,13 @@ import (
 
 var interrupted = make(chan os.Signal, 1)
 
+// predeclared are the names predeclared in the REPL, which the globals
+// may hide.
+var predeclared = starlark.StringDict{
+	"help": starlark.NewBuiltin("help", help).WithDoc("help(x, /)",
+		"help prints the signature and documentation of a function, or the attributes of another value."),
+}
+
 // REPL calls [REPLOptions] using [syntax.LegacyFileOptions].
 //
 // Deprecated: use [REPLOptions] with [syntax.FileOptions] instead,
@@ -116,8 +127,16 @@ func rep(opts *syntax.FileOptions, rl *readline.Instance, thread *starlark.Threa
 	}
 
 	if expr := soleExpr(f); expr != nil {
-		// eval
-		v, err := starlark.EvalExprOptions(f.Options, thread, expr, globals)
+		// eval, in an environment of the globals and the
+		// predeclared names they do not hide
+		env := make(starlark.StringDict, len(globals)+len(predeclared))
+		for name, v := range predeclared {
+			env[name] = v
+		}
+		for name, v := range globals {
+			env[name] = v
+		}
+		v, err := starlark.EvalExprOptions(f.Options, thread, expr, env)
 		if err != nil {
 			PrintError(err)
 			return nil
@@ -130,7 +149,7 @@ func rep(opts *syntax.FileOptions, rl *readline.Instance, thread *starlark.Threa
 		if v != starlark.None {
 			fmt.Println(v)
 		}
-	} else if err := starlark.ExecREPLChunk(f, thread, globals); err != nil {
+	} else if err := starlark.ExecREPLChunkPredeclared(f, thread, globals, predeclared); err != nil {
 		PrintError(err)
 		return nil
 	}
@@ -138,6 +157,43 @@ func rep(opts *syntax.FileOptions, rl *readline.Instance, thread *starlark.Threa
 	return nil
 }
 
+// help implements the REPL's help function.
+func help(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	if thread.Print != nil {
+		thread.Print(thread, describe(x))
+	} else {
+		fmt.Fprintln(os.Stderr, describe(x))
+	}
+	return starlark.None, nil
+}
+
+// describe returns the help text for a value.
+func describe(x starlark.Value) string {
+	var sig, doc string
+	switch x := x.(type) {
+	case *starlark.Builtin:
+		sig, doc = x.Signature(), x.Doc()
+	case *starlark.Function:
+		sig, doc = x.Signature(), x.Doc()
+	default:
+		desc := fmt.Sprintf("%s value", x.Type())
+		if x, ok := x.(starlark.HasAttrs); ok {
+			if names := x.AttrNames(); len(names) > 0 {
+				desc += " with attributes " + strings.Join(names, ", ")
+			}
+		}
+		return desc
+	}
+	if doc != "" {
+		sig += "\n\n" + doc
+	}
+	return sig
+}
+
 func soleExpr(f *syntax.File) syntax.Expr {
 	if len(f.Stmts) == 1 {
 		if stmt, ok := f.Stmts[0].(*syntax.ExprStmt); ok {
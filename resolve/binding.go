@@ -71,4 +71,7 @@ type Function struct {
 	NumKwonlyParams int        // number of keyword-only optional parameters
 	Locals          []*Binding // this function's local/cell variables, parameters first
 	FreeVars        []*Binding // enclosing cells to capture in closure
+
+	ParamTypes []*Type // types of the parameters, in the order of Locals (nil if unannotated), or nil
+	ResultType *Type   // type of an annotated result, or nil
 }
@@ -0,0 +1,71 @@
+package resolve
+
+// This file defines the static analysis of the capabilities that a
+// file may use.
+
+import (
+	"sort"
+	"strings"
+
+	"go.starlark.net/syntax"
+)
+
+// Capabilities returns the sorted set of capabilities that the
+// resolved file may use, that is, those required by the predeclared
+// and universal names to which it refers. A reference to a member of
+// such a name, such as time.now, is also considered.
+//
+// The capabilityOf function reports the capability required by a name
+// or dotted reference, or "" if it requires none. Applications should
+// typically pass starlark.CapabilityFunc(predeclared).
+//
+// The analysis is conservative: a file may refer to a name without
+// calling it. But it cannot see a built-in function obtained
+// dynamically, for example by getattr, or passed to the file by a
+// call from another module; the evaluator checks every call anyway.
+func Capabilities(file *syntax.File, capabilityOf func(name string) string) []string {
+	set := make(map[string]bool)
+	syntax.Walk(file, func(n syntax.Node) bool {
+		if e, ok := n.(syntax.Expr); ok {
+			if name, ok := predeclaredRef(e); ok {
+				if c := capabilityOf(name); c != "" {
+					set[c] = true
+				}
+			}
+		}
+		return true
+	})
+	capabilities := make([]string, 0, len(set))
+	for c := range set {
+		capabilities = append(capabilities, c)
+	}
+	sort.Strings(capabilities)
+	return capabilities
+}
+
+// predeclaredRef returns the name of e, a predeclared or universal
+// identifier or a chain of selections of its members, such as "x.y.z".
+func predeclaredRef(e syntax.Expr) (string, bool) {
+	var path []string
+	for {
+		switch x := e.(type) {
+		case *syntax.DotExpr:
+			path = append(path, x.Name.Name)
+			e = x.X
+			continue
+		case *syntax.Ident:
+			bind, ok := x.Binding.(*Binding)
+			if !ok || (bind.Scope != Predeclared && bind.Scope != Universal) {
+				return "", false
+			}
+			path = append(path, x.Name)
+		default:
+			return "", false
+		}
+		break
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return strings.Join(path, "."), true
+}
@@ -437,6 +437,9 @@ func (r *resolver) useToplevel(use use) (bind *Binding) {
 		if !r.options.Set && id.Name == "set" {
 			r.errorf(id.NamePos, doesnt+"support sets")
 		}
+		if !r.options.Catch && id.Name == "catch" {
+			r.errorf(id.NamePos, doesnt+"support catch")
+		}
 		bind = &Binding{Scope: Universal}
 		r.predeclared[id.Name] = bind // save it
 	} else {
@@ -501,6 +504,9 @@ func (r *resolver) stmt(stmt syntax.Stmt) {
 	case *syntax.ExprStmt:
 		r.expr(stmt.X)
 
+	case *syntax.BadStmt:
+		// A syntax error, already reported by the parser.
+
 	case *syntax.BranchStmt:
 		if r.loops == 0 && (stmt.Token == syntax.BREAK || stmt.Token == syntax.CONTINUE) {
 			r.errorf(stmt.TokenPos, "%s not in a loop", stmt.Token)
@@ -529,6 +535,7 @@ func (r *resolver) stmt(stmt syntax.Stmt) {
 			Params: stmt.Params,
 			Body:   stmt.Body,
 		}
+		fn.ParamTypes, fn.ResultType = r.annotations(stmt)
 		stmt.Function = fn
 		r.function(fn, stmt.Def)
 
@@ -647,6 +654,14 @@ func (r *resolver) expr(e syntax.Expr) {
 
 	case *syntax.Literal:
 
+	case *syntax.FStringExpr:
+		for _, field := range e.Fields {
+			r.expr(field.X)
+		}
+
+	case *syntax.BadExpr:
+		// A syntax error, already reported by the parser.
+
 	case *syntax.ListExpr:
 		for _, x := range e.List {
 			r.expr(x)
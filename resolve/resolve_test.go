@@ -5,6 +5,7 @@
 package resolve_test
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 
@@ -90,3 +91,38 @@ func TestLambdaVarargsAndKwargsSet(t *testing.T) {
 func isPredeclared(name string) bool { return name == "M" }
 
 func isUniversal(name string) bool { return name == "U" || name == "float" }
+
+func TestCapabilities(t *testing.T) {
+	const src = `
+load("lib", "read")
+
+def f(print):
+    print("shadowed")   # a parameter, not the universal print
+    return os.read       # requires fs.read
+
+def g():
+    return os.path.join("a", "b"), len([]), time.now()
+
+read("x")                # loaded, not predeclared
+`
+	f, err := (&syntax.FileOptions{}).Parse("caps.star", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	isPredeclared := func(name string) bool { return name == "os" || name == "time" }
+	isUniversal := func(name string) bool { return name == "print" || name == "len" }
+	if err := resolve.File(f, isPredeclared, isUniversal); err != nil {
+		t.Fatal(err)
+	}
+	required := map[string]string{
+		"print":        "print",
+		"read":         "never",
+		"os.read":      "fs.read",
+		"os.path.join": "fs",
+		"time.now":     "time.now",
+	}
+	got := resolve.Capabilities(f, func(name string) string { return required[name] })
+	if want := "[fs fs.read time.now]"; fmt.Sprint(got) != want {
+		t.Errorf("Capabilities = %v, want %s", got, want)
+	}
+}
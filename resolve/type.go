@@ -0,0 +1,227 @@
+package resolve
+
+import (
+	"strings"
+
+	"go.starlark.net/syntax"
+)
+
+// This file defines the meaning of type annotations.
+
+// A Type is the meaning of a type annotation, such as int in
+// def f(x: int). The resolver records the types of the annotated
+// parameters and results of each function (see Function).
+//
+// Annotations are gradual: a value has a type if its Type method
+// reports the type's Name, so any type, including those of
+// application-defined values, may be named. The special type any
+// is the type of all values, and callable the type of all callable
+// values. The names str and None denote the types string and NoneType,
+// and the type float also includes int values (see Accepts).
+// The annotation of a *args or **kwargs parameter is the type of each
+// of its elements or values.
+// A type may also be one of these forms:
+//
+//	list[T]             a list whose elements have type T
+//	set[T]              a set whose elements have type T
+//	dict[K, V]          a dict whose keys and values have types K and V
+//	tuple[T1, ..., Tn]  a tuple of n elements of types T1, ..., Tn
+//	T1 | ... | Tn       a value of one of the types T1, ..., Tn
+//
+// The names of types are not variables: they are not resolved.
+type Type struct {
+	Name string  // "any", "callable", a type name such as "int", or "|" for a union
+	Args []*Type // type arguments, as in list[T], or the alternatives of a union
+}
+
+// Any is the type of all values.
+var Any = &Type{Name: "any"}
+
+// typeArity records the number of arguments of each parameterized type,
+// or -1 for a variable number.
+var typeArity = map[string]int{
+	"list":  1,
+	"set":   1,
+	"dict":  2,
+	"tuple": -1,
+}
+
+func (t *Type) String() string {
+	if t.Name == "|" {
+		alts := make([]string, len(t.Args))
+		for i, alt := range t.Args {
+			alts[i] = alt.String()
+		}
+		return strings.Join(alts, " | ")
+	}
+	if len(t.Args) == 0 {
+		return t.Name
+	}
+	args := make([]string, len(t.Args))
+	for i, arg := range t.Args {
+		args[i] = arg.String()
+	}
+	return t.Name + "[" + strings.Join(args, ", ") + "]"
+}
+
+// Accepts reports whether every value of type u is a value of type t,
+// treating any as compatible with every type in either direction.
+// It is the assignability relation of gradual typing.
+func (t *Type) Accepts(u *Type) bool {
+	switch {
+	case t.Name == "any" || u.Name == "any":
+		return true
+	case u.Name == "|":
+		for _, alt := range u.Args {
+			if !t.Accepts(alt) {
+				return false
+			}
+		}
+		return true
+	case t.Name == "|":
+		for _, alt := range t.Args {
+			if alt.Accepts(u) {
+				return true
+			}
+		}
+		return false
+	case t.Name == "callable":
+		return u.Name == "callable" || u.Name == "function" || u.Name == "builtin_function_or_method"
+	case t.Name == "float" && u.Name == "int":
+		return true
+	case t.Name != u.Name:
+		return false
+	}
+	// Unparameterized types, such as list, accept any parameters.
+	if len(t.Args) == 0 || len(u.Args) == 0 {
+		return true
+	}
+	if len(t.Args) != len(u.Args) {
+		return false
+	}
+	for i := range t.Args {
+		if !t.Args[i].Accepts(u.Args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Union returns the union of two types.
+func Union(x, y *Type) *Type {
+	switch {
+	case x.Accepts(y) && x.Name != "any":
+		return x
+	case y.Accepts(x) && y.Name != "any":
+		return y
+	case x.Name == "any" || y.Name == "any":
+		return Any
+	}
+	var alts []*Type
+	for _, t := range []*Type{x, y} {
+		if t.Name == "|" {
+			alts = append(alts, t.Args...)
+		} else {
+			alts = append(alts, t)
+		}
+	}
+	return &Type{Name: "|", Args: alts}
+}
+
+// typ returns the type denoted by an annotation.
+// It reports an error and returns Any if the annotation is invalid.
+func (r *resolver) typ(e syntax.Expr) *Type {
+	switch e := e.(type) {
+	case *syntax.Ident:
+		switch e.Name {
+		case "None":
+			return &Type{Name: "NoneType"}
+		case "str":
+			return &Type{Name: "string"}
+		case "Any":
+			return Any
+		}
+		return &Type{Name: e.Name}
+
+	case *syntax.ParenExpr:
+		return r.typ(e.X)
+
+	case *syntax.IndexExpr:
+		id, ok := e.X.(*syntax.Ident)
+		if !ok {
+			break
+		}
+		n, ok := typeArity[id.Name]
+		if !ok {
+			r.errorf(e.Lbrack, "type %s has no type arguments", id.Name)
+			return Any
+		}
+		args := []syntax.Expr{e.Y}
+		if tuple, ok := e.Y.(*syntax.TupleExpr); ok {
+			args = tuple.List
+		}
+		if n >= 0 && len(args) != n {
+			r.errorf(e.Lbrack, "type %s requires %d type argument(s), got %d", id.Name, n, len(args))
+			return Any
+		}
+		t := &Type{Name: id.Name, Args: make([]*Type, len(args))}
+		for i, arg := range args {
+			t.Args[i] = r.typ(arg)
+		}
+		return t
+
+	case *syntax.BinaryExpr:
+		if e.Op != syntax.PIPE {
+			break
+		}
+		t := &Type{Name: "|"}
+		for _, x := range []syntax.Expr{e.X, e.Y} {
+			if alt := r.typ(x); alt.Name == "|" {
+				t.Args = append(t.Args, alt.Args...)
+			} else {
+				t.Args = append(t.Args, alt)
+			}
+		}
+		return t
+	}
+	start, _ := e.Span()
+	r.errorf(start, "invalid type annotation")
+	return Any
+}
+
+// annotations returns the types of the annotated parameters of a
+// function, in the order of its Locals, and of its result.
+func (r *resolver) annotations(def *syntax.DefStmt) (params []*Type, result *Type) {
+	if def.ParamTypes != nil {
+		var star, starStar *Type
+		var hasStar, hasStarStar bool
+		for i, param := range def.Params {
+			var t *Type
+			if e := def.ParamTypes[i]; e != nil {
+				t = r.typ(e)
+			}
+			if unary, ok := param.(*syntax.UnaryExpr); ok {
+				if unary.X == nil {
+					continue // bare *
+				} else if unary.Op == syntax.STAR {
+					star, hasStar = t, true
+				} else {
+					starStar, hasStarStar = t, true
+				}
+				continue
+			}
+			params = append(params, t)
+		}
+		// *args and **kwargs come last (see function).
+		if hasStar {
+			params = append(params, star)
+		}
+		if hasStarStar {
+			params = append(params, starStar)
+		}
+	}
+	if def.ResultType != nil {
+		result = r.typ(def.ResultType)
+	}
+	return params, result
+}
@@ -6,6 +6,7 @@ package starlark_test
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -17,6 +18,8 @@ import (
 	"go.starlark.net/starlarktest"
 )
 
+var optimize = flag.Bool("optimize", false, "compile benchmarks with the bytecode optimizer")
+
 func BenchmarkStarlark(b *testing.B) {
 	starlark.Universe["json"] = json.Module
 
@@ -35,6 +38,7 @@ func BenchmarkStarlark(b *testing.B) {
 			continue
 		}
 		opts := getOptions(string(src))
+		opts.Optimize = *optimize
 
 		// Evaluate the file once.
 		globals, err := starlark.ExecFileOptions(opts, thread, filename, src, nil)
@@ -0,0 +1,87 @@
+package starlark
+
+// This file defines the capabilities that a thread must be granted to
+// call certain built-in functions.
+
+import "strings"
+
+// Grant restricts the thread to calls of built-in functions that
+// require no capability, or that require one of the capabilities
+// granted by this and previous calls to Grant. A capability such as
+// "fs" also grants the capabilities beneath it, such as "fs.read" and
+// "fs.write". A call to a built-in function that requires a capability
+// that was not granted fails with an error of kind PermissionDenied.
+//
+// A thread on which Grant has not been called may call any built-in
+// function. Calling Grant with no arguments denies all capabilities.
+// Calls of functions that require a capability are never memoized, so
+// Grant also restricts programs that the thread has executed before.
+//
+// See Builtin.WithCapability and resolve.Capabilities.
+func (thread *Thread) Grant(capabilities ...string) {
+	if thread.capabilities == nil {
+		thread.capabilities = make(map[string]bool)
+	}
+	for _, c := range capabilities {
+		thread.capabilities[c] = true
+	}
+}
+
+// HasCapability reports whether the thread may call built-in
+// functions that require the specified capability.
+func (thread *Thread) HasCapability(capability string) bool {
+	if thread.capabilities == nil {
+		return true
+	}
+	for {
+		if thread.capabilities[capability] {
+			return true
+		}
+		i := strings.LastIndexByte(capability, '.')
+		if i < 0 {
+			return false
+		}
+		capability = capability[:i]
+	}
+}
+
+// Capability returns the capability that the built-in function
+// requires of its caller's thread, or "" if it requires none.
+func (b *Builtin) Capability() string { return b.capability }
+
+// WithCapability returns a copy of b that requires the specified
+// capability, such as "fs.read" or "net", of the thread that calls it.
+// A capability is a dotted name by convention; see Thread.Grant.
+func (b *Builtin) WithCapability(capability string) *Builtin {
+	restricted := *b
+	restricted.capability = capability
+	return &restricted
+}
+
+// CapabilityFunc returns a function that reports the capability
+// required by a name, such as "print", or dotted reference to a member
+// of a module, such as "time.now", in the environment of the specified
+// predeclared names and the universal ones. Its result is suitable for
+// use with resolve.Capabilities.
+func CapabilityFunc(predeclared StringDict) func(name string) string {
+	return func(name string) string {
+		path := strings.Split(name, ".")
+		v, ok := predeclared[path[0]]
+		if !ok {
+			v, ok = Universe[path[0]]
+		}
+		for _, field := range path[1:] {
+			x, ok := v.(HasAttrs)
+			if !ok {
+				return ""
+			}
+			if v, _ = x.Attr(field); v == nil {
+				return ""
+			}
+		}
+		if b, ok := v.(*Builtin); ok {
+			return b.capability
+		}
+		return ""
+	}
+}
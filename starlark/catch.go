@@ -0,0 +1,127 @@
+package starlark
+
+// This file defines the catch built-in function, which is enabled by
+// syntax.FileOptions.Catch, and the error values that it returns.
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+)
+
+// catch(fn, *args, **kwargs) calls fn with the specified arguments,
+// and returns None if the call succeeded or an error value describing
+// why it failed. Cancellation of the thread is not caught.
+func catch(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s: missing argument for fn", b.Name())
+	}
+	depth := len(thread.stack) // frames of the caller, including catch itself
+	_, err := Call(thread, args[0], args[1:], kwargs)
+	if err == nil {
+		return None, nil
+	}
+	if atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&thread.cancelled))) != nil {
+		return nil, err
+	}
+	return newFailure(err, depth), nil
+}
+
+// A Failure is the Starlark value of type "error" returned by the
+// catch built-in function to describe the error of a failed call.
+// It is also a Go error, which wraps the original error.
+//
+// Its attributes are:
+//
+//	message  the error message, a string
+//	stack    the call stack at the point of the error, below the call
+//	         to catch, as a tuple of strings such as "a.star:1:2: in f"
+//	cause    the error passed as the cause argument of fail, or None
+//	details  the details argument of fail, or None
+type Failure struct {
+	msg     string
+	stack   CallStack
+	cause   *Failure
+	details Value
+	err     error
+}
+
+var (
+	_ HasAttrs = (*Failure)(nil)
+	_ error    = (*Failure)(nil)
+)
+
+// newFailure returns the Failure for err, the error of a call made
+// at the specified depth of the call stack.
+func newFailure(err error, depth int) *Failure {
+	f := &Failure{msg: err.Error(), details: None, err: err}
+	var cause error = err
+	if evalErr, ok := err.(*EvalError); ok {
+		f.msg = evalErr.Msg
+		if depth < len(evalErr.CallStack) {
+			f.stack = evalErr.CallStack[depth:]
+		}
+		cause = evalErr.cause
+	}
+	if failErr, ok := cause.(*FailError); ok {
+		f.cause = failErr.Cause
+		f.details = failErr.Details
+	}
+	return f
+}
+
+// Message returns the error message.
+func (f *Failure) Message() string { return f.msg }
+
+// CallStack returns the call stack at the point of the error,
+// below the call to catch.
+func (f *Failure) CallStack() CallStack { return f.stack }
+
+func (f *Failure) Error() string  { return f.msg }
+func (f *Failure) Unwrap() error  { return f.err }
+func (f *Failure) String() string { return "error(" + String(f.msg).String() + ")" }
+func (f *Failure) Type() string   { return "error" }
+func (f *Failure) Freeze()        { f.details.Freeze() }
+func (f *Failure) Truth() Bool    { return True }
+func (f *Failure) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: error")
+}
+
+func (f *Failure) AttrNames() []string { return []string{"cause", "details", "message", "stack"} }
+
+func (f *Failure) Attr(name string) (Value, error) {
+	switch name {
+	case "message":
+		return String(f.msg), nil
+	case "stack":
+		stack := make(Tuple, len(f.stack))
+		for i, fr := range f.stack {
+			stack[i] = String(fmt.Sprintf("%s: in %s", fr.Pos, fr.Name))
+		}
+		return stack, nil
+	case "cause":
+		if f.cause == nil {
+			return None, nil
+		}
+		return f.cause, nil
+	case "details":
+		return f.details, nil
+	}
+	return nil, nil // no such attribute
+}
+
+// A FailError is the error returned by the fail built-in function.
+type FailError struct {
+	Msg     string   // the message, including the "fail: " prefix
+	Cause   *Failure // the error passed as the cause argument, or nil
+	Details Value    // the details argument, or None
+}
+
+func (e *FailError) Error() string { return e.Msg }
+
+func (e *FailError) Unwrap() error {
+	if e.Cause == nil {
+		return nil
+	}
+	return e.Cause
+}
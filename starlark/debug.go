@@ -22,13 +22,30 @@ func (fr *frame) NumLocals() int { return len(fr.locals) }
 // Local returns the binding (name and binding position) and value of
 // the i'th local variable of the frame's function.
 // Beware: the value may be nil if it has not yet been assigned!
+// For a variable shared with a nested function, the value is the
+// current content of the shared variable.
 //
 // The index i must be less than [NumLocals].
 // Local may be called only while the frame is active.
 //
 // This function is provided only for debugging tools.
 func (fr *frame) Local(i int) (Binding, Value) {
-	return Binding(fr.callable.(*Function).funcode.Locals[i]), fr.locals[i]
+	v := fr.locals[i]
+	if c, ok := v.(*cell); ok {
+		v = c.v
+	}
+	return Binding(fr.callable.(*Function).funcode.Locals[i]), v
+}
+
+// Globals returns a new dictionary containing the global variables of
+// the module of the frame's function, or nil if it is not a *Function.
+//
+// This function is provided only for debugging tools.
+func (fr *frame) Globals() StringDict {
+	if fn, ok := fr.callable.(*Function); ok {
+		return fn.Globals()
+	}
+	return nil
 }
 
 // DebugFrame is the debugger API for a frame of the interpreter's call stack.
@@ -48,6 +65,7 @@ type DebugFrame interface {
 	Callable() Callable           // returns the frame's function
 	NumLocals() int               // returns the number of local variables in this frame
 	Local(i int) (Binding, Value) // returns the binding and value of the (Starlark) frame's ith local variable
+	Globals() StringDict          // returns the global variables of the (Starlark) frame's module
 	Position() syntax.Position    // returns the current position of execution in this frame
 }
 
@@ -58,3 +76,50 @@ type DebugFrame interface {
 // This function is intended for use in debugging tools.
 // Most applications should have no need for it; use CallFrame instead.
 func (thread *Thread) DebugFrame(depth int) DebugFrame { return thread.frameAt(depth) }
+
+// A Debugger observes the execution of a thread on behalf of a
+// debugging tool such as a Debug Adapter Protocol server.
+//
+// The interpreter calls its methods on the goroutine executing the
+// thread, which is suspended until they return. Meanwhile, another
+// goroutine may inspect the call stack of the thread using DebugFrame,
+// or call Cancel. Thus a Debugger implements breakpoints and stepping
+// by blocking in Line until the user resumes execution.
+type Debugger interface {
+	// Line is called before the innermost Starlark frame,
+	// thread.DebugFrame(0), executes the first instruction
+	// of a new line, including on entry to the function.
+	Line(thread *Thread)
+
+	// Exception is called when an error is raised by the innermost
+	// Starlark frame, thread.DebugFrame(0), either by one of its
+	// operations or by a built-in function that it called, or
+	// by a failure to bind the arguments of a call to it.
+	// It is called once for each error, before the error propagates
+	// to callers, and while the frame is still active.
+	Exception(thread *Thread, err error)
+}
+
+// SetDebugger installs a debugger in the thread.
+// It must not be called during execution.
+//
+// While a debugger is installed, no function calls or top-level
+// statements are answered from the thread's memo table, so that the
+// debugger observes all execution.
+//
+// This function is intended for use in debugging tools.
+func (thread *Thread) SetDebugger(d Debugger) { thread.debugger = d }
+
+// raisedHere reports whether err, which occurred during execution of
+// the innermost Starlark frame, was raised by that frame or by a
+// built-in function it called, as opposed to being propagated from a
+// Starlark function it called, in which case it has already been
+// reported to the debugger.
+func (thread *Thread) raisedHere(err error) bool {
+	e, ok := err.(*EvalError)
+	if !ok {
+		return true
+	}
+	stack := e.CallStack
+	return len(stack) == len(thread.stack)+1 && stack[len(stack)-1].Pos.Filename() == builtinFilename
+}
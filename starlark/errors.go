@@ -0,0 +1,90 @@
+package starlark
+
+// This file defines the kinds of errors reported by Starlark operations.
+
+import (
+	"errors"
+	"fmt"
+)
+
+// An ErrorKind classifies the error of a Starlark operation or
+// built-in function, so that applications can categorize failures
+// without inspecting error messages. Errors that fit none of the
+// kinds, such as an attempt to mutate a frozen value, or a call to
+// fail, are not classified.
+type ErrorKind uint8
+
+const (
+	UnknownError     ErrorKind = iota // the error is not classified
+	TypeError                         // an operand or argument has an inappropriate type, or a call has the wrong arguments
+	ValueError                        // an operand or argument has an appropriate type but an inappropriate value
+	KeyError                          // a mapping has no such key
+	IndexError                        // an index is out of range
+	AttributeError                    // a value has no such attribute, or it cannot be set
+	ZeroDivision                      // the divisor of a division or remainder operation is zero
+	Cancelled                         // the thread was cancelled, or exceeded a limit on steps or allocation
+	Overflow                          // a number is too large to represent or to use as an operand
+	PermissionDenied                  // a built-in function requires a capability not granted to the thread
+)
+
+var errorKindNames = [...]string{
+	UnknownError:     "UnknownError",
+	TypeError:        "TypeError",
+	ValueError:       "ValueError",
+	KeyError:         "KeyError",
+	IndexError:       "IndexError",
+	AttributeError:   "AttributeError",
+	ZeroDivision:     "ZeroDivision",
+	Cancelled:        "Cancelled",
+	Overflow:         "Overflow",
+	PermissionDenied: "PermissionDenied",
+}
+
+func (k ErrorKind) String() string {
+	if int(k) < len(errorKindNames) {
+		return errorKindNames[k]
+	}
+	return fmt.Sprintf("ErrorKind(%d)", k)
+}
+
+// A KindError is an error of a particular kind. The operations and
+// built-in functions of this package and its libraries return a
+// KindError for each error that they classify, and an application may
+// retrieve it from an EvalError using errors.As:
+//
+//	var kerr *starlark.KindError
+//	if errors.As(err, &kerr) && kerr.Kind == starlark.KeyError { ... }
+//
+// The message of a KindError is that of Err.
+type KindError struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *KindError) Error() string { return e.Err.Error() }
+func (e *KindError) Unwrap() error { return e.Err }
+
+// Errorf returns a KindError of the specified kind whose error is
+// formatted as if by fmt.Errorf.
+func Errorf(kind ErrorKind, format string, args ...interface{}) error {
+	return &KindError{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+// ErrorKindOf returns the kind of the first KindError in the chain of
+// err, or UnknownError if there is none.
+func ErrorKindOf(err error) ErrorKind {
+	var kerr *KindError
+	if errors.As(err, &kerr) {
+		return kerr.Kind
+	}
+	return UnknownError
+}
+
+// withKind returns err, classified as the specified kind unless an
+// error in its chain is already classified.
+func withKind(kind ErrorKind, err error) error {
+	if ErrorKindOf(err) != UnknownError {
+		return err
+	}
+	return &KindError{Kind: kind, Err: err}
+}
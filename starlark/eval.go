@@ -5,6 +5,7 @@
 package starlark
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -27,6 +28,21 @@ import (
 // A Thread contains the state of a Starlark thread,
 // such as its call stack and thread-local storage.
 // The Thread is threaded throughout the evaluator.
+//
+// Each list, dict, and set has an owner, the thread passed to
+// NewList, NewDict, or NewSet, whose memoization cache records the
+// reads and writes of the value. The owner may be nil, so that Go
+// code can build values, such as predeclared constants, before any
+// thread exists. Reads of an owner-less value are not recorded. A
+// thread that mutates a value (by executing Starlark code or a
+// built-in function) records the mutation in its own cache and
+// becomes the value's owner. A mutation of a value owned by another
+// thread, or of an owner-less value that has already been read,
+// conservatively invalidates the memoized results of all threads.
+// Go code that mutates a value through methods such as SetKey or
+// Append, which know no thread, is assumed to act for its owner.
+// Frozen values are never recorded, so hosts should freeze values
+// that they share among threads.
 type Thread struct {
 	// Name is an optional name that describes the thread, for debugging.
 	Name string
@@ -59,8 +75,19 @@ type Thread struct {
 	// The precise meaning of "step" is not specified and may change.
 	Steps, maxSteps uint64
 
-	// cancelReason records the reason from the first call to Cancel.
-	cancelReason *string
+	// Allocs is an approximate count of the bytes of memory allocated
+	// by this thread for Starlark values, such as the results of string
+	// concatenation and the elements of lists and dicts. It is
+	// incremented by the interpreter and by built-in functions that
+	// call AddAllocs. Like Steps, it may be used as a measure of cost.
+	Allocs, maxAllocs uint64
+
+	// cancelled records the reason from the first call to Cancel,
+	// or the error of the context that cancelled the thread.
+	cancelled *cancellation
+
+	// ctx is the context of the current ExecFileContext or CallContext, if any.
+	ctx context.Context
 
 	// locals holds arbitrary "thread-local" Go values belonging to the client.
 	// They are accessible to the client but not to any Starlark program.
@@ -74,6 +101,16 @@ type Thread struct {
 
 	// proftime holds the accumulated execution time since the last profile event.
 	proftime time.Duration
+
+	// debugger, if non-nil, observes execution (see SetDebugger).
+	debugger Debugger
+
+	// report, if non-nil, records resource usage (see ExecFileWithReport).
+	report *Report
+
+	// capabilities, if non-nil, is the set of capabilities granted
+	// to the thread (see Grant).
+	capabilities map[string]bool
 }
 
 // ExecutionSteps returns the current value of Steps.
@@ -90,12 +127,60 @@ func (thread *Thread) SetMaxExecutionSteps(max uint64) {
 	thread.maxSteps = max
 }
 
+// SetMaxAllocs sets a limit on the approximate number of bytes of
+// memory that may be allocated by this thread (see Allocs).
+// An operation that would exceed the limit fails with a Cancelled
+// KindError that wraps an *AllocLimitError, and the thread is
+// cancelled, so that its execution promptly fails with an EvalError
+// that wraps the same error. A limit of zero means no limit.
+func (thread *Thread) SetMaxAllocs(max uint64) {
+	thread.maxAllocs = max
+}
+
+// AddAllocs records that the thread is about to allocate
+// approximately n bytes of memory. If this would exceed the limit set
+// by SetMaxAllocs, it cancels the thread and returns a Cancelled
+// KindError that wraps an *AllocLimitError, and the caller should not
+// perform the allocation.
+//
+// Built-in functions that allocate memory in proportion to their
+// arguments should call AddAllocs before doing so and return the
+// error, if any. A nil thread has no limit.
+func (thread *Thread) AddAllocs(n int64) error {
+	if thread == nil || n <= 0 {
+		return nil
+	}
+	if thread.maxAllocs != 0 && thread.Allocs+uint64(n) > thread.maxAllocs {
+		err := &AllocLimitError{Limit: thread.maxAllocs}
+		thread.cancel(&cancellation{reason: err.Error(), cause: err})
+		return &KindError{Kind: Cancelled, Err: err}
+	}
+	thread.Allocs += uint64(n)
+	return nil
+}
+
+// An AllocLimitError reports that a thread exceeded the limit on
+// allocation set by SetMaxAllocs.
+type AllocLimitError struct {
+	Limit uint64 // the limit, in bytes
+}
+
+func (e *AllocLimitError) Error() string {
+	return fmt.Sprintf("exceeded memory allocation limit of %d bytes", e.Limit)
+}
+
+// Approximate sizes of the parts of values, in bytes, for AddAllocs.
+const (
+	valueSize = int64(unsafe.Sizeof(Value(nil))) // an element of a list or tuple
+	entrySize = int64(unsafe.Sizeof(entry{}))    // an entry of a dict or set
+)
+
 // Uncancel resets the cancellation state.
 //
 // Unlike most methods of Thread, it is safe to call Uncancel from any
 // goroutine, even if the thread is actively executing.
 func (thread *Thread) Uncancel() {
-	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&thread.cancelReason)), nil)
+	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&thread.cancelled)), nil)
 }
 
 // Cancel causes execution of Starlark code in the specified thread to
@@ -108,8 +193,73 @@ func (thread *Thread) Uncancel() {
 // Unlike most methods of Thread, it is safe to call Cancel from any
 // goroutine, even if the thread is actively executing.
 func (thread *Thread) Cancel(reason string) {
-	// Atomically set cancelReason, preserving earlier reason if any.
-	atomic.CompareAndSwapPointer((*unsafe.Pointer)(unsafe.Pointer(&thread.cancelReason)), nil, unsafe.Pointer(&reason))
+	thread.cancel(&cancellation{reason: reason})
+}
+
+func (thread *Thread) cancel(c *cancellation) {
+	// Atomically set cancelled, preserving earlier reason if any.
+	atomic.CompareAndSwapPointer((*unsafe.Pointer)(unsafe.Pointer(&thread.cancelled)), nil, unsafe.Pointer(c))
+}
+
+// A cancellation records why a thread was cancelled.
+type cancellation struct {
+	reason string
+	cause  error // the context's error, for cancellation by a context
+}
+
+func (c *cancellation) error() error {
+	if c.cause != nil {
+		return Errorf(Cancelled, "Starlark computation cancelled: %w", c.cause)
+	}
+	return Errorf(Cancelled, "Starlark computation cancelled: %s", c.reason)
+}
+
+// Context returns the context of the innermost active call to
+// ExecFileContext or CallContext on this thread, or
+// context.Background() if there is none.
+//
+// Built-in functions that perform I/O or other blocking operations
+// should use it so that they observe the caller's cancellation and
+// deadline.
+func (thread *Thread) Context() context.Context {
+	if thread.ctx == nil {
+		return context.Background()
+	}
+	return thread.ctx
+}
+
+// withContext makes ctx the context of the thread, and arranges for
+// the thread to be cancelled when ctx is done. It returns a function
+// that restores the previous context, given a pointer to the error of
+// the execution. If the execution succeeded, a cancellation by ctx
+// that came too late to interrupt it is undone.
+func (thread *Thread) withContext(ctx context.Context) (restore func(*error)) {
+	prev := thread.ctx
+	thread.ctx = ctx
+	c := new(cancellation)
+	done := make(chan struct{})
+	cancel := func() {
+		defer close(done)
+		err := ctx.Err()
+		c.reason, c.cause = err.Error(), err
+		thread.cancel(c)
+	}
+	var stop func() bool
+	if ctx.Err() != nil {
+		cancel() // don't wait for the AfterFunc goroutine
+		stop = func() bool { return false }
+	} else {
+		stop = context.AfterFunc(ctx, cancel)
+	}
+	return func(err *error) {
+		if !stop() {
+			<-done // wait for cancel to finish
+			if *err == nil {
+				atomic.CompareAndSwapPointer((*unsafe.Pointer)(unsafe.Pointer(&thread.cancelled)), unsafe.Pointer(c), nil)
+			}
+		}
+		thread.ctx = prev
+	}
 }
 
 // SetLocal sets the thread-local value associated with the specified key.
@@ -119,13 +269,41 @@ func (thread *Thread) SetLocal(key string, value interface{}) {
 		thread.locals = make(map[string]interface{})
 	}
 	thread.locals[key] = value
+	// Memoized calls that read this key through ReadLocal must be revalidated.
+	thread.cache.version++
 }
 
 // Local returns the thread-local value associated with the specified key.
+//
+// Local does not record a dependency, so a memoized function whose
+// result depends on the value should use ReadLocal instead.
 func (thread *Thread) Local(key string) interface{} {
 	return thread.locals[key]
 }
 
+// ReadLocal is like Local, but it also records that the currently
+// executing function depends on the thread-local value, in the same way
+// that reading an input does. A memoized call that read the key is
+// re-executed if a later call to SetLocal changes its value.
+//
+// Values are compared using == if their type is comparable,
+// and by identity otherwise.
+func (thread *Thread) ReadLocal(key string) interface{} {
+	v := thread.locals[key]
+	thread.dependencies.locals = append(thread.dependencies.locals, LocalValue{key: key, value: v})
+	return v
+}
+
+// SetCacheKey sets additional components of the memoization key for
+// every function call executed by this thread. Calls made under
+// different keys are memoized separately, so a host that executes the
+// same prepared program in several contexts (for example, once per
+// target platform) can call SetCacheKey before each ExecPreparedProgram
+// without evicting the results computed for the other contexts.
+func (thread *Thread) SetCacheKey(components ...string) {
+	thread.cache.key = strings.Join(components, "\x00")
+}
+
 // CallFrame returns a copy of the specified frame of the callstack.
 // It should only be used in built-ins called from Starlark code.
 // Depth 0 means the frame of the built-in itself, 1 is its caller, and so on.
@@ -197,6 +375,8 @@ type frame struct {
 	pc        uint32   // program counter (Starlark frames only)
 	locals    []Value  // local variables (Starlark frames only)
 	spanStart int64    // start time of current profiler span
+
+	calleeSteps uint64 // steps of the Starlark functions called, if reporting
 }
 
 // Position returns the source position of the current point of execution in this frame.
@@ -368,12 +548,39 @@ func ExecFileOptions(opts *syntax.FileOptions, thread *Thread, filename string,
 	return g, err
 }
 
+// ExecFileContext is like ExecFileOptions, but the execution is
+// cancelled when ctx is done. The error then wraps ctx.Err(), so that
+// errors.Is(err, context.DeadlineExceeded), for example, reports
+// whether the deadline expired. As with Cancel, the thread remains
+// cancelled until a call to Uncancel, unless the execution succeeded
+// before it observed the cancellation.
+//
+// The context is available to built-in functions as thread.Context().
+func ExecFileContext(ctx context.Context, opts *syntax.FileOptions, thread *Thread, filename string, src interface{}, predeclared StringDict) (_ StringDict, err error) {
+	defer thread.withContext(ctx)(&err)
+	return ExecFileOptions(opts, thread, filename, src, predeclared)
+}
+
 // PrepareExecFile is like ExecFileOptions but it prepares a program for incremental execution.
+//
+// In addition to function calls, each top-level statement of the
+// prepared program is memoized, so that when it is executed again by
+// ExecPreparedProgram, only the statements that depend on changed
+// inputs (directly or through the globals assigned by other statements)
+// are executed again. Once a statement modifies a value created by an
+// earlier one, for example by appending to a list, every statement is
+// executed each time, as the earlier statements would otherwise leave
+// the modified value in place.
 func PrepareExecFile(opts *syntax.FileOptions, filename string, src interface{}, predeclared StringDict) (*Function, error) {
 	// Parse, resolve, and compile a Starlark source file.
 	_, prog, err := SourceProgramOptions(opts, filename, src, predeclared.Has)
+	if err != nil {
+		return nil, err
+	}
 
-	return makeToplevelFunction(prog.compiled, predeclared), err
+	toplevel := makeToplevelFunction(prog.compiled, predeclared)
+	toplevel.module.incremental = true
+	return toplevel, nil
 }
 
 // Exec executes the prepared program against the specified predeclared environment,
@@ -485,8 +692,16 @@ func (prog *Program) Init(thread *Thread, predeclared StringDict) (StringDict, e
 // This function is intended to support only go.starlark.net/repl.
 // Its API stability is not guaranteed.
 func ExecREPLChunk(f *syntax.File, thread *Thread, globals StringDict) error {
-	var predeclared StringDict
+	return ExecREPLChunkPredeclared(f, thread, globals, nil)
+}
 
+// ExecREPLChunkPredeclared is like ExecREPLChunk, but the chunk may
+// also refer to the predeclared names, which a global of the same name
+// hides. Unlike globals, predeclared names cannot be reassigned.
+//
+// This function is intended to support only go.starlark.net/repl.
+// Its API stability is not guaranteed.
+func ExecREPLChunkPredeclared(f *syntax.File, thread *Thread, globals, predeclared StringDict) error {
 	// -- variant of FileProgram --
 
 	if err := resolve.REPLChunk(f, globals.Has, predeclared.Has, Universe.Has); err != nil {
@@ -651,32 +866,40 @@ func makeExprFunc(opts *syntax.FileOptions, expr syntax.Expr, env StringDict) (*
 // The following functions are primitive operations of the byte code interpreter.
 
 // list += iterable
-func listExtend(x *List, y Iterable) {
+func listExtend(thread *Thread, x *List, y Iterable) error {
 	if ylist, ok := y.(*List); ok {
 		// fast path: list += list
 		ylist.read()
-		x.write()
+		if err := thread.AddAllocs(valueSize * int64(len(ylist.elems))); err != nil {
+			return err
+		}
+		x.write(thread)
 		x.elems = append(x.elems, ylist.elems...)
 	} else {
 		iter := y.Iterate()
 		defer iter.Done()
 		var z Value
-		x.write()
+		x.write(thread)
 		for iter.Next(&z) {
+			if err := thread.AddAllocs(valueSize); err != nil {
+				return err
+			}
 			x.elems = append(x.elems, z)
 		}
 	}
+	thread.noteLen(x, len(x.elems))
+	return nil
 }
 
-// getAttr implements x.dot.
-func getAttr(x Value, name string) (Value, error) {
+// getAttr implements x.dot, executed by thread.
+func getAttr(thread *Thread, x Value, name string) (Value, error) {
 	hasAttr, ok := x.(HasAttrs)
 	if !ok {
-		return nil, fmt.Errorf("%s has no .%s field or method", x.Type(), name)
+		return nil, Errorf(AttributeError, "%s has no .%s field or method", x.Type(), name)
 	}
 
 	var errmsg string
-	v, err := hasAttr.Attr(name)
+	v, err := threadAttr(thread, hasAttr, name)
 	if err == nil {
 		if v != nil {
 			return v, nil // success
@@ -694,23 +917,37 @@ func getAttr(x Value, name string) (Value, error) {
 		errmsg = fmt.Sprintf("%s (did you mean .%s?)", errmsg, n)
 	}
 
-	return nil, fmt.Errorf("%s", errmsg)
+	return nil, Errorf(AttributeError, "%s", errmsg)
 }
 
-// setField implements x.name = y.
-func setField(x Value, name string, y Value) error {
+// threadAttr returns the attribute of x read by thread.
+func threadAttr(thread *Thread, x HasAttrs, name string) (Value, error) {
+	if x, ok := x.(HasThreadAttrs); ok {
+		return x.ThreadAttr(thread, name)
+	}
+	return x.Attr(name)
+}
+
+// setField implements x.name = y, executed by thread.
+func setField(thread *Thread, x Value, name string, y Value) error {
 	if x, ok := x.(HasSetField); ok {
-		err := x.SetField(name, y)
+		var err error
+		if tx, ok := x.(HasThreadSetField); ok {
+			err = tx.ThreadSetField(thread, name, y)
+		} else {
+			err = x.SetField(name, y)
+		}
 		if _, ok := err.(NoSuchAttrError); ok {
 			// No such field: check spelling.
 			if n := spell.Nearest(name, x.AttrNames()); n != "" {
 				err = fmt.Errorf("%s (did you mean .%s?)", err, n)
 			}
+			err = &KindError{Kind: AttributeError, Err: err}
 		}
 		return err
 	}
 
-	return fmt.Errorf("can't assign to .%s field of %s", name, x.Type())
+	return Errorf(AttributeError, "can't assign to .%s field of %s", name, x.Type())
 }
 
 // getIndex implements x[y].
@@ -722,7 +959,7 @@ func getIndex(x, y Value) (Value, error) {
 			return nil, err
 		}
 		if !found {
-			return nil, fmt.Errorf("key %v not in %s", y, x.Type())
+			return nil, Errorf(KeyError, "key %v not in %s", y, x.Type())
 		}
 		return z, nil
 
@@ -730,7 +967,7 @@ func getIndex(x, y Value) (Value, error) {
 		n := x.Len()
 		i, err := AsInt32(y)
 		if err != nil {
-			return nil, fmt.Errorf("%s index: %s", x.Type(), err)
+			return nil, withKind(TypeError, fmt.Errorf("%s index: %w", x.Type(), err))
 		}
 		origI := i
 		if i < 0 {
@@ -741,21 +978,24 @@ func getIndex(x, y Value) (Value, error) {
 		}
 		return x.Index(i), nil
 	}
-	return nil, fmt.Errorf("unhandled index operation %s[%s]", x.Type(), y.Type())
+	return nil, Errorf(TypeError, "unhandled index operation %s[%s]", x.Type(), y.Type())
 }
 
 func outOfRange(i, n int, x Value) error {
 	if n == 0 {
-		return fmt.Errorf("index %d out of range: empty %s", i, x.Type())
+		return Errorf(IndexError, "index %d out of range: empty %s", i, x.Type())
 	} else {
-		return fmt.Errorf("%s index %d out of range [%d:%d]", x.Type(), i, -n, n-1)
+		return Errorf(IndexError, "%s index %d out of range [%d:%d]", x.Type(), i, -n, n-1)
 	}
 }
 
-// setIndex implements x[y] = z.
-func setIndex(x, y, z Value) error {
+// setIndex implements x[y] = z, executed by thread.
+func setIndex(thread *Thread, x, y, z Value) error {
 	switch x := x.(type) {
 	case HasSetKey:
+		if d, ok := x.(*Dict); ok {
+			return d.setKey(thread, y, z)
+		}
 		if err := x.SetKey(y, z); err != nil {
 			return err
 		}
@@ -773,10 +1013,13 @@ func setIndex(x, y, z Value) error {
 		if i < 0 || i >= n {
 			return outOfRange(origI, n, x)
 		}
+		if l, ok := x.(*List); ok {
+			return l.setIndex(thread, i, z)
+		}
 		return x.SetIndex(i, z)
 
 	default:
-		return fmt.Errorf("%s value does not support item assignment", x.Type())
+		return Errorf(TypeError, "%s value does not support item assignment", x.Type())
 	}
 	return nil
 }
@@ -797,7 +1040,7 @@ func Unary(op syntax.Token, x Value) (Value, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("unknown unary op: %s %s", op, x.Type())
+	return nil, Errorf(TypeError, "unknown unary op: %s %s", op, x.Type())
 }
 
 // Binary applies a strict binary operator (not AND or OR) to its operands.
@@ -808,6 +1051,10 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 		switch x := x.(type) {
 		case String:
 			if y, ok := y.(String); ok {
+				if err := thread.AddAllocs(int64(len(x) + len(y))); err != nil {
+					return nil, err
+				}
+				thread.countAlloc("string")
 				return x + y, nil
 			}
 		case Int:
@@ -834,6 +1081,9 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 			}
 		case *List:
 			if y, ok := y.(*List); ok {
+				if err := thread.AddAllocs(valueSize * int64(x.Len()+y.Len())); err != nil {
+					return nil, err
+				}
 				z := make([]Value, 0, x.Len()+y.Len())
 				x.read()
 				y.read()
@@ -843,9 +1093,13 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 			}
 		case Tuple:
 			if y, ok := y.(Tuple); ok {
+				if err := thread.AddAllocs(valueSize * int64(len(x)+len(y))); err != nil {
+					return nil, err
+				}
 				z := make(Tuple, 0, len(x)+len(y))
 				z = append(z, x...)
 				z = append(z, y...)
+				thread.countAlloc("tuple")
 				return z, nil
 			}
 		}
@@ -895,18 +1149,18 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 				}
 				return xf * y, nil
 			case String:
-				return stringRepeat(y, x)
+				return stringRepeat(thread, y, x)
 			case Bytes:
-				return bytesRepeat(y, x)
+				return bytesRepeat(thread, y, x)
 			case *List:
 				y.read()
-				elems, err := tupleRepeat(Tuple(y.elems), x)
+				elems, err := tupleRepeat(thread, Tuple(y.elems), x)
 				if err != nil {
 					return nil, err
 				}
 				return NewList(thread, elems), nil
 			case Tuple:
-				return tupleRepeat(y, x)
+				return tupleRepeat(thread, y, x)
 			}
 		case Float:
 			switch y := y.(type) {
@@ -921,16 +1175,16 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 			}
 		case String:
 			if y, ok := y.(Int); ok {
-				return stringRepeat(x, y)
+				return stringRepeat(thread, x, y)
 			}
 		case Bytes:
 			if y, ok := y.(Int); ok {
-				return bytesRepeat(x, y)
+				return bytesRepeat(thread, x, y)
 			}
 		case *List:
 			if y, ok := y.(Int); ok {
 				x.read()
-				elems, err := tupleRepeat(Tuple(x.elems), y)
+				elems, err := tupleRepeat(thread, Tuple(x.elems), y)
 				if err != nil {
 					return nil, err
 				}
@@ -938,7 +1192,7 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 			}
 		case Tuple:
 			if y, ok := y.(Int); ok {
-				return tupleRepeat(x, y)
+				return tupleRepeat(thread, x, y)
 			}
 
 		}
@@ -957,12 +1211,12 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 					return nil, err
 				}
 				if yf == 0.0 {
-					return nil, fmt.Errorf("floating-point division by zero")
+					return nil, Errorf(ZeroDivision, "floating-point division by zero")
 				}
 				return xf / yf, nil
 			case Float:
 				if y == 0.0 {
-					return nil, fmt.Errorf("floating-point division by zero")
+					return nil, Errorf(ZeroDivision, "floating-point division by zero")
 				}
 				return xf / y, nil
 			}
@@ -970,7 +1224,7 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 			switch y := y.(type) {
 			case Float:
 				if y == 0.0 {
-					return nil, fmt.Errorf("floating-point division by zero")
+					return nil, Errorf(ZeroDivision, "floating-point division by zero")
 				}
 				return x / y, nil
 			case Int:
@@ -979,7 +1233,7 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 					return nil, err
 				}
 				if yf == 0.0 {
-					return nil, fmt.Errorf("floating-point division by zero")
+					return nil, Errorf(ZeroDivision, "floating-point division by zero")
 				}
 				return x / yf, nil
 			}
@@ -991,7 +1245,7 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 			switch y := y.(type) {
 			case Int:
 				if y.Sign() == 0 {
-					return nil, fmt.Errorf("floored division by zero")
+					return nil, Errorf(ZeroDivision, "floored division by zero")
 				}
 				return x.Div(y), nil
 			case Float:
@@ -1000,7 +1254,7 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 					return nil, err
 				}
 				if y == 0.0 {
-					return nil, fmt.Errorf("floored division by zero")
+					return nil, Errorf(ZeroDivision, "floored division by zero")
 				}
 				return floor(xf / y), nil
 			}
@@ -1008,7 +1262,7 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 			switch y := y.(type) {
 			case Float:
 				if y == 0.0 {
-					return nil, fmt.Errorf("floored division by zero")
+					return nil, Errorf(ZeroDivision, "floored division by zero")
 				}
 				return floor(x / y), nil
 			case Int:
@@ -1017,7 +1271,7 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 					return nil, err
 				}
 				if yf == 0.0 {
-					return nil, fmt.Errorf("floored division by zero")
+					return nil, Errorf(ZeroDivision, "floored division by zero")
 				}
 				return floor(x / yf), nil
 			}
@@ -1029,7 +1283,7 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 			switch y := y.(type) {
 			case Int:
 				if y.Sign() == 0 {
-					return nil, fmt.Errorf("integer modulo by zero")
+					return nil, Errorf(ZeroDivision, "integer modulo by zero")
 				}
 				return x.Mod(y), nil
 			case Float:
@@ -1038,7 +1292,7 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 					return nil, err
 				}
 				if y == 0 {
-					return nil, fmt.Errorf("floating-point modulo by zero")
+					return nil, Errorf(ZeroDivision, "floating-point modulo by zero")
 				}
 				return xf.Mod(y), nil
 			}
@@ -1046,12 +1300,12 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 			switch y := y.(type) {
 			case Float:
 				if y == 0.0 {
-					return nil, fmt.Errorf("floating-point modulo by zero")
+					return nil, Errorf(ZeroDivision, "floating-point modulo by zero")
 				}
 				return x.Mod(y), nil
 			case Int:
 				if y.Sign() == 0 {
-					return nil, fmt.Errorf("floating-point modulo by zero")
+					return nil, Errorf(ZeroDivision, "floating-point modulo by zero")
 				}
 				yf, err := y.finiteFloat()
 				if err != nil {
@@ -1060,7 +1314,11 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 				return x.Mod(yf), nil
 			}
 		case String:
-			return interpolate(string(x), y)
+			z, err := interpolate(string(x), y)
+			if err == nil {
+				thread.countAlloc("string")
+			}
+			return z, err
 		}
 
 	case syntax.NOT_IN:
@@ -1102,7 +1360,7 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 		case String:
 			needle, ok := x.(String)
 			if !ok {
-				return nil, fmt.Errorf("'in <string>' requires string as left operand, not %s", x.Type())
+				return nil, Errorf(TypeError, "'in <string>' requires string as left operand, not %s", x.Type())
 			}
 			return Bool(strings.Contains(string(y), string(needle))), nil
 		case Bytes:
@@ -1112,16 +1370,16 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 			case Int:
 				var b byte
 				if err := AsInt(needle, &b); err != nil {
-					return nil, fmt.Errorf("int in bytes: %s", err)
+					return nil, withKind(ValueError, fmt.Errorf("int in bytes: %w", err))
 				}
 				return Bool(strings.IndexByte(string(y), b) >= 0), nil
 			default:
-				return nil, fmt.Errorf("'in bytes' requires bytes or int as left operand, not %s", x.Type())
+				return nil, Errorf(TypeError, "'in bytes' requires bytes or int as left operand, not %s", x.Type())
 			}
 		case rangeValue:
 			i, err := NumberToInt(x)
 			if err != nil {
-				return nil, fmt.Errorf("'in <range>' requires integer as left operand, not %s", x.Type())
+				return nil, Errorf(TypeError, "'in <range>' requires integer as left operand, not %s", x.Type())
 			}
 			return Bool(y.contains(i)), nil
 		}
@@ -1181,11 +1439,11 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 				return nil, err
 			}
 			if y < 0 {
-				return nil, fmt.Errorf("negative shift count: %v", y)
+				return nil, Errorf(ValueError, "negative shift count: %v", y)
 			}
 			if op == syntax.LTLT {
 				if y >= 512 {
-					return nil, fmt.Errorf("shift count too large: %v", y)
+					return nil, Errorf(Overflow, "shift count too large: %v", y)
 				}
 				return x.Lsh(uint(y)), nil
 			} else {
@@ -1215,20 +1473,20 @@ func Binary(thread *Thread, op syntax.Token, x, y Value) (Value, error) {
 
 	// unsupported operand types
 unknown:
-	return nil, fmt.Errorf("unknown binary op: %s %s %s", x.Type(), op, y.Type())
+	return nil, Errorf(TypeError, "unknown binary op: %s %s %s", x.Type(), op, y.Type())
 }
 
 // It's always possible to overeat in small bites but we'll
 // try to stop someone swallowing the world in one gulp.
 const maxAlloc = 1 << 30
 
-func tupleRepeat(elems Tuple, n Int) (Tuple, error) {
+func tupleRepeat(thread *Thread, elems Tuple, n Int) (Tuple, error) {
 	if len(elems) == 0 {
 		return nil, nil
 	}
 	i, err := AsInt32(n)
 	if err != nil {
-		return nil, fmt.Errorf("repeat count %s too large", n)
+		return nil, Errorf(Overflow, "repeat count %s too large", n)
 	}
 	if i < 1 {
 		return nil, nil
@@ -1237,7 +1495,10 @@ func tupleRepeat(elems Tuple, n Int) (Tuple, error) {
 	of, sz := bits.Mul(uint(len(elems)), uint(i))
 	if of != 0 || sz >= maxAlloc { // of != 0 => overflow
 		// Don't print sz.
-		return nil, fmt.Errorf("excessive repeat (%d * %d elements)", len(elems), i)
+		return nil, Errorf(Overflow, "excessive repeat (%d * %d elements)", len(elems), i)
+	}
+	if err := thread.AddAllocs(valueSize * int64(sz)); err != nil {
+		return nil, err
 	}
 	res := make([]Value, sz)
 	// copy elems into res, doubling each time
@@ -1249,18 +1510,18 @@ func tupleRepeat(elems Tuple, n Int) (Tuple, error) {
 	return res, nil
 }
 
-func bytesRepeat(b Bytes, n Int) (Bytes, error) {
-	res, err := stringRepeat(String(b), n)
+func bytesRepeat(thread *Thread, b Bytes, n Int) (Bytes, error) {
+	res, err := stringRepeat(thread, String(b), n)
 	return Bytes(res), err
 }
 
-func stringRepeat(s String, n Int) (String, error) {
+func stringRepeat(thread *Thread, s String, n Int) (String, error) {
 	if s == "" {
 		return "", nil
 	}
 	i, err := AsInt32(n)
 	if err != nil {
-		return "", fmt.Errorf("repeat count %s too large", n)
+		return "", Errorf(Overflow, "repeat count %s too large", n)
 	}
 	if i < 1 {
 		return "", nil
@@ -1269,7 +1530,10 @@ func stringRepeat(s String, n Int) (String, error) {
 	of, sz := bits.Mul(uint(len(s)), uint(i))
 	if of != 0 || sz >= maxAlloc { // of != 0 => overflow
 		// Don't print sz.
-		return "", fmt.Errorf("excessive repeat (%d * %d elements)", len(s), i)
+		return "", Errorf(Overflow, "excessive repeat (%d * %d elements)", len(s), i)
+	}
+	if err := thread.AddAllocs(int64(sz)); err != nil {
+		return "", err
 	}
 	return String(strings.Repeat(string(s), i)), nil
 }
@@ -1278,9 +1542,15 @@ func stringRepeat(s String, n Int) (String, error) {
 func Call(thread *Thread, fn Value, args Tuple, kwargs []Tuple) (Value, error) {
 	c, ok := fn.(Callable)
 	if !ok {
-		return nil, fmt.Errorf("invalid call of non-function (%s)", fn.Type())
+		return nil, Errorf(TypeError, "invalid call of non-function (%s)", fn.Type())
 	}
+	return thread.call(c, nil, args, kwargs)
+}
 
+// call calls c, which must be a *Builtin if recv is non-nil, in
+// which case recv is passed as the receiver of a method of a built-in
+// type, without the allocation of a bound method.
+func (thread *Thread) call(c Callable, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	// Allocate and push a new frame.
 	var fr *frame
 	// Optimization: use slack portion of thread.stack
@@ -1303,6 +1573,11 @@ func Call(thread *Thread, fn Value, args Tuple, kwargs []Tuple) (Value, error) {
 
 	fr.callable = c
 
+	report, start := thread.report, thread.Steps
+	if report != nil {
+		report.enter(thread)
+	}
+
 	thread.beginProfSpan()
 
 	// Use defer to ensure that panics from built-ins
@@ -1311,6 +1586,10 @@ func Call(thread *Thread, fn Value, args Tuple, kwargs []Tuple) (Value, error) {
 	defer func() {
 		thread.endProfSpan()
 
+		if report != nil {
+			report.exit(thread, start)
+		}
+
 		// clear out any references
 		// TODO(adonovan): opt: zero fr.Locals and
 		// reuse it if it is large enough.
@@ -1319,11 +1598,17 @@ func Call(thread *Thread, fn Value, args Tuple, kwargs []Tuple) (Value, error) {
 		thread.stack = thread.stack[:len(thread.stack)-1] // pop
 	}()
 
-	result, err := c.CallInternal(thread, args, kwargs)
+	var result Value
+	var err error
+	if recv != nil {
+		result, err = c.(*Builtin).callWith(thread, recv, args, kwargs)
+	} else {
+		result, err = c.CallInternal(thread, args, kwargs)
+	}
 
 	// Sanity check: nil is not a valid Starlark value.
 	if result == nil && err == nil {
-		err = fmt.Errorf("internal error: nil (not None) returned from %s", fn)
+		err = fmt.Errorf("internal error: nil (not None) returned from %s", c)
 	}
 
 	// Always return an EvalError with an accurate frame.
@@ -1336,10 +1621,17 @@ func Call(thread *Thread, fn Value, args Tuple, kwargs []Tuple) (Value, error) {
 	return result, err
 }
 
+// CallContext is like Call, but the call is cancelled when ctx is done.
+// See ExecFileContext.
+func CallContext(ctx context.Context, thread *Thread, fn Value, args Tuple, kwargs []Tuple) (_ Value, err error) {
+	defer thread.withContext(ctx)(&err)
+	return Call(thread, fn, args, kwargs)
+}
+
 func slice(thread *Thread, x, lo, hi, step_ Value) (Value, error) {
 	sliceable, ok := x.(Sliceable)
 	if !ok {
-		return nil, fmt.Errorf("invalid slice operand %s", x.Type())
+		return nil, Errorf(TypeError, "invalid slice operand %s", x.Type())
 	}
 
 	n := sliceable.Len()
@@ -1348,10 +1640,10 @@ func slice(thread *Thread, x, lo, hi, step_ Value) (Value, error) {
 		var err error
 		step, err = AsInt32(step_)
 		if err != nil {
-			return nil, fmt.Errorf("invalid slice step: %s", err)
+			return nil, withKind(TypeError, fmt.Errorf("invalid slice step: %w", err))
 		}
 		if step == 0 {
-			return nil, fmt.Errorf("zero is not a valid slice step")
+			return nil, Errorf(ValueError, "zero is not a valid slice step")
 		}
 	}
 
@@ -1377,7 +1669,7 @@ func slice(thread *Thread, x, lo, hi, step_ Value) (Value, error) {
 		// [n-1:-1-n:-1] because of the treatment of -ve values.
 		start = n - 1
 		if err := asIndex(lo, n, &start); err != nil {
-			return nil, fmt.Errorf("invalid start index: %s", err)
+			return nil, withKind(TypeError, fmt.Errorf("invalid start index: %w", err))
 		}
 		if start >= n {
 			start = n - 1
@@ -1385,7 +1677,7 @@ func slice(thread *Thread, x, lo, hi, step_ Value) (Value, error) {
 
 		end = -1
 		if err := asIndex(hi, n, &end); err != nil {
-			return nil, fmt.Errorf("invalid end index: %s", err)
+			return nil, withKind(TypeError, fmt.Errorf("invalid end index: %w", err))
 		}
 		if end < -1 {
 			end = -1
@@ -1396,7 +1688,12 @@ func slice(thread *Thread, x, lo, hi, step_ Value) (Value, error) {
 		}
 	}
 
-	return sliceable.Slice(thread, start, end, step), nil
+	res := sliceable.Slice(thread, start, end, step)
+	switch res.(type) {
+	case String, Bytes, Tuple:
+		thread.countAlloc(res.Type()) // lists are counted by NewList
+	}
+	return res, nil
 }
 
 // From Hacker's Delight, section 2.8.
@@ -1412,7 +1709,7 @@ func signum(x int) int     { return signum64(int64(x)) }
 func indices(start_, end_ Value, len int) (start, end int, err error) {
 	start = 0
 	if err := asIndex(start_, len, &start); err != nil {
-		return 0, 0, fmt.Errorf("invalid start index: %s", err)
+		return 0, 0, withKind(TypeError, fmt.Errorf("invalid start index: %w", err))
 	}
 	// Clamp to [0:len].
 	if start < 0 {
@@ -1423,7 +1720,7 @@ func indices(start_, end_ Value, len int) (start, end int, err error) {
 
 	end = len
 	if err := asIndex(end_, len, &end); err != nil {
-		return 0, 0, fmt.Errorf("invalid end index: %s", err)
+		return 0, 0, withKind(TypeError, fmt.Errorf("invalid end index: %w", err))
 	}
 	// Clamp to [0:len].
 	if end < 0 {
@@ -1476,7 +1773,7 @@ func setArgs(thread *Thread, locals []Value, fn *Function, args Tuple, kwargs []
 	// Nullary function?
 	if fn.NumParams() == 0 {
 		if nactual := len(args) + len(kwargs); nactual > 0 {
-			return fmt.Errorf("function %s accepts no arguments (%d given)", fn.Name(), nactual)
+			return Errorf(TypeError, "function %s accepts no arguments (%d given)", fn.Name(), nactual)
 		}
 		return nil
 	}
@@ -1508,7 +1805,7 @@ func setArgs(thread *Thread, locals []Value, fn *Function, args Tuple, kwargs []
 	n := len(args)
 	if len(args) > nonkwonly {
 		if !fn.HasVarargs() {
-			return fmt.Errorf("function %s accepts %s%d positional argument%s (%d given)",
+			return Errorf(TypeError, "function %s accepts %s%d positional argument%s (%d given)",
 				fn.Name(),
 				cond(len(fn.defaults) > fn.NumKwonlyParams(), "at most ", ""),
 				nonkwonly,
@@ -1538,18 +1835,18 @@ func setArgs(thread *Thread, locals []Value, fn *Function, args Tuple, kwargs []
 		k, v := pair[0].(String), pair[1]
 		if i := findParam(paramIdents, string(k)); i >= 0 {
 			if locals[i] != nil {
-				return fmt.Errorf("function %s got multiple values for parameter %s", fn.Name(), k)
+				return Errorf(TypeError, "function %s got multiple values for parameter %s", fn.Name(), k)
 			}
 			locals[i] = v
 			continue
 		}
 		if kwdict == nil {
-			return fmt.Errorf("function %s got an unexpected keyword argument %s", fn.Name(), k)
+			return Errorf(TypeError, "function %s got an unexpected keyword argument %s", fn.Name(), k)
 		}
 		oldlen := kwdict.len
 		kwdict.insert(k, v)
 		if kwdict.len == oldlen {
-			return fmt.Errorf("function %s got multiple values for parameter %s", fn.Name(), k)
+			return Errorf(TypeError, "function %s got multiple values for parameter %s", fn.Name(), k)
 		}
 	}
 
@@ -1579,13 +1876,106 @@ func setArgs(thread *Thread, locals []Value, fn *Function, args Tuple, kwargs []
 		}
 
 		if missing != nil {
-			return fmt.Errorf("function %s missing %d argument%s (%s)",
+			return Errorf(TypeError, "function %s missing %d argument%s (%s)",
 				fn.Name(), len(missing), cond(len(missing) > 1, "s", ""), strings.Join(missing, ", "))
 		}
 	}
+
+	if fn.funcode.Prog.CheckTypes && fn.funcode.ParamTypes != nil {
+		return checkArgs(fn, locals, nparams)
+	}
 	return nil
 }
 
+// checkArgs checks the values of the parameters of fn against their
+// annotated types. The first nparams parameters are ordinary ones.
+func checkArgs(fn *Function, locals []Value, nparams int) error {
+	for i, t := range fn.funcode.ParamTypes {
+		if t == nil {
+			continue
+		}
+		name := fn.funcode.Locals[i].Name
+		var elems []Value // values of *args or **kwargs
+		switch {
+		case i < nparams:
+			if !hasType(locals[i], t) {
+				return Errorf(TypeError, "function %s: for parameter %s: got %s, want %s", fn.Name(), name, locals[i].Type(), t)
+			}
+			continue
+		case i == nparams && fn.HasVarargs():
+			elems = locals[i].(Tuple)
+		default:
+			for _, item := range locals[i].(*Dict).ht.items() {
+				elems = append(elems, item[1])
+			}
+		}
+		for _, elem := range elems {
+			if !hasType(elem, t) {
+				return Errorf(TypeError, "function %s: for parameter %s: got %s element, want %s", fn.Name(), name, elem.Type(), t)
+			}
+		}
+	}
+	return nil
+}
+
+// hasType reports whether v is a value of type t (see resolve.Type).
+func hasType(v Value, t *resolve.Type) bool {
+	switch t.Name {
+	case "any":
+		return true
+	case "|":
+		for _, alt := range t.Args {
+			if hasType(v, alt) {
+				return true
+			}
+		}
+		return false
+	case "callable":
+		_, ok := v.(Callable)
+		return ok
+	case "float":
+		if _, ok := v.(Int); ok {
+			return true
+		}
+	}
+	if v.Type() != t.Name {
+		return false
+	}
+	if len(t.Args) == 0 {
+		return true
+	}
+	all := func(elems []Value, t *resolve.Type) bool {
+		for _, elem := range elems {
+			if !hasType(elem, t) {
+				return false
+			}
+		}
+		return true
+	}
+	switch v := v.(type) {
+	case *List:
+		return all(v.elems, t.Args[0])
+	case *Set:
+		return all(v.ht.keys(), t.Args[0])
+	case *Dict:
+		for _, item := range v.ht.items() {
+			if !hasType(item[0], t.Args[0]) || !hasType(item[1], t.Args[1]) {
+				return false
+			}
+		}
+	case Tuple:
+		if len(v) != len(t.Args) {
+			return false
+		}
+		for i, elem := range v {
+			if !hasType(elem, t.Args[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func findParam(params []compile.Binding, name string) int {
 	for i, param := range params {
 		if param.Name == name {
@@ -1624,21 +2014,21 @@ func interpolate(format string, x Value) (Value, error) {
 			format = format[1:]
 			j := strings.IndexByte(format, ')')
 			if j < 0 {
-				return nil, fmt.Errorf("incomplete format key")
+				return nil, Errorf(ValueError, "incomplete format key")
 			}
 			key := format[:j]
 			if dict, ok := x.(Mapping); !ok {
-				return nil, fmt.Errorf("format requires a mapping")
+				return nil, Errorf(TypeError, "format requires a mapping")
 			} else if v, found, _ := dict.Get(String(key)); found {
 				arg = v
 			} else {
-				return nil, fmt.Errorf("key not found: %s", key)
+				return nil, Errorf(KeyError, "key not found: %s", key)
 			}
 			format = format[j+1:]
 		} else {
 			// positional argument: %s.
 			if index >= nargs {
-				return nil, fmt.Errorf("not enough arguments for format string")
+				return nil, Errorf(TypeError, "not enough arguments for format string")
 			}
 			if tuple, ok := x.(Tuple); ok {
 				arg = tuple[index]
@@ -1655,7 +2045,7 @@ func interpolate(format string, x Value) (Value, error) {
 
 		// conversion type
 		if format == "" {
-			return nil, fmt.Errorf("incomplete format")
+			return nil, Errorf(ValueError, "incomplete format")
 		}
 		switch c := format[0]; c {
 		case 's', 'r':
@@ -1667,7 +2057,7 @@ func interpolate(format string, x Value) (Value, error) {
 		case 'd', 'i', 'o', 'x', 'X':
 			i, err := NumberToInt(arg)
 			if err != nil {
-				return nil, fmt.Errorf("%%%c format requires integer: %v", c, err)
+				return nil, withKind(TypeError, fmt.Errorf("%%%c format requires integer: %w", c, err))
 			}
 			switch c {
 			case 'd', 'i':
@@ -1682,7 +2072,7 @@ func interpolate(format string, x Value) (Value, error) {
 		case 'e', 'f', 'g', 'E', 'F', 'G':
 			f, ok := AsFloat(arg)
 			if !ok {
-				return nil, fmt.Errorf("%%%c format requires float, not %s", c, arg.Type())
+				return nil, Errorf(TypeError, "%%%c format requires float, not %s", c, arg.Type())
 			}
 			Float(f).format(buf, c)
 		case 'c':
@@ -1691,29 +2081,29 @@ func interpolate(format string, x Value) (Value, error) {
 				// chr(int)
 				r, err := AsInt32(arg)
 				if err != nil || r < 0 || r > unicode.MaxRune {
-					return nil, fmt.Errorf("%%c format requires a valid Unicode code point, got %s", arg)
+					return nil, Errorf(ValueError, "%%c format requires a valid Unicode code point, got %s", arg)
 				}
 				buf.WriteRune(rune(r))
 			case String:
 				r, size := utf8.DecodeRuneInString(string(arg))
 				if size != len(arg) || len(arg) == 0 {
-					return nil, fmt.Errorf("%%c format requires a single-character string")
+					return nil, Errorf(ValueError, "%%c format requires a single-character string")
 				}
 				buf.WriteRune(r)
 			default:
-				return nil, fmt.Errorf("%%c format requires int or single-character string, not %s", arg.Type())
+				return nil, Errorf(TypeError, "%%c format requires int or single-character string, not %s", arg.Type())
 			}
 		case '%':
 			buf.WriteByte('%')
 		default:
-			return nil, fmt.Errorf("unknown conversion %%%c", c)
+			return nil, Errorf(ValueError, "unknown conversion %%%c", c)
 		}
 		format = format[1:]
 		index++
 	}
 
 	if index < nargs && !is[Mapping](x) {
-		return nil, fmt.Errorf("too many arguments for format string")
+		return nil, Errorf(TypeError, "too many arguments for format string")
 	}
 
 	return String(buf.String()), nil
@@ -6,6 +6,7 @@ package starlark_test
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"math"
@@ -16,12 +17,14 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	gotime "time"
 
 	"go.starlark.net/internal/chunkedfile"
 	"go.starlark.net/lib/json"
 	starlarkmath "go.starlark.net/lib/math"
 	starlarkproto "go.starlark.net/lib/proto"
 	"go.starlark.net/lib/time"
+	"go.starlark.net/resolve"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
 	"go.starlark.net/starlarktest"
@@ -30,17 +33,21 @@ import (
 	"google.golang.org/protobuf/reflect/protodesc"
 
 	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/anypb"
 )
 
 // A test may enable non-standard options by containing (e.g.) "option:recursion".
 func getOptions(src string) *syntax.FileOptions {
 	return &syntax.FileOptions{
 		Set:               option(src, "set"),
+		Catch:             option(src, "catch"),
 		While:             option(src, "while"),
 		TopLevelControl:   option(src, "toplevelcontrol"),
 		GlobalReassign:    option(src, "globalreassign"),
 		LoadBindsGlobally: option(src, "loadbindsglobally"),
 		Recursion:         option(src, "recursion"),
+		CheckTypes:        option(src, "checktypes"),
+		FStrings:          option(src, "fstrings"),
 	}
 }
 
@@ -114,12 +121,22 @@ func TestEvalExpr(t *testing.T) {
 }
 
 func TestExecFile(t *testing.T) {
+	testExecFile(t, false)
+}
+
+// TestExecFileOptimized runs the same tests as TestExecFile
+// with the bytecode optimizer enabled.
+func TestExecFileOptimized(t *testing.T) {
+	testExecFile(t, true)
+}
+
+func testExecFile(t *testing.T, optimize bool) {
 	testdata := starlarktest.DataFile("starlark", ".")
 	thread := &starlark.Thread{Load: load}
 	starlarktest.SetReporter(thread, t)
 
 	// This proto is used for the proto.star tests. It's generated by running:
-	// protoc --descriptor_set_out=test.fds test.proto
+	// protoc --include_imports --descriptor_set_out=test.fds test.proto
 	data, err := os.ReadFile("testdata/proto/test.fds")
 	if err != nil {
 		t.Fatal(err)
@@ -139,6 +156,7 @@ func TestExecFile(t *testing.T) {
 		"testdata/bool.star",
 		"testdata/builtins.star",
 		"testdata/bytes.star",
+		"testdata/catch.star",
 		"testdata/cache.star",
 		"testdata/cache_dict.star",
 		"testdata/cache_list.star",
@@ -158,6 +176,8 @@ func TestExecFile(t *testing.T) {
 		"testdata/string.star",
 		"testdata/time.star",
 		"testdata/tuple.star",
+		"testdata/types.star",
+		"testdata/fstring.star",
 		"testdata/recursion.star",
 		"testdata/module.star",
 		"testdata/while.star",
@@ -173,6 +193,7 @@ func TestExecFile(t *testing.T) {
 			}
 
 			opts := getOptions(chunk.Source)
+			opts.Optimize = optimize
 			_, err := starlark.ExecFileOptions(opts, thread, filename, chunk.Source, predeclared)
 			switch err := err.(type) {
 			case *starlark.EvalError:
@@ -247,6 +268,189 @@ y = f()`
 	}
 }
 
+func TestIncrementalToplevel(t *testing.T) {
+	opts := &syntax.FileOptions{}
+	source := `
+a = s1()
+x = input("x").value
+b = x + s2()
+c = [a, b]
+`
+	predeclared := starlark.StringDict{
+		"input": starlark.InputBuiltin,
+		"s1":    &sneaky{},
+		"s2":    &sneaky{},
+	}
+	prog, err := starlark.PrepareExecFile(opts, "toplevel.star", source, predeclared)
+	if err != nil {
+		t.Fatalf("PrepareExecFile: %v", err)
+	}
+	thread := new(starlark.Thread)
+	for _, test := range []struct {
+		x    int
+		want string
+	}{
+		{10, "[1, 11]"},
+		{10, "[1, 11]"}, // nothing changed
+		{20, "[1, 22]"}, // only the statements that depend on x are rerun
+		{20, "[1, 22]"},
+	} {
+		inputs := starlark.StringDict{"x": starlark.MakeInt(test.x)}
+		globals, err := starlark.ExecPreparedProgram(thread, prog, inputs)
+		if err != nil {
+			t.Fatalf("Exec: %v", err)
+		}
+		if got := globals["c"].String(); got != test.want {
+			t.Errorf("x=%d: c = %s, want %s", test.x, got, test.want)
+		}
+	}
+}
+
+func TestIncrementalThreadLocal(t *testing.T) {
+	opts := &syntax.FileOptions{}
+	source := `
+def f():
+       return package() + str(s())
+
+y = f()`
+	predeclared := starlark.StringDict{
+		"package": starlark.NewBuiltin("package", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return starlark.String(thread.ReadLocal("package").(string)), nil
+		}),
+		"s": &sneaky{},
+	}
+	prog, err := starlark.PrepareExecFile(opts, "local.star", source, predeclared)
+	if err != nil {
+		t.Fatalf("PrepareExecFile: %v", err)
+	}
+	thread := new(starlark.Thread)
+	for _, test := range []struct {
+		pkg, want string
+	}{
+		{"a", "a1"},
+		{"a", "a1"}, // unchanged local: cache holds
+		{"b", "b2"}, // changed local busts the cache
+		{"b", "b2"}, // same value set again: cache holds
+	} {
+		thread.SetLocal("package", test.pkg)
+		globals, err := starlark.ExecPreparedProgram(thread, prog, nil)
+		if err != nil {
+			t.Fatalf("Exec: %v", err)
+		}
+		if got := globals["y"]; got != starlark.String(test.want) {
+			t.Errorf("package=%s: y = %s, want %q", test.pkg, got, test.want)
+		}
+	}
+}
+
+func TestIncrementalCacheKey(t *testing.T) {
+	opts := &syntax.FileOptions{}
+	source := `
+def f():
+       return s()
+
+y = f()`
+	predeclared := starlark.StringDict{"s": &sneaky{}}
+	prog, err := starlark.PrepareExecFile(opts, "key.star", source, predeclared)
+	if err != nil {
+		t.Fatalf("PrepareExecFile: %v", err)
+	}
+	thread := new(starlark.Thread)
+	for _, test := range []struct {
+		key  string
+		want int
+	}{
+		{"linux", 1},
+		{"linux", 1},
+		{"darwin", 2}, // new key: computed afresh
+		{"linux", 1},  // results for the old key are retained
+		{"darwin", 2},
+	} {
+		thread.SetCacheKey(test.key)
+		globals, err := starlark.ExecPreparedProgram(thread, prog, nil)
+		if err != nil {
+			t.Fatalf("Exec: %v", err)
+		}
+		if got, want := globals["y"], starlark.MakeInt(test.want); got != want {
+			t.Errorf("key=%s: y = %s, want %s", test.key, got, want)
+		}
+	}
+}
+
+// TestOwnerlessContainers checks that memoized results observe
+// mutations of lists, dicts, and sets created with no owner thread.
+func TestOwnerlessContainers(t *testing.T) {
+	xs := starlark.NewList(nil, []starlark.Value{starlark.MakeInt(1), starlark.MakeInt(2)})
+	var d starlark.Dict // the zero value is valid too
+	consts := starlark.NewSet(nil, 0)
+	if err := consts.Insert(starlark.String("c")); err != nil {
+		t.Fatal(err)
+	}
+	consts.Freeze()
+	predeclared := starlark.StringDict{"xs": xs, "d": &d, "consts": consts}
+
+	thread := new(starlark.Thread)
+	globals, err := starlark.ExecFile(thread, "ownerless.star", `
+def total():
+    n = len(d) + len(consts)
+    for x in xs:
+        n += x
+    return n
+
+def push(x):
+    xs.append(x)
+
+def put(k):
+    d[k] = None
+`, predeclared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(want int) {
+		t.Helper()
+		v, err := starlark.Call(thread, globals["total"], nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		} else if v != starlark.MakeInt(want) {
+			t.Errorf("total() = %s, want %d", v, want)
+		}
+	}
+	check(4)
+	check(4) // memoized
+
+	// Mutations by Go code invalidate the memoized result.
+	if err := xs.Append(starlark.MakeInt(4)); err != nil {
+		t.Fatal(err)
+	}
+	check(8)
+	if err := d.SetKey(starlark.String("k"), starlark.None); err != nil {
+		t.Fatal(err)
+	}
+	check(9)
+
+	// A mutation by Starlark code makes the thread the owner.
+	if _, err := starlark.Call(thread, globals["push"], starlark.Tuple{starlark.MakeInt(8)}, nil); err != nil {
+		t.Fatal(err)
+	}
+	check(17)
+	if err := xs.Append(starlark.MakeInt(16)); err != nil {
+		t.Fatal(err)
+	}
+	check(33)
+
+	// A mutation by another thread, which records it in its own
+	// cache, also invalidates the memoized result.
+	thread2 := new(starlark.Thread)
+	if _, err := starlark.Call(thread2, globals["push"], starlark.Tuple{starlark.MakeInt(32)}, nil); err != nil {
+		t.Fatal(err)
+	}
+	check(65)
+	if _, err := starlark.Call(thread2, globals["put"], starlark.Tuple{starlark.String("k2")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	check(66)
+}
+
 // A fib is an iterable value representing the infinite Fibonacci sequence.
 type fib struct{}
 
@@ -910,6 +1114,122 @@ def somefunc():
 	}
 }
 
+func TestSignature(t *testing.T) {
+	globals, err := starlark.ExecFile(&starlark.Thread{}, "sig.star", `
+def f(a, b=1, *args, c, d="x", **kwargs): pass
+def g(a, *, b=[]): pass
+def h(): pass
+`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"f": `f(a, b=1, *args, c, d="x", **kwargs)`,
+		"g": `g(a, *, b=[])`,
+		"h": `h()`,
+	} {
+		if got := globals[name].(*starlark.Function).Signature(); got != want {
+			t.Errorf("%s.Signature() = %s, want %s", name, got, want)
+		}
+	}
+
+	// The universal built-ins are documented.
+	sorted := starlark.Universe["sorted"].(*starlark.Builtin)
+	if got, want := sorted.Signature(), `sorted(x, *, key=None, reverse=False)`; got != want {
+		t.Errorf("sorted.Signature() = %s, want %s", got, want)
+	}
+	if !strings.HasPrefix(sorted.Doc(), "sorted returns") || len(sorted.Params()) != 4 {
+		t.Errorf("sorted: Doc() = %q, Params() = %v", sorted.Doc(), sorted.Params())
+	}
+	for name, v := range starlark.Universe {
+		if b, ok := v.(*starlark.Builtin); ok && b.Doc() == "" {
+			t.Errorf("universal built-in %s is not documented", name)
+		}
+	}
+
+	// Undocumented built-ins have an unknown signature,
+	// and bound methods retain their documentation.
+	undocumented := starlark.NewBuiltin("f", nil)
+	if got := undocumented.Signature(); got != "f(...)" {
+		t.Errorf("f.Signature() = %s", got)
+	}
+	for _, v := range []starlark.Value{starlark.String(""), starlark.Bytes(""), new(starlark.Dict), new(starlark.List), new(starlark.Set)} {
+		for _, name := range v.(starlark.HasAttrs).AttrNames() {
+			m, _ := v.(starlark.HasAttrs).Attr(name)
+			if b := m.(*starlark.Builtin); b.Doc() == "" || strings.HasSuffix(b.Signature(), "(...)") {
+				t.Errorf("%s.%s is not documented", v.Type(), name)
+			}
+		}
+	}
+	split, _ := starlark.String("a").Attr("split")
+	if got, want := split.(*starlark.Builtin).Signature(), "split(sep=None, maxsplit=-1, /)"; got != want {
+		t.Errorf("split.Signature() = %s, want %s", got, want)
+	}
+
+	// Default values may contain commas, parentheses, and "=".
+	f := starlark.NewBuiltin("f", nil).WithDoc(`f(a, sep=", ", pair=(1, 2), g=dict(k="=)"), *, b=..., **kwargs)`, "f is a test.")
+	if got, want := fmt.Sprint(f.Params()), `[{a } {sep ", "} {pair (1, 2)} {g dict(k="=)")} {* } {b ...} {**kwargs }]`; got != want {
+		t.Errorf("f.Params() = %s, want %s", got, want)
+	}
+	for _, sig := range []string{"f(x=)", "f(x=(1, 2)", "f(x y)", "f"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("WithDoc(%q) did not panic", sig)
+				}
+			}()
+			starlark.NewBuiltin("f", nil).WithDoc(sig, "")
+		}()
+	}
+	type repeatParams struct {
+		S string `starlark:"s"`
+		N int    `starlark:"n?"`
+	}
+	repeat := starlark.NewBuiltinFromParams(starlark.NewParams("repeat", repeatParams{N: 2}),
+		"repeat returns n copies of s.",
+		func(thread *starlark.Thread, b *starlark.Builtin, args repeatParams) (starlark.Value, error) {
+			return starlark.String(strings.Repeat(args.S, args.N)), nil
+		})
+	bound := repeat.BindReceiver(starlark.None)
+	if got, want := bound.Signature(), `repeat(s, n=2)`; got != want || bound.Doc() != repeat.Doc() {
+		t.Errorf("Signature() = %s, want %s; Doc() = %q", got, want, bound.Doc())
+	}
+	thread := new(starlark.Thread)
+	if v, err := starlark.Call(thread, repeat, starlark.Tuple{starlark.String("ab")}, nil); err != nil || v != starlark.String("abab") {
+		t.Errorf("repeat(\"ab\") = %v, %v", v, err)
+	}
+	if _, err := starlark.Call(thread, repeat, nil, nil); err == nil || err.Error() != "repeat: missing argument for s" {
+		t.Errorf("repeat() error = %v", err)
+	}
+
+	// WithDoc documents a copy, retaining the name.
+	indent := json.Module.Members["indent"].(*starlark.Builtin)
+	if got, want := indent.Signature(), `json.indent(str, /, *, prefix="", indent="\t")`; got != want {
+		t.Errorf("json.indent.Signature() = %s, want %s", got, want)
+	}
+	// WithDoc and WithCapability combine in either order.
+	write := starlark.NewBuiltinWithEffects("write", nil)
+	for _, b := range []*starlark.Builtin{
+		write.WithDoc("write(path, data)", "write writes a file.").WithCapability("fs.write"),
+		write.WithCapability("fs.write").WithDoc("write(path, data)", "write writes a file."),
+	} {
+		if b.Signature() != "write(path, data)" || b.Doc() == "" || b.Capability() != "fs.write" {
+			t.Errorf("Signature() = %s, Doc() = %q, Capability() = %q", b.Signature(), b.Doc(), b.Capability())
+		}
+	}
+	now := time.Module.Members["now"].(*starlark.Builtin)
+	if got := now.Signature(); got != "now()" || len(now.Params()) != 0 {
+		t.Errorf("time.now: Signature() = %s, Params() = %v", got, now.Params())
+	}
+	for _, m := range []*starlarkstruct.Module{json.Module, starlarkmath.Module, starlarkproto.Module, time.Module} {
+		for name, v := range m.Members {
+			if b, ok := v.(*starlark.Builtin); ok && b.Doc() == "" {
+				t.Errorf("%s.%s is not documented", m.Name, name)
+			}
+		}
+	}
+}
+
 func TestFrameLocals(t *testing.T) {
 	// trace prints a nice stack trace including argument
 	// values of calls to Starlark functions.
@@ -994,6 +1314,82 @@ func TestUnpackErrorBadType(t *testing.T) {
 	}
 }
 
+func TestParams(t *testing.T) {
+	type findParams struct {
+		Sub     string         `starlark:"sub"`
+		Start   int            `starlark:"start?"`
+		End     starlark.Value `starlark:"end??"`
+		Fold    bool           `starlark:"fold?"`
+		Options *starlark.Dict `starlark:"options?"`
+		cache   int
+	}
+	find := starlark.NewParams("find", findParams{Start: 1})
+	if got, want := find.Signature(), `find(sub, start=1, end=None, fold=False, options=...)`; got != want {
+		t.Errorf("Signature() = %s, want %s", got, want)
+	}
+	if name, optional := find.Param(1); find.NumParams() != 5 || name != "start" || !optional {
+		t.Errorf("Param(1) = %s, %t", name, optional)
+	}
+
+	args := starlark.Tuple{starlark.String("x")}
+	kwargs := []starlark.Tuple{{starlark.String("end"), starlark.None}, {starlark.String("fold"), starlark.True}}
+	p, err := find.Unpack(args, kwargs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Sub != "x" || p.Start != 1 || p.End != nil || !p.Fold || p.Options != nil {
+		t.Errorf("Unpack = %+v", p)
+	}
+	if _, err := find.Unpack(starlark.Tuple{starlark.MakeInt(1)}, nil); err == nil ||
+		err.Error() != "find: for parameter sub: got int, want string" {
+		t.Errorf("Unpack error = %v", err)
+	}
+
+	// Mistakes in the declaration are reported by NewParams.
+	for _, test := range []struct {
+		decl func()
+		want string
+	}{
+		{func() { starlark.NewParams("f", 1) }, `starlark.NewParams(f): got int, want struct type`},
+		{func() {
+			starlark.NewParams("f", struct {
+				X int
+			}{})
+		}, `starlark.NewParams(f): field X has no starlark tag`},
+		{func() {
+			starlark.NewParams("f", struct {
+				X int `starlark:"x y"`
+			}{})
+		}, `starlark.NewParams(f): field X has invalid parameter name "x y"`},
+		{func() {
+			starlark.NewParams("f", struct {
+				X int `starlark:"x"`
+				Y int `starlark:"x?"`
+			}{})
+		}, `starlark.NewParams(f): duplicate parameter x`},
+		{func() {
+			starlark.NewParams("f", struct {
+				X float32 `starlark:"x"`
+			}{})
+		}, `starlark.NewParams(f): parameter x has unsupported type float32`},
+		{func() {
+			starlark.NewParams("f", struct {
+				X int `starlark:"x?"`
+				Y int `starlark:"y"`
+			}{})
+		}, `starlark.NewParams(f): required parameter y follows optional parameter x`},
+	} {
+		func() {
+			defer func() {
+				if got := fmt.Sprint(recover()); got != test.want {
+					t.Errorf("NewParams panicked with %q, want %q", got, test.want)
+				}
+			}()
+			test.decl()
+		}()
+	}
+}
+
 // Regression test for github.com/google/starlark-go/issues/233.
 func TestREPLChunk(t *testing.T) {
 	thread := new(starlark.Thread)
@@ -1017,6 +1413,20 @@ func TestREPLChunk(t *testing.T) {
 	if got, want := fmt.Sprintf("%v %v", globals["x"], globals["y"]), "1 1"; got != want {
 		t.Fatalf("chunk2: got %s, want %s", got, want)
 	}
+
+	// Predeclared names are visible, but hidden by globals,
+	// and are not added to the globals.
+	predeclared := starlark.StringDict{"p": starlark.MakeInt(10), "y": starlark.MakeInt(100)}
+	f, err := syntax.Parse("<repl>", "z = p + y", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := starlark.ExecREPLChunkPredeclared(f, thread, globals, predeclared); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprintf("%v %v", globals["z"], globals["p"]), "11 <nil>"; got != want {
+		t.Fatalf("chunk3: got %s, want %s", got, want)
+	}
 }
 
 func TestCancel(t *testing.T) {
@@ -1051,6 +1461,239 @@ func TestCancel(t *testing.T) {
 	}
 }
 
+func TestCancelContext(t *testing.T) {
+	// A context that is already done cancels execution before it begins.
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		thread := new(starlark.Thread)
+		_, err := starlark.ExecFileContext(ctx, &syntax.FileOptions{}, thread, "precancel.star", `x = 1//0`, nil)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("execution returned error %q, want context.Canceled", err)
+		}
+		if fmt.Sprint(err) != "Starlark computation cancelled: context canceled" {
+			t.Errorf("execution returned error %q, want cancellation", err)
+		}
+	}
+	// A deadline interrupts a long computation.
+	{
+		ctx, cancel := context.WithTimeout(context.Background(), 10*gotime.Millisecond)
+		defer cancel()
+		thread := new(starlark.Thread)
+		_, err := starlark.ExecFileContext(ctx, &syntax.FileOptions{}, thread, "loop.star", `
+def f():
+    for x in range(1 << 60):
+        pass
+f()
+`, nil)
+		var evalErr *starlark.EvalError
+		if !errors.As(err, &evalErr) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("execution returned error %q, want EvalError wrapping context.DeadlineExceeded", err)
+		}
+	}
+	// Built-ins can obtain the context of the call.
+	{
+		type key struct{}
+		ctx := context.WithValue(context.Background(), key{}, "hello")
+		thread := new(starlark.Thread)
+		getvalue := starlark.NewBuiltin("getvalue", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			v, _ := thread.Context().Value(key{}).(string)
+			return starlark.String(v), nil
+		})
+		v, err := starlark.CallContext(ctx, thread, getvalue, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != starlark.String("hello") {
+			t.Errorf("getvalue() = %v, want hello", v)
+		}
+		// The context applies only during the call.
+		if thread.Context() != context.Background() {
+			t.Errorf("thread.Context() after CallContext = %v, want Background", thread.Context())
+		}
+		if _, err := starlark.Call(thread, getvalue, nil, nil); err != nil {
+			t.Errorf("Call after CallContext: %v", err)
+		}
+	}
+	// A context that is done only after a successful call completes
+	// does not leave the thread cancelled.
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		thread := new(starlark.Thread)
+		stop := starlark.NewBuiltin("stop", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			cancel()
+			return starlark.None, nil
+		})
+		if _, err := starlark.CallContext(ctx, thread, stop, nil, nil); err != nil {
+			t.Fatalf("CallContext: %v", err)
+		}
+		gotime.Sleep(gotime.Millisecond) // let a late cancellation happen
+		if _, err := starlark.ExecFile(thread, "after.star", `x = 1`, nil); err != nil {
+			t.Fatalf("ExecFile after CallContext: %v", err)
+		}
+	}
+}
+
+// TestMethodCallAllocs checks that a call x.f(...) of a method of a
+// built-in type does not allocate a bound method: it allocates no more
+// than a call of a method bound beforehand.
+func TestMethodCallAllocs(t *testing.T) {
+	allocs := func(call string) float64 {
+		src := "d = {}\nget = d.get\nfor i in range(100):\n    " + call + "\n"
+		_, prog, err := starlark.SourceProgramOptions(&syntax.FileOptions{TopLevelControl: true}, "methods.star", src, func(string) bool { return false })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return testing.AllocsPerRun(10, func() {
+			if _, err := prog.Init(new(starlark.Thread), nil); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	if method, bound := allocs("d.get(0)"), allocs("get(0)"); method > bound {
+		t.Errorf("d.get(0) in a loop made %v allocations, get(0) %v", method, bound)
+	}
+}
+
+func TestMaxAllocs(t *testing.T) {
+	for _, src := range []string{
+		`"x" * 100000000`,
+		`[0] * 100000000`,
+		`list(range(100000000))`,
+		`x = [i for i in range(100000000)]`,
+		`x = []
+for i in range(100000000): x.append(i)`,
+		`x = {}
+for i in range(100000000): x[i] = i`,
+		`def f():
+    x = ""
+    for i in range(100000000):
+        x += "abc"
+f()`,
+		`x = "x" * 2000; y = x.replace("x", x)`,
+		`json.decode("[" + ",".join(["\"abcdefghij\""] * 100000) + "]")`,
+		// Containers with no owner are charged to the mutating thread.
+		`for i in range(100000000): d[i] = i`,
+		`for i in range(100000000): s.add(i)`,
+		`for i in range(100000000): xs.append(i)`,
+	} {
+		thread := new(starlark.Thread)
+		thread.SetMaxAllocs(1 << 20)
+		predeclared := starlark.StringDict{
+			"json": json.Module,
+			"d":    starlark.NewDict(nil, 0),
+			"s":    starlark.NewSet(nil, 0),
+			"xs":   starlark.NewList(nil, nil),
+		}
+		_, err := starlark.ExecFileOptions(&syntax.FileOptions{TopLevelControl: true}, thread, "allocs.star", src, predeclared)
+		var limitErr *starlark.AllocLimitError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%s: got error %v, want AllocLimitError", src, err)
+			continue
+		}
+		if limitErr.Limit != 1<<20 {
+			t.Errorf("%s: error reports limit %d, want %d", src, limitErr.Limit, 1<<20)
+		}
+		if thread.Allocs > 1<<20 {
+			t.Errorf("%s: thread allocated %d bytes before failing", src, thread.Allocs)
+		}
+
+		// The thread is cancelled.
+		_, err = starlark.ExecFile(thread, "after.star", `x = 1`, nil)
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%s: after exceeding limit, got error %v, want AllocLimitError", src, err)
+		}
+	}
+
+	// Execution within the limit succeeds, and is accounted.
+	thread := new(starlark.Thread)
+	thread.SetMaxAllocs(1 << 20)
+	if _, err := starlark.ExecFile(thread, "small.star", `x = [str(i) for i in range(1000)]`, nil); err != nil {
+		t.Fatal(err)
+	}
+	if thread.Allocs == 0 {
+		t.Errorf("thread.Allocs = 0 after allocating a list")
+	}
+}
+
+// debugRecorder is a Debugger that records a line for each event.
+type debugRecorder struct{ events []string }
+
+func (r *debugRecorder) Line(thread *starlark.Thread) {
+	fr := thread.DebugFrame(0)
+	r.events = append(r.events, fmt.Sprintf("%s:%d depth=%d", fr.Callable().Name(), fr.Position().Line, thread.CallStackDepth()))
+}
+
+func (r *debugRecorder) Exception(thread *starlark.Thread, err error) {
+	fr := thread.DebugFrame(0)
+	r.events = append(r.events, fmt.Sprintf("%s:%d error: %v", fr.Callable().Name(), fr.Position().Line, err))
+}
+
+func TestDebugger(t *testing.T) {
+	const src = `
+def f(x):
+    y = x + 1
+    return y
+
+a = f(1)
+b = f(1)
+def g():
+    return len(None)
+c = [g()]
+`
+	thread := new(starlark.Thread)
+	rec := new(debugRecorder)
+	thread.SetDebugger(rec)
+	_, err := starlark.ExecFile(thread, "debug.star", src, nil)
+	if err == nil {
+		t.Fatal("ExecFile succeeded unexpectedly")
+	}
+	got := strings.Join(rec.events, "\n")
+	want := `<toplevel>:2 depth=1
+<toplevel>:6 depth=1
+f:3 depth=2
+f:4 depth=2
+<toplevel>:7 depth=1
+f:3 depth=2
+f:4 depth=2
+<toplevel>:8 depth=1
+<toplevel>:10 depth=1
+g:8 depth=2
+g:9 depth=2
+g:9 error: len: value of type NoneType has no len`
+	if got != want {
+		t.Errorf("debugger events:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The debugger may inspect locals and globals of each frame.
+	thread = new(starlark.Thread)
+	var locals, globals string
+	thread.SetDebugger(debugFunc(func(thread *starlark.Thread) {
+		fr := thread.DebugFrame(0)
+		if fr.Callable().Name() == "f" && fr.Position().Line == 4 {
+			for i := 0; i < fr.NumLocals(); i++ {
+				b, v := fr.Local(i)
+				locals += fmt.Sprintf("%s=%v ", b.Name, v)
+			}
+			globals = fmt.Sprint(fr.Globals())
+		}
+	}))
+	if _, err := starlark.ExecFile(thread, "debug.star", "a = 1\ndef f(x):\n    y = x + 1\n    return y\nb = f(a)\n", nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "x=1 y=2 "; locals != want {
+		t.Errorf("locals = %q, want %q", locals, want)
+	}
+	if !strings.Contains(globals, "a: 1") {
+		t.Errorf("globals = %s, want a=1", globals)
+	}
+}
+
+type debugFunc func(thread *starlark.Thread)
+
+func (f debugFunc) Line(thread *starlark.Thread)                 { f(thread) }
+func (f debugFunc) Exception(thread *starlark.Thread, err error) {}
+
 func TestExecutionSteps(t *testing.T) {
 	// A Thread records the number of computation steps.
 	thread := new(starlark.Thread)
@@ -1243,3 +1886,320 @@ func TestUnpackArgsOptionalInference(t *testing.T) {
 		t.Errorf("got %s, want %s", got, want)
 	}
 }
+
+func TestCatch(t *testing.T) {
+	// catch requires the Catch file option.
+	_, err := starlark.ExecFileOptions(&syntax.FileOptions{}, new(starlark.Thread), "catch.star", `catch(len)`, nil)
+	if err == nil || !strings.Contains(err.Error(), "dialect does not support catch") {
+		t.Errorf("got error %v, want dialect error", err)
+	}
+
+	// Cancellation is not caught.
+	opts := &syntax.FileOptions{Catch: true, While: true}
+	thread := new(starlark.Thread)
+	thread.SetMaxExecutionSteps(1000)
+	_, err = starlark.ExecFileOptions(opts, thread, "catch.star", `
+def loop():
+    while True:
+        pass
+
+catch(loop)
+`, nil)
+	if err == nil || !strings.Contains(err.Error(), "too many steps") {
+		t.Errorf("got error %v, want cancellation", err)
+	}
+
+	// An uncaught structured failure can be inspected by the host.
+	thread = new(starlark.Thread)
+	_, err = starlark.ExecFileOptions(opts, thread, "catch.star", `
+fail("bad", cause = catch(fail, "worse"), details = 42)
+`, nil)
+	var failErr *starlark.FailError
+	if !errors.As(err, &failErr) {
+		t.Fatalf("got error %v, want FailError", err)
+	}
+	if failErr.Msg != "fail: bad" || failErr.Details != starlark.MakeInt(42) || failErr.Cause.Message() != "fail: worse" {
+		t.Errorf("got %+v", failErr)
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	for _, test := range []struct {
+		src  string
+		want starlark.ErrorKind
+	}{
+		{`{[]: 1}`, starlark.TypeError},
+		{`1 + "a"`, starlark.TypeError},
+		{`len(1)`, starlark.TypeError},
+		{`"abc".index(1)`, starlark.TypeError},
+		{`"abc".index("d")`, starlark.ValueError},
+		{`int("x")`, starlark.ValueError},
+		{`{}["k"]`, starlark.KeyError},
+		{`{}.pop("k")`, starlark.KeyError},
+		{`[][0]`, starlark.IndexError},
+		{`[1].pop(2)`, starlark.IndexError},
+		{`(1).foo`, starlark.AttributeError},
+		{`getattr(1, "foo")`, starlark.AttributeError},
+		{`1 // 0`, starlark.ZeroDivision},
+		{`math.log(1, 1)`, starlark.ZeroDivision},
+		{`float(1 << 1100)`, starlark.Overflow},
+		{`"x" * (1 << 40)`, starlark.Overflow},
+		{`"%d" % "x"`, starlark.TypeError},
+		{`json.decode("[")`, starlark.ValueError},
+		{`json.encode({1: 2})`, starlark.TypeError},
+		{`time.parse_duration(1.5)`, starlark.TypeError},
+		{`time.parse_duration("1x")`, starlark.ValueError},
+		{`proto.marshal(Any(type_url="\u00e9"[:1]))`, starlark.ValueError},
+		{`proto.marshal_text(Any(type_url="\u00e9"[:1]))`, starlark.ValueError},
+		{`proto.marshal_json(Any(type_url="nope"))`, starlark.ValueError},
+		{`[].append(1, 2)`, starlark.TypeError},
+		{`fail("x")`, starlark.UnknownError},
+	} {
+		predeclared := starlark.StringDict{
+			"json":  json.Module,
+			"math":  starlarkmath.Module,
+			"time":  time.Module,
+			"proto": starlarkproto.Module,
+			"Any":   starlarkproto.MessageDescriptor{Desc: (&anypb.Any{}).ProtoReflect().Descriptor()},
+		}
+		_, err := starlark.ExecFile(new(starlark.Thread), "kinds.star", test.src, predeclared)
+		if err == nil {
+			t.Errorf("%s: succeeded unexpectedly", test.src)
+			continue
+		}
+		if got := starlark.ErrorKindOf(err); got != test.want {
+			t.Errorf("%s: got %v (%v), want %v", test.src, got, err, test.want)
+		}
+		var kerr *starlark.KindError
+		if ok := errors.As(err, &kerr); ok != (test.want != starlark.UnknownError) {
+			t.Errorf("%s: errors.As(err, &KindError) = %t", test.src, ok)
+		}
+	}
+
+	// Cancellation, including by a limit, is reported as Cancelled.
+	thread := new(starlark.Thread)
+	thread.SetMaxExecutionSteps(100)
+	_, err := starlark.ExecFile(thread, "kinds.star", `[x for x in range(1000)]`, nil)
+	if got := starlark.ErrorKindOf(err); got != starlark.Cancelled {
+		t.Errorf("too many steps: got %v (%v), want Cancelled", got, err)
+	}
+	thread = new(starlark.Thread)
+	thread.SetMaxAllocs(1000)
+	_, err = starlark.ExecFile(thread, "kinds.star", `x = "x" * 10000`, nil)
+	var limitErr *starlark.AllocLimitError
+	if got := starlark.ErrorKindOf(err); got != starlark.Cancelled || !errors.As(err, &limitErr) {
+		t.Errorf("alloc limit: got %v (%v), want Cancelled AllocLimitError", got, err)
+	}
+}
+
+func TestExecFileWithReport(t *testing.T) {
+	const src = `
+load("lib", "k")
+
+def square(x):
+    return x * x
+
+def build(n):
+    return [square(i) for i in range(n)]
+
+big = build(100)
+again = [square(1), square(1)]
+d = {i: k for i in range(3)}
+t = (1, 2)
+`
+	exec := func() *starlark.Report {
+		thread := &starlark.Thread{
+			Load: func(_ *starlark.Thread, module string) (starlark.StringDict, error) {
+				return starlark.StringDict{"k": starlark.String(module)}, nil
+			},
+		}
+		_, report, err := starlark.ExecFileWithReport(&syntax.FileOptions{}, thread, "report.star", src, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Steps != thread.Steps {
+			t.Errorf("report.Steps = %d, want %d", report.Steps, thread.Steps)
+		}
+		return report
+	}
+	report := exec()
+
+	var steps, hits uint64
+	calls := make(map[string]uint64)
+	for _, fn := range report.Functions {
+		steps += fn.Steps
+		hits += fn.MemoHits
+		calls[fn.Name] = fn.Calls
+	}
+	if steps != report.Steps {
+		t.Errorf("sum of function steps = %d, want %d", steps, report.Steps)
+	}
+	if want := map[string]uint64{"<toplevel>": 1, "build": 1, "square": 102}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	// Whether square(1) is memoized depends on whether other
+	// threads have read owner-less containers, so we check only
+	// that the totals agree.
+	if report.MemoHits != hits || hits > 2 {
+		t.Errorf("MemoHits = %d, sum of function MemoHits = %d, want at most 2", report.MemoHits, hits)
+	}
+	if report.MaxDepth != 3 { // <toplevel>, build, square
+		t.Errorf("MaxDepth = %d, want 3", report.MaxDepth)
+	}
+	if got := report.Allocations["list"]; got != 2 {
+		t.Errorf("Allocations[list] = %d, want 2", got)
+	}
+	if got := report.Allocations["dict"]; got != 1 {
+		t.Errorf("Allocations[dict] = %d, want 1", got)
+	}
+	if len(report.Containers) == 0 {
+		t.Fatal("no containers reported")
+	}
+	if c := report.Containers[0]; c.Type != "list" || c.Len != 100 || c.Pos.Line != 8 {
+		t.Errorf("largest container = %+v, want list of 100 at line 8", c)
+	}
+	if want := []string{"lib"}; !reflect.DeepEqual(report.Loads, want) {
+		t.Errorf("Loads = %v, want %v", report.Loads, want)
+	}
+
+	// The report is deterministic.
+	if again := exec(); !reflect.DeepEqual(again, report) {
+		t.Errorf("reports differ:\n%+v\n%+v", report, again)
+	}
+
+	// Strings and lists created by built-in functions and operators
+	// other than literals are counted too.
+	_, report, err := starlark.ExecFileWithReport(&syntax.FileOptions{}, new(starlark.Thread), "alloc.star", `
+a = str(12)
+b = ",".join(["x", "y"])
+c = "%d" % 1
+d = a[1:]
+e = sorted((2, 1))
+f = [x for x in "ab".elems()]
+`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := report.Allocations, map[string]uint64{"string": 4, "list": 3, "tuple": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Allocations = %v, want %v", got, want)
+	}
+
+	// Top-level statements of an incremental program, executed by a
+	// load on the same thread, count as memo hits when skipped.
+	// The second load changes the input, so that the program is
+	// executed again, but its first statement is skipped.
+	prepared, err := starlark.PrepareExecFile(&syntax.FileOptions{}, "lib.star", "a = 1\nb = input(\"x\").value\n",
+		starlark.StringDict{"input": starlark.InputBuiltin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	thread := &starlark.Thread{
+		Load: func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+			return starlark.ExecPreparedProgram(thread, prepared, starlark.StringDict{"x": starlark.String(module)})
+		},
+	}
+	_, report, err = starlark.ExecFileWithReport(&syntax.FileOptions{}, thread, "main.star", `
+load("first", "a")
+load("second", "b")
+`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.MemoHits != 1 {
+		t.Errorf("MemoHits = %d, want 1 (the first statement of the second load)", report.MemoHits)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	read := starlark.NewBuiltin("read", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		return starlark.String("data"), nil
+	}).WithCapability("fs.read")
+	fs := &starlarkstruct.Module{Name: "fs", Members: starlark.StringDict{"read": read}}
+	predeclared := starlark.StringDict{"fs": fs, "time": time.Module}
+
+	const src = `
+def f():
+    return fs.read()
+
+x = [len("a"), f()]
+`
+	f, err := (&syntax.FileOptions{}).Parse("caps.star", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resolve.File(f, predeclared.Has, starlark.Universe.Has); err != nil {
+		t.Fatal(err)
+	}
+	caps := resolve.Capabilities(f, starlark.CapabilityFunc(predeclared))
+	if want := []string{"fs.read"}; !reflect.DeepEqual(caps, want) {
+		t.Errorf("Capabilities = %v, want %v", caps, want)
+	}
+
+	for _, test := range []struct {
+		grant []string // nil => don't call Grant
+		err   string
+	}{
+		{nil, ""},
+		{[]string{"fs.read"}, ""},
+		{[]string{"fs"}, ""},
+		{[]string{"fs.write", "net"}, `read: capability "fs.read" not granted`},
+		{[]string{}, `read: capability "fs.read" not granted`},
+	} {
+		thread := new(starlark.Thread)
+		if test.grant != nil {
+			thread.Grant(test.grant...)
+		}
+		_, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, "caps.star", src, predeclared)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("Grant(%q): %v", test.grant, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Grant(%q): got error %v, want %q", test.grant, err, test.err)
+		} else if kind := starlark.ErrorKindOf(err); kind != starlark.PermissionDenied {
+			t.Errorf("Grant(%q): got error of kind %v, want PermissionDenied", test.grant, kind)
+		}
+	}
+
+	// A memoized result does not bypass a later call to Grant.
+	_, prog, err := starlark.SourceProgramOptions(&syntax.FileOptions{}, "caps.star", "def g(n): return fs.read()\nx = g(1)\n", predeclared.Has)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thread := new(starlark.Thread)
+	if _, err := prog.Init(thread, predeclared); err != nil {
+		t.Fatal(err)
+	}
+	thread.Grant()
+	if _, err := prog.Init(thread, predeclared); starlark.ErrorKindOf(err) != starlark.PermissionDenied {
+		t.Errorf("after Grant(), got error %v, want PermissionDenied", err)
+	}
+
+	// Nor does a memoized result of its caller.
+	_, prog, err = starlark.SourceProgramOptions(&syntax.FileOptions{}, "caps.star", "def g(): return fs.read()\ndef h(): return g()\n", predeclared.Has)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thread = new(starlark.Thread)
+	globals, err := prog.Init(thread, predeclared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := starlark.Call(thread, globals["h"], nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	thread.Grant()
+	if _, err := starlark.Call(thread, globals["h"], nil, nil); starlark.ErrorKindOf(err) != starlark.PermissionDenied {
+		t.Errorf("h() after Grant(): got error %v, want PermissionDenied", err)
+	}
+
+	// Library and universal functions declare their capabilities.
+	capabilityOf := starlark.CapabilityFunc(predeclared)
+	for name, want := range map[string]string{"print": "print", "time.now": "time.now", "len": "", "time.parse_time": ""} {
+		if got := capabilityOf(name); got != want {
+			t.Errorf("capability of %s = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package starlark
+
+// This file defines the formatting of the replacement fields of f-strings.
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// formatField returns the text of the f-string replacement field
+// {x!conv:spec}, where conv is 's', 'r', or 0 for no conversion.
+//
+// The format specification follows Python's mini-language:
+//
+//	[[fill]align][sign][#][0][width][grouping][.precision][type]
+//
+// An int may be formatted using the types b, c, d, n, o, x, and X,
+// or any of the float types; a float using e, E, f, F, g, G, n, and %.
+// All other values, and the results of conversions, are formatted as
+// strings, by str; they accept only the type s, and a precision
+// truncates them.
+func formatField(thread *Thread, x Value, conv byte, spec string) (String, error) {
+	switch conv {
+	case 'r':
+		x = String(x.String())
+	case 's':
+		x = String(toStr(x))
+	}
+	if spec == "" {
+		return String(toStr(x)), nil
+	}
+	fs, ok := parseFormatSpec(spec)
+	if !ok {
+		return "", Errorf(ValueError, "invalid format specification %q", spec)
+	}
+	switch x := x.(type) {
+	case Int:
+		return fs.formatInt(thread, x)
+	case Float:
+		return fs.formatFloat(thread, float64(x))
+	}
+	return fs.formatString(thread, x.Type(), toStr(x))
+}
+
+// toStr returns the text of a value, as by the str function.
+func toStr(x Value) string {
+	if s, ok := AsString(x); ok {
+		return s
+	}
+	return x.String()
+}
+
+// A formatSpec is a parsed format specification.
+type formatSpec struct {
+	spec      string
+	fill      rune
+	align     byte // one of "<>^=", or 0
+	sign      byte // one of "+- ", or 0
+	alt       bool // '#': add a base prefix to an int
+	zero      bool // '0': pad numbers with zeros after the sign
+	width     int
+	grouping  byte // ',' or '_', or 0
+	precision int  // -1 if none
+	typ       byte // 0 if none
+}
+
+// parseFormatSpec parses a format specification.
+func parseFormatSpec(spec string) (*formatSpec, bool) {
+	fs := &formatSpec{spec: spec, fill: ' ', precision: -1}
+	s := spec
+	isAlign := func(c byte) bool { return strings.IndexByte("<>^=", c) >= 0 }
+	if r, size := utf8.DecodeRuneInString(s); size < len(s) && isAlign(s[size]) {
+		fs.fill, fs.align = r, s[size]
+		s = s[size+1:]
+	} else if len(s) > 0 && isAlign(s[0]) {
+		fs.align = s[0]
+		s = s[1:]
+	}
+	if len(s) > 0 && strings.IndexByte("+- ", s[0]) >= 0 {
+		fs.sign = s[0]
+		s = s[1:]
+	}
+	if len(s) > 0 && s[0] == '#' {
+		fs.alt = true
+		s = s[1:]
+	}
+	if len(s) > 0 && s[0] == '0' {
+		fs.zero = true
+		s = s[1:]
+	}
+	digits := func() (int, bool) {
+		i := 0
+		for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+			i++
+		}
+		n, err := strconv.Atoi(s[:i])
+		s = s[i:]
+		return n, err == nil
+	}
+	if len(s) > 0 && '0' <= s[0] && s[0] <= '9' {
+		var ok bool
+		if fs.width, ok = digits(); !ok {
+			return nil, false
+		}
+	}
+	if len(s) > 0 && (s[0] == ',' || s[0] == '_') {
+		fs.grouping = s[0]
+		s = s[1:]
+	}
+	if len(s) > 0 && s[0] == '.' {
+		s = s[1:]
+		var ok bool
+		if fs.precision, ok = digits(); !ok {
+			return nil, false
+		}
+	}
+	if len(s) > 0 && strings.IndexByte("bcdoxXneEfFgG%s", s[0]) >= 0 {
+		fs.typ = s[0]
+		s = s[1:]
+	}
+	return fs, s == ""
+}
+
+func (fs *formatSpec) unknownType(typ string) error {
+	return Errorf(ValueError, "unknown format code '%c' for %s", fs.typ, typ)
+}
+
+// formatInt formats an int.
+func (fs *formatSpec) formatInt(thread *Thread, x Int) (String, error) {
+	base := 10
+	switch fs.typ {
+	case 0, 'd', 'n':
+	case 'b':
+		base = 2
+	case 'o':
+		base = 8
+	case 'x', 'X':
+		base = 16
+	case 'c':
+		i, ok := x.Int64()
+		if !ok || i < 0 || i > unicode.MaxRune || fs.sign != 0 || fs.alt || fs.grouping != 0 {
+			return "", Errorf(ValueError, "invalid format specification %q for int %s", fs.spec, x)
+		}
+		fs.typ = 0
+		return fs.formatString(thread, "int", string(rune(i)))
+	case 's':
+		return "", fs.unknownType("int")
+	default:
+		return fs.formatFloat(thread, float64(x.Float()))
+	}
+	if fs.precision >= 0 {
+		return "", Errorf(ValueError, "precision not allowed in integer format specification")
+	}
+	if fs.grouping == ',' && base != 10 {
+		return "", Errorf(ValueError, "cannot specify ',' with '%c'", fs.typ)
+	}
+
+	i := x.BigInt()
+	sign := fs.signOf(i.Sign() < 0)
+	digits := new(big.Int).Abs(i).Text(base)
+	if fs.typ == 'X' {
+		digits = strings.ToUpper(digits)
+	}
+	if fs.grouping != 0 {
+		n := 3
+		if base != 10 {
+			n = 4
+		}
+		digits = group(digits, fs.grouping, n)
+	}
+	if fs.alt && base != 10 {
+		sign += "0" + string(fs.typ)
+	}
+	return fs.pad(thread, sign, digits, true)
+}
+
+// formatFloat formats a float, or an int with a float type.
+func (fs *formatSpec) formatFloat(thread *Thread, f float64) (String, error) {
+	typ := fs.typ
+	switch typ {
+	case 0:
+		if fs.precision < 0 {
+			break
+		}
+		typ = 'g'
+	case 'n':
+		typ = 'g'
+	case 'e', 'E', 'f', 'F', 'g', 'G', '%':
+	default:
+		return "", fs.unknownType("float")
+	}
+	prec := fs.precision
+	if prec < 0 {
+		prec = 6
+	}
+
+	sign := fs.signOf(math.Signbit(f) && !math.IsNaN(f))
+	f = math.Abs(f)
+	var digits string
+	switch {
+	case math.IsInf(f, 0):
+		digits = "inf"
+	case math.IsNaN(f):
+		digits = "nan"
+	case typ == 0:
+		digits = Float(f).String()
+	case typ == '%':
+		digits = strconv.FormatFloat(f*100, 'f', prec, 64) + "%"
+	case typ == 'F':
+		digits = strconv.FormatFloat(f, 'f', prec, 64)
+	default:
+		digits = strconv.FormatFloat(f, typ, prec, 64)
+	}
+	if typ == 'E' || typ == 'F' || typ == 'G' {
+		digits = strings.ToUpper(digits)
+	}
+	if fs.grouping != 0 {
+		// Group the digits of the integer part.
+		i := strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' })
+		if i < 0 {
+			i = len(digits)
+		}
+		digits = group(digits[:i], fs.grouping, 3) + digits[i:]
+	}
+	return fs.pad(thread, sign, digits, true)
+}
+
+// formatString formats a string, the text of a value of the named type.
+func (fs *formatSpec) formatString(thread *Thread, typ, s string) (String, error) {
+	switch {
+	case fs.typ != 0 && fs.typ != 's':
+		return "", fs.unknownType(typ)
+	case fs.sign != 0:
+		return "", Errorf(ValueError, "sign not allowed in string format specification")
+	case fs.alt:
+		return "", Errorf(ValueError, "alternate form (#) not allowed in string format specification")
+	case fs.grouping != 0:
+		return "", Errorf(ValueError, "cannot specify '%c' with 's'", fs.grouping)
+	case fs.align == '=':
+		return "", Errorf(ValueError, "'=' alignment not allowed in string format specification")
+	}
+	if fs.precision >= 0 {
+		// Truncate to precision runes.
+		n := 0
+		for i := range s {
+			if n == fs.precision {
+				s = s[:i]
+				break
+			}
+			n++
+		}
+	}
+	return fs.pad(thread, "", s, false)
+}
+
+// signOf returns the sign to write before a number.
+func (fs *formatSpec) signOf(negative bool) string {
+	switch {
+	case negative:
+		return "-"
+	case fs.sign == '+':
+		return "+"
+	case fs.sign == ' ':
+		return " "
+	}
+	return ""
+}
+
+// pad returns the sign and body of a formatted value,
+// padded to the width of the specification.
+// Numbers are aligned to the right by default, strings to the left.
+func (fs *formatSpec) pad(thread *Thread, sign, body string, number bool) (String, error) {
+	fill, align := fs.fill, fs.align
+	if fs.zero && align == 0 {
+		fill = '0'
+		if number {
+			align = '='
+		}
+	}
+	if align == 0 {
+		align = '<'
+		if number {
+			align = '>'
+		}
+	}
+
+	n := fs.width - utf8.RuneCountInString(sign) - utf8.RuneCountInString(body)
+	if n <= 0 {
+		return String(sign + body), nil
+	}
+	if err := thread.AddAllocs(int64(n*utf8.RuneLen(fill) + len(sign) + len(body))); err != nil {
+		return "", err
+	}
+	padding := func(n int) string { return strings.Repeat(string(fill), n) }
+	switch align {
+	case '<':
+		return String(sign + body + padding(n)), nil
+	case '^':
+		return String(padding(n/2) + sign + body + padding(n-n/2)), nil
+	case '=':
+		return String(sign + padding(n) + body), nil
+	}
+	return String(padding(n) + sign + body), nil
+}
+
+// group inserts a separator between each group of n digits,
+// counting from the right.
+func group(digits string, sep byte, n int) string {
+	var buf strings.Builder
+	for i := range digits {
+		if i > 0 && (len(digits)-i)%n == 0 {
+			buf.WriteByte(sep)
+		}
+		buf.WriteByte(digits[i])
+	}
+	return buf.String()
+}
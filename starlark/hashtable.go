@@ -74,7 +74,11 @@ func (ht *hashtable) freeze() {
 	}
 }
 
-func (ht *hashtable) insert(k, v Value) error {
+func (ht *hashtable) insert(k, v Value) error { return ht.insertBy(nil, k, v) }
+
+// insertBy is like insert, but if the key is not already present, it
+// first charges the memory of the new entry to thread (see AddAllocs).
+func (ht *hashtable) insertBy(thread *Thread, k, v Value) error {
 	if err := ht.checkMutable("insert into"); err != nil {
 		return err
 	}
@@ -121,6 +125,10 @@ retry:
 
 	// Key not found.  p points to the last bucket.
 
+	if err := thread.AddAllocs(entrySize); err != nil {
+		return err
+	}
+
 	// Does the number of elements exceed the buckets' load factor?
 	if overloaded(int(ht.len), len(ht.table)) {
 		ht.grow()
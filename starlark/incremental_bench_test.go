@@ -0,0 +1,281 @@
+package starlark
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"go.starlark.net/syntax"
+)
+
+// This file defines benchmarks of incremental execution,
+// and generators of large synthetic Starlark programs for them.
+
+// genDependencyGraph returns the source of a program whose functions
+// form a layered dependency graph. Each function in layer 0 reads one
+// input and does a little arithmetic; each function in a later layer
+// calls two neighbouring functions of the layer below. Every function
+// in the last layer is called by its own top-level statement.
+//
+// Because dependencies are local, a change to one input invalidates
+// only a cone of about layers² functions and the top-level statements
+// at its base.
+func genDependencyGraph(layers, width int, seed int64) string {
+	rng := rand.New(rand.NewSource(seed))
+	var buf strings.Builder
+	for i := 0; i < width; i++ {
+		fmt.Fprintf(&buf, "def f0_%d():\n", i)
+		fmt.Fprintf(&buf, "    x = input(%q).value\n", inputName(i))
+		fmt.Fprintf(&buf, "    for j in range(%d):\n", 10+rng.Intn(10))
+		fmt.Fprintf(&buf, "        x += j\n")
+		fmt.Fprintf(&buf, "    return x\n\n")
+	}
+	for l := 1; l < layers; l++ {
+		for i := 0; i < width; i++ {
+			a := (i + width - 1 + rng.Intn(2)) % width // i-1 or i
+			b := (i + 1) % width
+			fmt.Fprintf(&buf, "def f%d_%d():\n", l, i)
+			fmt.Fprintf(&buf, "    return f%d_%d() + f%d_%d()\n\n", l-1, a, l-1, b)
+		}
+	}
+	for i := 0; i < width; i++ {
+		fmt.Fprintf(&buf, "r%d = f%d_%d()\n", i, layers-1, i)
+	}
+	return buf.String()
+}
+
+// genStatementChains returns the source of a program of n top-level
+// statements forming width independent chains: each of the first
+// width statements reads an input, and each later statement reads
+// the global assigned by the last statement of the same chain.
+func genStatementChains(n, width int) string {
+	var buf strings.Builder
+	for i := 0; i < n; i++ {
+		if i < width {
+			fmt.Fprintf(&buf, "v%d = input(%q).value\n", i, inputName(i))
+		} else {
+			fmt.Fprintf(&buf, "v%d = v%d + %d\n", i, i-width, i)
+		}
+	}
+	return buf.String()
+}
+
+func inputName(i int) string { return fmt.Sprintf("in%d", i) }
+
+// makeInputs returns an input environment for the generated programs
+// in which the value of input changed differs from the default.
+func makeInputs(width, changed, value int) StringDict {
+	inputs := make(StringDict, width)
+	for i := 0; i < width; i++ {
+		inputs[inputName(i)] = MakeInt(i)
+	}
+	if changed >= 0 {
+		inputs[inputName(changed)] = MakeInt(value)
+	}
+	return inputs
+}
+
+var incrementalPredeclared = StringDict{"input": InputBuiltin}
+
+func prepare(tb testing.TB, src string) *Function {
+	toplevel, err := PrepareExecFile(&syntax.FileOptions{}, "gen.star", src, incrementalPredeclared)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return toplevel
+}
+
+func execPrepared(tb testing.TB, thread *Thread, toplevel *Function, inputs StringDict) {
+	if _, err := ExecPreparedProgram(thread, toplevel, inputs); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func BenchmarkIncremental(b *testing.B) {
+	const layers, width = 8, 64
+	for _, prog := range []struct {
+		name string
+		src  string
+	}{
+		{"graph", genDependencyGraph(layers, width, 1)},
+		{"chains", genStatementChains(1000, width)},
+	} {
+		b.Run(prog.name, func(b *testing.B) {
+			inputs := makeInputs(width, -1, 0)
+
+			// Non-incremental execution, including compilation,
+			// which nonetheless pays for recording dependencies
+			// and memoizing calls.
+			b.Run("execfile", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					thread := new(Thread)
+					thread.cache.inputs = inputs
+					if _, err := ExecFileOptions(&syntax.FileOptions{}, thread, "gen.star", prog.src, incrementalPredeclared); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+
+			// Incremental execution with an empty memo table.
+			b.Run("cold", func(b *testing.B) {
+				toplevel := prepare(b, prog.src)
+				for i := 0; i < b.N; i++ {
+					execPrepared(b, new(Thread), toplevel, inputs)
+				}
+			})
+
+			// Incremental execution with nothing changed.
+			b.Run("warm", func(b *testing.B) {
+				toplevel := prepare(b, prog.src)
+				thread := new(Thread)
+				execPrepared(b, thread, toplevel, inputs)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					execPrepared(b, thread, toplevel, inputs)
+				}
+			})
+
+			// Incremental execution after a change to a single input.
+			b.Run("one_input_change", func(b *testing.B) {
+				toplevel := prepare(b, prog.src)
+				thread := new(Thread)
+				execPrepared(b, thread, toplevel, inputs)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					// Each value is new, so every iteration does work.
+					execPrepared(b, thread, toplevel, makeInputs(width, 0, 1000+i))
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkMemoFill measures the cost of a memo table hit
+// as the table fills up, and reports the mean probe length
+// and the fraction of records evicted by later ones.
+func BenchmarkMemoFill(b *testing.B) {
+	for _, percent := range []int{10, 25, 50, 75, 90} {
+		b.Run(fmt.Sprintf("load=%d%%", percent), func(b *testing.B) {
+			db := NewProgramStateDB()
+			fn := &Function{}
+			result := db.Intern(None)
+			n := CACHE_SIZE * percent / 100
+			keys := make([][]Interned, n)
+			for i := range keys {
+				keys[i] = []Interned{db.Intern(MakeInt(1<<40 + i))}
+				db.Put(fn, keys[i], Dependencies{}, result, 1)
+			}
+
+			// Look up only the keys that were not evicted.
+			probes, hits := 0, keys[:0]
+			for _, args := range keys {
+				if db.Get(fn, args) != nil {
+					probes += probeLength(db, fn, args)
+					hits = append(hits, args)
+				}
+			}
+			evicted := float64(n-len(hits)) / float64(n)
+			keys, n = hits, len(hits)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if db.Get(fn, keys[i%n]) == nil {
+					b.Fatal("miss")
+				}
+			}
+			// (ResetTimer discards metrics reported before it.)
+			b.ReportMetric(float64(probes)/float64(n), "probes/hit")
+			b.ReportMetric(evicted, "evicted/put")
+		})
+	}
+}
+
+// probeLength returns the number of slots examined by a lookup of the key.
+func probeLength(db *ProgramStateDB, fn *Function, args []Interned) int {
+	idx := hashKey(fn, nil, db.key, args)
+	for i := 0; i < maxProbes; i++ {
+		rec := db.memo[(idx+i)%CACHE_SIZE]
+		if rec == nil || rec.matches(fn, nil, db.key, args) {
+			return i + 1
+		}
+	}
+	return maxProbes
+}
+
+// TestIncrementalWork guards against regressions in the amount of work
+// saved by incremental execution of the generated programs.
+func TestIncrementalWork(t *testing.T) {
+	const layers, width = 8, 64
+	for _, test := range []struct {
+		name string
+		src  string
+		// maxRatio bounds the steps of an execution after a
+		// one-input change as a fraction of a cold execution.
+		maxRatio float64
+	}{
+		{"graph", genDependencyGraph(layers, width, 1), 0.25},
+		{"chains", genStatementChains(1000, width), 0.30}, // skipping a statement costs two steps
+	} {
+		toplevel := prepare(t, test.src)
+		thread := new(Thread)
+
+		steps := func(inputs StringDict) uint64 {
+			before := thread.Steps
+			execPrepared(t, thread, toplevel, inputs)
+			return thread.Steps - before
+		}
+		cold := steps(makeInputs(width, -1, 0))
+		warm := steps(makeInputs(width, -1, 0))
+		changed := steps(makeInputs(width, width/2, 1000))
+
+		if warm != 0 {
+			t.Errorf("%s: warm execution took %d steps, want 0", test.name, warm)
+		}
+		if ratio := float64(changed) / float64(cold); ratio > test.maxRatio {
+			t.Errorf("%s: execution after one input change took %d steps, %.2f of cold execution (%d), want <= %.2f",
+				test.name, changed, ratio, cold, test.maxRatio)
+		}
+	}
+
+	// A statement that modifies a list created by an earlier statement
+	// must not see the list as modified by previous executions.
+	toplevel := prepare(t, "L = []\nL.append(1)\ny = input(\"x\").value\nL.append(y)\n")
+	thread := new(Thread)
+	for _, test := range []struct {
+		x    int
+		want string
+	}{
+		{1, "[1, 1]"},
+		{1, "[1, 1]"},
+		{2, "[1, 2]"},
+		{2, "[1, 2]"},
+	} {
+		globals, err := ExecPreparedProgram(thread, toplevel, StringDict{"x": MakeInt(test.x)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := globals["L"].String(); got != test.want {
+			t.Errorf("with x=%d, L = %s, want %s", test.x, got, test.want)
+		}
+	}
+
+	// A failed statement does not disturb the execution of the others.
+	toplevel = prepare(t, "a = input(\"x\").value\nb = 12 // a\nc = a + 1\n")
+	thread = new(Thread)
+	for _, x := range []int{0, 3, 0, 4} {
+		globals, err := ExecPreparedProgram(thread, toplevel, StringDict{"x": MakeInt(x)})
+		if x == 0 {
+			if err == nil {
+				t.Errorf("with x=0, got no error")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := fmt.Sprint(globals["b"], globals["c"]), fmt.Sprint(12/x, x+1); got != want {
+			t.Errorf("with x=%d, b, c = %s, want %s", x, got, want)
+		}
+	}
+}
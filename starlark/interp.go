@@ -5,6 +5,7 @@ package starlark
 import (
 	"fmt"
 	"os"
+	"strings"
 	"sync/atomic"
 	"unsafe"
 
@@ -31,7 +32,11 @@ func (fn *Function) CallInternal(thread *Thread, args Tuple, kwargs []Tuple) (Va
 			// not function value, otherwise the user could
 			// defeat the check by writing the Y combinator.
 			if frfn, ok := fr.Callable().(*Function); ok && frfn.funcode == f {
-				return nil, fmt.Errorf("function %s called recursively", fn.Name())
+				err := fmt.Errorf("function %s called recursively", fn.Name())
+				if thread.debugger != nil {
+					thread.debugger.Exception(thread, err)
+				}
+				return nil, err
 			}
 		}
 	}
@@ -57,18 +62,26 @@ func (fn *Function) CallInternal(thread *Thread, args Tuple, kwargs []Tuple) (Va
 	// Digest arguments and set parameters.
 	err := setArgs(thread, locals, fn, args, kwargs)
 	if err != nil {
+		if thread.debugger != nil {
+			thread.debugger.Exception(thread, err)
+		}
 		return nil, thread.evalError(err)
 	}
 
 	cache := &thread.cache
+	cache.observeHost()
 	snapshot := cache.version
 	internedArgs := make([]Interned, fn.NumParams())
 	for i := range internedArgs {
 		internedArgs[i] = cache.Intern(locals[i])
 	}
 	cachedResult := cache.Get(fn, internedArgs)
-	if cachedResult != nil && cache.validate(cachedResult) {
+	if cachedResult != nil && thread.debugger == nil && cache.validate(cachedResult, thread.locals) {
 		thread.dependencies.calls = append(thread.dependencies.calls, cachedResult)
+		if thread.report != nil {
+			thread.report.MemoHits++
+			thread.report.function(fn).MemoHits++
+		}
 		return cache.Value(cachedResult.result), nil
 	}
 
@@ -97,6 +110,18 @@ func (fn *Function) CallInternal(thread *Thread, args Tuple, kwargs []Tuple) (Va
 	// - there is no redefinition of 'err'.
 
 	var iterstack []Iterator // stack of active iterators
+	var debugLine int32 = -1 // line of the last Line event (see Debugger)
+
+	// State of the active top-level segment, if any, and of the
+	// enclosing one, which is restored when it ends.
+	var (
+		segment         *compile.Segment
+		segmentArgs     []Interned
+		segmentSnapshot uint64
+		segmentParent   Dependencies
+		outerStart      uint64
+		outerWrote      bool
+	)
 
 	// Use defer so that application panics can pass through
 	// interpreter without leaving thread in a bad state.
@@ -123,13 +148,20 @@ loop:
 				thread.Cancel("too many steps")
 			}
 		}
-		if reason := atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&thread.cancelReason))); reason != nil {
-			err = fmt.Errorf("Starlark computation cancelled: %s", *(*string)(reason))
+		if c := (*cancellation)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&thread.cancelled)))); c != nil {
+			err = c.error()
 			break loop
 		}
 
 		fr.pc = pc
 
+		if thread.debugger != nil {
+			if line := f.Position(pc).Line; line != debugLine {
+				debugLine = line
+				thread.debugger.Line(thread)
+			}
+		}
+
 		op := compile.Opcode(code[pc])
 		pc++
 		var arg uint32
@@ -238,7 +270,9 @@ loop:
 					if err = xlist.checkMutable("apply += to"); err != nil {
 						break loop
 					}
-					listExtend(xlist, yiter)
+					if err = listExtend(thread, xlist, yiter); err != nil {
+						break loop
+					}
 					z = xlist
 				}
 			}
@@ -266,7 +300,7 @@ loop:
 					if err = xdict.ht.checkMutable("apply |= to"); err != nil {
 						break loop
 					}
-					xdict.write()
+					xdict.write(thread)
 					ydict.read()
 					xdict.ht.addAll(&ydict.ht) // can't fail
 					z = xdict
@@ -301,7 +335,7 @@ loop:
 		case compile.JMP:
 			pc = arg
 
-		case compile.CALL, compile.CALL_VAR, compile.CALL_KW, compile.CALL_VAR_KW:
+		case compile.CALL, compile.CALL_VAR, compile.CALL_KW, compile.CALL_VAR_KW, compile.CALL_METHOD:
 			var kwargs Value
 			if op == compile.CALL_KW || op == compile.CALL_VAR_KW {
 				kwargs = stack[sp-1]
@@ -332,13 +366,13 @@ loop:
 				// Add key/value items from **kwargs dictionary.
 				dict, ok := kwargs.(IterableMapping)
 				if !ok {
-					err = fmt.Errorf("argument after ** must be a mapping, not %s", kwargs.Type())
+					err = Errorf(TypeError, "argument after ** must be a mapping, not %s", kwargs.Type())
 					break loop
 				}
 				items := dict.Items()
 				for _, item := range items {
 					if _, ok := item[0].(String); !ok {
-						err = fmt.Errorf("keywords must be strings, not %s", item[0].Type())
+						err = Errorf(TypeError, "keywords must be strings, not %s", item[0].Type())
 						break loop
 					}
 				}
@@ -358,7 +392,11 @@ loop:
 				// Copy positional arguments into a new array,
 				// unless the callee is another Starlark function,
 				// in which case it can be trusted not to mutate them.
-				if _, ok := stack[sp-1].(*Function); !ok || args != nil {
+				callee := stack[sp-1]
+				if op == compile.CALL_METHOD {
+					callee = stack[sp-2] // (recv is at sp-1)
+				}
+				if _, ok := callee.(*Function); !ok || args != nil {
 					positional = append(Tuple(nil), positional...)
 				}
 			}
@@ -366,7 +404,7 @@ loop:
 				// Add elements from *args sequence.
 				iter := Iterate(args)
 				if iter == nil {
-					err = fmt.Errorf("argument after * must be iterable, not %s", args.Type())
+					err = Errorf(TypeError, "argument after * must be iterable, not %s", args.Type())
 					break loop
 				}
 				var elem Value
@@ -377,6 +415,11 @@ loop:
 			}
 
 			function := stack[sp-1]
+			var recv Value // receiver of an unbound method
+			if op == compile.CALL_METHOD {
+				sp--
+				recv, function = function, stack[sp-1]
+			}
 
 			if vmdebug {
 				fmt.Printf("VM call %s args=%s kwargs=%s @%s\n",
@@ -384,7 +427,13 @@ loop:
 			}
 
 			thread.endProfSpan()
-			z, err2 := Call(thread, function, positional, kvpairs)
+			var z Value
+			var err2 error
+			if recv != nil {
+				z, err2 = thread.call(function.(*Builtin), recv, positional, kvpairs)
+			} else {
+				z, err2 = Call(thread, function, positional, kvpairs)
+			}
 			thread.beginProfSpan()
 			if err2 != nil {
 				err = err2
@@ -400,7 +449,7 @@ loop:
 			sp--
 			iter := Iterate(x)
 			if iter == nil {
-				err = fmt.Errorf("%s value is not iterable", x.Type())
+				err = Errorf(TypeError, "%s value is not iterable", x.Type())
 				break loop
 			}
 			iterstack = append(iterstack, iter)
@@ -413,6 +462,58 @@ loop:
 				pc = arg
 			}
 
+		case compile.SEGMENT:
+			if !fn.module.incremental || cache.unskippable[fn.module] {
+				break // statements are memoized only for incremental execution
+			}
+			seg := f.SegmentAt(fr.pc)
+			args := make([]Interned, len(seg.Locals))
+			for i, index := range seg.Locals {
+				x := locals[index]
+				if c, ok := x.(*cell); ok {
+					x = c.v
+				}
+				args[i] = cache.Intern(x)
+			}
+			if rec := cache.get(fn, seg, args); rec != nil && thread.debugger == nil && cache.validate(rec, thread.locals) {
+				// The globals assigned by the statement still
+				// hold the values it computed, so skip it.
+				thread.dependencies.calls = append(thread.dependencies.calls, rec)
+				if thread.report != nil {
+					thread.report.MemoHits++
+				}
+				pc = arg
+				break
+			}
+			// Values created from here on are newer than segmentStart.
+			cache.version++
+			segment = seg
+			segmentArgs = args
+			segmentSnapshot = cache.version
+			segmentParent = thread.dependencies
+			thread.dependencies = Dependencies{}
+			outerStart, outerWrote = cache.segmentStart, cache.segmentWrote
+			cache.segmentStart, cache.segmentWrote = cache.version, false
+
+		case compile.ENDSEGMENT:
+			if segment == nil {
+				break // skipped or not memoized
+			}
+			deps := thread.dependencies
+			thread.dependencies = segmentParent
+			cache.endSegment(fn.module, outerStart, outerWrote)
+			if deps.effects {
+				// Unlike a call, a statement can't be rerun on its own,
+				// so the toplevel must be rerun too.
+				thread.dependencies.effects = true
+			} else {
+				rec := cache.put(fn, segment, segmentArgs, deps, cache.Intern(None), segmentSnapshot)
+				thread.dependencies.calls = append(thread.dependencies.calls, rec)
+			}
+			segment = nil
+			segmentArgs = nil
+			segmentParent = Dependencies{}
+
 		case compile.ITERPOP:
 			n := len(iterstack) - 1
 			iterstack[n].Done()
@@ -423,6 +524,9 @@ loop:
 
 		case compile.RETURN:
 			result = stack[sp-1]
+			if f.ResultType != nil && f.Prog.CheckTypes && !hasType(result, f.ResultType) {
+				err = Errorf(TypeError, "function %s returned %s, want %s", fn.Name(), result.Type(), f.ResultType)
+			}
 			break loop
 
 		case compile.SETINDEX:
@@ -430,7 +534,7 @@ loop:
 			y := stack[sp-2]
 			x := stack[sp-3]
 			sp -= 3
-			err = setIndex(x, y, z)
+			err = setIndex(thread, x, y, z)
 			if err != nil {
 				break loop
 			}
@@ -450,19 +554,62 @@ loop:
 		case compile.ATTR:
 			x := stack[sp-1]
 			name := f.Prog.Names[arg]
-			y, err2 := getAttr(x, name)
+			y, err2 := getAttr(thread, x, name)
 			if err2 != nil {
 				err = err2
 				break loop
 			}
 			stack[sp-1] = y
 
+		case compile.METHOD:
+			x := stack[sp-1]
+			site := &f.MethodSites[arg]
+			if method := lookupMethod(f.Prog, site, x); method != nil {
+				stack[sp-1] = method
+				stack[sp] = x
+			} else {
+				y, err2 := getAttr(thread, x, f.Prog.Names[site.Name])
+				if err2 != nil {
+					err = err2
+					break loop
+				}
+				stack[sp-1] = y
+				stack[sp] = nil // no receiver
+			}
+			sp++
+
+		case compile.ATTR_CALL:
+			x := stack[sp-1]
+			site := &f.MethodSites[arg]
+			var y, z Value
+			var err2 error
+			m := lookupMethod(f.Prog, site, x)
+			if m == nil {
+				y, err2 = getAttr(thread, x, f.Prog.Names[site.Name])
+				if err2 != nil {
+					err = err2
+					break loop
+				}
+			}
+			thread.endProfSpan()
+			if m != nil {
+				z, err2 = thread.call(m, x, nil, nil)
+			} else {
+				z, err2 = Call(thread, y, nil, nil)
+			}
+			thread.beginProfSpan()
+			if err2 != nil {
+				err = err2
+				break loop
+			}
+			stack[sp-1] = z
+
 		case compile.SETFIELD:
 			y := stack[sp-1]
 			x := stack[sp-2]
 			sp -= 2
 			name := f.Prog.Names[arg]
-			if err2 := setField(x, name, y); err2 != nil {
+			if err2 := setField(thread, x, name, y); err2 != nil {
 				err = err2
 				break loop
 			}
@@ -477,12 +624,12 @@ loop:
 			v := stack[sp-1]
 			sp -= 3
 			oldlen := dict.Len()
-			if err2 := dict.SetKey(k, v); err2 != nil {
+			if err2 := dict.setKey(thread, k, v); err2 != nil {
 				err = err2
 				break loop
 			}
 			if op == compile.SETDICTUNIQ && dict.Len() == oldlen {
-				err = fmt.Errorf("duplicate key: %v", k)
+				err = Errorf(ValueError, "duplicate key: %v", k)
 				break loop
 			}
 
@@ -490,9 +637,13 @@ loop:
 			elem := stack[sp-1]
 			list := stack[sp-2].(*List)
 			sp -= 2
+			if err = thread.AddAllocs(valueSize); err != nil {
+				break loop
+			}
 			list.read()
-			list.write()
+			list.write(thread)
 			list.elems = append(list.elems, elem)
+			thread.noteLen(list, len(list.elems))
 
 		case compile.SLICE:
 			x := stack[sp-4]
@@ -514,7 +665,7 @@ loop:
 			sp--
 			iter := Iterate(iterable)
 			if iter == nil {
-				err = fmt.Errorf("got %s in sequence assignment", iterable.Type())
+				err = Errorf(TypeError, "got %s in sequence assignment", iterable.Type())
 				break loop
 			}
 			i := 0
@@ -525,12 +676,12 @@ loop:
 			var dummy Value
 			if iter.Next(&dummy) {
 				// NB: Len may return -1 here in obscure cases.
-				err = fmt.Errorf("too many values to unpack (got %d, want %d)", Len(iterable), n)
+				err = Errorf(ValueError, "too many values to unpack (got %d, want %d)", Len(iterable), n)
 				break loop
 			}
 			iter.Done()
 			if i < n {
-				err = fmt.Errorf("too few values to unpack (got %d, want %d)", i, n)
+				err = Errorf(ValueError, "too few values to unpack (got %d, want %d)", i, n)
 				break loop
 			}
 
@@ -544,6 +695,44 @@ loop:
 			stack[sp] = fn.module.constants[arg]
 			sp++
 
+		case compile.CONSTANT_PLUS:
+			x := stack[sp-1]
+			y := fn.module.constants[arg]
+			z, err2 := Binary(thread, syntax.PLUS, x, y)
+			if err2 != nil {
+				err = err2
+				break loop
+			}
+			stack[sp-1] = z
+
+		case compile.FORMAT:
+			conv, spec := compile.FormatArg(arg)
+			z, err2 := formatField(thread, stack[sp-1], conv, string(fn.module.constants[spec].(String)))
+			if err2 != nil {
+				err = err2
+				break loop
+			}
+			stack[sp-1] = z
+
+		case compile.CONCAT:
+			n := int(arg)
+			sp -= n
+			size := 0
+			for _, x := range stack[sp : sp+n] {
+				size += len(x.(String))
+			}
+			if err = thread.AddAllocs(int64(size)); err != nil {
+				break loop
+			}
+			var buf strings.Builder
+			buf.Grow(size)
+			for _, x := range stack[sp : sp+n] {
+				buf.WriteString(string(x.(String)))
+			}
+			stack[sp] = String(buf.String())
+			sp++
+			thread.countAlloc("string")
+
 		case compile.MAKETUPLE:
 			n := int(arg)
 			tuple := make(Tuple, n)
@@ -551,9 +740,13 @@ loop:
 			copy(tuple, stack[sp:])
 			stack[sp] = tuple
 			sp++
+			thread.countAlloc("tuple")
 
 		case compile.MAKELIST:
 			n := int(arg)
+			if err = thread.AddAllocs(valueSize * int64(n)); err != nil {
+				break loop
+			}
 			elems := make([]Value, n)
 			sp -= n
 			copy(elems, stack[sp:])
@@ -578,11 +771,15 @@ loop:
 				defaults: defaults,
 				freevars: freevars,
 			}
+			thread.countAlloc("function")
 
 		case compile.LOAD:
 			n := int(arg)
 			module := string(stack[sp-1].(String))
 			sp--
+			if thread.report != nil {
+				thread.report.Loads = append(thread.report.Loads, module)
+			}
 
 			if thread.Load == nil {
 				err = fmt.Errorf("load not implemented by this application")
@@ -643,6 +840,21 @@ loop:
 			stack[sp] = x
 			sp++
 
+		case compile.LOCAL_LOCAL:
+			x := locals[arg&0xffff]
+			if x == nil {
+				err = fmt.Errorf("local variable %s referenced before assignment", f.Locals[arg&0xffff].Name)
+				break loop
+			}
+			y := locals[arg>>16]
+			if y == nil {
+				err = fmt.Errorf("local variable %s referenced before assignment", f.Locals[arg>>16].Name)
+				break loop
+			}
+			stack[sp] = x
+			stack[sp+1] = y
+			sp += 2
+
 		case compile.FREE:
 			stack[sp] = fn.freevars[arg]
 			sp++
@@ -703,13 +915,29 @@ loop:
 		}
 	}
 
+	if err != nil && thread.debugger != nil && thread.raisedHere(err) {
+		thread.debugger.Exception(thread, err)
+	}
+
+	if segment != nil {
+		// A statement failed. Restore the state of the enclosing
+		// segment and the dependencies of the toplevel.
+		thread.dependencies = segmentParent
+		thread.dependencies.effects = true
+		cache.endSegment(fn.module, outerStart, outerWrote)
+	}
+
 	// Cache the result.
 	// TODO if the result is stored inline in Intern and fast to compute, don't cache it.
 	if err == nil && result != nil && !thread.dependencies.effects {
 		rec := cache.Put(fn, internedArgs, thread.dependencies, cache.Intern(result), snapshot)
 		parent.calls = append(parent.calls, rec)
 	}
-	// Restore the previous observed set.
+	// Restore the previous observed set. A caller of a function with
+	// effects has them too, as a memoized result would skip them.
+	if thread.dependencies.effects {
+		parent.effects = true
+	}
 	thread.dependencies = parent
 	// (deferred cleanup runs here)
 	return result, err
@@ -10,7 +10,6 @@ package starlark
 // mutable types such as lists and dicts.
 
 import (
-	"errors"
 	"fmt"
 	"math"
 	"math/big"
@@ -22,6 +21,7 @@ import (
 	"unicode/utf16"
 	"unicode/utf8"
 
+	"go.starlark.net/internal/compile"
 	"go.starlark.net/syntax"
 )
 
@@ -44,6 +44,7 @@ func init() {
 		"all":       NewBuiltin("all", all),
 		"bool":      NewBuiltin("bool", bool_),
 		"bytes":     NewBuiltin("bytes", bytes_),
+		"catch":     NewBuiltin("catch", catch), // requires syntax.FileOptions.Catch
 		"chr":       NewBuiltin("chr", chr),
 		"dict":      NewBuiltin("dict", dict),
 		"dir":       NewBuiltin("dir", dir),
@@ -59,7 +60,7 @@ func init() {
 		"max":       NewBuiltin("max", minmax),
 		"min":       NewBuiltin("min", minmax),
 		"ord":       NewBuiltin("ord", ord),
-		"print":     NewBuiltin("print", print),
+		"print":     NewBuiltin("print", print).WithCapability("print"),
 		"range":     NewBuiltin("range", range_),
 		"repr":      NewBuiltin("repr", repr),
 		"reversed":  NewBuiltin("reversed", reversed),
@@ -70,88 +71,89 @@ func init() {
 		"type":      NewBuiltin("type", type_),
 		"zip":       NewBuiltin("zip", zip),
 	}
+	documentUniverse()
 }
 
 // methods of built-in types
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#built-in-methods
 var (
 	bytesMethods = map[string]*Builtin{
-		"elems": NewBuiltin("elems", bytes_elems),
+		"elems": newMethod("elems", bytes_elems),
 	}
 
 	dictMethods = map[string]*Builtin{
-		"clear":      NewBuiltin("clear", dict_clear),
-		"get":        NewBuiltin("get", dict_get),
-		"items":      NewBuiltin("items", dict_items),
-		"keys":       NewBuiltin("keys", dict_keys),
-		"pop":        NewBuiltin("pop", dict_pop),
-		"popitem":    NewBuiltin("popitem", dict_popitem),
-		"setdefault": NewBuiltin("setdefault", dict_setdefault),
-		"update":     NewBuiltin("update", dict_update),
-		"values":     NewBuiltin("values", dict_values),
+		"clear":      newMethod("clear", dict_clear),
+		"get":        newMethod("get", dict_get),
+		"items":      newMethod("items", dict_items),
+		"keys":       newMethod("keys", dict_keys),
+		"pop":        newMethod("pop", dict_pop),
+		"popitem":    newMethod("popitem", dict_popitem),
+		"setdefault": newMethod("setdefault", dict_setdefault),
+		"update":     newMethod("update", dict_update),
+		"values":     newMethod("values", dict_values),
 	}
 
 	listMethods = map[string]*Builtin{
-		"append": NewBuiltin("append", list_append),
-		"clear":  NewBuiltin("clear", list_clear),
-		"extend": NewBuiltin("extend", list_extend),
-		"index":  NewBuiltin("index", list_index),
-		"insert": NewBuiltin("insert", list_insert),
-		"pop":    NewBuiltin("pop", list_pop),
-		"remove": NewBuiltin("remove", list_remove),
+		"append": newMethod("append", list_append),
+		"clear":  newMethod("clear", list_clear),
+		"extend": newMethod("extend", list_extend),
+		"index":  newMethod("index", list_index),
+		"insert": newMethod("insert", list_insert),
+		"pop":    newMethod("pop", list_pop),
+		"remove": newMethod("remove", list_remove),
 	}
 
 	stringMethods = map[string]*Builtin{
-		"capitalize":     NewBuiltin("capitalize", string_capitalize),
-		"codepoint_ords": NewBuiltin("codepoint_ords", string_iterable),
-		"codepoints":     NewBuiltin("codepoints", string_iterable), // sic
-		"count":          NewBuiltin("count", string_count),
-		"elem_ords":      NewBuiltin("elem_ords", string_iterable),
-		"elems":          NewBuiltin("elems", string_iterable),      // sic
-		"endswith":       NewBuiltin("endswith", string_startswith), // sic
-		"find":           NewBuiltin("find", string_find),
-		"format":         NewBuiltin("format", string_format),
-		"index":          NewBuiltin("index", string_index),
-		"isalnum":        NewBuiltin("isalnum", string_isalnum),
-		"isalpha":        NewBuiltin("isalpha", string_isalpha),
-		"isdigit":        NewBuiltin("isdigit", string_isdigit),
-		"islower":        NewBuiltin("islower", string_islower),
-		"isspace":        NewBuiltin("isspace", string_isspace),
-		"istitle":        NewBuiltin("istitle", string_istitle),
-		"isupper":        NewBuiltin("isupper", string_isupper),
-		"join":           NewBuiltin("join", string_join),
-		"lower":          NewBuiltin("lower", string_lower),
-		"lstrip":         NewBuiltin("lstrip", string_strip), // sic
-		"partition":      NewBuiltin("partition", string_partition),
-		"removeprefix":   NewBuiltin("removeprefix", string_removefix),
-		"removesuffix":   NewBuiltin("removesuffix", string_removefix),
-		"replace":        NewBuiltin("replace", string_replace),
-		"rfind":          NewBuiltin("rfind", string_rfind),
-		"rindex":         NewBuiltin("rindex", string_rindex),
-		"rpartition":     NewBuiltin("rpartition", string_partition), // sic
-		"rsplit":         NewBuiltin("rsplit", string_split),         // sic
-		"rstrip":         NewBuiltin("rstrip", string_strip),         // sic
-		"split":          NewBuiltin("split", string_split),
-		"splitlines":     NewBuiltin("splitlines", string_splitlines),
-		"startswith":     NewBuiltin("startswith", string_startswith),
-		"strip":          NewBuiltin("strip", string_strip),
-		"title":          NewBuiltin("title", string_title),
-		"upper":          NewBuiltin("upper", string_upper),
+		"capitalize":     newMethod("capitalize", string_capitalize),
+		"codepoint_ords": newMethod("codepoint_ords", string_iterable),
+		"codepoints":     newMethod("codepoints", string_iterable), // sic
+		"count":          newMethod("count", string_count),
+		"elem_ords":      newMethod("elem_ords", string_iterable),
+		"elems":          newMethod("elems", string_iterable),      // sic
+		"endswith":       newMethod("endswith", string_startswith), // sic
+		"find":           newMethod("find", string_find),
+		"format":         newMethod("format", string_format),
+		"index":          newMethod("index", string_index),
+		"isalnum":        newMethod("isalnum", string_isalnum),
+		"isalpha":        newMethod("isalpha", string_isalpha),
+		"isdigit":        newMethod("isdigit", string_isdigit),
+		"islower":        newMethod("islower", string_islower),
+		"isspace":        newMethod("isspace", string_isspace),
+		"istitle":        newMethod("istitle", string_istitle),
+		"isupper":        newMethod("isupper", string_isupper),
+		"join":           newMethod("join", string_join),
+		"lower":          newMethod("lower", string_lower),
+		"lstrip":         newMethod("lstrip", string_strip), // sic
+		"partition":      newMethod("partition", string_partition),
+		"removeprefix":   newMethod("removeprefix", string_removefix),
+		"removesuffix":   newMethod("removesuffix", string_removefix),
+		"replace":        newMethod("replace", string_replace),
+		"rfind":          newMethod("rfind", string_rfind),
+		"rindex":         newMethod("rindex", string_rindex),
+		"rpartition":     newMethod("rpartition", string_partition), // sic
+		"rsplit":         newMethod("rsplit", string_split),         // sic
+		"rstrip":         newMethod("rstrip", string_strip),         // sic
+		"split":          newMethod("split", string_split),
+		"splitlines":     newMethod("splitlines", string_splitlines),
+		"startswith":     newMethod("startswith", string_startswith),
+		"strip":          newMethod("strip", string_strip),
+		"title":          newMethod("title", string_title),
+		"upper":          newMethod("upper", string_upper),
 	}
 
 	setMethods = map[string]*Builtin{
-		"add":                  NewBuiltin("add", set_add),
-		"clear":                NewBuiltin("clear", set_clear),
-		"difference":           NewBuiltin("difference", set_difference),
-		"discard":              NewBuiltin("discard", set_discard),
-		"intersection":         NewBuiltin("intersection", set_intersection),
-		"issubset":             NewBuiltin("issubset", set_issubset),
-		"issuperset":           NewBuiltin("issuperset", set_issuperset),
-		"pop":                  NewBuiltin("pop", set_pop),
-		"remove":               NewBuiltin("remove", set_remove),
-		"symmetric_difference": NewBuiltin("symmetric_difference", set_symmetric_difference),
-		"union":                NewBuiltin("union", set_union),
-		"update":               NewBuiltin("update", set_update),
+		"add":                  newMethod("add", set_add),
+		"clear":                newMethod("clear", set_clear),
+		"difference":           newMethod("difference", set_difference),
+		"discard":              newMethod("discard", set_discard),
+		"intersection":         newMethod("intersection", set_intersection),
+		"issubset":             newMethod("issubset", set_issubset),
+		"issuperset":           newMethod("issuperset", set_issuperset),
+		"pop":                  newMethod("pop", set_pop),
+		"remove":               newMethod("remove", set_remove),
+		"symmetric_difference": newMethod("symmetric_difference", set_symmetric_difference),
+		"union":                newMethod("union", set_union),
+		"update":               newMethod("update", set_update),
 	}
 )
 
@@ -163,6 +165,68 @@ func builtinAttr(recv Value, name string, methods map[string]*Builtin) (Value, e
 	return b.BindReceiver(recv), nil
 }
 
+// A methodKind identifies a built-in type that has methods.
+type methodKind uint8
+
+const (
+	noMethods methodKind = iota
+	bytesKind
+	dictKind
+	listKind
+	setKind
+	stringKind
+)
+
+// methodTables maps each methodKind to its table of methods.
+var methodTables = [...]map[string]*Builtin{
+	bytesKind:  bytesMethods,
+	dictKind:   dictMethods,
+	listKind:   listMethods,
+	setKind:    setMethods,
+	stringKind: stringMethods,
+}
+
+func methodKindOf(x Value) methodKind {
+	switch x.(type) {
+	case Bytes:
+		return bytesKind
+	case *Dict:
+		return dictKind
+	case *List:
+		return listKind
+	case *Set:
+		return setKind
+	case String:
+		return stringKind
+	}
+	return noMethods
+}
+
+// A methodCacheEntry is the inline cache of a method call site:
+// the method of the given kind of receiver.
+type methodCacheEntry struct {
+	kind   methodKind
+	method *Builtin
+}
+
+// lookupMethod returns the unbound method called by site
+// if recv is a value of a built-in type that has such a method.
+// Otherwise it returns nil, and the caller should use getAttr.
+func lookupMethod(prog *compile.Program, site *compile.MethodSite, recv Value) *Builtin {
+	kind := methodKindOf(recv)
+	if kind == noMethods {
+		return nil
+	}
+	if e, ok := site.Cache.Load().(methodCacheEntry); ok && e.kind == kind {
+		return e.method
+	}
+	method := methodTables[kind][prog.Names[site.Name]]
+	if method != nil {
+		site.Cache.Store(methodCacheEntry{kind, method})
+	}
+	return method
+}
+
 func builtinAttrNames(methods map[string]*Builtin) []string {
 	names := make([]string, 0, len(methods))
 	for name := range methods {
@@ -189,7 +253,7 @@ func abs(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 		}
 		return zero.Sub(x), nil
 	default:
-		return nil, fmt.Errorf("got %s, want int or float", x.Type())
+		return nil, Errorf(TypeError, "got %s, want int or float", x.Type())
 	}
 }
 
@@ -239,10 +303,10 @@ func bool_(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#bytes
 func bytes_(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if len(kwargs) > 0 {
-		return nil, fmt.Errorf("bytes does not accept keyword arguments")
+		return nil, Errorf(TypeError, "bytes does not accept keyword arguments")
 	}
 	if len(args) != 1 {
-		return nil, fmt.Errorf("bytes: got %d arguments, want exactly 1", len(args))
+		return nil, Errorf(TypeError, "bytes: got %d arguments, want exactly 1", len(args))
 	}
 	switch x := args[0].(type) {
 	case Bytes:
@@ -263,7 +327,7 @@ func bytes_(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 		var b byte
 		for i := 0; iter.Next(&elem); i++ {
 			if err := AsInt(elem, &b); err != nil {
-				return nil, fmt.Errorf("bytes: at index %d, %s", i, err)
+				return nil, withKind(TypeError, fmt.Errorf("bytes: at index %d, %w", i, err))
 			}
 			buf.WriteByte(b)
 		}
@@ -271,27 +335,27 @@ func bytes_(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 
 	default:
 		// Unlike string(foo), which stringifies it, bytes(foo) is an error.
-		return nil, fmt.Errorf("bytes: got %s, want string, bytes, or iterable of ints", x.Type())
+		return nil, Errorf(TypeError, "bytes: got %s, want string, bytes, or iterable of ints", x.Type())
 	}
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#chr
 func chr(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if len(kwargs) > 0 {
-		return nil, fmt.Errorf("chr does not accept keyword arguments")
+		return nil, Errorf(TypeError, "chr does not accept keyword arguments")
 	}
 	if len(args) != 1 {
-		return nil, fmt.Errorf("chr: got %d arguments, want 1", len(args))
+		return nil, Errorf(TypeError, "chr: got %d arguments, want 1", len(args))
 	}
 	i, err := AsInt32(args[0])
 	if err != nil {
-		return nil, fmt.Errorf("chr: %s", err)
+		return nil, withKind(TypeError, fmt.Errorf("chr: %w", err))
 	}
 	if i < 0 {
-		return nil, fmt.Errorf("chr: Unicode code point %d out of range (<0)", i)
+		return nil, Errorf(ValueError, "chr: Unicode code point %d out of range (<0)", i)
 	}
 	if i > unicode.MaxRune {
-		return nil, fmt.Errorf("chr: Unicode code point U+%X out of range (>0x10FFFF)", i)
+		return nil, Errorf(ValueError, "chr: Unicode code point U+%X out of range (>0x10FFFF)", i)
 	}
 	return String(string(rune(i))), nil
 }
@@ -299,11 +363,11 @@ func chr(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict
 func dict(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if len(args) > 1 {
-		return nil, fmt.Errorf("dict: got %d arguments, want at most 1", len(args))
+		return nil, Errorf(TypeError, "dict: got %d arguments, want at most 1", len(args))
 	}
 	dict := NewDict(thread, 0)
-	if err := updateDict(dict, args, kwargs); err != nil {
-		return nil, fmt.Errorf("dict: %v", err)
+	if err := updateDict(thread, dict, args, kwargs); err != nil {
+		return nil, withKind(TypeError, fmt.Errorf("dict: %w", err))
 	}
 	return dict, nil
 }
@@ -311,10 +375,10 @@ func dict(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dir
 func dir(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if len(kwargs) > 0 {
-		return nil, fmt.Errorf("dir does not accept keyword arguments")
+		return nil, Errorf(TypeError, "dir does not accept keyword arguments")
 	}
 	if len(args) != 1 {
-		return nil, fmt.Errorf("dir: got %d arguments, want 1", len(args))
+		return nil, Errorf(TypeError, "dir: got %d arguments, want 1", len(args))
 	}
 
 	var names []string
@@ -368,7 +432,9 @@ func enumerate(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, e
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#fail
 func fail(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	sep := " "
-	if err := UnpackArgs("fail", nil, kwargs, "sep?", &sep); err != nil {
+	var cause Value = None
+	var details Value = None
+	if err := UnpackArgs("fail", nil, kwargs, "sep?", &sep, "cause?", &cause, "details?", &details); err != nil {
 		return nil, err
 	}
 	buf := new(strings.Builder)
@@ -384,18 +450,26 @@ func fail(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 		}
 	}
 
-	return nil, errors.New(buf.String())
+	err := &FailError{Msg: buf.String(), Details: details}
+	switch cause := cause.(type) {
+	case NoneType:
+	case *Failure:
+		err.Cause = cause
+	default:
+		return nil, Errorf(TypeError, "fail: for parameter cause: got %s, want error or None", cause.Type())
+	}
+	return nil, err
 }
 
 func float(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if len(kwargs) > 0 {
-		return nil, fmt.Errorf("float does not accept keyword arguments")
+		return nil, Errorf(TypeError, "float does not accept keyword arguments")
 	}
 	if len(args) == 0 {
 		return Float(0.0), nil
 	}
 	if len(args) != 1 {
-		return nil, fmt.Errorf("float got %d arguments, wants 1", len(args))
+		return nil, Errorf(TypeError, "float got %d arguments, wants 1", len(args))
 	}
 	switch x := args[0].(type) {
 	case Bool:
@@ -410,7 +484,7 @@ func float(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 		return x, nil
 	case String:
 		if x == "" {
-			return nil, fmt.Errorf("float: empty string")
+			return nil, Errorf(ValueError, "float: empty string")
 		}
 		// +/- NaN or Inf or Infinity (case insensitive)?
 		s := string(x)
@@ -434,14 +508,14 @@ func float(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 		}
 		f, err := strconv.ParseFloat(s, 64)
 		if math.IsInf(f, 0) {
-			return nil, fmt.Errorf("floating-point number too large")
+			return nil, Errorf(Overflow, "floating-point number too large")
 		}
 		if err != nil {
-			return nil, fmt.Errorf("invalid float literal: %s", s)
+			return nil, Errorf(ValueError, "invalid float literal: %s", s)
 		}
 		return Float(f), nil
 	default:
-		return nil, fmt.Errorf("float got %s, want number or string", x.Type())
+		return nil, Errorf(TypeError, "float got %s, want number or string", x.Type())
 	}
 }
 
@@ -452,14 +526,14 @@ var (
 )
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#getattr
-func getattr(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func getattr(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var object, dflt Value
 	var name string
 	if err := UnpackPositionalArgs("getattr", args, kwargs, 2, &object, &name, &dflt); err != nil {
 		return nil, err
 	}
 	if object, ok := object.(HasAttrs); ok {
-		v, err := object.Attr(name)
+		v, err := threadAttr(thread, object, name)
 		if err != nil {
 			// An error could mean the field doesn't exist,
 			// or it exists but could not be computed.
@@ -476,18 +550,18 @@ func getattr(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if dflt != nil {
 		return dflt, nil
 	}
-	return nil, fmt.Errorf("getattr: %s has no .%s field or method", object.Type(), name)
+	return nil, Errorf(AttributeError, "getattr: %s has no .%s field or method", object.Type(), name)
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#hasattr
-func hasattr(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func hasattr(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var object Value
 	var name string
 	if err := UnpackPositionalArgs("hasattr", args, kwargs, 2, &object, &name); err != nil {
 		return nil, err
 	}
 	if object, ok := object.(HasAttrs); ok {
-		v, err := object.Attr(name)
+		v, err := threadAttr(thread, object, name)
 		if err == nil {
 			return Bool(v != nil), nil
 		}
@@ -525,7 +599,7 @@ func hash(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	case Bytes:
 		h = int64(softHashString(string(x))) // FNV32
 	default:
-		return nil, fmt.Errorf("hash: got %s, want string or bytes", x.Type())
+		return nil, Errorf(TypeError, "hash: got %s, want string or bytes", x.Type())
 	}
 	return MakeInt64(h), nil
 }
@@ -561,21 +635,21 @@ func int_(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 			var err error
 			b, err = AsInt32(base)
 			if err != nil {
-				return nil, fmt.Errorf("int: for base, got %s, want int", base.Type())
+				return nil, Errorf(TypeError, "int: for base, got %s, want int", base.Type())
 			}
 			if b != 0 && (b < 2 || b > 36) {
-				return nil, fmt.Errorf("int: base must be an integer >= 2 && <= 36")
+				return nil, Errorf(ValueError, "int: base must be an integer >= 2 && <= 36")
 			}
 		}
 		res := parseInt(s, b)
 		if res == nil {
-			return nil, fmt.Errorf("int: invalid literal with base %d: %s", b, s)
+			return nil, Errorf(ValueError, "int: invalid literal with base %d: %s", b, s)
 		}
 		return res, nil
 	}
 
 	if base != nil {
-		return nil, fmt.Errorf("int: can't convert non-string with explicit base")
+		return nil, Errorf(TypeError, "int: can't convert non-string with explicit base")
 	}
 
 	if b, ok := x.(Bool); ok {
@@ -588,7 +662,7 @@ func int_(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 
 	i, err := NumberToInt(x)
 	if err != nil {
-		return nil, fmt.Errorf("int: %s", err)
+		return nil, withKind(TypeError, fmt.Errorf("int: %w", err))
 	}
 	return i, nil
 }
@@ -671,7 +745,7 @@ func len_(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	}
 	len := Len(x)
 	if len < 0 {
-		return nil, fmt.Errorf("len: value of type %s has no len", x.Type())
+		return nil, Errorf(TypeError, "len: value of type %s has no len", x.Type())
 	}
 	return MakeInt(len), nil
 }
@@ -684,23 +758,43 @@ func list(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	}
 	var elems []Value
 	if iterable != nil {
-		iter := iterable.Iterate()
-		defer iter.Done()
-		if n := Len(iterable); n > 0 {
-			elems = make([]Value, 0, n) // preallocate if length known
-		}
-		var x Value
-		for iter.Next(&x) {
-			elems = append(elems, x)
+		var err error
+		if elems, err = collect(thread, iterable); err != nil {
+			return nil, err
 		}
 	}
 	return NewList(thread, elems), nil
 }
 
+// collect returns a new slice of the elements of iterable,
+// recording their storage with AddAllocs.
+func collect(thread *Thread, iterable Iterable) ([]Value, error) {
+	iter := iterable.Iterate()
+	defer iter.Done()
+	var elems []Value
+	n := Len(iterable)
+	if n > 0 {
+		if err := thread.AddAllocs(valueSize * int64(n)); err != nil {
+			return nil, err
+		}
+		elems = make([]Value, 0, n) // preallocate if length known
+	}
+	var x Value
+	for iter.Next(&x) {
+		if len(elems) >= n {
+			if err := thread.AddAllocs(valueSize); err != nil {
+				return nil, err
+			}
+		}
+		elems = append(elems, x)
+	}
+	return elems, nil
+}
+
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#min
 func minmax(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if len(args) == 0 {
-		return nil, fmt.Errorf("%s requires at least one positional argument", b.Name())
+		return nil, Errorf(TypeError, "%s requires at least one positional argument", b.Name())
 	}
 	var keyFunc Callable
 	if err := UnpackArgs(b.Name(), nil, kwargs, "key?", &keyFunc); err != nil {
@@ -720,12 +814,12 @@ func minmax(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, erro
 	}
 	iter := Iterate(iterable)
 	if iter == nil {
-		return nil, fmt.Errorf("%s: %s value is not iterable", b.Name(), iterable.Type())
+		return nil, Errorf(TypeError, "%s: %s value is not iterable", b.Name(), iterable.Type())
 	}
 	defer iter.Done()
 	var extremum Value
 	if !iter.Next(&extremum) {
-		return nil, nameErr(b, "argument is an empty sequence")
+		return nil, nameErr(b, Errorf(ValueError, "argument is an empty sequence"))
 	}
 
 	var extremeKey Value
@@ -768,10 +862,10 @@ func minmax(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, erro
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#ord
 func ord(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if len(kwargs) > 0 {
-		return nil, fmt.Errorf("ord does not accept keyword arguments")
+		return nil, Errorf(TypeError, "ord does not accept keyword arguments")
 	}
 	if len(args) != 1 {
-		return nil, fmt.Errorf("ord: got %d arguments, want 1", len(args))
+		return nil, Errorf(TypeError, "ord: got %d arguments, want 1", len(args))
 	}
 	switch x := args[0].(type) {
 	case String:
@@ -780,18 +874,18 @@ func ord(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 		r, sz := utf8.DecodeRuneInString(s)
 		if sz == 0 || sz != len(s) {
 			n := utf8.RuneCountInString(s)
-			return nil, fmt.Errorf("ord: string encodes %d Unicode code points, want 1", n)
+			return nil, Errorf(ValueError, "ord: string encodes %d Unicode code points, want 1", n)
 		}
 		return MakeInt(int(r)), nil
 
 	case Bytes:
 		// ord(bytes) returns int value of sole byte.
 		if len(x) != 1 {
-			return nil, fmt.Errorf("ord: bytes has length %d, want 1", len(x))
+			return nil, Errorf(ValueError, "ord: bytes has length %d, want 1", len(x))
 		}
 		return MakeInt(int(x[0])), nil
 	default:
-		return nil, fmt.Errorf("ord: got %s, want string or bytes", x.Type())
+		return nil, Errorf(TypeError, "ord: got %s, want string or bytes", x.Type())
 	}
 }
 
@@ -838,7 +932,7 @@ func range_(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	}
 	if step == 0 {
 		// we were given range(start, stop, 0)
-		return nil, nameErr(b, "step argument must not be zero")
+		return nil, nameErr(b, Errorf(ValueError, "step argument must not be zero"))
 	}
 
 	return rangeValue{start: start, stop: stop, step: step, len: rangeLen(start, stop, step)}, nil
@@ -902,7 +996,7 @@ func (r rangeValue) String() string {
 }
 func (r rangeValue) Type() string          { return "range" }
 func (r rangeValue) Truth() Bool           { return r.len > 0 }
-func (r rangeValue) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable: range") }
+func (r rangeValue) Hash() (uint32, error) { return 0, Errorf(TypeError, "unhashable: range") }
 
 func (x rangeValue) CompareSameType(op syntax.Token, y_ Value, depth int) (bool, error) {
 	y := y_.(rangeValue)
@@ -912,7 +1006,7 @@ func (x rangeValue) CompareSameType(op syntax.Token, y_ Value, depth int) (bool,
 	case syntax.NEQ:
 		return !rangeEqual(x, y), nil
 	default:
-		return false, fmt.Errorf("%s %s %s not implemented", x.Type(), op, y.Type())
+		return false, Errorf(TypeError, "%s %s %s not implemented", x.Type(), op, y.Type())
 	}
 }
 
@@ -970,15 +1064,9 @@ func reversed(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, er
 	if err := UnpackPositionalArgs("reversed", args, kwargs, 1, &iterable); err != nil {
 		return nil, err
 	}
-	iter := iterable.Iterate()
-	defer iter.Done()
-	var elems []Value
-	if n := Len(args[0]); n >= 0 {
-		elems = make([]Value, 0, n) // preallocate if length known
-	}
-	var x Value
-	for iter.Next(&x) {
-		elems = append(elems, x)
+	elems, err := collect(thread, iterable)
+	if err != nil {
+		return nil, err
 	}
 	n := len(elems)
 	for i := 0; i < n>>1; i++ {
@@ -999,7 +1087,7 @@ func set(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 		defer iter.Done()
 		var x Value
 		for iter.Next(&x) {
-			if err := set.Insert(x); err != nil {
+			if err := set.insert(thread, x); err != nil {
 				return nil, nameErr(b, err)
 			}
 		}
@@ -1021,15 +1109,9 @@ func sorted(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, erro
 		return nil, err
 	}
 
-	iter := iterable.Iterate()
-	defer iter.Done()
-	var values []Value
-	if n := Len(iterable); n > 0 {
-		values = make(Tuple, 0, n) // preallocate if length is known
-	}
-	var x Value
-	for iter.Next(&x) {
-		values = append(values, x)
+	values, err := collect(thread, iterable)
+	if err != nil {
+		return nil, err
 	}
 
 	// Derive keys from values by applying key function.
@@ -1080,20 +1162,22 @@ func (s *sortSlice) Swap(i, j int) {
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#str
-func str(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func str(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if len(kwargs) > 0 {
-		return nil, fmt.Errorf("str does not accept keyword arguments")
+		return nil, Errorf(TypeError, "str does not accept keyword arguments")
 	}
 	if len(args) != 1 {
-		return nil, fmt.Errorf("str: got %d arguments, want exactly 1", len(args))
+		return nil, Errorf(TypeError, "str: got %d arguments, want exactly 1", len(args))
 	}
 	switch x := args[0].(type) {
 	case String:
 		return x, nil
 	case Bytes:
 		// Invalid encodings are replaced by that of U+FFFD.
+		thread.countAlloc("string")
 		return String(utf8Transcode(string(x))), nil
 	default:
+		thread.countAlloc("string")
 		return String(x.String()), nil
 	}
 }
@@ -1113,7 +1197,7 @@ func utf8Transcode(s string) string {
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#tuple
-func tuple(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func tuple(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	var iterable Iterable
 	if err := UnpackPositionalArgs("tuple", args, kwargs, 0, &iterable); err != nil {
 		return nil, err
@@ -1121,26 +1205,20 @@ func tuple(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if len(args) == 0 {
 		return Tuple(nil), nil
 	}
-	iter := iterable.Iterate()
-	defer iter.Done()
-	var elems Tuple
-	if n := Len(iterable); n > 0 {
-		elems = make(Tuple, 0, n) // preallocate if length is known
-	}
-	var x Value
-	for iter.Next(&x) {
-		elems = append(elems, x)
+	elems, err := collect(thread, iterable)
+	if err != nil {
+		return nil, err
 	}
-	return elems, nil
+	return Tuple(elems), nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#type
 func type_(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if len(kwargs) > 0 {
-		return nil, fmt.Errorf("type does not accept keyword arguments")
+		return nil, Errorf(TypeError, "type does not accept keyword arguments")
 	}
 	if len(args) != 1 {
-		return nil, fmt.Errorf("type: got %d arguments, want exactly 1", len(args))
+		return nil, Errorf(TypeError, "type: got %d arguments, want exactly 1", len(args))
 	}
 	return String(args[0].Type()), nil
 }
@@ -1148,7 +1226,7 @@ func type_(_ *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#zip
 func zip(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	if len(kwargs) > 0 {
-		return nil, fmt.Errorf("zip does not accept keyword arguments")
+		return nil, Errorf(TypeError, "zip does not accept keyword arguments")
 	}
 	rows, cols := 0, len(args)
 	iters := make([]Iterator, cols)
@@ -1162,7 +1240,7 @@ func zip(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 	for i, seq := range args {
 		it := Iterate(seq)
 		if it == nil {
-			return nil, fmt.Errorf("zip: argument #%d is not iterable: %s", i+1, seq.Type())
+			return nil, Errorf(TypeError, "zip: argument #%d is not iterable: %s", i+1, seq.Type())
 		}
 		iters[i] = it
 		n := Len(seq)
@@ -1202,12 +1280,12 @@ func zip(thread *Thread, _ *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 // ---- methods of built-in types ---
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict·get
-func dict_get(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_get(_ *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var key, dflt Value
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &key, &dflt); err != nil {
 		return nil, err
 	}
-	if v, ok, err := b.Receiver().(*Dict).Get(key); err != nil {
+	if v, ok, err := recv.(*Dict).Get(key); err != nil {
 		return nil, nameErr(b, err)
 	} else if ok {
 		return v, nil
@@ -1218,19 +1296,19 @@ func dict_get(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict·clear
-func dict_clear(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_clear(thread *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	return None, b.Receiver().(*Dict).Clear()
+	return None, recv.(*Dict).clear(thread)
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict·items
-func dict_items(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_items(thread *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	items := b.Receiver().(*Dict).Items()
+	items := recv.(*Dict).Items()
 	res := make([]Value, len(items))
 	for i, item := range items {
 		res[i] = item // convert [2]Value to Value
@@ -1239,41 +1317,41 @@ func dict_items(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value,
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict·keys
-func dict_keys(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_keys(thread *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	return NewList(thread, b.Receiver().(*Dict).Keys()), nil
+	return NewList(thread, recv.(*Dict).Keys()), nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict·pop
-func dict_pop(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_pop(thread *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var k, d Value
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &k, &d); err != nil {
 		return nil, err
 	}
-	if v, found, err := b.Receiver().(*Dict).Delete(k); err != nil {
+	if v, found, err := recv.(*Dict).delete(thread, k); err != nil {
 		return nil, nameErr(b, err) // dict is frozen or key is unhashable
 	} else if found {
 		return v, nil
 	} else if d != nil {
 		return d, nil
 	}
-	return nil, nameErr(b, "missing key")
+	return nil, nameErr(b, Errorf(KeyError, "missing key"))
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict·popitem
-func dict_popitem(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_popitem(thread *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	recv := b.Receiver().(*Dict)
+	recv := recv_.(*Dict)
 	recv.read()
 	k, ok := recv.ht.first()
 	if !ok {
-		return nil, nameErr(b, "empty dict")
+		return nil, nameErr(b, Errorf(KeyError, "empty dict"))
 	}
-	v, _, err := recv.Delete(k)
+	v, _, err := recv.delete(thread, k)
 	if err != nil {
 		return nil, nameErr(b, err) // dict is frozen
 	}
@@ -1281,17 +1359,17 @@ func dict_popitem(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, err
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict·setdefault
-func dict_setdefault(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_setdefault(thread *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var key, dflt Value = nil, None
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &key, &dflt); err != nil {
 		return nil, err
 	}
-	dict := b.Receiver().(*Dict)
+	dict := recv.(*Dict)
 	if v, ok, err := dict.Get(key); err != nil {
 		return nil, nameErr(b, err)
 	} else if ok {
 		return v, nil
-	} else if err := dict.SetKey(key, dflt); err != nil {
+	} else if err := dict.setKey(thread, key, dflt); err != nil {
 		return nil, nameErr(b, err)
 	} else {
 		return dflt, nil
@@ -1299,22 +1377,22 @@ func dict_setdefault(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value,
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict·update
-func dict_update(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_update(thread *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if len(args) > 1 {
-		return nil, fmt.Errorf("update: got %d arguments, want at most 1", len(args))
+		return nil, Errorf(TypeError, "update: got %d arguments, want at most 1", len(args))
 	}
-	if err := updateDict(b.Receiver().(*Dict), args, kwargs); err != nil {
-		return nil, fmt.Errorf("update: %v", err)
+	if err := updateDict(thread, recv.(*Dict), args, kwargs); err != nil {
+		return nil, withKind(TypeError, fmt.Errorf("update: %w", err))
 	}
 	return None, nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#dict·update
-func dict_values(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func dict_values(thread *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	items := b.Receiver().(*Dict).Items()
+	items := recv.(*Dict).Items()
 	res := make([]Value, len(items))
 	for i, item := range items {
 		res[i] = item[1]
@@ -1323,36 +1401,40 @@ func dict_values(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value,
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#list·append
-func list_append(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func list_append(thread *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var object Value
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &object); err != nil {
 		return nil, err
 	}
-	recv := b.Receiver().(*List)
+	recv := recv_.(*List)
 	if err := recv.checkMutable("append to"); err != nil {
 		return nil, nameErr(b, err)
 	}
+	if err := thread.AddAllocs(valueSize); err != nil {
+		return nil, err
+	}
 	recv.read()
-	recv.write()
+	recv.write(thread)
 	recv.elems = append(recv.elems, object)
+	thread.noteLen(recv, len(recv.elems))
 	return None, nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#list·clear
-func list_clear(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func list_clear(thread *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	recv := b.Receiver().(*List)
-	if err := recv.Clear(); err != nil {
+	recv := recv_.(*List)
+	if err := recv.clear(thread); err != nil {
 		return nil, nameErr(b, err)
 	}
 	return None, nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#list·extend
-func list_extend(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	recv := b.Receiver().(*List)
+func list_extend(thread *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+	recv := recv_.(*List)
 	var iterable Iterable
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &iterable); err != nil {
 		return nil, err
@@ -1360,18 +1442,20 @@ func list_extend(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, erro
 	if err := recv.checkMutable("extend"); err != nil {
 		return nil, nameErr(b, err)
 	}
-	listExtend(recv, iterable)
+	if err := listExtend(thread, recv, iterable); err != nil {
+		return nil, nameErr(b, err)
+	}
 	return None, nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#list·index
-func list_index(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func list_index(_ *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var value, start_, end_ Value
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &value, &start_, &end_); err != nil {
 		return nil, err
 	}
 
-	recv := b.Receiver().(*List)
+	recv := recv_.(*List)
 	start, end, err := indices(start_, end_, recv.Len())
 	if err != nil {
 		return nil, nameErr(b, err)
@@ -1385,12 +1469,12 @@ func list_index(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error
 			return MakeInt(i), nil
 		}
 	}
-	return nil, nameErr(b, "value not in list")
+	return nil, nameErr(b, Errorf(ValueError, "value not in list"))
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#list·insert
-func list_insert(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	recv := b.Receiver().(*List)
+func list_insert(thread *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+	recv := recv_.(*List)
 	var index int
 	var object Value
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 2, &index, &object); err != nil {
@@ -1404,27 +1488,31 @@ func list_insert(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, erro
 		index += recv.Len()
 	}
 
+	if err := thread.AddAllocs(valueSize); err != nil {
+		return nil, err
+	}
 	if index >= recv.Len() {
 		// end
 		recv.read()
-		recv.write()
+		recv.write(thread)
 		recv.elems = append(recv.elems, object)
 	} else {
 		if index < 0 {
 			index = 0 // start
 		}
 		recv.read()
-		recv.write()
+		recv.write(thread)
 		recv.elems = append(recv.elems, nil)
 		copy(recv.elems[index+1:], recv.elems[index:]) // slide up one
 		recv.elems[index] = object
 	}
+	thread.noteLen(recv, len(recv.elems))
 	return None, nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#list·remove
-func list_remove(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	recv := b.Receiver().(*List)
+func list_remove(thread *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+	recv := recv_.(*List)
 	var value Value
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &value); err != nil {
 		return nil, err
@@ -1433,21 +1521,20 @@ func list_remove(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, erro
 		return nil, nameErr(b, err)
 	}
 	recv.read()
-	recv.write()
+	recv.write(thread)
 	for i, elem := range recv.elems {
 		if eq, err := Equal(elem, value); err != nil {
-			return nil, fmt.Errorf("remove: %v", err)
+			return nil, fmt.Errorf("remove: %w", err)
 		} else if eq {
 			recv.elems = append(recv.elems[:i], recv.elems[i+1:]...)
 			return None, nil
 		}
 	}
-	return nil, fmt.Errorf("remove: element not found")
+	return nil, Errorf(ValueError, "remove: element not found")
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#list·pop
-func list_pop(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	recv := b.Receiver()
+func list_pop(thread *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	list := recv.(*List)
 	n := list.Len()
 	i := n - 1
@@ -1465,18 +1552,18 @@ func list_pop(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, er
 		return nil, nameErr(b, err)
 	}
 	list.read()
-	list.write()
+	list.write(thread)
 	res := list.elems[i]
 	list.elems = append(list.elems[:i], list.elems[i+1:]...)
 	return res, nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·capitalize
-func string_capitalize(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_capitalize(_ *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	s := string(b.Receiver().(String))
+	s := string(recv.(String))
 	res := new(strings.Builder)
 	res.Grow(len(s))
 	for i, r := range s {
@@ -1495,11 +1582,11 @@ func string_capitalize(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value
 // - codepoints: successive substrings that encode a single Unicode code point.
 // - elem_ords: numeric values of successive bytes
 // - codepoint_ords: numeric values of successive Unicode code points
-func string_iterable(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_iterable(_ *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	s := b.Receiver().(String)
+	s := recv.(String)
 	ords := b.Name()[len(b.Name())-2] == 'd'
 	codepoints := b.Name()[0] == 'c'
 	if codepoints {
@@ -1511,11 +1598,11 @@ func string_iterable(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value,
 
 // bytes_elems returns an unspecified iterable value whose
 // iterator yields the int values of successive elements.
-func bytes_elems(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func bytes_elems(_ *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	return bytesIterable{b.Receiver().(Bytes)}, nil
+	return bytesIterable{recv.(Bytes)}, nil
 }
 
 // A bytesIterable is an iterable returned by bytes.elems(),
@@ -1524,12 +1611,14 @@ type bytesIterable struct{ bytes Bytes }
 
 var _ Iterable = (*bytesIterable)(nil)
 
-func (bi bytesIterable) String() string        { return bi.bytes.String() + ".elems()" }
-func (bi bytesIterable) Type() string          { return "bytes.elems" }
-func (bi bytesIterable) Freeze()               {} // immutable
-func (bi bytesIterable) Truth() Bool           { return True }
-func (bi bytesIterable) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable: %s", bi.Type()) }
-func (bi bytesIterable) Iterate() Iterator     { return &bytesIterator{bi.bytes} }
+func (bi bytesIterable) String() string { return bi.bytes.String() + ".elems()" }
+func (bi bytesIterable) Type() string   { return "bytes.elems" }
+func (bi bytesIterable) Freeze()        {} // immutable
+func (bi bytesIterable) Truth() Bool    { return True }
+func (bi bytesIterable) Hash() (uint32, error) {
+	return 0, Errorf(TypeError, "unhashable: %s", bi.Type())
+}
+func (bi bytesIterable) Iterate() Iterator { return &bytesIterator{bi.bytes} }
 
 type bytesIterator struct{ bytes Bytes }
 
@@ -1545,14 +1634,14 @@ func (it *bytesIterator) Next(p *Value) bool {
 func (*bytesIterator) Done() {}
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·count
-func string_count(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_count(_ *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var sub string
 	var start_, end_ Value
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &sub, &start_, &end_); err != nil {
 		return nil, err
 	}
 
-	recv := string(b.Receiver().(String))
+	recv := string(recv_.(String))
 	start, end, err := indices(start_, end_, len(recv))
 	if err != nil {
 		return nil, nameErr(b, err)
@@ -1566,11 +1655,11 @@ func string_count(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, err
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·isalnum
-func string_isalnum(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_isalnum(_ *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	recv := string(b.Receiver().(String))
+	recv := string(recv_.(String))
 	for _, r := range recv {
 		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
 			return False, nil
@@ -1580,11 +1669,11 @@ func string_isalnum(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, e
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·isalpha
-func string_isalpha(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_isalpha(_ *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	recv := string(b.Receiver().(String))
+	recv := string(recv_.(String))
 	for _, r := range recv {
 		if !unicode.IsLetter(r) {
 			return False, nil
@@ -1594,11 +1683,11 @@ func string_isalpha(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, e
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·isdigit
-func string_isdigit(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_isdigit(_ *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	recv := string(b.Receiver().(String))
+	recv := string(recv_.(String))
 	for _, r := range recv {
 		if !unicode.IsDigit(r) {
 			return False, nil
@@ -1608,11 +1697,11 @@ func string_isdigit(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, e
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·islower
-func string_islower(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_islower(_ *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	recv := string(b.Receiver().(String))
+	recv := string(recv_.(String))
 	return Bool(isCasedString(recv) && recv == strings.ToLower(recv)), nil
 }
 
@@ -1633,11 +1722,11 @@ func isCasedRune(r rune) bool {
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·isspace
-func string_isspace(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_isspace(_ *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	recv := string(b.Receiver().(String))
+	recv := string(recv_.(String))
 	for _, r := range recv {
 		if !unicode.IsSpace(r) {
 			return False, nil
@@ -1647,11 +1736,11 @@ func string_isspace(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, e
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·istitle
-func string_istitle(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_istitle(_ *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	recv := string(b.Receiver().(String))
+	recv := string(recv_.(String))
 
 	// Python semantics differ from x==strings.{To,}Title(x) in Go:
 	// "uppercase characters may only follow uncased characters and
@@ -1680,22 +1769,22 @@ func string_istitle(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, e
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·isupper
-func string_isupper(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_isupper(_ *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	recv := string(b.Receiver().(String))
+	recv := string(recv_.(String))
 	return Bool(isCasedString(recv) && recv == strings.ToUpper(recv)), nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·find
-func string_find(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	return string_find_impl(b, args, kwargs, true, false)
+func string_find(_ *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	return string_find_impl(b, recv, args, kwargs, true, false)
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·format
-func string_format(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	format := string(b.Receiver().(String))
+func string_format(_ *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	format := string(recv.(String))
 	var auto, manual bool // kinds of positional indexing used
 	buf := new(strings.Builder)
 	index := 0
@@ -1714,7 +1803,7 @@ func string_format(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, er
 				break
 			}
 			if len(literal) == j+1 || literal[j+1] != '}' {
-				return nil, fmt.Errorf("format: single '}' in format")
+				return nil, Errorf(ValueError, "format: single '}' in format")
 			}
 			buf.WriteString(literal[:j+1])
 			literal = literal[j+2:]
@@ -1734,7 +1823,7 @@ func string_format(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, er
 		format = format[i+1:]
 		i = strings.IndexByte(format, '}')
 		if i < 0 {
-			return nil, fmt.Errorf("format: unmatched '{' in format")
+			return nil, Errorf(ValueError, "format: unmatched '{' in format")
 		}
 
 		var arg Value
@@ -1769,22 +1858,22 @@ func string_format(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, er
 		if name == "" {
 			// "{}": automatic indexing
 			if manual {
-				return nil, fmt.Errorf("format: cannot switch from manual field specification to automatic field numbering")
+				return nil, Errorf(ValueError, "format: cannot switch from manual field specification to automatic field numbering")
 			}
 			auto = true
 			if index >= len(args) {
-				return nil, fmt.Errorf("format: tuple index out of range")
+				return nil, Errorf(IndexError, "format: tuple index out of range")
 			}
 			arg = args[index]
 			index++
 		} else if num, ok := decimal(name); ok {
 			// positional argument
 			if auto {
-				return nil, fmt.Errorf("format: cannot switch from automatic field numbering to manual field specification")
+				return nil, Errorf(ValueError, "format: cannot switch from automatic field numbering to manual field specification")
 			}
 			manual = true
 			if num >= len(args) {
-				return nil, fmt.Errorf("format: tuple index out of range")
+				return nil, Errorf(IndexError, "format: tuple index out of range")
 			} else {
 				arg = args[num]
 			}
@@ -1800,21 +1889,21 @@ func string_format(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, er
 				// Starlark does not support Python's x.y or a[i] syntaxes,
 				// or nested use of {...}.
 				if strings.Contains(name, ".") {
-					return nil, fmt.Errorf("format: attribute syntax x.y is not supported in replacement fields: %s", name)
+					return nil, Errorf(ValueError, "format: attribute syntax x.y is not supported in replacement fields: %s", name)
 				}
 				if strings.Contains(name, "[") {
-					return nil, fmt.Errorf("format: element syntax a[i] is not supported in replacement fields: %s", name)
+					return nil, Errorf(ValueError, "format: element syntax a[i] is not supported in replacement fields: %s", name)
 				}
 				if strings.Contains(name, "{") {
-					return nil, fmt.Errorf("format: nested replacement fields not supported")
+					return nil, Errorf(ValueError, "format: nested replacement fields not supported")
 				}
-				return nil, fmt.Errorf("format: keyword %s not found", name)
+				return nil, Errorf(KeyError, "format: keyword %s not found", name)
 			}
 		}
 
 		if spec != "" {
 			// Starlark does not support Python's format_spec features.
-			return nil, fmt.Errorf("format spec features not supported in replacement fields: %s", spec)
+			return nil, Errorf(ValueError, "format spec features not supported in replacement fields: %s", spec)
 		}
 
 		switch conv {
@@ -1827,7 +1916,7 @@ func string_format(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, er
 		case "r":
 			writeValue(buf, arg, nil)
 		default:
-			return nil, fmt.Errorf("format: unknown conversion %q", conv)
+			return nil, Errorf(ValueError, "format: unknown conversion %q", conv)
 		}
 	}
 	return String(buf.String()), nil
@@ -1850,13 +1939,13 @@ func decimal(s string) (x int, ok bool) {
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·index
-func string_index(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	return string_find_impl(b, args, kwargs, false, false)
+func string_index(_ *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	return string_find_impl(b, recv, args, kwargs, false, false)
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·join
-func string_join(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	recv := string(b.Receiver().(String))
+func string_join(thread *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+	recv := string(recv_.(String))
 	var iterable Iterable
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &iterable); err != nil {
 		return nil, err
@@ -1871,30 +1960,34 @@ func string_join(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, erro
 		}
 		s, ok := AsString(x)
 		if !ok {
-			return nil, fmt.Errorf("join: in list, want string, got %s", x.Type())
+			return nil, Errorf(TypeError, "join: in list, want string, got %s", x.Type())
+		}
+		if err := thread.AddAllocs(int64(len(recv) + len(s))); err != nil {
+			return nil, err
 		}
 		buf.WriteString(s)
 	}
+	thread.countAlloc("string")
 	return String(buf.String()), nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·lower
-func string_lower(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_lower(_ *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	return String(strings.ToLower(string(b.Receiver().(String)))), nil
+	return String(strings.ToLower(string(recv.(String)))), nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·partition
-func string_partition(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	recv := string(b.Receiver().(String))
+func string_partition(_ *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+	recv := string(recv_.(String))
 	var sep string
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &sep); err != nil {
 		return nil, err
 	}
 	if sep == "" {
-		return nil, nameErr(b, "empty separator")
+		return nil, nameErr(b, Errorf(ValueError, "empty separator"))
 	}
 	var i int
 	if b.Name()[0] == 'p' {
@@ -1917,8 +2010,8 @@ func string_partition(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value,
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·removeprefix
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·removesuffix
-func string_removefix(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	recv := string(b.Receiver().(String))
+func string_removefix(_ *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+	recv := string(recv_.(String))
 	var fix string
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &fix); err != nil {
 		return nil, err
@@ -1932,29 +2025,38 @@ func string_removefix(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value,
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·replace
-func string_replace(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	recv := string(b.Receiver().(String))
+func string_replace(thread *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+	recv := string(recv_.(String))
 	var old, new string
 	count := -1
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 2, &old, &new, &count); err != nil {
 		return nil, err
 	}
+	if len(new) > len(old) {
+		n := strings.Count(recv, old)
+		if count >= 0 && count < n {
+			n = count
+		}
+		if err := thread.AddAllocs(int64(len(recv)) + int64(n)*int64(len(new)-len(old))); err != nil {
+			return nil, err
+		}
+	}
 	return String(strings.Replace(recv, old, new, count)), nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·rfind
-func string_rfind(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	return string_find_impl(b, args, kwargs, true, true)
+func string_rfind(_ *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	return string_find_impl(b, recv, args, kwargs, true, true)
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·rindex
-func string_rindex(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	return string_find_impl(b, args, kwargs, false, true)
+func string_rindex(_ *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	return string_find_impl(b, recv, args, kwargs, false, true)
 }
 
 // https://github.com/google/starlark-go/starlark/blob/master/doc/spec.md#string·startswith
 // https://github.com/google/starlark-go/starlark/blob/master/doc/spec.md#string·endswith
-func string_startswith(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_startswith(_ *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var x Value
 	var start, end Value = None, None
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x, &start, &end); err != nil {
@@ -1962,7 +2064,7 @@ func string_startswith(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value
 	}
 
 	// compute effective substring.
-	s := string(b.Receiver().(String))
+	s := string(recv.(String))
 	if start, end, err := indices(start, end, len(s)); err != nil {
 		return nil, nameErr(b, err)
 	} else {
@@ -1982,7 +2084,7 @@ func string_startswith(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value
 		for i, x := range x {
 			prefix, ok := AsString(x)
 			if !ok {
-				return nil, fmt.Errorf("%s: want string, got %s, for element %d",
+				return nil, Errorf(TypeError, "%s: want string, got %s, for element %d",
 					b.Name(), x.Type(), i)
 			}
 			if f(s, prefix) {
@@ -1993,18 +2095,18 @@ func string_startswith(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value
 	case String:
 		return Bool(f(s, string(x))), nil
 	}
-	return nil, fmt.Errorf("%s: got %s, want string or tuple of string", b.Name(), x.Type())
+	return nil, Errorf(TypeError, "%s: got %s, want string or tuple of string", b.Name(), x.Type())
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·strip
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·lstrip
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·rstrip
-func string_strip(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_strip(_ *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var chars string
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0, &chars); err != nil {
 		return nil, err
 	}
-	recv := string(b.Receiver().(String))
+	recv := string(recv_.(String))
 	var s string
 	switch b.Name()[0] {
 	case 's': // strip
@@ -2030,12 +2132,12 @@ func string_strip(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, err
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·title
-func string_title(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_title(_ *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
 
-	s := string(b.Receiver().(String))
+	s := string(recv.(String))
 
 	// Python semantics differ from x==strings.{To,}Title(x) in Go:
 	// "uppercase characters may only follow uncased characters and
@@ -2056,17 +2158,17 @@ func string_title(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, err
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·upper
-func string_upper(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_upper(_ *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	return String(strings.ToUpper(string(b.Receiver().(String)))), nil
+	return String(strings.ToUpper(string(recv.(String)))), nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·split
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·rsplit
-func string_split(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	recv := string(b.Receiver().(String))
+func string_split(thread *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
+	recv := string(recv_.(String))
 	var sep_ Value
 	maxsplit := -1
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0, &sep_, &maxsplit); err != nil {
@@ -2087,7 +2189,7 @@ func string_split(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value
 
 	} else if sep, ok := AsString(sep_); ok {
 		if sep == "" {
-			return nil, fmt.Errorf("split: empty separator")
+			return nil, Errorf(ValueError, "split: empty separator")
 		}
 		// usual case: split on non-empty separator
 		if maxsplit < 0 {
@@ -2103,7 +2205,7 @@ func string_split(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value
 		}
 
 	} else {
-		return nil, fmt.Errorf("split: got %s for separator, want string", sep_.Type())
+		return nil, Errorf(TypeError, "split: got %s for separator, want string", sep_.Type())
 	}
 
 	list := make([]Value, len(res))
@@ -2168,13 +2270,13 @@ func splitspace(s string, max int) []string {
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#string·splitlines
-func string_splitlines(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func string_splitlines(thread *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var keepends bool
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0, &keepends); err != nil {
 		return nil, err
 	}
 	var lines []string
-	if s := string(b.Receiver().(String)); s != "" {
+	if s := string(recv.(String)); s != "" {
 		// TODO(adonovan): handle CRLF correctly.
 		if keepends {
 			lines = strings.SplitAfter(s, "\n")
@@ -2193,12 +2295,12 @@ func string_splitlines(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#set·add.
-func set_add(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func set_add(thread *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var elem Value
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &elem); err != nil {
 		return nil, err
 	}
-	recv := b.Receiver().(*Set)
+	recv := recv_.(*Set)
 	// It is always an error to attempt to mutate a set that cannot be mutated
 	if err := recv.ht.checkMutable("insert into"); err != nil {
 		return nil, nameErr(b, err)
@@ -2209,7 +2311,7 @@ func set_add(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 	} else if found {
 		return None, nil
 	}
-	err := recv.Insert(elem)
+	err := recv.insert(thread, elem)
 	if err != nil {
 		return nil, nameErr(b, err)
 	}
@@ -2217,12 +2319,12 @@ func set_add(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#set·clear.
-func set_clear(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func set_clear(thread *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	if b.Receiver().(*Set).Len() > 0 {
-		if err := b.Receiver().(*Set).Clear(); err != nil {
+	if recv.(*Set).Len() > 0 {
+		if err := recv.(*Set).clear(thread); err != nil {
 			return nil, nameErr(b, err)
 		}
 	}
@@ -2230,7 +2332,7 @@ func set_clear(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error)
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#set·difference.
-func set_difference(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func set_difference(thread *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	// TODO: support multiple others: s.difference(*others)
 	var other Iterable
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0, &other); err != nil {
@@ -2238,7 +2340,7 @@ func set_difference(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Val
 	}
 	iter := other.Iterate()
 	defer iter.Done()
-	diff, err := b.Receiver().(*Set).Difference(thread, iter)
+	diff, err := recv.(*Set).Difference(thread, iter)
 	if err != nil {
 		return nil, nameErr(b, err)
 	}
@@ -2246,7 +2348,7 @@ func set_difference(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Val
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#set_intersection.
-func set_intersection(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func set_intersection(thread *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	// TODO: support multiple others: s.difference(*others)
 	var other Iterable
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0, &other); err != nil {
@@ -2254,7 +2356,7 @@ func set_intersection(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (V
 	}
 	iter := other.Iterate()
 	defer iter.Done()
-	diff, err := b.Receiver().(*Set).Intersection(thread, iter)
+	diff, err := recv.(*Set).Intersection(thread, iter)
 	if err != nil {
 		return nil, nameErr(b, err)
 	}
@@ -2262,14 +2364,14 @@ func set_intersection(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (V
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#set_issubset.
-func set_issubset(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func set_issubset(_ *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var other Iterable
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0, &other); err != nil {
 		return nil, err
 	}
 	iter := other.Iterate()
 	defer iter.Done()
-	diff, err := b.Receiver().(*Set).IsSubset(iter)
+	diff, err := recv.(*Set).IsSubset(iter)
 	if err != nil {
 		return nil, nameErr(b, err)
 	}
@@ -2277,14 +2379,14 @@ func set_issubset(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, err
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#set_issuperset.
-func set_issuperset(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func set_issuperset(_ *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var other Iterable
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0, &other); err != nil {
 		return nil, err
 	}
 	iter := other.Iterate()
 	defer iter.Done()
-	diff, err := b.Receiver().(*Set).IsSuperset(iter)
+	diff, err := recv.(*Set).IsSuperset(iter)
 	if err != nil {
 		return nil, nameErr(b, err)
 	}
@@ -2292,12 +2394,12 @@ func set_issuperset(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, e
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#set·discard.
-func set_discard(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func set_discard(thread *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var k Value
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &k); err != nil {
 		return nil, err
 	}
-	recv := b.Receiver().(*Set)
+	recv := recv_.(*Set)
 	// It is always an error to attempt to mutate a set that cannot be mutated
 	if err := recv.ht.checkMutable("delete from"); err != nil {
 		return nil, nameErr(b, err)
@@ -2308,23 +2410,23 @@ func set_discard(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, erro
 	} else if !found {
 		return None, nil
 	}
-	if _, err := recv.Delete(k); err != nil {
+	if _, err := recv.delete(thread, k); err != nil {
 		return nil, nameErr(b, err) // set is frozen
 	}
 	return None, nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#set·pop.
-func set_pop(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func set_pop(thread *Thread, b *Builtin, recv_ Value, args Tuple, kwargs []Tuple) (Value, error) {
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
 		return nil, err
 	}
-	recv := b.Receiver().(*Set)
+	recv := recv_.(*Set)
 	k, ok := recv.ht.first()
 	if !ok {
-		return nil, nameErr(b, "empty set")
+		return nil, nameErr(b, Errorf(KeyError, "empty set"))
 	}
-	_, err := recv.Delete(k)
+	_, err := recv.delete(thread, k)
 	if err != nil {
 		return nil, nameErr(b, err) // set is frozen
 	}
@@ -2332,28 +2434,28 @@ func set_pop(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#set·remove.
-func set_remove(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func set_remove(thread *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var k Value
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &k); err != nil {
 		return nil, err
 	}
-	if found, err := b.Receiver().(*Set).Delete(k); err != nil {
+	if found, err := recv.(*Set).delete(thread, k); err != nil {
 		return nil, nameErr(b, err) // dict is frozen or key is unhashable
 	} else if found {
 		return None, nil
 	}
-	return nil, nameErr(b, "missing key")
+	return nil, nameErr(b, Errorf(KeyError, "missing key"))
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#set·symmetric_difference.
-func set_symmetric_difference(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+func set_symmetric_difference(thread *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
 	var other Iterable
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 0, &other); err != nil {
 		return nil, err
 	}
 	iter := other.Iterate()
 	defer iter.Done()
-	diff, err := b.Receiver().(*Set).SymmetricDifference(thread, iter)
+	diff, err := recv.(*Set).SymmetricDifference(thread, iter)
 	if err != nil {
 		return nil, nameErr(b, err)
 	}
@@ -2361,31 +2463,31 @@ func set_symmetric_difference(thread *Thread, b *Builtin, args Tuple, kwargs []T
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#set·union.
-func set_union(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	receiverSet := b.Receiver().(*Set).clone(thread)
-	if err := setUpdate(receiverSet, args, kwargs); err != nil {
+func set_union(thread *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	receiverSet := recv.(*Set).clone(thread)
+	if err := setUpdate(thread, receiverSet, args, kwargs); err != nil {
 		return nil, nameErr(b, err)
 	}
 	return receiverSet, nil
 }
 
 // https://github.com/google/starlark-go/blob/master/doc/spec.md#set·update.
-func set_update(_ *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
-	if err := setUpdate(b.Receiver().(*Set), args, kwargs); err != nil {
+func set_update(thread *Thread, b *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	if err := setUpdate(thread, recv.(*Set), args, kwargs); err != nil {
 		return nil, nameErr(b, err)
 	}
 	return None, nil
 }
 
 // Common implementation of string_{r}{find,index}.
-func string_find_impl(b *Builtin, args Tuple, kwargs []Tuple, allowError, last bool) (Value, error) {
+func string_find_impl(b *Builtin, recv Value, args Tuple, kwargs []Tuple, allowError, last bool) (Value, error) {
 	var sub string
 	var start_, end_ Value
 	if err := UnpackPositionalArgs(b.Name(), args, kwargs, 1, &sub, &start_, &end_); err != nil {
 		return nil, err
 	}
 
-	s := string(b.Receiver().(String))
+	s := string(recv.(String))
 	start, end, err := indices(start_, end_, len(s))
 	if err != nil {
 		return nil, nameErr(b, err)
@@ -2403,7 +2505,7 @@ func string_find_impl(b *Builtin, args Tuple, kwargs []Tuple, allowError, last b
 	}
 	if i < 0 {
 		if !allowError {
-			return nil, nameErr(b, "substring not found")
+			return nil, nameErr(b, Errorf(ValueError, "substring not found"))
 		}
 		return MakeInt(-1), nil
 	}
@@ -2412,13 +2514,13 @@ func string_find_impl(b *Builtin, args Tuple, kwargs []Tuple, allowError, last b
 
 // Common implementation of builtin dict function and dict.update method.
 // Precondition: len(updates) == 0 or 1.
-func updateDict(dict *Dict, updates Tuple, kwargs []Tuple) error {
+func updateDict(thread *Thread, dict *Dict, updates Tuple, kwargs []Tuple) error {
 	if len(updates) == 1 {
 		switch updates := updates[0].(type) {
 		case IterableMapping:
 			// Iterate over dict's key/value pairs, not just keys.
 			for _, item := range updates.Items() {
-				if err := dict.SetKey(item[0], item[1]); err != nil {
+				if err := dict.setKey(thread, item[0], item[1]); err != nil {
 					return err // dict is frozen
 				}
 			}
@@ -2426,27 +2528,27 @@ func updateDict(dict *Dict, updates Tuple, kwargs []Tuple) error {
 			// all other sequences
 			iter := Iterate(updates)
 			if iter == nil {
-				return fmt.Errorf("got %s, want iterable", updates.Type())
+				return Errorf(TypeError, "got %s, want iterable", updates.Type())
 			}
 			defer iter.Done()
 			var pair Value
 			for i := 0; iter.Next(&pair); i++ {
 				iter2 := Iterate(pair)
 				if iter2 == nil {
-					return fmt.Errorf("dictionary update sequence element #%d is not iterable (%s)", i, pair.Type())
+					return Errorf(TypeError, "dictionary update sequence element #%d is not iterable (%s)", i, pair.Type())
 
 				}
 				defer iter2.Done()
 				len := Len(pair)
 				if len < 0 {
-					return fmt.Errorf("dictionary update sequence element #%d has unknown length (%s)", i, pair.Type())
+					return Errorf(TypeError, "dictionary update sequence element #%d has unknown length (%s)", i, pair.Type())
 				} else if len != 2 {
-					return fmt.Errorf("dictionary update sequence element #%d has length %d, want 2", i, len)
+					return Errorf(ValueError, "dictionary update sequence element #%d has length %d, want 2", i, len)
 				}
 				var k, v Value
 				iter2.Next(&k)
 				iter2.Next(&v)
-				if err := dict.SetKey(k, v); err != nil {
+				if err := dict.setKey(thread, k, v); err != nil {
 					return err
 				}
 			}
@@ -2456,7 +2558,7 @@ func updateDict(dict *Dict, updates Tuple, kwargs []Tuple) error {
 	// Then add the kwargs.
 	before := dict.Len()
 	for _, pair := range kwargs {
-		if err := dict.SetKey(pair[0], pair[1]); err != nil {
+		if err := dict.setKey(thread, pair[0], pair[1]); err != nil {
 			return err // dict is frozen
 		}
 	}
@@ -2467,7 +2569,7 @@ func updateDict(dict *Dict, updates Tuple, kwargs []Tuple) error {
 		for _, kv := range kwargs {
 			k := kv[0].(String)
 			if keys[k] {
-				return fmt.Errorf("duplicate keyword arg: %v", k)
+				return Errorf(TypeError, "duplicate keyword arg: %v", k)
 			}
 			keys[k] = true
 		}
@@ -2476,20 +2578,20 @@ func updateDict(dict *Dict, updates Tuple, kwargs []Tuple) error {
 	return nil
 }
 
-func setUpdate(s *Set, args Tuple, kwargs []Tuple) error {
+func setUpdate(thread *Thread, s *Set, args Tuple, kwargs []Tuple) error {
 	if len(kwargs) > 0 {
-		return errors.New("does not accept keyword arguments")
+		return Errorf(TypeError, "does not accept keyword arguments")
 	}
 
 	for i, arg := range args {
 		iterable, ok := arg.(Iterable)
 		if !ok {
-			return fmt.Errorf("argument #%d is not iterable: %s", i+1, arg.Type())
+			return Errorf(TypeError, "argument #%d is not iterable: %s", i+1, arg.Type())
 		}
 		if err := func() error {
 			iter := iterable.Iterate()
 			defer iter.Done()
-			return s.InsertAll(iter)
+			return s.insertAll(thread, iter)
 		}(); err != nil {
 			return err
 		}
@@ -2500,6 +2602,10 @@ func setUpdate(s *Set, args Tuple, kwargs []Tuple) error {
 
 // nameErr returns an error message of the form "name: msg"
 // where name is b.Name() and msg is a string or error.
+// If msg is an error, the result wraps it.
 func nameErr(b *Builtin, msg interface{}) error {
+	if err, ok := msg.(error); ok {
+		return fmt.Errorf("%s: %w", b.Name(), err)
+	}
 	return fmt.Errorf("%s: %v", b.Name(), msg)
 }
@@ -0,0 +1,213 @@
+package starlark
+
+// This file defines the documentation of the universal built-ins and
+// of the methods of the built-in types.
+
+import (
+	"fmt"
+	"strings"
+
+	"go.starlark.net/syntax"
+)
+
+// universeDocs holds the signature and doc string of each universal
+// built-in function, as reported by Builtin.Signature and Builtin.Doc.
+var universeDocs = map[string][2]string{
+	"abs":       {"abs(x, /)", "abs returns the absolute value of its argument x, which must be an int or float."},
+	"any":       {"any(x, /)", "any returns True if any element of the iterable sequence x has a truth value of true."},
+	"all":       {"all(x, /)", "all returns False if any element of the iterable sequence x has a truth value of false."},
+	"bool":      {"bool(x=False, /)", "bool returns the truth value of x."},
+	"bytes":     {"bytes(x, /)", "bytes converts its argument, a string, bytes, or iterable of ints, to a bytes value."},
+	"catch":     {"catch(fn, *args, **kwargs)", "catch calls fn with the specified arguments, and returns None if the call succeeded, or an error value describing why it failed."},
+	"chr":       {"chr(i, /)", "chr returns a string that encodes the single Unicode code point whose value is specified by the integer i."},
+	"dict":      {"dict(pairs=..., /, **kwargs)", "dict creates a dictionary from an optional iterable of key/value pairs or mapping, and keyword arguments."},
+	"dir":       {"dir(x, /)", "dir returns a new sorted list of the names of the attributes (fields and methods) of its operand."},
+	"enumerate": {"enumerate(x, start=0, /)", "enumerate returns a list of (index, value) pairs, each containing successive values of the iterable sequence x and the index of the value within the sequence, starting at start."},
+	"fail":      {"fail(*args, sep=\" \", cause=None, details=None)", "fail causes execution to fail with an error message that includes the string forms of the arguments, separated by sep. The optional cause, an error value returned by catch, and details, any value, are reported by the error value if the failure is caught."},
+	"float":     {"float(x=0.0, /)", "float returns a float corresponding to x, which may be a bool, int, float, or string."},
+	"getattr":   {"getattr(x, name, default=..., /)", "getattr returns the value of the attribute (field or method) of x named name, or default if x has no such attribute."},
+	"hasattr":   {"hasattr(x, name, /)", "hasattr reports whether x has an attribute (field or method) named name."},
+	"hash":      {"hash(x, /)", "hash returns an integer hash of a string or bytes value x such that equal values have the same hash."},
+	"int":       {"int(x, base=...)", "int converts x to an integer. If x is a string, it is interpreted in the specified base."},
+	"len":       {"len(x, /)", "len returns the number of elements in its argument."},
+	"list":      {"list(x=(), /)", "list constructs a list containing the elements of the iterable sequence x."},
+	"max":       {"max(*args, key=None)", "max returns the greatest of its arguments, or of the elements of its sole iterable argument, as ordered by the optional key function."},
+	"min":       {"min(*args, key=None)", "min returns the least of its arguments, or of the elements of its sole iterable argument, as ordered by the optional key function."},
+	"ord":       {"ord(s, /)", "ord returns the integer value of the sole Unicode code point encoded by the string s, or of the sole byte of the bytes s."},
+	"print":     {"print(*args, sep=\" \")", "print prints its arguments, followed by a newline. Arguments are formatted as if by str and separated by sep."},
+	"range":     {"range(start_or_stop, stop=..., step=1, /)", "range returns an immutable sequence of integers from start (default 0) up to but not including stop, in steps of step."},
+	"repr":      {"repr(x, /)", "repr formats its argument as a string."},
+	"reversed":  {"reversed(x, /)", "reversed returns a new list containing the elements of the iterable sequence x in reverse order."},
+	"set":       {"set(x=(), /)", "set returns a new set containing the elements of the iterable sequence x."},
+	"sorted":    {"sorted(x, *, key=None, reverse=False)", "sorted returns a new list containing the elements of the iterable sequence x, in sorted order, as ordered by the optional key function."},
+	"str":       {"str(x, /)", "str formats its argument as a string. A string is returned unchanged."},
+	"tuple":     {"tuple(x=(), /)", "tuple returns a tuple containing the elements of the iterable sequence x."},
+	"type":      {"type(x, /)", "type returns a string describing the type of its operand."},
+	"zip":       {"zip(*args)", "zip returns a new list of n-tuples formed from corresponding elements of each of the n iterable sequences provided as arguments."},
+}
+
+// The following maps hold the signature and doc string of each method
+// of the built-in types. As in the spec, the methods accept only
+// positional arguments, except where noted.
+var (
+	bytesMethodDocs = map[string][2]string{
+		"elems": {"elems()", "elems returns an iterable value containing the successive 1-element byte substrings of the bytes value."},
+	}
+
+	dictMethodDocs = map[string][2]string{
+		"clear":      {"clear()", "clear removes all the entries of the dictionary and returns None."},
+		"get":        {"get(key, default=None, /)", "get returns the value corresponding to key, or default if the dictionary contains no such value."},
+		"items":      {"items()", "items returns a new list of the key/value pairs of the dictionary, in iteration order."},
+		"keys":       {"keys()", "keys returns a new list of the keys of the dictionary, in iteration order."},
+		"pop":        {"pop(key, default=..., /)", "pop removes the entry for key and returns its value, or default if the dictionary contains no such entry and default is specified."},
+		"popitem":    {"popitem()", "popitem removes the first key/value pair of the dictionary and returns it."},
+		"setdefault": {"setdefault(key, default=None, /)", "setdefault returns the value corresponding to key, first inserting default as its value if the dictionary contains no such value."},
+		"update":     {"update(pairs=..., /, **kwargs)", "update inserts into the dictionary the entries of pairs, a mapping or iterable of key/value pairs, followed by the keyword arguments, and returns None."},
+		"values":     {"values()", "values returns a new list of the values of the dictionary, in iteration order."},
+	}
+
+	listMethodDocs = map[string][2]string{
+		"append": {"append(x, /)", "append appends x to the list and returns None."},
+		"clear":  {"clear()", "clear removes all the elements of the list and returns None."},
+		"extend": {"extend(x, /)", "extend appends the elements of the iterable sequence x to the list and returns None."},
+		"index":  {"index(x, start=None, end=None, /)", "index returns the index of the first occurrence of x in the list, or in the portion of it from start to end."},
+		"insert": {"insert(i, x, /)", "insert inserts x into the list at index i, moving the subsequent elements along by one, and returns None."},
+		"pop":    {"pop(i=-1, /)", "pop removes the element at index i, by default the last, and returns it."},
+		"remove": {"remove(x, /)", "remove removes the first occurrence of x from the list and returns None."},
+	}
+
+	stringMethodDocs = map[string][2]string{
+		"capitalize":     {"capitalize()", "capitalize returns a copy of the string with its first code point changed to title case and all subsequent letters changed to lower case."},
+		"codepoint_ords": {"codepoint_ords()", "codepoint_ords returns an iterable value containing the integer Unicode code points encoded by the string."},
+		"codepoints":     {"codepoints()", "codepoints returns an iterable value containing the 1-code-point substrings of the string."},
+		"count":          {"count(sub, start=None, end=None, /)", "count returns the number of non-overlapping occurrences of sub within the string, or within the portion of it from start to end."},
+		"elem_ords":      {"elem_ords()", "elem_ords returns an iterable value containing the numeric byte values of the string."},
+		"elems":          {"elems()", "elems returns an iterable value containing the 1-byte substrings of the string."},
+		"endswith":       {"endswith(suffix, start=None, end=None, /)", "endswith reports whether the portion of the string from start to end ends with suffix, or with any element of suffix if it is a tuple."},
+		"find":           {"find(sub, start=None, end=None, /)", "find returns the index of the first occurrence of sub within the string, or within the portion of it from start to end, or -1 if there is none."},
+		"format":         {"format(*args, **kwargs)", "format returns a copy of the format string in which each bracketed portion {...} is replaced by the corresponding argument, formatted as specified."},
+		"index":          {"index(sub, start=None, end=None, /)", "index is like find, but fails if there is no occurrence of sub."},
+		"isalnum":        {"isalnum()", "isalnum reports whether the string is non-empty and consists only of Unicode letters and digits."},
+		"isalpha":        {"isalpha()", "isalpha reports whether the string is non-empty and consists only of Unicode letters."},
+		"isdigit":        {"isdigit()", "isdigit reports whether the string is non-empty and consists only of Unicode digits."},
+		"islower":        {"islower()", "islower reports whether the string contains at least one cased Unicode letter, and all such letters are lowercase."},
+		"isspace":        {"isspace()", "isspace reports whether the string is non-empty and consists only of Unicode spaces."},
+		"istitle":        {"istitle()", "istitle reports whether the string contains at least one cased Unicode letter, and all such letters that begin a word are in title case."},
+		"isupper":        {"isupper()", "isupper reports whether the string contains at least one cased Unicode letter, and all such letters are uppercase."},
+		"join":           {"join(iterable, /)", "join returns the concatenation of the strings of iterable, separated by the string."},
+		"lower":          {"lower()", "lower returns a copy of the string with letters converted to lowercase."},
+		"lstrip":         {"lstrip(cutset=..., /)", "lstrip returns a copy of the string with leading whitespace, or leading code points in cutset, removed."},
+		"partition":      {"partition(x, /)", "partition splits the string at the first occurrence of x and returns a 3-tuple of the part before it, x, and the part after it."},
+		"removeprefix":   {"removeprefix(prefix, /)", "removeprefix returns a copy of the string with prefix removed, if it starts with prefix."},
+		"removesuffix":   {"removesuffix(suffix, /)", "removesuffix returns a copy of the string with suffix removed, if it ends with suffix."},
+		"replace":        {"replace(old, new, count=-1, /)", "replace returns a copy of the string with occurrences of old replaced by new, at most count of them if count is non-negative."},
+		"rfind":          {"rfind(sub, start=None, end=None, /)", "rfind returns the index of the last occurrence of sub within the string, or within the portion of it from start to end, or -1 if there is none."},
+		"rindex":         {"rindex(sub, start=None, end=None, /)", "rindex is like rfind, but fails if there is no occurrence of sub."},
+		"rpartition":     {"rpartition(x, /)", "rpartition is like partition, but splits the string at the last occurrence of x."},
+		"rsplit":         {"rsplit(sep=None, maxsplit=-1, /)", "rsplit is like split, but when maxsplit is non-negative, it splits at the last occurrences of sep."},
+		"rstrip":         {"rstrip(cutset=..., /)", "rstrip returns a copy of the string with trailing whitespace, or trailing code points in cutset, removed."},
+		"split":          {"split(sep=None, maxsplit=-1, /)", "split returns the list of substrings of the string separated by sep, or by runs of whitespace if sep is None, splitting at most maxsplit times if it is non-negative."},
+		"splitlines":     {"splitlines(keepends=False, /)", "splitlines returns the list of lines of the string, including their line endings if keepends is true."},
+		"startswith":     {"startswith(prefix, start=None, end=None, /)", "startswith reports whether the portion of the string from start to end starts with prefix, or with any element of prefix if it is a tuple."},
+		"strip":          {"strip(cutset=..., /)", "strip returns a copy of the string with leading and trailing whitespace, or code points in cutset, removed."},
+		"title":          {"title()", "title returns a copy of the string with letters converted to title case."},
+		"upper":          {"upper()", "upper returns a copy of the string with letters converted to uppercase."},
+	}
+
+	setMethodDocs = map[string][2]string{
+		"add":                  {"add(x, /)", "add adds x to the set and returns None."},
+		"clear":                {"clear()", "clear removes all the elements of the set and returns None."},
+		"difference":           {"difference(y, /)", "difference returns a new set of the elements of the set that are not in the iterable sequence y."},
+		"discard":              {"discard(x, /)", "discard removes x from the set, if present, and returns None."},
+		"intersection":         {"intersection(y, /)", "intersection returns a new set of the elements of the set that are also in the iterable sequence y."},
+		"issubset":             {"issubset(y, /)", "issubset reports whether every element of the set is in the iterable sequence y."},
+		"issuperset":           {"issuperset(y, /)", "issuperset reports whether every element of the iterable sequence y is in the set."},
+		"pop":                  {"pop()", "pop removes the first inserted element of the set and returns it."},
+		"remove":               {"remove(x, /)", "remove removes x from the set, failing if it is not present, and returns None."},
+		"symmetric_difference": {"symmetric_difference(y, /)", "symmetric_difference returns a new set of the elements that are in either the set or the iterable sequence y, but not both."},
+		"union":                {"union(*args)", "union returns a new set of the elements of the set and of each iterable sequence argument."},
+		"update":               {"update(*args)", "update adds to the set the elements of each iterable sequence argument and returns None."},
+	}
+)
+
+// documentUniverse attaches universeDocs to the built-ins of Universe,
+// and the method docs to the methods of the built-in types.
+func documentUniverse() {
+	for name, d := range universeDocs {
+		b := Universe[name].(*Builtin)
+		b.info = &builtinInfo{doc: d[1], params: parseSignature(d[0])}
+	}
+	for _, t := range []struct {
+		methods map[string]*Builtin
+		docs    map[string][2]string
+	}{
+		{bytesMethods, bytesMethodDocs},
+		{dictMethods, dictMethodDocs},
+		{listMethods, listMethodDocs},
+		{stringMethods, stringMethodDocs},
+		{setMethods, setMethodDocs},
+	} {
+		for name, d := range t.docs {
+			t.methods[name].info = &builtinInfo{doc: d[1], params: parseSignature(d[0])}
+		}
+	}
+}
+
+// parseSignature returns the parameters of a signature such as
+// f(x, y=1, /, *args). Each default value is a Starlark expression,
+// or "..." for an optional parameter whose default cannot be written.
+// It panics if the signature is malformed.
+func parseSignature(sig string) []BuiltinParam {
+	i := strings.IndexByte(sig, '(')
+	if i < 0 || !strings.HasSuffix(sig, ")") {
+		panic(fmt.Sprintf("malformed signature %q", sig))
+	}
+	params := []BuiltinParam{}
+	rest := strings.TrimLeft(sig[i+1:], " ")
+	for rest != ")" {
+		// name, or the "/" or "*" marker
+		j := strings.IndexAny(rest, "=,) ")
+		if j <= 0 {
+			panic(fmt.Sprintf("malformed signature %q", sig))
+		}
+		p := BuiltinParam{Name: rest[:j]}
+		rest = strings.TrimLeft(rest[j:], " ")
+
+		if strings.HasPrefix(rest, "=") {
+			p.Default, rest = cutDefault(strings.TrimLeft(rest[1:], " "))
+			if p.Default == "" {
+				panic(fmt.Sprintf("malformed default value of %s in signature %q", p.Name, sig))
+			}
+		}
+		params = append(params, p)
+
+		switch {
+		case strings.HasPrefix(rest, ","):
+			rest = strings.TrimLeft(rest[1:], " ")
+		case rest != ")":
+			panic(fmt.Sprintf("malformed signature %q", sig))
+		}
+	}
+	return params
+}
+
+// cutDefault returns the default value at the start of s and the rest
+// of s. The default value is the shortest prefix of s that is followed
+// by a comma or parenthesis and is "..." or a valid expression, so it
+// may itself contain commas, parentheses, or "=" within brackets or
+// string literals. The default value is "" if there is no such prefix.
+func cutDefault(s string) (dflt, rest string) {
+	for i := 0; i < len(s); i++ {
+		if s[i] != ',' && s[i] != ')' {
+			continue
+		}
+		x := strings.TrimRight(s[:i], " ")
+		if x == "..." {
+			return x, s[i:]
+		}
+		if _, err := syntax.ParseExpr("", x, 0); err == nil {
+			return x, s[i:]
+		}
+	}
+	return "", s
+}
@@ -0,0 +1,214 @@
+package starlark
+
+// This file defines Params, a typed alternative to UnpackArgs.
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go.starlark.net/syntax"
+)
+
+// Params describes the parameters of a built-in function, declared
+// once as the fields of the struct type T, and unpacks the arguments
+// of a call into a value of that type.
+//
+// Each parameter is a field of T whose tag gives its name, with the
+// suffixes "?" and "??" that UnpackArgs accepts:
+//
+//	type findParams struct {
+//	    Sub   string `starlark:"sub"`
+//	    Start int    `starlark:"start?"`
+//	    End   Value  `starlark:"end??"`
+//	}
+//
+//	var find = starlark.NewParams("find", findParams{Start: 0})
+//
+//	func string_find(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+//	    p, err := find.Unpack(args, kwargs)
+//	    if err != nil {
+//	        return nil, err
+//	    }
+//	    ... p.Sub, p.Start, p.End ...
+//	}
+//
+// Arguments are unpacked as by UnpackArgs, so each field must have a
+// type that UnpackArg supports. Unlike UnpackArgs, NewParams checks
+// the declaration when it is called, so that mistakes are reported
+// when the program starts rather than when a script first calls the
+// function.
+type Params[T any] struct {
+	name     string
+	defaults T
+	params   []param
+}
+
+// A param describes one parameter of a Params.
+type param struct {
+	tag      string // the name, including any "?" suffix
+	name     string
+	index    int // of the field
+	optional bool
+	skipNone bool
+}
+
+var (
+	valueType    = reflect.TypeOf((*Value)(nil)).Elem()
+	unpackerType = reflect.TypeOf((*Unpacker)(nil)).Elem()
+)
+
+// NewParams returns the Params for a function of the specified name,
+// whose parameters are the tagged fields of T. Unpack starts from a
+// copy of defaults, so the values of its fields for optional
+// parameters are the default values.
+//
+// NewParams panics if T is not a struct type, if an exported field has
+// no starlark tag, if a tagged field has a type that UnpackArg does not
+// support, if two parameters have the same name, or if a required
+// parameter follows an optional one.
+func NewParams[T any](name string, defaults T) *Params[T] {
+	t := reflect.TypeOf(defaults)
+	if t == nil || t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("starlark.NewParams(%s): got %v, want struct type", name, t))
+	}
+	p := &Params[T]{name: name, defaults: defaults}
+	seen := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("starlark")
+		if !ok {
+			if f.IsExported() {
+				panic(fmt.Sprintf("starlark.NewParams(%s): field %s has no starlark tag", name, f.Name))
+			}
+			continue
+		}
+		if tag == "-" {
+			continue
+		}
+		if !f.IsExported() {
+			panic(fmt.Sprintf("starlark.NewParams(%s): field %s is not exported", name, f.Name))
+		}
+		prm := param{tag: tag, name: strings.TrimRight(tag, "?"), index: i}
+		prm.optional = prm.name != tag
+		prm.skipNone = strings.HasSuffix(tag, "??")
+		if len(tag)-len(prm.name) > 2 || !isIdent(prm.name) {
+			panic(fmt.Sprintf("starlark.NewParams(%s): field %s has invalid parameter name %q", name, f.Name, tag))
+		}
+		if seen[prm.name] {
+			panic(fmt.Sprintf("starlark.NewParams(%s): duplicate parameter %s", name, prm.name))
+		}
+		seen[prm.name] = true
+		if !unpackable(f.Type) {
+			panic(fmt.Sprintf("starlark.NewParams(%s): parameter %s has unsupported type %s", name, prm.name, f.Type))
+		}
+		if n := len(p.params); n > 0 && p.params[n-1].optional && !prm.optional {
+			panic(fmt.Sprintf("starlark.NewParams(%s): required parameter %s follows optional parameter %s", name, prm.name, p.params[n-1].name))
+		}
+		p.params = append(p.params, prm)
+	}
+	return p
+}
+
+// isIdent reports whether s is a valid Starlark identifier.
+func isIdent(s string) bool {
+	expr, err := syntax.ParseExpr("", s, 0)
+	if err != nil {
+		return false
+	}
+	_, ok := expr.(*syntax.Ident)
+	return ok
+}
+
+// unpackable reports whether UnpackArg supports variables of type t.
+func unpackable(t reflect.Type) bool {
+	if reflect.PointerTo(t).Implements(unpackerType) || t.AssignableTo(valueType) {
+		return true
+	}
+	switch t {
+	case reflect.TypeOf(""), reflect.TypeOf(false), reflect.TypeOf(0.0),
+		reflect.TypeOf(int(0)), reflect.TypeOf(int8(0)), reflect.TypeOf(int16(0)),
+		reflect.TypeOf(int32(0)), reflect.TypeOf(int64(0)),
+		reflect.TypeOf(uint(0)), reflect.TypeOf(uint8(0)), reflect.TypeOf(uint16(0)),
+		reflect.TypeOf(uint32(0)), reflect.TypeOf(uint64(0)), reflect.TypeOf(uintptr(0)):
+		return true
+	}
+	return false
+}
+
+// Name returns the name of the function.
+func (p *Params[T]) Name() string { return p.name }
+
+// NumParams returns the number of parameters.
+func (p *Params[T]) NumParams() int { return len(p.params) }
+
+// Param returns the name of the ith parameter, and whether it is
+// optional.
+func (p *Params[T]) Param(i int) (name string, optional bool) {
+	return p.params[i].name, p.params[i].optional
+}
+
+// Unpack unpacks the positional and keyword arguments of a call into
+// a copy of the defaults, as described at UnpackArgs.
+func (p *Params[T]) Unpack(args Tuple, kwargs []Tuple) (T, error) {
+	res := p.defaults
+	v := reflect.ValueOf(&res).Elem()
+	pairs := make([]any, 0, 2*len(p.params))
+	for _, prm := range p.params {
+		pairs = append(pairs, prm.tag, v.Field(prm.index).Addr().Interface())
+	}
+	if err := UnpackArgs(p.name, args, kwargs, pairs...); err != nil {
+		var zero T
+		return zero, err
+	}
+	return res, nil
+}
+
+// Signature returns the signature of the function in Starlark
+// notation, such as find(sub, start=0, end=None). The default value of
+// an optional parameter is shown as ... if it has no Starlark
+// representation.
+func (p *Params[T]) Signature() string {
+	return signature(p.name, p.BuiltinParams())
+}
+
+// BuiltinParams returns the description of the parameters, as reported
+// by the Params method of a Builtin made by NewBuiltinFromParams.
+func (p *Params[T]) BuiltinParams() []BuiltinParam {
+	params := make([]BuiltinParam, len(p.params))
+	v := reflect.ValueOf(p.defaults)
+	for i, prm := range p.params {
+		params[i].Name = prm.name
+		if prm.optional {
+			params[i].Default = defaultString(v.Field(prm.index), prm.skipNone)
+		}
+	}
+	return params
+}
+
+// defaultString returns the Starlark notation for the default value x
+// of a parameter.
+func defaultString(x reflect.Value, skipNone bool) string {
+	if x.Type().AssignableTo(valueType) {
+		if (x.Kind() == reflect.Interface || x.Kind() == reflect.Ptr) && x.IsNil() {
+			if skipNone {
+				return "None"
+			}
+			return "..."
+		}
+		return x.Interface().(Value).String()
+	}
+	switch x.Kind() {
+	case reflect.String:
+		return String(x.String()).String()
+	case reflect.Bool:
+		return Bool(x.Bool()).String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return MakeInt64(x.Int()).String()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return MakeUint64(x.Uint()).String()
+	case reflect.Float64:
+		return Float(x.Float()).String()
+	}
+	return "..."
+}
@@ -2,40 +2,184 @@ package starlark
 
 import (
 	"encoding/binary"
+	"reflect"
+	"sync/atomic"
 	"unsafe"
 
 	"github.com/cespare/xxhash/v2"
+	"go.starlark.net/internal/compile"
 )
 
-// CACHE_SIZE is calculated so the empty memo array is 1 MB in size.
-const CACHE_SIZE = (1 << 20) / (4 * 8) // 1 MB / (size of Record)
+// CACHE_SIZE is the number of slots of the memo table, calculated so
+// the empty memo array, which is embedded in every Thread, is 256 KB
+// in size. Each slot points to a Record, allocated when it is stored.
+const CACHE_SIZE = (1 << 18) / 8 // 256 KB / (size of *Record)
+
+// maxProbes is the number of slots of the memo table examined by a
+// lookup, so that lookups in a nearly full table remain fast.
+const maxProbes = 64
 
 type ProgramStateDB struct {
 	// inputs provides values for the input() builtin during execution.
 	inputs StringDict
+	// key holds the host-supplied components of the memo key,
+	// as set by Thread.SetCacheKey.
+	key string
 	// memo stores the cached results of previous function calls.
 	// It is an open-addressing hash table with linear probing. The
-	// table currently has a fixed size and does not resize or evict
-	// entries.
-	memo [CACHE_SIZE]Record
+	// table has a fixed size; when the maxProbes slots of a key are
+	// full, a new record evicts the one in the first slot probed. An evicted record remains
+	// valid for the records whose dependencies refer to it.
+	memo [CACHE_SIZE]*Record
 	// version is bumped every time a mutable or captured variable is updated.
 	// This allows us to invalidate the cache when the program state changes,
 	// but skip validation if no changes were made globally, which is common.
 	version uint64
+	// host is the value of hostVersion last observed by validate, and
+	// epoch the version at which it was observed. Records verified
+	// before epoch may depend on unrecorded reads of owner-less values.
+	host, epoch uint64
+	// segmentStart is the version at the start of the active top-level
+	// segment, or 0 if none, and segmentWrote records whether the
+	// segment has modified a value last modified before it started,
+	// such as a list created by an earlier segment.
+	segmentStart uint64
+	segmentWrote bool
+	// unskippable holds the modules whose segments have modified
+	// values of earlier segments. Their segments are never skipped, as
+	// the values assigned by a skipped segment may since have changed.
+	unskippable map[*module]bool
+}
+
+// hostVersion counts the mutations of lists, dicts, sets, and tracked
+// values on which the records of threads other than the mutating one
+// may depend. Any change invalidates the records of every
+// ProgramStateDB; see Thread.
+var hostVersion atomic.Uint64
+
+// version returns the current version of the thread's cache,
+// or zero for a nil thread.
+func (thread *Thread) version() uint64 {
+	if thread == nil {
+		return 0
+	}
+	return thread.cache.version
+}
+
+// endSegment ends the active top-level segment of module m, restoring
+// the state of the enclosing one. If the segment modified a value of
+// an earlier segment, the segments of m are no longer skipped.
+func (db *ProgramStateDB) endSegment(m *module, outerStart uint64, outerWrote bool) {
+	if db.segmentWrote {
+		if db.unskippable == nil {
+			db.unskippable = make(map[*module]bool)
+		}
+		db.unskippable[m] = true
+	}
+	db.segmentStart, db.segmentWrote = outerStart, outerWrote
+}
+
+// modify records a mutation, by thread (nil if unknown), of a value
+// with the specified owner that was last modified at version modified.
+// It returns the value's new owner and version.
+//
+// The mutating thread becomes the owner and records the new version
+// in its own cache. If another thread owned the value, or the value
+// had no owner but has been seen by unrecorded reads, the mutation is
+// counted by hostVersion, as the records of other threads may depend
+// on it. A mutation by an unknown thread is recorded by the owner, if
+// any, and otherwise counted by hostVersion if the value was seen.
+func modify(owner, thread *Thread, modified uint64, seen bool) (*Thread, uint64) {
+	if thread != nil {
+		if owner != thread && (owner != nil || seen) {
+			hostVersion.Add(1)
+		}
+		owner = thread
+	} else if owner == nil {
+		if seen {
+			hostVersion.Add(1)
+		}
+		return nil, modified + 1
+	}
+	db := &owner.cache
+	if modified < db.segmentStart {
+		db.segmentWrote = true
+	}
+	db.version++
+	if db.version <= modified {
+		// An adopted value may be newer than the owner's cache.
+		db.version = modified + 1
+	}
+	return owner, db.version
+}
+
+// A Tracker records the reads and writes of a mutable value defined by
+// an application, so that memoized results that depend on the value
+// are invalidated when it changes, as they are for a list, dict, or set.
+// The value should call Read before each read of its state, and Write
+// before each write, passing the executing thread, which is nil if
+// unknown; a frozen value need call neither. A value whose attributes
+// are tracked should implement HasThreadAttrs and HasThreadSetField to
+// learn the thread.
+//
+// The zero value is a Tracker with no owner; see Thread.
+type Tracker struct {
+	owner    *Thread
+	modified uint64
+	seen     bool // read while owner-less
+	shared   bool // read by a thread other than the owner since the last write
+}
+
+// NewTracker returns a Tracker owned by the specified thread, which
+// may be nil.
+func NewTracker(owner *Thread) *Tracker {
+	return &Tracker{owner: owner, modified: owner.version()}
+}
+
+// Read records a read of the tracked value by thread, which is nil if
+// unknown, in which case the read is attributed to the owner.
+func (t *Tracker) Read(thread *Thread) {
+	if thread == nil {
+		thread = t.owner
+	}
+	if thread == nil {
+		t.seen = true
+		return
+	}
+	if thread != t.owner {
+		t.shared = true
+	}
+	thread.dependencies.trackers = append(thread.dependencies.trackers, TrackerVersion{t, t.modified})
+}
+
+// Write records a write of the tracked value by thread, which is nil
+// if unknown, in which case the write is attributed to the owner.
+func (t *Tracker) Write(thread *Thread) {
+	if t.shared {
+		// Another thread's records depend on the value.
+		hostVersion.Add(1)
+		t.shared = false
+	}
+	t.owner, t.modified = modify(t.owner, thread, t.modified, t.seen)
+	if t.owner != nil {
+		t.owner.dependencies.trackers = append(t.owner.dependencies.trackers, TrackerVersion{t, t.modified})
+	}
 }
 
 // Dependencies groups the values and list versions read during execution.
 // The interpreter records these slices while executing a function body
 // and the cache uses them to detect invalidation when values change.
 type Dependencies struct {
-	inputs  []InputValue
-	globals []VariableValue
-	cells   []CellValue
-	lists   []ListVersion
-	dicts   []DictVersion
-	sets    []SetVersion
-	calls   []*Record
-	effects bool // true for builtin functions that have side effects that are not captured in the dependencies.
+	inputs   []InputValue
+	locals   []LocalValue
+	globals  []VariableValue
+	cells    []CellValue
+	lists    []ListVersion
+	dicts    []DictVersion
+	sets     []SetVersion
+	trackers []TrackerVersion
+	calls    []*Record
+	effects  bool // true for builtin functions that have side effects that are not captured in the dependencies.
 }
 
 // Record memoizes the result of a function call along with the values of
@@ -43,8 +187,15 @@ type Dependencies struct {
 // The key of the ProgramStateDB is the function identifier
 // and its arguments, but the recorded captures are used to invalidate the
 // cache if their values change.
+//
+// A Record may also memoize a single top-level statement of a module
+// prepared by PrepareExecFile, in which case segment is non-nil, the
+// args are the toplevel locals read by the statement, and the result
+// is None: the statement's effect is the assignment of globals.
 type Record struct {
 	function *Function
+	segment  *compile.Segment
+	key      string
 	args     []Interned
 	deps     Dependencies
 	result   Interned
@@ -58,6 +209,13 @@ type InputValue struct {
 	value Interned
 }
 
+// LocalValue records the thread-local value observed through
+// Thread.ReadLocal during execution.
+type LocalValue struct {
+	key   string
+	value interface{}
+}
+
 // VariableValue records the value observed for a variable during execution of
 // a function body. A single variable may appear multiple times if it was
 // read and then written with a different value.
@@ -93,6 +251,12 @@ type SetVersion struct {
 	modified uint64
 }
 
+// TrackerVersion records the version of a Tracker observed during execution.
+type TrackerVersion struct {
+	value    *Tracker
+	modified uint64
+}
+
 // Interned is a reference to an interned value in the program state database.
 type Interned struct {
 	value Value
@@ -155,31 +319,58 @@ func (in *Input) record(db *ProgramStateDB, val Value) {
 }
 
 func (db *ProgramStateDB) Get(function *Function, args []Interned) *Record {
-	idx := hashKey(function, args)
-	for i := 0; i < CACHE_SIZE; i++ {
-		rec := &db.memo[(idx+i)%CACHE_SIZE]
+	return db.get(function, nil, args)
+}
+
+func (db *ProgramStateDB) Put(function *Function, args []Interned, deps Dependencies, result Interned, verified uint64) *Record {
+	return db.put(function, nil, args, deps, result, verified)
+}
+
+func (db *ProgramStateDB) get(function *Function, segment *compile.Segment, args []Interned) *Record {
+	idx := hashKey(function, segment, db.key, args)
+	for i := 0; i < maxProbes; i++ {
+		rec := db.memo[(idx+i)%CACHE_SIZE]
 		// empty slot => miss
-		if rec.result.Empty() {
+		if rec == nil {
 			return nil
 		}
-		if fnEqual(rec.function, function) && argsEqual(rec.args, args) {
+		if rec.matches(function, segment, db.key, args) {
 			return rec
 		}
 	}
 	return nil
 }
 
-func (db *ProgramStateDB) Put(function *Function, args []Interned, deps Dependencies, result Interned, verified uint64) *Record {
-	idx := hashKey(function, args)
-	for i := 0; i < CACHE_SIZE; i++ {
+func (db *ProgramStateDB) put(function *Function, segment *compile.Segment, args []Interned, deps Dependencies, result Interned, verified uint64) *Record {
+	// A record is never modified in place, as the records of
+	// callers may depend on it.
+	rec := &Record{function, segment, db.key, args, deps, result, verified}
+	idx := hashKey(function, segment, db.key, args)
+	for i := 0; i < maxProbes; i++ {
 		pos := (idx + i) % CACHE_SIZE
-		rec := &db.memo[pos]
-		if rec.result.Empty() || (fnEqual(rec.function, function) && argsEqual(rec.args, args)) {
-			db.memo[pos] = Record{function, args, deps, result, verified}
-			return &db.memo[pos]
+		if old := db.memo[pos]; old == nil || old.matches(function, segment, db.key, args) {
+			db.memo[pos] = rec
+			return rec
 		}
 	}
-	panic("ProgramStateDB memo table full")
+	// The slots are full: evict the record in the first slot probed.
+	// Lookups of the keys of other records are unaffected, as the
+	// slot is not emptied.
+	db.memo[idx] = rec
+	return rec
+}
+
+// matches reports whether the record is for the specified key.
+func (rec *Record) matches(function *Function, segment *compile.Segment, key string, args []Interned) bool {
+	if rec.segment != segment || rec.key != key || !argsEqual(rec.args, args) {
+		return false
+	}
+	if segment != nil {
+		// A segment's effects are on the globals of its own module,
+		// so only the identical toplevel function will do.
+		return rec.function == function
+	}
+	return fnEqual(rec.function, function)
 }
 
 // Eq checks if two Interned values are equal by identity.
@@ -195,13 +386,29 @@ func (i Interned) words() [2]uintptr {
 	return *(*[2]uintptr)(unsafe.Pointer(&i.value))
 }
 
+// observeHost starts a new epoch if hostVersion has changed since it
+// was last observed. It must be called before a snapshot of the version
+// is taken for a new record, lest a record made after a change be
+// mistaken for one made before it.
+func (db *ProgramStateDB) observeHost() {
+	if h := hostVersion.Load(); h != db.host {
+		db.host = h
+		db.version++
+		db.epoch = db.version
+	}
+}
+
 // validate checks whether the given record is still valid under the
-// current ProgramStateDB version. It recursively validates any
-// dependent calls.
-func (db *ProgramStateDB) validate(rec *Record) bool {
+// current ProgramStateDB version and thread-local values. It recursively
+// validates any dependent calls.
+func (db *ProgramStateDB) validate(rec *Record, locals map[string]interface{}) bool {
+	db.observeHost()
 	if rec.verified == db.version {
 		return true
 	}
+	if rec.verified < db.epoch {
+		rec.verified = 0
+	}
 	if rec.verified == 0 {
 		return false
 	}
@@ -212,6 +419,13 @@ func (db *ProgramStateDB) validate(rec *Record) bool {
 			return false
 		}
 	}
+	// thread-locals
+	for _, l := range rec.deps.locals {
+		if !localEqual(locals[l.key], l.value) {
+			rec.verified = 0
+			return false
+		}
+	}
 	// globals
 	for _, c := range rec.deps.globals {
 		if !db.Intern(rec.function.module.globals[c.variable]).Eq(c.value) {
@@ -247,9 +461,16 @@ func (db *ProgramStateDB) validate(rec *Record) bool {
 			return false
 		}
 	}
+	// trackers
+	for _, m := range rec.deps.trackers {
+		if m.modified < m.value.modified {
+			rec.verified = 0
+			return false
+		}
+	}
 	// calls
 	for _, call := range rec.deps.calls {
-		if !db.validate(call) {
+		if !db.validate(call, locals) {
 			rec.verified = 0
 			return false
 		}
@@ -258,10 +479,13 @@ func (db *ProgramStateDB) validate(rec *Record) bool {
 	return true
 }
 
-// hashKey computes a hash key for the given function and arguments.
-func hashKey(function *Function, args []Interned) int {
+// hashKey computes a hash key for the given function, segment, host key and arguments.
+func hashKey(function *Function, segment *compile.Segment, key string, args []Interned) int {
 	var buf [8]byte
 	h := xxhash.New()
+	_, _ = h.WriteString(key)
+	binary.LittleEndian.PutUint64(buf[:], uint64(uintptr(unsafe.Pointer(segment))))
+	_, _ = h.Write(buf[:])
 	// Hash function based on *Funcode and freevars.
 	binary.LittleEndian.PutUint64(buf[:], uint64(uintptr(unsafe.Pointer(function.funcode))))
 	_, _ = h.Write(buf[:])
@@ -318,3 +542,18 @@ func argsEqual(a, b []Interned) bool {
 	}
 	return true
 }
+
+// localEqual reports whether two thread-local values are the same,
+// using == for comparable types and identity otherwise.
+func localEqual(x, y interface{}) bool {
+	if x == nil || y == nil {
+		return x == nil && y == nil
+	}
+	if reflect.TypeOf(x) != reflect.TypeOf(y) {
+		return false
+	}
+	if reflect.ValueOf(x).Comparable() && reflect.ValueOf(y).Comparable() {
+		return x == y
+	}
+	return *(*[2]uintptr)(unsafe.Pointer(&x)) == *(*[2]uintptr)(unsafe.Pointer(&y))
+}
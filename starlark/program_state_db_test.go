@@ -1,15 +1,42 @@
 package starlark
 
 import (
+	"fmt"
 	"testing"
 
 	"go.starlark.net/internal/compile"
+	"go.starlark.net/syntax"
 )
 
 // helpers produce dynamic values to avoid compile-time optimizations.
 func dynamicInt(i int) int          { return i + 0 }
 func dynamicString(s string) string { return s + "" }
 
+func TestProgramStateDBFull(t *testing.T) {
+	// A program that memoizes more calls than the table holds.
+	n := CACHE_SIZE + 1000
+	src := fmt.Sprintf("def f(i): return i + 1\nx = [f(i) for i in range(%d)]\ny = [f(i) for i in range(%d)]\n", n, n)
+	globals, err := ExecFileOptions(&syntax.FileOptions{}, new(Thread), "full.star", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"x", "y"} {
+		if l := globals[name].(*List); l.Len() != n || l.Index(n-1) != MakeInt(n) {
+			t.Errorf("%s has %d elements, last %v; want %d, last %d", name, l.Len(), l.Index(l.Len()-1), n, n)
+		}
+	}
+
+	// The most recent records of a full table are found.
+	db := NewProgramStateDB()
+	fn := &Function{funcode: &compile.Funcode{}}
+	for i := 0; i < n; i++ {
+		db.Put(fn, []Interned{db.Intern(MakeInt(i))}, Dependencies{}, db.Intern(MakeInt(i)), 1)
+	}
+	if rec := db.Get(fn, []Interned{db.Intern(MakeInt(n - 1))}); rec == nil || db.Value(rec.result) != MakeInt(n-1) {
+		t.Errorf("Get of the last record put into a full table = %v", rec)
+	}
+}
+
 func TestInternDistinctObjectsNotEqual(t *testing.T) {
 	db := NewProgramStateDB()
 	s1 := Value(String(dynamicString("a")))
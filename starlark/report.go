@@ -0,0 +1,239 @@
+package starlark
+
+// This file defines the resource-usage report of ExecFileWithReport.
+
+import (
+	"sort"
+
+	"go.starlark.net/internal/compile"
+	"go.starlark.net/syntax"
+)
+
+// A Report describes the resources used by an execution of a Starlark
+// file, as returned by ExecFileWithReport. Its contents depend only on
+// the program and its inputs, so that, unlike a profile, it is
+// suitable for accounting.
+type Report struct {
+	// Steps is the number of computation steps executed,
+	// in the sense of Thread.Steps.
+	Steps uint64
+
+	// Functions reports each Starlark function called, in order of
+	// decreasing Steps.
+	Functions []FunctionUsage
+
+	// MaxDepth is the maximum depth of the call stack, relative to
+	// that of the call to ExecFileWithReport.
+	MaxDepth int
+
+	// Allocations is a partial count of the values created by the
+	// program, indexed by type. It counts the lists, dicts, and sets
+	// created by expressions and by built-in functions on behalf of
+	// the thread; the tuples, functions, and strings created by
+	// literals, def statements, lambda expressions, concatenation, and
+	// slicing; and the strings created by str, join, and the %
+	// operator. Other strings and tuples created by built-in
+	// functions, such as the results of most string methods, are not
+	// counted.
+	Allocations map[string]uint64
+
+	// Containers reports the largest lists, dicts, and sets that the
+	// thread created or added elements to, at most ten, in order of
+	// decreasing size. Elements added by Go code that does not know
+	// the thread, such as calls of List.Append, are not observed.
+	Containers []ContainerUsage
+
+	// Loads is the name of the module of each load statement
+	// executed, in order of execution.
+	Loads []string
+
+	// MemoHits is the number of function calls whose result was
+	// obtained from the memoization cache, plus the number of
+	// top-level statements of programs prepared for incremental
+	// execution that were skipped because they were memoized.
+	MemoHits uint64
+
+	funcs      map[*compile.Funcode]*FunctionUsage
+	containers map[Value]int // index of each entry of Containers
+	values     []Value       // the container of each entry of Containers
+	depth      int           // depth of the call stack at the start
+}
+
+// A FunctionUsage reports the resources used by a Starlark function.
+type FunctionUsage struct {
+	Name     string
+	Pos      syntax.Position // position of the function's definition
+	Calls    uint64          // number of calls, including memoized calls
+	Steps    uint64          // steps executed by the function, excluding its callees
+	MemoHits uint64          // number of calls whose result was memoized
+}
+
+// A ContainerUsage reports the size of a list, dict, or set.
+type ContainerUsage struct {
+	Type string          // "list", "dict", or "set"
+	Len  int             // the largest number of elements observed
+	Pos  syntax.Position // position of the execution that grew it to Len
+}
+
+// maxReportContainers is the number of containers described by a Report.
+const maxReportContainers = 10
+
+// ExecFileWithReport is like ExecFileOptions, but it also returns a
+// report of the resources used by the execution. The report is nil
+// only if the file could not be compiled.
+//
+// The report covers only the execution on this thread, so it does not
+// include the execution of loaded modules unless thread.Load executes
+// them on the same thread.
+func ExecFileWithReport(opts *syntax.FileOptions, thread *Thread, filename string, src interface{}, predeclared StringDict) (StringDict, *Report, error) {
+	_, mod, err := SourceProgramOptions(opts, filename, src, predeclared.Has)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := &Report{
+		Allocations: make(map[string]uint64),
+		funcs:       make(map[*compile.Funcode]*FunctionUsage),
+		containers:  make(map[Value]int),
+		depth:       len(thread.stack),
+	}
+	outer := thread.report
+	thread.report = r
+	steps := thread.Steps
+	g, err := mod.Init(thread, predeclared)
+	thread.report = outer
+	g.Freeze()
+
+	r.Steps = thread.Steps - steps
+	r.finish()
+	return g, r, err
+}
+
+// finish populates the fields of a report derived from its internal state.
+func (r *Report) finish() {
+	for _, usage := range r.funcs {
+		r.Functions = append(r.Functions, *usage)
+	}
+	sort.Slice(r.Functions, func(i, j int) bool {
+		x, y := &r.Functions[i], &r.Functions[j]
+		if x.Steps != y.Steps {
+			return x.Steps > y.Steps
+		}
+		if x.Pos.Filename() != y.Pos.Filename() {
+			return x.Pos.Filename() < y.Pos.Filename()
+		}
+		if x.Pos.Line != y.Pos.Line {
+			return x.Pos.Line < y.Pos.Line
+		}
+		return x.Pos.Col < y.Pos.Col
+	})
+	sort.SliceStable(r.Containers, func(i, j int) bool {
+		return r.Containers[i].Len > r.Containers[j].Len
+	})
+	r.funcs = nil
+	r.containers = nil
+	r.values = nil
+}
+
+// function returns the usage of the specified function.
+func (r *Report) function(fn *Function) *FunctionUsage {
+	usage := r.funcs[fn.funcode]
+	if usage == nil {
+		usage = &FunctionUsage{Name: fn.Name(), Pos: fn.Position()}
+		r.funcs[fn.funcode] = usage
+	}
+	return usage
+}
+
+// enter records a call by the thread, whose frame has just been pushed.
+func (r *Report) enter(thread *Thread) {
+	if depth := len(thread.stack) - r.depth; depth > r.MaxDepth {
+		r.MaxDepth = depth
+	}
+}
+
+// exit records the completion of the call of the thread's topmost
+// frame, which started at the specified step.
+func (r *Report) exit(thread *Thread, start uint64) {
+	fr := thread.frameAt(0)
+	fn, ok := fr.callable.(*Function)
+	if !ok {
+		// The steps of a built-in's callees belong to its caller.
+		return
+	}
+	total := thread.Steps - start
+	usage := r.function(fn)
+	usage.Calls++
+	usage.Steps += total - fr.calleeSteps
+	for i := len(thread.stack) - 2; i >= 0; i-- {
+		if caller := thread.stack[i]; isFunction(caller.callable) {
+			caller.calleeSteps += total
+			break
+		}
+	}
+}
+
+func isFunction(c Callable) bool {
+	_, ok := c.(*Function)
+	return ok
+}
+
+// container records that a container has grown to size n.
+func (r *Report) container(thread *Thread, v Value, n int) {
+	if i, ok := r.containers[v]; ok {
+		if n > r.Containers[i].Len {
+			r.Containers[i].Len = n
+			r.Containers[i].Pos = thread.position()
+		}
+		return
+	}
+	i := len(r.Containers)
+	if i == maxReportContainers {
+		// Replace the smallest container, if this one is larger.
+		i = 0
+		for j := range r.Containers {
+			if r.Containers[j].Len < r.Containers[i].Len {
+				i = j
+			}
+		}
+		if n <= r.Containers[i].Len {
+			return
+		}
+		delete(r.containers, r.values[i])
+	} else {
+		r.Containers = append(r.Containers, ContainerUsage{})
+		r.values = append(r.values, nil)
+	}
+	r.Containers[i] = ContainerUsage{Type: v.Type(), Len: n, Pos: thread.position()}
+	r.values[i] = v
+	r.containers[v] = i
+}
+
+// The following methods record events for the report of a thread, if
+// any. Like version, they may be called on a nil thread.
+
+// countAlloc records the creation of a value of the specified type.
+func (thread *Thread) countAlloc(typ string) {
+	if thread != nil && thread.report != nil {
+		thread.report.Allocations[typ]++
+	}
+}
+
+// noteLen records that a list, dict, or set has n elements after the
+// thread created it or added to it.
+func (thread *Thread) noteLen(v Value, n int) {
+	if thread != nil && thread.report != nil && n > 0 {
+		thread.report.container(thread, v, n)
+	}
+}
+
+// position returns the position of execution in the innermost
+// Starlark function of the thread.
+func (thread *Thread) position() syntax.Position {
+	for i := len(thread.stack) - 1; i >= 0; i-- {
+		if fr := thread.stack[i]; isFunction(fr.callable) {
+			return fr.Position()
+		}
+	}
+	return syntax.Position{}
+}
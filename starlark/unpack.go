@@ -89,6 +89,9 @@ type Unpacker interface {
 //	if d == nil { d = None; }
 //	if e == nil { e = new(List); }
 //	if f == nil { f = new(Dict); }
+//
+// See also Params, which declares the parameters of a function once,
+// as a struct type, and checks the declaration in advance.
 func UnpackArgs(fnname string, args Tuple, kwargs []Tuple, pairs ...any) error {
 	nparams := len(pairs) / 2
 	var defined intset
@@ -108,7 +111,7 @@ func UnpackArgs(fnname string, args Tuple, kwargs []Tuple, pairs ...any) error {
 
 	// positional arguments
 	if len(args) > nparams {
-		return fmt.Errorf("%s: got %d arguments, want at most %d",
+		return Errorf(TypeError, "%s: got %d arguments, want at most %d",
 			fnname, len(args), nparams)
 	}
 	for i, arg := range args {
@@ -120,7 +123,7 @@ func UnpackArgs(fnname string, args Tuple, kwargs []Tuple, pairs ...any) error {
 			}
 		}
 		if err := UnpackArg(arg, pairs[2*i+1]); err != nil {
-			return fmt.Errorf("%s: for parameter %s: %s", fnname, name, err)
+			return withKind(TypeError, fmt.Errorf("%s: for parameter %s: %w", fnname, name, err))
 		}
 	}
 
@@ -133,7 +136,7 @@ kwloop:
 			if pName == string(name) {
 				// found it
 				if defined.set(i) {
-					return fmt.Errorf("%s: got multiple values for keyword argument %s",
+					return Errorf(TypeError, "%s: got multiple values for keyword argument %s",
 						fnname, name)
 				}
 
@@ -145,21 +148,21 @@ kwloop:
 
 				ptr := pairs[2*i+1]
 				if err := UnpackArg(arg, ptr); err != nil {
-					return fmt.Errorf("%s: for parameter %s: %s", fnname, name, err)
+					return withKind(TypeError, fmt.Errorf("%s: for parameter %s: %w", fnname, name, err))
 				}
 				continue kwloop
 			}
 		}
-		err := fmt.Errorf("%s: unexpected keyword argument %s", fnname, name)
+		msg := fmt.Sprintf("%s: unexpected keyword argument %s", fnname, name)
 		names := make([]string, 0, nparams)
 		for i := 0; i < nparams; i += 2 {
 			param, _ := paramName(pairs[i])
 			names = append(names, param)
 		}
 		if n := spell.Nearest(string(name), names); n != "" {
-			err = fmt.Errorf("%s (did you mean %s?)", err.Error(), n)
+			msg = fmt.Sprintf("%s (did you mean %s?)", msg, n)
 		}
-		return err
+		return Errorf(TypeError, "%s", msg)
 	}
 
 	// Check that all non-optional parameters are defined.
@@ -173,7 +176,7 @@ kwloop:
 			continue
 		}
 		if !defined.get(i) {
-			return fmt.Errorf("%s: missing argument for %s", fnname, name)
+			return Errorf(TypeError, "%s: missing argument for %s", fnname, name)
 		}
 	}
 
@@ -191,7 +194,7 @@ kwloop:
 // See UnpackArgs for general comments.
 func UnpackPositionalArgs(fnname string, args Tuple, kwargs []Tuple, min int, vars ...any) error {
 	if len(kwargs) > 0 {
-		return fmt.Errorf("%s: unexpected keyword arguments", fnname)
+		return Errorf(TypeError, "%s: unexpected keyword arguments", fnname)
 	}
 	max := len(vars)
 	if len(args) < min {
@@ -199,18 +202,18 @@ func UnpackPositionalArgs(fnname string, args Tuple, kwargs []Tuple, min int, va
 		if min < max {
 			atleast = "at least "
 		}
-		return fmt.Errorf("%s: got %d arguments, want %s%d", fnname, len(args), atleast, min)
+		return Errorf(TypeError, "%s: got %d arguments, want %s%d", fnname, len(args), atleast, min)
 	}
 	if len(args) > max {
 		var atmost string
 		if max > min {
 			atmost = "at most "
 		}
-		return fmt.Errorf("%s: got %d arguments, want %s%d", fnname, len(args), atmost, max)
+		return Errorf(TypeError, "%s: got %d arguments, want %s%d", fnname, len(args), atmost, max)
 	}
 	for i, arg := range args {
 		if err := UnpackArg(arg, vars[i]); err != nil {
-			return fmt.Errorf("%s: for parameter %d: %s", fnname, i+1, err)
+			return withKind(TypeError, fmt.Errorf("%s: for parameter %d: %w", fnname, i+1, err))
 		}
 	}
 	return nil
@@ -233,13 +236,13 @@ func UnpackArg(v Value, ptr any) error {
 	case *string:
 		s, ok := AsString(v)
 		if !ok {
-			return fmt.Errorf("got %s, want string", v.Type())
+			return Errorf(TypeError, "got %s, want string", v.Type())
 		}
 		*ptr = s
 	case *bool:
 		b, ok := v.(Bool)
 		if !ok {
-			return fmt.Errorf("got %s, want bool", v.Type())
+			return Errorf(TypeError, "got %s, want bool", v.Type())
 		}
 		*ptr = bool(b)
 	case *int, *int8, *int16, *int32, *int64,
@@ -248,31 +251,31 @@ func UnpackArg(v Value, ptr any) error {
 	case *float64:
 		f, ok := v.(Float)
 		if !ok {
-			return fmt.Errorf("got %s, want float", v.Type())
+			return Errorf(TypeError, "got %s, want float", v.Type())
 		}
 		*ptr = float64(f)
 	case **List:
 		list, ok := v.(*List)
 		if !ok {
-			return fmt.Errorf("got %s, want list", v.Type())
+			return Errorf(TypeError, "got %s, want list", v.Type())
 		}
 		*ptr = list
 	case **Dict:
 		dict, ok := v.(*Dict)
 		if !ok {
-			return fmt.Errorf("got %s, want dict", v.Type())
+			return Errorf(TypeError, "got %s, want dict", v.Type())
 		}
 		*ptr = dict
 	case *Callable:
 		f, ok := v.(Callable)
 		if !ok {
-			return fmt.Errorf("got %s, want callable", v.Type())
+			return Errorf(TypeError, "got %s, want callable", v.Type())
 		}
 		*ptr = f
 	case *Iterable:
 		it, ok := v.(Iterable)
 		if !ok {
-			return fmt.Errorf("got %s, want iterable", v.Type())
+			return Errorf(TypeError, "got %s, want iterable", v.Type())
 		}
 		*ptr = it
 	default:
@@ -313,7 +316,7 @@ func UnpackArg(v Value, ptr any) error {
 					paramType = typer.Type()
 				}
 			}()
-			return fmt.Errorf("got %s, want %s", v.Type(), paramType)
+			return Errorf(TypeError, "got %s, want %s", v.Type(), paramType)
 		}
 		paramVar.Set(reflect.ValueOf(v))
 	}
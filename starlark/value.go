@@ -371,6 +371,23 @@ type HasSetField interface {
 	SetField(name string, val Value) error
 }
 
+// A HasThreadAttrs value is a HasAttrs whose attribute reads must
+// know the thread that executes them, for example to record the read
+// for memoization (see Tracker). The interpreter and the getattr and
+// hasattr built-ins call ThreadAttr in preference to Attr.
+type HasThreadAttrs interface {
+	HasAttrs
+	ThreadAttr(thread *Thread, name string) (Value, error)
+}
+
+// A HasThreadSetField value is a HasSetField whose field writes must
+// know the thread that executes them. The interpreter calls
+// ThreadSetField in preference to SetField.
+type HasThreadSetField interface {
+	HasSetField
+	ThreadSetField(thread *Thread, name string, val Value) error
+}
+
 // A NoSuchAttrError may be returned by an implementation of
 // HasAttrs.Attr or HasSetField.SetField to indicate that no such field
 // exists. In that case the runtime may augment the error message to
@@ -611,12 +628,14 @@ func (si stringElems) String() string {
 		return si.s.String() + ".elems()"
 	}
 }
-func (si stringElems) Type() string          { return "string.elems" }
-func (si stringElems) Freeze()               {} // immutable
-func (si stringElems) Truth() Bool           { return True }
-func (si stringElems) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable: %s", si.Type()) }
-func (si stringElems) Iterate() Iterator     { return &stringElemsIterator{si, 0} }
-func (si stringElems) Len() int              { return len(si.s) }
+func (si stringElems) Type() string { return "string.elems" }
+func (si stringElems) Freeze()      {} // immutable
+func (si stringElems) Truth() Bool  { return True }
+func (si stringElems) Hash() (uint32, error) {
+	return 0, Errorf(TypeError, "unhashable: %s", si.Type())
+}
+func (si stringElems) Iterate() Iterator { return &stringElemsIterator{si, 0} }
+func (si stringElems) Len() int          { return len(si.s) }
 func (si stringElems) Index(i int) Value {
 	if si.ords {
 		return MakeInt(int(si.s[i]))
@@ -660,11 +679,13 @@ func (si stringCodepoints) String() string {
 		return si.s.String() + ".codepoints()"
 	}
 }
-func (si stringCodepoints) Type() string          { return "string.codepoints" }
-func (si stringCodepoints) Freeze()               {} // immutable
-func (si stringCodepoints) Truth() Bool           { return True }
-func (si stringCodepoints) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable: %s", si.Type()) }
-func (si stringCodepoints) Iterate() Iterator     { return &stringCodepointsIterator{si, 0} }
+func (si stringCodepoints) Type() string { return "string.codepoints" }
+func (si stringCodepoints) Freeze()      {} // immutable
+func (si stringCodepoints) Truth() Bool  { return True }
+func (si stringCodepoints) Hash() (uint32, error) {
+	return 0, Errorf(TypeError, "unhashable: %s", si.Type())
+}
+func (si stringCodepoints) Iterate() Iterator { return &stringCodepointsIterator{si, 0} }
 
 type stringCodepointsIterator struct {
 	si stringCodepoints
@@ -709,6 +730,7 @@ type module struct {
 	predeclared StringDict
 	globals     []Value
 	constants   []Value
+	incremental bool // memoize top-level statements (see PrepareExecFile)
 }
 
 // makeGlobalDict returns a new, unfrozen StringDict containing all global
@@ -781,6 +803,50 @@ func (fn *Function) ParamDefault(i int) Value {
 func (fn *Function) HasVarargs() bool { return fn.funcode.HasVarargs }
 func (fn *Function) HasKwargs() bool  { return fn.funcode.HasKwargs }
 
+// Signature returns the signature of the function in Starlark
+// notation, such as f(x, y=1, *args, z, **kwargs).
+func (fn *Function) Signature() string {
+	return signature(fn.Name(), fn.Params())
+}
+
+// Params returns the parameters of fn in the form of those of a
+// documented built-in function, with each default value in Starlark
+// notation.
+func (fn *Function) Params() []BuiltinParam {
+	var params []BuiltinParam
+	param := func(i int, prefix string) {
+		name, _ := fn.Param(i)
+		p := BuiltinParam{Name: prefix + name}
+		if dflt := fn.ParamDefault(i); dflt != nil {
+			p.Default = dflt.String()
+		}
+		params = append(params, p)
+	}
+	n := fn.NumParams()
+	if fn.HasKwargs() {
+		n--
+	}
+	if fn.HasVarargs() {
+		n--
+	}
+	npos := n - fn.NumKwonlyParams()
+	for i := 0; i < npos; i++ {
+		param(i, "")
+	}
+	if fn.HasVarargs() {
+		param(n, "*")
+	} else if fn.NumKwonlyParams() > 0 {
+		params = append(params, BuiltinParam{Name: "*"})
+	}
+	for i := npos; i < n; i++ {
+		param(i, "")
+	}
+	if fn.HasKwargs() {
+		param(fn.NumParams()-1, "**")
+	}
+	return params
+}
+
 // NumFreeVars returns the number of free variables of this function.
 func (fn *Function) NumFreeVars() int { return len(fn.funcode.FreeVars) }
 
@@ -794,11 +860,79 @@ func (fn *Function) FreeVar(i int) (Binding, Value) {
 type Builtin struct {
 	name    string
 	fn      func(thread *Thread, fn *Builtin, args Tuple, kwargs []Tuple) (Value, error)
-	recv    Value // for bound methods (e.g. "".startswith)
-	effects bool  // true if the function has side effects that can't be cached.
+	method  methodFunc // for methods of built-in types, instead of fn
+	recv    Value      // for bound methods (e.g. "".startswith)
+	effects bool       // true if the function has side effects that can't be cached.
+	info    *builtinInfo
+
+	capability string // required of the calling thread, if non-empty; see Thread.Grant
+}
+
+// builtinInfo holds the optional documentation of a Builtin.
+type builtinInfo struct {
+	doc    string
+	params []BuiltinParam
+}
+
+// A BuiltinParam describes a parameter of a built-in function, for
+// documentation. As in a Python signature, the parameters may include
+// the markers "/", after which parameters may be passed by keyword,
+// and "*", after which they must be.
+type BuiltinParam struct {
+	Name    string // the name, with a "*" or "**" prefix for variadic parameters
+	Default string // the default value in Starlark notation, or "" if required
 }
 
 func (b *Builtin) Name() string { return b.name }
+
+// Doc returns the documentation of the built-in function,
+// or "" if it has none.
+func (b *Builtin) Doc() string {
+	if b.info == nil {
+		return ""
+	}
+	return b.info.doc
+}
+
+// Params returns the parameters of the built-in function,
+// or nil if they are not documented.
+// The result must not be modified.
+func (b *Builtin) Params() []BuiltinParam {
+	if b.info == nil {
+		return nil
+	}
+	return b.info.params
+}
+
+// Signature returns the signature of the built-in function in
+// Starlark notation, such as sorted(iterable, /, *, key=None), or
+// just its name followed by (...) if its parameters are not documented.
+func (b *Builtin) Signature() string {
+	if b.info == nil {
+		return b.name + "(...)"
+	}
+	return signature(b.name, b.info.params)
+}
+
+// signature returns the signature of a function with the specified
+// name and parameters.
+func signature(name string, params []BuiltinParam) string {
+	var buf strings.Builder
+	buf.WriteString(name)
+	buf.WriteByte('(')
+	for i, p := range params {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(p.Name)
+		if p.Default != "" {
+			buf.WriteByte('=')
+			buf.WriteString(p.Default)
+		}
+	}
+	buf.WriteByte(')')
+	return buf.String()
+}
 func (b *Builtin) Freeze() {
 	if b.recv != nil {
 		b.recv.Freeze()
@@ -815,9 +949,23 @@ func (b *Builtin) Receiver() Value { return b.recv }
 func (b *Builtin) String() string  { return toString(b) }
 func (b *Builtin) Type() string    { return "builtin_function_or_method" }
 func (b *Builtin) CallInternal(thread *Thread, args Tuple, kwargs []Tuple) (Value, error) {
-	if b.effects {
+	return b.callWith(thread, b.recv, args, kwargs)
+}
+
+// callWith calls the built-in function, passing recv as the receiver
+// of a method of a built-in type, whether or not b is bound to it.
+func (b *Builtin) callWith(thread *Thread, recv Value, args Tuple, kwargs []Tuple) (Value, error) {
+	if b.capability != "" && !thread.HasCapability(b.capability) {
+		return nil, Errorf(PermissionDenied, "%s: capability %q not granted", b.Name(), b.capability)
+	}
+	if b.effects || b.capability != "" {
+		// A call that requires a capability is never memoized,
+		// so that the check is made again after a call to Grant.
 		thread.dependencies.effects = true
 	}
+	if b.method != nil {
+		return b.method(thread, b, recv, args, kwargs)
+	}
 	return b.fn(thread, b, args, kwargs)
 }
 func (b *Builtin) Truth() Bool { return true }
@@ -828,10 +976,35 @@ func NewBuiltin(name string, fn func(thread *Thread, fn *Builtin, args Tuple, kw
 	return &Builtin{name: name, fn: fn}
 }
 
+// A methodFunc implements a method of a built-in type, such as
+// list.append, whose receiver is passed separately so that a call
+// x.f(...) need not allocate a bound method.
+type methodFunc func(thread *Thread, fn *Builtin, recv Value, args Tuple, kwargs []Tuple) (Value, error)
+
+// newMethod returns a new unbound method of a built-in type.
+func newMethod(name string, fn methodFunc) *Builtin {
+	return &Builtin{name: name, method: fn}
+}
+
 func NewBuiltinWithEffects(name string, fn func(thread *Thread, fn *Builtin, args Tuple, kwargs []Tuple) (Value, error)) *Builtin {
 	return &Builtin{name: name, fn: fn, effects: true}
 }
 
+// NewBuiltinFromParams returns a new Builtin whose name and parameters
+// are declared by p, and whose implementation fn receives the
+// arguments of each call unpacked by p.Unpack. Its documentation is
+// doc, as if by WithDoc.
+func NewBuiltinFromParams[T any](p *Params[T], doc string, fn func(thread *Thread, fn *Builtin, args T) (Value, error)) *Builtin {
+	impl := func(thread *Thread, b *Builtin, args Tuple, kwargs []Tuple) (Value, error) {
+		x, err := p.Unpack(args, kwargs)
+		if err != nil {
+			return nil, err
+		}
+		return fn(thread, b, x)
+	}
+	return &Builtin{name: p.Name(), fn: impl, info: &builtinInfo{doc: doc, params: p.BuiltinParams()}}
+}
+
 // BindReceiver returns a new Builtin value representing a method
 // closure, that is, a built-in function bound to a receiver value.
 //
@@ -845,7 +1018,29 @@ func NewBuiltinWithEffects(name string, fn func(thread *Thread, fn *Builtin, arg
 //
 //	"abc".index("a")
 func (b *Builtin) BindReceiver(recv Value) *Builtin {
-	return &Builtin{name: b.name, fn: b.fn, recv: recv}
+	bound := *b
+	bound.recv = recv
+	return &bound
+}
+
+// WithDoc returns a copy of b that records the specified documentation
+// and the parameters of signature, such as "f(x, y=1, /, *args)", as
+// reported by its Doc, Params, and Signature methods. The function name
+// in signature is ignored. Each default value is a Starlark expression,
+// such as sep=", ", or "..." for an optional parameter whose default
+// cannot be written. WithDoc panics if the signature is malformed.
+//
+// WithDoc is the way to document any built-in function: the copy
+// retains the effects of a function made by NewBuiltinWithEffects,
+// and the capability, if any, that b requires, so that for example
+//
+//	NewBuiltinWithEffects("write", write).WithDoc("write(path, data)", doc).WithCapability("fs.write")
+//
+// is a documented function that has effects and requires a capability.
+func (b *Builtin) WithDoc(signature, doc string) *Builtin {
+	documented := *b
+	documented.info = &builtinInfo{doc: doc, params: parseSignature(signature)}
+	return &documented
 }
 
 // A *Dict represents a Starlark dictionary.
@@ -854,17 +1049,20 @@ func (b *Builtin) BindReceiver(recv Value) *Builtin {
 // it is more efficient to call NewDict.
 type Dict struct {
 	ht       hashtable
-	owner    *Thread // the thread that created this Dict.
+	owner    *Thread // the thread that created this Dict, or nil; see Thread
 	modified uint64
+	seen     bool // an unrecorded read of an owner-less Dict occurred
 }
 
 // NewDict returns a set with initial space for
 // at least size insertions before rehashing.
+// The owner may be nil; see Thread.
 func NewDict(owner *Thread, size int) *Dict {
 	dict := new(Dict)
 	dict.ht.init(size)
 	dict.owner = owner
-	dict.modified = owner.cache.version
+	dict.modified = owner.version()
+	owner.countAlloc("dict")
 	return dict
 }
 
@@ -872,22 +1070,20 @@ func NewDictFromValues(owner *Thread, values [][2]Value) *Dict {
 	dict := new(Dict)
 	dict.ht.init(len(values))
 	dict.owner = owner
-	dict.modified = owner.cache.version
+	dict.modified = owner.version()
 	for _, kv := range values {
 		if err := dict.ht.insert(kv[0], kv[1]); err != nil {
 			panic(fmt.Sprintf("NewDictFromMap: %s", err))
 		}
 	}
+	owner.countAlloc("dict")
+	owner.noteLen(dict, int(dict.ht.len))
 	return dict
 }
 
-func (d *Dict) Clear() error {
-	d.write()
-	return d.ht.clear()
-}
+func (d *Dict) Clear() error { return d.clear(nil) }
 func (d *Dict) Delete(k Value) (v Value, found bool, err error) {
-	d.write()
-	return d.ht.delete(k)
+	return d.delete(nil, k)
 }
 func (d *Dict) Get(k Value) (v Value, found bool, err error) {
 	d.read()
@@ -909,20 +1105,51 @@ func (d *Dict) Iterate() Iterator {
 	d.read()
 	return d.ht.iterate()
 }
-func (d *Dict) SetKey(k, v Value) error {
-	d.write()
-	return d.ht.insert(k, v)
+
+// SetKey sets the value of key k. Not knowing the calling thread, it
+// charges the memory of a new entry to the dict's owner, if any.
+func (d *Dict) SetKey(k, v Value) error { return d.setKey(nil, k, v) }
+
+// The following methods mutate the dict on behalf of thread, which
+// is nil if unknown, as for the exported methods.
+
+func (d *Dict) clear(thread *Thread) error {
+	d.write(thread)
+	return d.ht.clear()
+}
+
+func (d *Dict) delete(thread *Thread, k Value) (v Value, found bool, err error) {
+	d.write(thread)
+	return d.ht.delete(k)
+}
+
+func (d *Dict) setKey(thread *Thread, k, v Value) error {
+	payer := thread
+	if payer == nil {
+		payer = d.owner
+	}
+	d.write(thread)
+	n := d.ht.len
+	if err := d.ht.insertBy(payer, k, v); err != nil {
+		return err
+	}
+	if d.ht.len > n {
+		thread.noteLen(d, int(d.ht.len))
+	}
+	return nil
 }
 func (d *Dict) String() string {
 	return toString(d)
 }
 func (d *Dict) Type() string { return "dict" }
 func (d *Dict) Freeze() {
-	d.write()
+	if d.owner != nil {
+		d.write(nil)
+	}
 	d.ht.freeze()
 }
 func (d *Dict) Truth() Bool           { return d.Len() > 0 }
-func (d *Dict) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: dict") }
+func (d *Dict) Hash() (uint32, error) { return 0, Errorf(TypeError, "unhashable type: dict") }
 
 func (x *Dict) Union(thread *Thread, y *Dict) *Dict {
 	z := NewDict(thread, x.Len()) // a lower bound
@@ -946,21 +1173,28 @@ func (x *Dict) CompareSameType(op syntax.Token, y_ Value, depth int) (bool, erro
 		ok, err := dictsEqual(x, y, depth)
 		return !ok, err
 	default:
-		return false, fmt.Errorf("%s %s %s not implemented", x.Type(), op, y.Type())
+		return false, Errorf(TypeError, "%s %s %s not implemented", x.Type(), op, y.Type())
 	}
 }
 
 func (d *Dict) read() {
-	if !d.ht.frozen {
+	if d.ht.frozen {
+		return
+	}
+	if d.owner != nil {
 		d.owner.dependencies.dicts = append(d.owner.dependencies.dicts, DictVersion{d, d.modified})
+	} else {
+		d.seen = true
 	}
 }
 
-func (d *Dict) write() {
+// write records a mutation of the dict by thread, which is nil if unknown.
+func (d *Dict) write(thread *Thread) {
 	if !d.ht.frozen {
-		d.owner.cache.version++
-		d.modified = d.owner.cache.version
-		d.owner.dependencies.dicts = append(d.owner.dependencies.dicts, DictVersion{d, d.modified})
+		d.owner, d.modified = modify(d.owner, thread, d.modified, d.seen)
+		if d.owner != nil {
+			d.owner.dependencies.dicts = append(d.owner.dependencies.dicts, DictVersion{d, d.modified})
+		}
 	}
 }
 
@@ -987,20 +1221,25 @@ type List struct {
 	elems     []Value
 	frozen    bool
 	itercount uint32  // number of active iterators (ignored if frozen)
-	owner     *Thread // the thread that created this List
+	owner     *Thread // the thread that created this List, or nil; see Thread
 	modified  uint64
+	seen      bool // an unrecorded read of an owner-less List occurred
 }
 
 // NewList returns a list containing the specified elements.
 // Callers should not subsequently modify elems.
+// The owner may be nil; see Thread.
 func NewList(owner *Thread, elems []Value) *List {
-	return &List{elems: elems, owner: owner, modified: owner.cache.version}
+	list := &List{elems: elems, owner: owner, modified: owner.version()}
+	owner.countAlloc("list")
+	owner.noteLen(list, len(elems))
+	return list
 }
 
 func (l *List) Freeze() {
 	if !l.frozen {
 		l.frozen = true
-		l.write()
+		l.write(nil)
 		for _, elem := range l.elems {
 			elem.Freeze()
 		}
@@ -1021,7 +1260,7 @@ func (l *List) checkMutable(verb string) error {
 
 func (l *List) String() string        { return toString(l) }
 func (l *List) Type() string          { return "list" }
-func (l *List) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: list") }
+func (l *List) Hash() (uint32, error) { return 0, Errorf(TypeError, "unhashable type: list") }
 func (l *List) Truth() Bool           { return l.Len() > 0 }
 func (l *List) Len() int {
 	l.read()
@@ -1092,16 +1331,23 @@ func sliceCompare(op syntax.Token, x, y []Value, depth int) (bool, error) {
 }
 
 func (l *List) read() {
-	if !l.frozen {
+	if l.frozen {
+		return
+	}
+	if l.owner != nil {
 		l.owner.dependencies.lists = append(l.owner.dependencies.lists, ListVersion{l, l.modified})
+	} else {
+		l.seen = true
 	}
 }
 
-func (l *List) write() {
+// write records a mutation of the list by thread, which is nil if unknown.
+func (l *List) write(thread *Thread) {
 	if !l.frozen {
-		l.owner.cache.version++
-		l.modified = l.owner.cache.version
-		l.owner.dependencies.lists = append(l.owner.dependencies.lists, ListVersion{l, l.modified})
+		l.owner, l.modified = modify(l.owner, thread, l.modified, l.seen)
+		if l.owner != nil {
+			l.owner.dependencies.lists = append(l.owner.dependencies.lists, ListVersion{l, l.modified})
+		}
 	}
 }
 
@@ -1126,29 +1372,48 @@ func (it *listIterator) Done() {
 	}
 }
 
-func (l *List) SetIndex(i int, v Value) error {
+func (l *List) SetIndex(i int, v Value) error { return l.setIndex(nil, i, v) }
+
+// Append appends v to the list. Not knowing the calling thread, it
+// charges the memory of the new element to the list's owner, if any.
+func (l *List) Append(v Value) error { return l.appendElem(nil, v) }
+
+func (l *List) Clear() error { return l.clear(nil) }
+
+// The following methods mutate the list on behalf of thread, which
+// is nil if unknown, as for the exported methods.
+
+func (l *List) setIndex(thread *Thread, i int, v Value) error {
 	if err := l.checkMutable("assign to element of"); err != nil {
 		return err
 	}
-	l.write()
+	l.write(thread)
 	l.elems[i] = v
 	return nil
 }
 
-func (l *List) Append(v Value) error {
+func (l *List) appendElem(thread *Thread, v Value) error {
 	if err := l.checkMutable("append to"); err != nil {
 		return err
 	}
-	l.write()
+	payer := thread
+	if payer == nil {
+		payer = l.owner
+	}
+	if err := payer.AddAllocs(valueSize); err != nil {
+		return err
+	}
+	l.write(thread)
 	l.elems = append(l.elems, v)
+	thread.noteLen(l, len(l.elems))
 	return nil
 }
 
-func (l *List) Clear() error {
+func (l *List) clear(thread *Thread) error {
 	if err := l.checkMutable("clear"); err != nil {
 		return err
 	}
-	l.write()
+	l.write(thread)
 	for i := range l.elems {
 		l.elems[i] = nil // aid GC
 	}
@@ -1224,50 +1489,107 @@ func (it *tupleIterator) Done() {}
 // it is more efficient to call NewSet.
 type Set struct {
 	ht       hashtable // values are all None
-	owner    *Thread   // the thread that created this Set
+	owner    *Thread   // the thread that created this Set, or nil; see Thread
 	modified uint64
+	seen     bool // an unrecorded read of an owner-less Set occurred
 }
 
 // NewSet returns a dictionary with initial space for
 // at least size insertions before rehashing.
+// The owner may be nil; see Thread.
 func NewSet(owner *Thread, size int) *Set {
 	set := new(Set)
 	set.ht.init(size)
 	set.owner = owner
-	set.modified = owner.cache.version
+	set.modified = owner.version()
+	owner.countAlloc("set")
 	return set
 }
 
 func (s *Set) Delete(k Value) (found bool, err error) {
-	s.write()
-	_, found, err = s.ht.delete(k)
-	return
+	return s.delete(nil, k)
 }
-func (s *Set) Clear() error                        { s.write(); return s.ht.clear() }
+func (s *Set) Clear() error                        { return s.clear(nil) }
 func (s *Set) Has(k Value) (found bool, err error) { s.read(); _, found, err = s.ht.lookup(k); return }
-func (s *Set) Insert(k Value) error                { s.write(); return s.ht.insert(k, None) }
 func (s *Set) Len() int                            { s.read(); return int(s.ht.len) }
 func (s *Set) Iterate() Iterator                   { s.read(); return s.ht.iterate() }
 func (s *Set) String() string                      { return toString(s) }
 func (s *Set) Type() string                        { return "set" }
-func (s *Set) Freeze()                             { s.write(); s.ht.freeze() }
-func (s *Set) Hash() (uint32, error)               { return 0, fmt.Errorf("unhashable type: set") }
+func (s *Set) Hash() (uint32, error)               { return 0, Errorf(TypeError, "unhashable type: set") }
 func (s *Set) Truth() Bool                         { return s.Len() > 0 }
 
+// Insert adds k to the set. Not knowing the calling thread, it charges
+// the memory of a new element to the set's owner, if any.
+func (s *Set) Insert(k Value) error { return s.insert(nil, k) }
+
+// The following methods mutate the set on behalf of thread, which
+// is nil if unknown, as for the exported methods.
+
+func (s *Set) delete(thread *Thread, k Value) (found bool, err error) {
+	s.write(thread)
+	_, found, err = s.ht.delete(k)
+	return
+}
+
+func (s *Set) clear(thread *Thread) error {
+	s.write(thread)
+	return s.ht.clear()
+}
+
+func (s *Set) insert(thread *Thread, k Value) error {
+	payer := thread
+	if payer == nil {
+		payer = s.owner
+	}
+	s.write(thread)
+	n := s.ht.len
+	if err := s.ht.insertBy(payer, k, None); err != nil {
+		return err
+	}
+	if s.ht.len > n {
+		thread.noteLen(s, int(s.ht.len))
+	}
+	return nil
+}
+
+func (s *Set) insertAll(thread *Thread, iter Iterator) error {
+	var x Value
+	for iter.Next(&x) {
+		if err := s.insert(thread, x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *Set) Attr(name string) (Value, error) { return builtinAttr(s, name, setMethods) }
 func (s *Set) AttrNames() []string             { return builtinAttrNames(setMethods) }
 
+func (s *Set) Freeze() {
+	if s.owner != nil {
+		s.write(nil)
+	}
+	s.ht.freeze()
+}
+
 func (s *Set) read() {
-	if !s.ht.frozen {
+	if s.ht.frozen {
+		return
+	}
+	if s.owner != nil {
 		s.owner.dependencies.sets = append(s.owner.dependencies.sets, SetVersion{s, s.modified})
+	} else {
+		s.seen = true
 	}
 }
 
-func (s *Set) write() {
+// write records a mutation of the set by thread, which is nil if unknown.
+func (s *Set) write(thread *Thread) {
 	if !s.ht.frozen {
-		s.owner.cache.version++
-		s.modified = s.owner.cache.version
-		s.owner.dependencies.sets = append(s.owner.dependencies.sets, SetVersion{s, s.modified})
+		s.owner, s.modified = modify(s.owner, thread, s.modified, s.seen)
+		if s.owner != nil {
+			s.owner.dependencies.sets = append(s.owner.dependencies.sets, SetVersion{s, s.modified})
+		}
 	}
 }
 
@@ -1309,7 +1631,7 @@ func (x *Set) CompareSameType(op syntax.Token, y_ Value, depth int) (bool, error
 		defer iter.Done()
 		return x.IsSubset(iter)
 	default:
-		return false, fmt.Errorf("%s %s %s not implemented", x.Type(), op, y.Type())
+		return false, Errorf(TypeError, "%s %s %s not implemented", x.Type(), op, y.Type())
 	}
 }
 
@@ -1329,7 +1651,7 @@ func setFromIterator(owner *Thread, iter Iterator) (*Set, error) {
 	var x Value
 	set := NewSet(owner, 0)
 	for iter.Next(&x) {
-		err := set.Insert(x)
+		err := set.insert(owner, x)
 		if err != nil {
 			return set, err
 		}
@@ -1340,7 +1662,7 @@ func setFromIterator(owner *Thread, iter Iterator) (*Set, error) {
 func (s *Set) clone(thread *Thread) *Set {
 	set := NewSet(thread, int(s.ht.len))
 	for e := s.ht.head; e != nil; e = e.next {
-		set.Insert(e.key) // can't fail
+		set.insert(thread, e.key) // can't fail
 	}
 	return set
 }
@@ -1350,29 +1672,21 @@ func (s *Set) Union(thread *Thread, iter Iterator) (Value, error) {
 	set := s.clone(thread)
 	var x Value
 	for iter.Next(&x) {
-		if err := set.Insert(x); err != nil {
+		if err := set.insert(thread, x); err != nil {
 			return nil, err
 		}
 	}
 	return set, nil
 }
 
-func (s *Set) InsertAll(iter Iterator) error {
-	var x Value
-	for iter.Next(&x) {
-		if err := s.Insert(x); err != nil {
-			return err
-		}
-	}
-	return nil
-}
+func (s *Set) InsertAll(iter Iterator) error { return s.insertAll(nil, iter) }
 
 func (s *Set) Difference(thread *Thread, other Iterator) (Value, error) {
 	s.read()
 	diff := s.clone(thread)
 	var x Value
 	for other.Next(&x) {
-		if _, err := diff.Delete(x); err != nil {
+		if _, err := diff.delete(thread, x); err != nil {
 			return nil, err
 		}
 	}
@@ -1411,7 +1725,7 @@ func (s *Set) Intersection(thread *Thread, other Iterator) (Value, error) {
 			return nil, err
 		}
 		if found {
-			err = intersect.Insert(x)
+			err = intersect.insert(thread, x)
 			if err != nil {
 				return nil, err
 			}
@@ -1425,12 +1739,12 @@ func (s *Set) SymmetricDifference(thread *Thread, other Iterator) (Value, error)
 	diff := s.clone(thread)
 	var x Value
 	for other.Next(&x) {
-		found, err := diff.Delete(x)
+		found, err := diff.delete(thread, x)
 		if err != nil {
 			return nil, err
 		}
 		if !found {
-			diff.Insert(x)
+			diff.insert(thread, x)
 		}
 	}
 	return diff, nil
@@ -1616,7 +1930,7 @@ func CompareDepth(op syntax.Token, x, y Value, depth int) (bool, error) {
 		case syntax.NEQ:
 			return x != y, nil
 		}
-		return false, fmt.Errorf("%s %s %s not implemented", x.Type(), op, y.Type())
+		return false, Errorf(TypeError, "%s %s %s not implemented", x.Type(), op, y.Type())
 	}
 
 	// different types
@@ -1660,7 +1974,7 @@ func CompareDepth(op syntax.Token, x, y Value, depth int) (bool, error) {
 	case syntax.NEQ:
 		return true, nil
 	}
-	return false, fmt.Errorf("%s %s %s not implemented", x.Type(), op, y.Type())
+	return false, Errorf(TypeError, "%s %s %s not implemented", x.Type(), op, y.Type())
 }
 
 func sameType(x, y Value) bool {
@@ -0,0 +1,295 @@
+package starlarkconv
+
+// This file defines Object, which binds a Go struct to Starlark.
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.starlark.net/starlark"
+)
+
+// An Object is a Starlark value that wraps a pointer to a Go struct,
+// as created by Bind. Mutations of the Starlark value are visible in
+// the Go struct, and vice versa.
+//
+// The attributes of an Object are the fields of the struct, named as
+// described in the package documentation, and its exported methods.
+// Reading a field converts its current value by ToValue, so that a
+// Starlark list obtained from a field of slice type is a copy; but a
+// field that is a non-nil pointer to a struct is itself bound as an
+// Object. Setting a field converts the new value by FromValue.
+//
+// A method is called with positional arguments only, each converted
+// to the type of the corresponding Go parameter by FromValue, except
+// that a leading *starlark.Thread parameter receives the calling
+// thread. A final result of type error is reported as a Starlark error.
+// A method with no other results returns None, one with one result
+// returns it converted by ToValue, and one with several returns them
+// as a tuple.
+//
+// Freezing an Object prevents setting its fields and calling its
+// methods with pointer receivers, since either may mutate the struct.
+// Methods with value receivers may still be called. Reads and writes
+// of an unfrozen Object are recorded for memoization as if the
+// Object were a list; each call of a method with a pointer receiver
+// counts as a write. Go code that mutates a bound struct directly
+// must call Modified. The Objects of nested structs share the frozen
+// state and the record of reads and writes of the outermost one.
+type Object struct {
+	ptr     reflect.Value // pointer to struct
+	class   *class
+	frozen  *bool // shared with nested Objects
+	tracker *starlark.Tracker
+}
+
+// A class holds the attributes of a struct type bound to Starlark.
+type class struct {
+	name    string
+	fields  map[string]field
+	methods map[string]method
+	names   []string // sorted names of fields and methods
+}
+
+// A method describes a method of a bound struct type.
+type method struct {
+	index  int  // index in the method set of the pointer type
+	thread bool // method accepts a leading *starlark.Thread parameter
+	value  bool // method has a value receiver
+}
+
+var (
+	_ starlark.HasThreadAttrs    = (*Object)(nil)
+	_ starlark.HasThreadSetField = (*Object)(nil)
+
+	classes   sync.Map // maps reflect.Type to *class
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+	threadPtr = reflect.TypeOf((*starlark.Thread)(nil))
+)
+
+// Bind returns an Object that wraps ptr, which must be a non-nil
+// pointer to a Go struct. Its reads and writes are recorded by owner,
+// which may be nil, as for starlark.NewList.
+func Bind(owner *starlark.Thread, ptr interface{}) (*Object, error) {
+	p := reflect.ValueOf(ptr)
+	if p.Kind() != reflect.Ptr || p.Elem().Kind() != reflect.Struct || p.IsNil() {
+		return nil, fmt.Errorf("starlarkconv.Bind: got %T, want non-nil pointer to struct", ptr)
+	}
+	return &Object{ptr: p, class: classOf(p.Type()), frozen: new(bool), tracker: starlark.NewTracker(owner)}, nil
+}
+
+// classOf returns the class of the pointer-to-struct type t.
+func classOf(t reflect.Type) *class {
+	if c, ok := classes.Load(t); ok {
+		return c.(*class)
+	}
+	c := &class{
+		name:    t.Elem().Name(),
+		fields:  make(map[string]field),
+		methods: make(map[string]method),
+	}
+	if c.name == "" {
+		c.name = "struct"
+	}
+	for _, f := range fields(t.Elem()) {
+		c.fields[f.name] = f
+		c.names = append(c.names, f.name)
+	}
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		if _, ok := c.fields[m.Name]; ok {
+			continue // the field takes precedence
+		}
+		_, value := t.Elem().MethodByName(m.Name)
+		mt := m.Type // includes the receiver
+		c.methods[m.Name] = method{
+			index:  i,
+			thread: mt.NumIn() > 1 && mt.In(1) == threadPtr,
+			value:  value,
+		}
+		c.names = append(c.names, m.Name)
+	}
+	sort.Strings(c.names)
+	c2, _ := classes.LoadOrStore(t, c)
+	return c2.(*class)
+}
+
+// Interface returns the pointer to the Go struct wrapped by o.
+func (o *Object) Interface() interface{} { return o.ptr.Interface() }
+
+// Modified records that Go code executed by thread, which is nil if
+// unknown, has mutated the struct wrapped by o other than through o,
+// so that memoized results that depend on o are invalidated.
+func (o *Object) Modified(thread *starlark.Thread) { o.tracker.Write(thread) }
+
+func (o *Object) Type() string          { return o.class.name }
+func (o *Object) Truth() starlark.Bool  { return true }
+func (o *Object) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: %s", o.Type()) }
+func (o *Object) Freeze()               { *o.frozen = true }
+
+func (o *Object) String() string {
+	var buf strings.Builder
+	buf.WriteString(o.class.name)
+	buf.WriteByte('(')
+	sep := ""
+	for _, name := range o.class.names {
+		if _, ok := o.class.fields[name]; !ok {
+			continue
+		}
+		v, err := o.Attr(name)
+		if err != nil || v == nil {
+			continue
+		}
+		buf.WriteString(sep)
+		buf.WriteString(name)
+		buf.WriteString(" = ")
+		buf.WriteString(v.String())
+		sep = ", "
+	}
+	buf.WriteByte(')')
+	return buf.String()
+}
+
+func (o *Object) AttrNames() []string { return o.class.names }
+
+func (o *Object) Attr(name string) (starlark.Value, error) { return o.ThreadAttr(nil, name) }
+
+// ThreadAttr returns the named attribute, recording the read of a
+// field by thread.
+func (o *Object) ThreadAttr(thread *starlark.Thread, name string) (starlark.Value, error) {
+	if m, ok := o.class.methods[name]; ok {
+		return starlark.NewBuiltin(name, o.call(m)).BindReceiver(o), nil
+	}
+	f, ok := o.class.fields[name]
+	if !ok {
+		return nil, nil // no such attribute
+	}
+	if !*o.frozen {
+		o.tracker.Read(thread)
+	}
+	fv, ok := fieldByIndex(o.ptr.Elem(), f.index, false)
+	if !ok {
+		return starlark.None, nil // field of nil embedded struct
+	}
+	if fv.Kind() == reflect.Ptr && fv.Type().Elem().Kind() == reflect.Struct && !fv.IsNil() && isPlain(fv.Type()) {
+		// Bind a nested struct so that its mutations are visible,
+		// and are recorded as mutations of o.
+		return &Object{ptr: fv, class: classOf(fv.Type()), frozen: o.frozen, tracker: o.tracker}, nil
+	}
+	v, err := toValue(nil, fv)
+	if err != nil {
+		return nil, fmt.Errorf("%s.%s: %v", o.Type(), name, err)
+	}
+	if *o.frozen {
+		v.Freeze()
+	}
+	return v, nil
+}
+
+// isPlain reports whether the pointer type t is not one that ToValue
+// converts specially.
+func isPlain(t reflect.Type) bool {
+	return !t.Implements(valueType) && !t.Implements(messageType) && t != bigIntType
+}
+
+func (o *Object) SetField(name string, v starlark.Value) error {
+	return o.ThreadSetField(nil, name, v)
+}
+
+// ThreadSetField sets the named field, recording the write by thread.
+func (o *Object) ThreadSetField(thread *starlark.Thread, name string, v starlark.Value) error {
+	f, ok := o.class.fields[name]
+	if !ok {
+		if _, ok := o.class.methods[name]; ok {
+			return fmt.Errorf("cannot set method %s of %s", name, o.Type())
+		}
+		return starlark.NoSuchAttrError(fmt.Sprintf("%s has no .%s field", o.Type(), name))
+	}
+	if *o.frozen {
+		return fmt.Errorf("cannot set field %s of frozen %s", name, o.Type())
+	}
+	fv, _ := fieldByIndex(o.ptr.Elem(), f.index, true)
+	if !fv.CanSet() {
+		return fmt.Errorf("cannot set field %s of %s", name, o.Type())
+	}
+	// Convert into a temporary so that a failure leaves the field unchanged.
+	tmp := reflect.New(fv.Type()).Elem()
+	if err := fromValue(v, tmp, 0); err != nil {
+		return fmt.Errorf("%s.%s: %v", o.Type(), name, err)
+	}
+	o.tracker.Write(thread)
+	fv.Set(tmp)
+	return nil
+}
+
+// call returns the implementation of the specified method of o.
+func (o *Object) call(m method) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if len(kwargs) > 0 {
+			return nil, fmt.Errorf("%s: unexpected keyword arguments", b.Name())
+		}
+		if !m.value {
+			if *o.frozen {
+				return nil, fmt.Errorf("%s: cannot call method of frozen %s", b.Name(), o.Type())
+			}
+			o.tracker.Write(thread)
+		} else if !*o.frozen {
+			o.tracker.Read(thread)
+		}
+
+		fn := o.ptr.Method(m.index)
+		ft := fn.Type()
+		var in []reflect.Value
+		if m.thread {
+			in = append(in, reflect.ValueOf(thread))
+		}
+		nparams := ft.NumIn() - len(in)
+		if ft.IsVariadic() {
+			if len(args) < nparams-1 {
+				return nil, fmt.Errorf("%s: got %d arguments, want at least %d", b.Name(), len(args), nparams-1)
+			}
+		} else if len(args) != nparams {
+			return nil, fmt.Errorf("%s: got %d arguments, want %d", b.Name(), len(args), nparams)
+		}
+		for i, arg := range args {
+			j := len(in)
+			var t reflect.Type
+			if ft.IsVariadic() && j >= ft.NumIn()-1 {
+				t = ft.In(ft.NumIn() - 1).Elem()
+			} else {
+				t = ft.In(j)
+			}
+			x := reflect.New(t).Elem()
+			if err := fromValue(arg, x, 0); err != nil {
+				return nil, fmt.Errorf("%s: for parameter %d: %v", b.Name(), i+1, err)
+			}
+			in = append(in, x)
+		}
+
+		out := fn.Call(in)
+		if n := len(out); n > 0 && ft.Out(n-1) == errorType {
+			if err := out[n-1].Interface(); err != nil {
+				return nil, err.(error)
+			}
+			out = out[:n-1]
+		}
+		results := make(starlark.Tuple, len(out))
+		for i, x := range out {
+			v, err := toValue(thread, x)
+			if err != nil {
+				return nil, fmt.Errorf("%s: result %d: %v", b.Name(), i+1, err)
+			}
+			results[i] = v
+		}
+		switch len(results) {
+		case 0:
+			return starlark.None, nil
+		case 1:
+			return results[0], nil
+		}
+		return results, nil
+	}
+}
@@ -0,0 +1,163 @@
+package starlarkconv_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkconv"
+)
+
+type Counter struct {
+	Name  string `starlark:"name"`
+	Count int    `starlark:"count"`
+	Steps []int  `starlark:"steps"`
+	Inner *Inner `starlark:"inner"`
+}
+
+type Inner struct {
+	Label string `starlark:"label"`
+}
+
+func (c *Counter) Add(n int, more ...int) int {
+	c.Count += n
+	for _, m := range more {
+		c.Count += m
+	}
+	return c.Count
+}
+
+func (c *Counter) Check(thread *starlark.Thread, max int) (bool, error) {
+	if thread == nil {
+		return false, errors.New("no thread")
+	}
+	if c.Count > max {
+		return false, fmt.Errorf("count %d exceeds %d", c.Count, max)
+	}
+	return true, nil
+}
+
+func (c Counter) Describe() (string, int) { return c.Name, c.Count }
+
+func TestBind(t *testing.T) {
+	counter := &Counter{Name: "c", Inner: &Inner{Label: "x"}}
+	obj, err := starlarkconv.Bind(nil, counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := starlarkconv.Bind(nil, *counter); err == nil {
+		t.Errorf("Bind(struct) succeeded")
+	}
+
+	thread := new(starlark.Thread)
+	predeclared := starlark.StringDict{"counter": obj}
+	globals, err := starlark.ExecFile(thread, "bind.star", `
+def current():
+    return counter.count
+
+def check(want, got):
+    if got != want:
+        fail("got %r, want %r" % (got, want))
+
+check("Counter", type(counter))
+check(["Add", "Check", "Describe", "count", "inner", "name", "steps"], dir(counter))
+check(1, counter.Add(1))
+check(6, counter.Add(2, 1, 2))
+check(True, counter.Check(10))
+check(("c", 6), counter.Describe())
+counter.name = "d"
+counter.steps = [1, 2]
+counter.inner.label = "y"
+`, predeclared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter.Name != "d" || counter.Count != 6 || len(counter.Steps) != 2 || counter.Inner.Label != "y" {
+		t.Errorf("counter = %+v", counter)
+	}
+	if got, want := obj.String(), `Counter(count = 6, inner = Inner(label = "y"), name = "d", steps = [1, 2])`; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+
+	// Memoized results observe mutations, whether by Starlark or Go code.
+	current := func() starlark.Value {
+		v, err := starlark.Call(thread, globals["current"], nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	if v := current(); v != starlark.MakeInt(6) {
+		t.Errorf("current() = %s, want 6", v)
+	}
+	if _, err := starlark.Call(thread, mustAttr(t, obj, "Add"), starlark.Tuple{starlark.MakeInt(1)}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v := current(); v != starlark.MakeInt(7) {
+		t.Errorf("current() = %s, want 7", v)
+	}
+	counter.Count = 10
+	obj.Modified(nil)
+	if v := current(); v != starlark.MakeInt(10) {
+		t.Errorf("current() = %s, want 10", v)
+	}
+
+	// A write by another thread invalidates the memoized result too.
+	thread2 := new(starlark.Thread)
+	if _, err := starlark.ExecFile(thread2, "bind.star", `counter.count = 11`, predeclared); err != nil {
+		t.Fatal(err)
+	}
+	if v := current(); v != starlark.MakeInt(11) {
+		t.Errorf("current() = %s, want 11", v)
+	}
+
+	// Errors.
+	for _, test := range []struct{ src, want string }{
+		{`counter.Add("1")`, `Add: for parameter 1: got string, want int`},
+		{`counter.Add()`, `Add: got 0 arguments, want at least 1`},
+		{`counter.Add(n=1)`, `Add: unexpected keyword arguments`},
+		{`counter.Check(1)`, `count 11 exceeds 1`},
+		{`counter.count = "x"`, `Counter.count: got string, want int`},
+		{`counter.nope = 1`, `Counter has no .nope field`},
+		{`counter.Add = 1`, `cannot set method Add of Counter`},
+	} {
+		_, err := starlark.ExecFile(thread, "bind.star", test.src, predeclared)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %s", test.src, err, test.want)
+		}
+	}
+
+	// A frozen Object permits only reads and value-receiver methods.
+	// Freezing it freezes the Objects of nested structs obtained earlier.
+	inner := mustAttr(t, obj, "inner").(*starlarkconv.Object)
+	obj.Freeze()
+	if err := inner.SetField("label", starlark.String("z")); err == nil {
+		t.Errorf("SetField on nested Object of frozen Object succeeded")
+	}
+	for _, test := range []struct{ src, want string }{
+		{`counter.Describe()`, ``},
+		{`counter.name = "e"`, `cannot set field name of frozen Counter`},
+		{`counter.inner.label = "z"`, `cannot set field label of frozen Inner`},
+		{`counter.Add(1)`, `Add: cannot call method of frozen Counter`},
+		{`counter.steps.append(3)`, `cannot append to frozen list`},
+	} {
+		_, err := starlark.ExecFile(thread, "bind.star", test.src, predeclared)
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("%s: %v", test.src, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %s", test.src, err, test.want)
+		}
+	}
+}
+
+func mustAttr(t *testing.T, x starlark.HasAttrs, name string) starlark.Value {
+	v, err := x.Attr(name)
+	if err != nil || v == nil {
+		t.Fatalf("no attribute %s: %v", name, err)
+	}
+	return v
+}
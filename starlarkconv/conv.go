@@ -0,0 +1,748 @@
+// Package starlarkconv converts between Go values and Starlark values
+// using reflection.
+//
+// ToValue converts a Go value to a Starlark value, and FromValue
+// converts a Starlark value to a Go value, according to this table:
+//
+//	Go                                 Starlark
+//	--                                 --------
+//	nil, nil pointer or interface      None
+//	bool                               bool
+//	int, int8, ..., uint64, uintptr    int
+//	*big.Int                           int
+//	float32, float64                   float
+//	string                             string
+//	[]byte                             bytes
+//	slice, array                       list (or tuple, or set, by FromValue)
+//	map                                dict
+//	struct                             struct (or dict or module, by FromValue)
+//	time.Time, time.Duration           time.time, time.duration (see lib/time)
+//	protocol message                   proto.Message (see lib/proto)
+//	starlark.Value                     itself
+//
+// The fields of a struct are converted to the attributes of a
+// Starlark struct. The name of an attribute is that of its field,
+// unless the field has a tag such as `starlark:"name"`, or else a
+// json tag. A tag of "-" omits the field, and the omitempty option,
+// as in `starlark:"name,omitempty"`, omits the attribute when ToValue
+// converts a zero value. Unexported fields are omitted, and the fields
+// of embedded structs are promoted, as in encoding/json.
+//
+// FromValue converts a Starlark value into a Go value of the
+// appropriate type; see FromValue for details.
+//
+// Conversion failures are reported as an *Error, which records the
+// path to the value that could not be converted.
+//
+// Whereas ToValue copies a struct, Bind wraps a pointer to a struct
+// as an Object, whose fields and methods are accessible to Starlark.
+package starlarkconv // import "go.starlark.net/starlarkconv"
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	gotime "time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	starlarkproto "go.starlark.net/lib/proto"
+	"go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// An Error reports a failure to convert a value.
+type Error struct {
+	// Path is the path from the root of the converted value to the
+	// element that could not be converted, in Starlark notation,
+	// such as .items[3]["key"], or "" for the root itself.
+	Path string
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return e.Msg
+	}
+	return e.Path + ": " + e.Msg
+}
+
+func errorf(format string, args ...interface{}) error {
+	return &Error{Msg: fmt.Sprintf(format, args...)}
+}
+
+// at prefixes the path of a conversion error with elem.
+func at(elem string, err error) error {
+	if e, ok := err.(*Error); ok {
+		e.Path = elem + e.Path
+	}
+	return err
+}
+
+// maxDepth limits the nesting of converted values, which may be cyclic.
+const maxDepth = 1000
+
+var (
+	valueType     = reflect.TypeOf((*starlark.Value)(nil)).Elem()
+	unpackerType  = reflect.TypeOf((*starlark.Unpacker)(nil)).Elem()
+	messageType   = reflect.TypeOf((*protoreflect.ProtoMessage)(nil)).Elem()
+	bigIntType    = reflect.TypeOf((*big.Int)(nil))
+	timeType      = reflect.TypeOf(gotime.Time{})
+	durationType  = reflect.TypeOf(gotime.Duration(0))
+	interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// -- Go to Starlark --
+
+// ToValue converts a Go value to a Starlark value, as described in the
+// package documentation. The new lists, dicts, and sets are owned by
+// thread, which may be nil (see starlark.Thread). The entries of a dict
+// converted from a Go map are in the order of their keys, and an array
+// within a key of a Go map is converted to a tuple, as a list is not
+// hashable. A cyclic value cannot be converted; the path of the error
+// leads to the first repetition of a value.
+func ToValue(thread *starlark.Thread, x interface{}) (starlark.Value, error) {
+	if x == nil {
+		return starlark.None, nil
+	}
+	return toValue(thread, reflect.ValueOf(x))
+}
+
+func toValue(thread *starlark.Thread, x reflect.Value) (starlark.Value, error) {
+	c := converter{thread: thread}
+	return c.value(x, 0, false)
+}
+
+// A converter converts Go values to Starlark values.
+type converter struct {
+	thread *starlark.Thread
+	// active holds the pointers, maps, and slices that enclose the
+	// value being converted, to detect cycles.
+	active map[visit]bool
+}
+
+// A visit identifies a pointer, map, or slice. The length of a slice
+// distinguishes it from a shorter slice of the same array.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// value converts x, which is within a key of a Go map if key is set.
+func (c *converter) value(x reflect.Value, depth int, key bool) (starlark.Value, error) {
+	if depth > maxDepth {
+		return nil, errorf("value is too deeply nested")
+	}
+	t := x.Type()
+
+	// Special types.
+	switch {
+	case t.Implements(valueType):
+		if x.Kind() == reflect.Interface || x.Kind() == reflect.Ptr {
+			if x.IsNil() {
+				return starlark.None, nil
+			}
+		}
+		return x.Interface().(starlark.Value), nil
+	case t.Implements(messageType):
+		if x.Kind() == reflect.Ptr && x.IsNil() {
+			return starlark.None, nil
+		}
+		return starlarkproto.Wrap(x.Interface().(protoreflect.ProtoMessage)), nil
+	case t == bigIntType:
+		if x.IsNil() {
+			return starlark.None, nil
+		}
+		return starlark.MakeBigInt(x.Interface().(*big.Int)), nil
+	case t == timeType:
+		return time.Time(x.Interface().(gotime.Time)), nil
+	case t == durationType:
+		return time.Duration(x.Int()), nil
+	}
+
+	switch x.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if x.IsNil() {
+			break
+		}
+		v := visit{x.Pointer(), t, 0}
+		if x.Kind() == reflect.Slice {
+			v.len = x.Len()
+		}
+		if c.active[v] {
+			return nil, errorf("cyclic value of type %s", t)
+		}
+		if c.active == nil {
+			c.active = make(map[visit]bool)
+		}
+		c.active[v] = true
+		defer delete(c.active, v)
+	}
+
+	switch x.Kind() {
+	case reflect.Bool:
+		return starlark.Bool(x.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return starlark.MakeInt64(x.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return starlark.MakeUint64(x.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return starlark.Float(x.Float()), nil
+	case reflect.String:
+		return starlark.String(x.String()), nil
+
+	case reflect.Ptr, reflect.Interface:
+		if x.IsNil() {
+			return starlark.None, nil
+		}
+		return c.value(x.Elem(), depth+1, key)
+
+	case reflect.Slice, reflect.Array:
+		if x.Kind() == reflect.Slice {
+			if x.IsNil() {
+				return starlark.None, nil
+			}
+			if t.Elem().Kind() == reflect.Uint8 {
+				return starlark.Bytes(x.Bytes()), nil
+			}
+		}
+		elems := make([]starlark.Value, x.Len())
+		for i := range elems {
+			elem, err := c.value(x.Index(i), depth+1, key)
+			if err != nil {
+				return nil, at("["+strconv.Itoa(i)+"]", err)
+			}
+			elems[i] = elem
+		}
+		if key && x.Kind() == reflect.Array {
+			return starlark.Tuple(elems), nil
+		}
+		return starlark.NewList(c.thread, elems), nil
+
+	case reflect.Map:
+		if x.IsNil() {
+			return starlark.None, nil
+		}
+		type entry struct{ k, v starlark.Value }
+		entries := make([]entry, 0, x.Len())
+		for iter := x.MapRange(); iter.Next(); {
+			k, err := c.value(iter.Key(), depth+1, true)
+			if err != nil {
+				return nil, at(fmt.Sprintf("[%v]", iter.Key()), err)
+			}
+			v, err := c.value(iter.Value(), depth+1, key)
+			if err != nil {
+				return nil, at("["+k.String()+"]", err)
+			}
+			entries = append(entries, entry{k, v})
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			less, _ := starlark.Compare(syntax.LT, entries[i].k, entries[j].k)
+			return less
+		})
+		dict := starlark.NewDict(c.thread, len(entries))
+		for _, e := range entries {
+			if err := dict.SetKey(e.k, e.v); err != nil {
+				return nil, at("["+e.k.String()+"]", errorf("%v", err))
+			}
+		}
+		return dict, nil
+
+	case reflect.Struct:
+		attrs := make(starlark.StringDict)
+		for _, f := range fields(t) {
+			fv, ok := fieldByIndex(x, f.index, false)
+			if !ok || f.omitEmpty && fv.IsZero() {
+				continue
+			}
+			v, err := c.value(fv, depth+1, key)
+			if err != nil {
+				return nil, at("."+f.name, err)
+			}
+			attrs[f.name] = v
+		}
+		return starlarkstruct.FromStringDict(starlarkstruct.Default, attrs), nil
+	}
+	return nil, errorf("cannot convert Go value of type %s", t)
+}
+
+// -- Starlark to Go --
+
+// FromValue converts the Starlark value v and stores the result in
+// the Go variable pointed to by ptr, as described in the package
+// documentation, with these additional rules:
+//
+// If the variable's type implements starlark.Unpacker, its Unpack
+// method is called. A variable of interface type that v implements,
+// such as starlark.Value, is set to v itself. Any other variable of
+// interface type receives the natural Go representation of v: nil,
+// bool, int64 (or *big.Int if too large), float64, string, []byte,
+// []interface{} for a list, tuple, or set, map[string]interface{}
+// for a dict with string keys or a struct, map[interface{}]interface{}
+// for any other dict, time.Time, time.Duration, or v itself for a
+// value of any other type.
+//
+// An int is converted to a Go integer only if it fits, and to a float
+// if its type is float. A list, tuple, or set is converted to a Go
+// array only if their lengths are equal. A Go struct may be converted
+// from a Starlark struct or other value with attributes, or from a dict
+// with string keys; fields without a corresponding attribute or key
+// are left unchanged, and other attributes or keys are ignored.
+// None converts to a nil pointer, slice, map, or interface.
+func FromValue(v starlark.Value, ptr interface{}) error {
+	p := reflect.ValueOf(ptr)
+	if p.Kind() != reflect.Ptr || p.IsNil() {
+		return fmt.Errorf("starlarkconv.FromValue: got %T, want non-nil pointer", ptr)
+	}
+	return fromValue(v, p.Elem(), 0)
+}
+
+func fromValue(v starlark.Value, x reflect.Value, depth int) error {
+	if depth > maxDepth {
+		return errorf("value is too deeply nested (cyclic?)")
+	}
+	t := x.Type()
+
+	// Special types.
+	switch {
+	case reflect.PtrTo(t).Implements(unpackerType):
+		if err := x.Addr().Interface().(starlark.Unpacker).Unpack(v); err != nil {
+			return errorf("%v", err)
+		}
+		return nil
+	case t.Kind() == reflect.Interface && reflect.TypeOf(v).Implements(t) && t != interfaceType:
+		x.Set(reflect.ValueOf(v))
+		return nil
+	case t.Kind() != reflect.Interface && reflect.TypeOf(v).AssignableTo(t):
+		x.Set(reflect.ValueOf(v))
+		return nil
+	case t.Implements(messageType) && t.Kind() == reflect.Ptr:
+		return fromMessage(v, x)
+	case t == bigIntType:
+		i, ok := v.(starlark.Int)
+		if !ok {
+			return mismatch(v, t)
+		}
+		x.Set(reflect.ValueOf(i.BigInt()))
+		return nil
+	case t == timeType:
+		tm, ok := v.(time.Time)
+		if !ok {
+			return mismatch(v, t)
+		}
+		x.Set(reflect.ValueOf(gotime.Time(tm)))
+		return nil
+	case t == durationType:
+		d, ok := v.(time.Duration)
+		if !ok {
+			return mismatch(v, t)
+		}
+		x.SetInt(int64(d))
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		b, ok := v.(starlark.Bool)
+		if !ok {
+			return mismatch(v, t)
+		}
+		x.SetBool(bool(b))
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := v.(starlark.Int)
+		if !ok {
+			return mismatch(v, t)
+		}
+		i64, ok := i.Int64()
+		if !ok || x.OverflowInt(i64) {
+			return errorf("int %s out of range for %s", i, t)
+		}
+		x.SetInt(i64)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := v.(starlark.Int)
+		if !ok {
+			return mismatch(v, t)
+		}
+		u64, ok := i.Uint64()
+		if !ok || x.OverflowUint(u64) {
+			return errorf("int %s out of range for %s", i, t)
+		}
+		x.SetUint(u64)
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		f, ok := starlark.AsFloat(v)
+		if !ok {
+			return mismatch(v, t)
+		}
+		if t.Kind() == reflect.Float32 && math.Abs(f) > math.MaxFloat32 && !math.IsInf(f, 0) {
+			return errorf("float %v out of range for %s", f, t)
+		}
+		x.SetFloat(f)
+		return nil
+
+	case reflect.String:
+		s, ok := v.(starlark.String)
+		if !ok {
+			return mismatch(v, t)
+		}
+		x.SetString(string(s))
+		return nil
+
+	case reflect.Ptr:
+		if v == starlark.None {
+			x.Set(reflect.Zero(t))
+			return nil
+		}
+		elem := reflect.New(t.Elem())
+		if err := fromValue(v, elem.Elem(), depth+1); err != nil {
+			return err
+		}
+		x.Set(elem)
+		return nil
+
+	case reflect.Interface:
+		if v == starlark.None {
+			x.Set(reflect.Zero(t))
+			return nil
+		}
+		y, err := goValue(v, depth)
+		if err != nil {
+			return err
+		}
+		if !reflect.TypeOf(y).AssignableTo(t) {
+			return mismatch(v, t)
+		}
+		x.Set(reflect.ValueOf(y))
+		return nil
+
+	case reflect.Slice:
+		if v == starlark.None {
+			x.Set(reflect.Zero(t))
+			return nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			switch v := v.(type) {
+			case starlark.Bytes:
+				x.SetBytes([]byte(v))
+				return nil
+			case starlark.String:
+				x.SetBytes([]byte(v))
+				return nil
+			}
+		}
+		elems, ok := sequence(v)
+		if !ok {
+			return mismatch(v, t)
+		}
+		s := reflect.MakeSlice(t, len(elems), len(elems))
+		for i, elem := range elems {
+			if err := fromValue(elem, s.Index(i), depth+1); err != nil {
+				return at("["+strconv.Itoa(i)+"]", err)
+			}
+		}
+		x.Set(s)
+		return nil
+
+	case reflect.Array:
+		elems, ok := sequence(v)
+		if !ok {
+			return mismatch(v, t)
+		}
+		if len(elems) != t.Len() {
+			return errorf("got %s of length %d, want %s", v.Type(), len(elems), t)
+		}
+		for i, elem := range elems {
+			if err := fromValue(elem, x.Index(i), depth+1); err != nil {
+				return at("["+strconv.Itoa(i)+"]", err)
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		if v == starlark.None {
+			x.Set(reflect.Zero(t))
+			return nil
+		}
+		mapping, ok := v.(starlark.IterableMapping)
+		if !ok {
+			return mismatch(v, t)
+		}
+		m := reflect.MakeMap(t)
+		for _, item := range mapping.Items() {
+			k, val := reflect.New(t.Key()).Elem(), reflect.New(t.Elem()).Elem()
+			elem := "[" + item[0].String() + "]"
+			if err := fromValue(item[0], k, depth+1); err != nil {
+				return at(elem, err)
+			}
+			if err := fromValue(item[1], val, depth+1); err != nil {
+				return at(elem, err)
+			}
+			m.SetMapIndex(k, val)
+		}
+		x.Set(m)
+		return nil
+
+	case reflect.Struct:
+		var get func(name string) (starlark.Value, bool, error)
+		var elem func(name string) string
+		switch v := v.(type) {
+		case *starlark.Dict:
+			get = func(name string) (starlark.Value, bool, error) {
+				return v.Get(starlark.String(name))
+			}
+			elem = func(name string) string { return "[" + strconv.Quote(name) + "]" }
+		case starlark.HasAttrs:
+			get = func(name string) (starlark.Value, bool, error) {
+				attr, err := v.Attr(name)
+				if _, ok := err.(starlark.NoSuchAttrError); ok {
+					return nil, false, nil // missing attribute
+				}
+				return attr, attr != nil, err
+			}
+			elem = func(name string) string { return "." + name }
+		default:
+			return mismatch(v, t)
+		}
+		for _, f := range fields(t) {
+			fv, found, err := get(f.name)
+			if err != nil {
+				return at(elem(f.name), errorf("%v", err))
+			}
+			if !found {
+				continue
+			}
+			field, _ := fieldByIndex(x, f.index, true)
+			if err := fromValue(fv, field, depth+1); err != nil {
+				return at(elem(f.name), err)
+			}
+		}
+		return nil
+	}
+	return errorf("cannot convert Starlark value to Go type %s", t)
+}
+
+func mismatch(v starlark.Value, t reflect.Type) error {
+	return errorf("got %s, want %s", v.Type(), t)
+}
+
+// sequence returns the elements of a list, tuple, or set.
+func sequence(v starlark.Value) ([]starlark.Value, bool) {
+	switch v := v.(type) {
+	case *starlark.List, starlark.Tuple, *starlark.Set:
+		iter := starlark.Iterate(v)
+		defer iter.Done()
+		var elems []starlark.Value
+		var elem starlark.Value
+		for iter.Next(&elem) {
+			elems = append(elems, elem)
+		}
+		return elems, true
+	}
+	return nil, false
+}
+
+// fromMessage converts a Starlark message to a Go protocol message
+// of the same type, pointed to by x.
+func fromMessage(v starlark.Value, x reflect.Value) error {
+	if v == starlark.None {
+		x.Set(reflect.Zero(x.Type()))
+		return nil
+	}
+	m, ok := v.(*starlarkproto.Message)
+	if !ok {
+		return mismatch(v, x.Type())
+	}
+	src := m.Message()
+	dst := reflect.New(x.Type().Elem()).Interface().(protoreflect.ProtoMessage)
+	if got, want := src.ProtoReflect().Descriptor().FullName(), dst.ProtoReflect().Descriptor().FullName(); got != want {
+		return errorf("got proto.Message %s, want %s", got, want)
+	}
+	// The messages may have different Go representations
+	// (for example, dynamic and generated), so copy by encoding.
+	data, err := proto.Marshal(src)
+	if err == nil {
+		err = proto.Unmarshal(data, dst)
+	}
+	if err != nil {
+		return errorf("%v", err)
+	}
+	x.Set(reflect.ValueOf(dst))
+	return nil
+}
+
+// goValue returns the natural Go representation of a Starlark value.
+func goValue(v starlark.Value, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errorf("value is too deeply nested (cyclic?)")
+	}
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return v.BigInt(), nil
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Bytes:
+		return []byte(v), nil
+	case time.Time:
+		return gotime.Time(v), nil
+	case time.Duration:
+		return gotime.Duration(v), nil
+	case *starlark.List, starlark.Tuple, *starlark.Set:
+		elems, _ := sequence(v)
+		list := make([]interface{}, len(elems))
+		for i, elem := range elems {
+			x, err := goValue(elem, depth+1)
+			if err != nil {
+				return nil, at("["+strconv.Itoa(i)+"]", err)
+			}
+			list[i] = x
+		}
+		return list, nil
+	case *starlark.Dict:
+		items := v.Items()
+		stringKeys := true
+		for _, item := range items {
+			if _, ok := item[0].(starlark.String); !ok {
+				stringKeys = false
+				break
+			}
+		}
+		if stringKeys {
+			m := make(map[string]interface{}, len(items))
+			for _, item := range items {
+				x, err := goValue(item[1], depth+1)
+				if err != nil {
+					return nil, at("["+item[0].String()+"]", err)
+				}
+				m[string(item[0].(starlark.String))] = x
+			}
+			return m, nil
+		}
+		m := make(map[interface{}]interface{}, len(items))
+		for _, item := range items {
+			k, err := goValue(item[0], depth+1)
+			if err != nil {
+				return nil, at("["+item[0].String()+"]", err)
+			}
+			if !reflect.TypeOf(k).Comparable() {
+				return nil, at("["+item[0].String()+"]", errorf("cannot use %s as a Go map key", item[0].Type()))
+			}
+			x, err := goValue(item[1], depth+1)
+			if err != nil {
+				return nil, at("["+item[0].String()+"]", err)
+			}
+			m[k] = x
+		}
+		return m, nil
+	case *starlarkstruct.Struct:
+		m := make(map[string]interface{})
+		for _, name := range v.AttrNames() {
+			attr, err := v.Attr(name)
+			if err != nil {
+				return nil, at("."+name, errorf("%v", err))
+			}
+			x, err := goValue(attr, depth+1)
+			if err != nil {
+				return nil, at("."+name, err)
+			}
+			m[name] = x
+		}
+		return m, nil
+	}
+	return v, nil
+}
+
+// -- struct fields --
+
+// A field describes a field of a Go struct that corresponds to an
+// attribute of a Starlark struct.
+type field struct {
+	name      string
+	index     []int // for reflect.Value.FieldByIndex
+	omitEmpty bool
+}
+
+// fields returns the fields of a struct type that correspond to
+// attributes, including those of embedded structs.
+func fields(t reflect.Type) []field {
+	var fields []field
+	seen := make(map[string]bool)
+	var visit func(t reflect.Type, index []int)
+	visit = func(t reflect.Type, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, opts := f.Tag.Get("starlark"), ""
+			if name == "" {
+				name = f.Tag.Get("json")
+			}
+			if comma := strings.IndexByte(name, ','); comma >= 0 {
+				name, opts = name[:comma], name[comma:]
+			}
+			if name == "-" {
+				continue
+			}
+			index := append(index[:len(index):len(index)], i)
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+				visit(ft, index) // promote the fields of an embedded struct
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			if seen[name] {
+				continue // a shallower field of the same name takes precedence
+			}
+			seen[name] = true
+			fields = append(fields, field{name, index, strings.Contains(opts, ",omitempty")})
+		}
+	}
+	visit(t, nil)
+	return fields
+}
+
+// fieldByIndex returns the field of struct x with the specified index.
+// If alloc, it allocates nil embedded struct pointers along the way;
+// otherwise it reports whether it encountered one.
+func fieldByIndex(x reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, j := range index {
+		if i > 0 && x.Kind() == reflect.Ptr {
+			if x.IsNil() {
+				if !alloc || !x.CanSet() {
+					return reflect.Value{}, false
+				}
+				x.Set(reflect.New(x.Type().Elem()))
+			}
+			x = x.Elem()
+		}
+		x = x.Field(j)
+	}
+	return x, true
+}
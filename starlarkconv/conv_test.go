@@ -0,0 +1,206 @@
+package starlarkconv_test
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+	gotime "time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	starlarkproto "go.starlark.net/lib/proto"
+	"go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkconv"
+	"go.starlark.net/starlarkstruct"
+)
+
+type Base struct {
+	ID int `starlark:"id"`
+}
+
+type Config struct {
+	Base
+	Name     string            `starlark:"name"`
+	Tags     []string          `json:"tags"`
+	Limits   map[string]uint16 `starlark:"limits"`
+	Ratio    float64
+	Optional *int            `starlark:"optional,omitempty"`
+	Data     []byte          `starlark:"data"`
+	Timeout  gotime.Duration `starlark:"timeout"`
+	Size     *big.Int        `starlark:"size"`
+	Extra    interface{}     `starlark:"extra"`
+	Hidden   string          `starlark:"-"`
+	private  int
+}
+
+func TestToValue(t *testing.T) {
+	thread := new(starlark.Thread)
+	for _, test := range []struct {
+		x    interface{}
+		want string
+	}{
+		{nil, `None`},
+		{true, `True`},
+		{int8(-3), `-3`},
+		{uint64(1 << 63), `9223372036854775808`},
+		{1.5, `1.5`},
+		{"hi", `"hi"`},
+		{[]byte("hi"), `b"hi"`},
+		{[2]int{1, 2}, `[1, 2]`},
+		{[]interface{}{1, "a", nil}, `[1, "a", None]`},
+		{map[string]int{"b": 2, "a": 1}, `{"a": 1, "b": 2}`},
+		{map[int]bool{3: true, 1: false}, `{1: False, 3: True}`},
+		{map[[2]int]string{{1, 2}: "a"}, `{(1, 2): "a"}`},
+		{map[struct{ A [1]string }]int{{[1]string{"x"}}: 1}, `{struct(A = ("x",)): 1}`},
+		{(*int)(nil), `None`},
+		{starlark.String("v"), `"v"`},
+		{gotime.Second, `1s`},
+		{big.NewInt(7), `7`},
+		{
+			Config{
+				Base:   Base{ID: 1},
+				Name:   "x",
+				Tags:   []string{"t"},
+				Limits: map[string]uint16{"cpu": 2},
+				Ratio:  0.5,
+				Extra:  map[string]interface{}{"k": []int{1}},
+				Hidden: "h",
+			},
+			`struct(Ratio = 0.5, data = None, extra = {"k": [1]}, id = 1, limits = {"cpu": 2}, name = "x", size = None, tags = ["t"], timeout = 0s)`,
+		},
+	} {
+		v, err := starlarkconv.ToValue(thread, test.x)
+		if err != nil {
+			t.Errorf("ToValue(%#v): %v", test.x, err)
+			continue
+		}
+		if got := v.String(); got != test.want {
+			t.Errorf("ToValue(%#v) = %s, want %s", test.x, got, test.want)
+		}
+	}
+
+	// Errors report the path to the failing element.
+	_, err := starlarkconv.ToValue(thread, map[string]interface{}{"a": []interface{}{1, make(chan int)}})
+	if got, want := err.Error(), `["a"][1]: cannot convert Go value of type chan int`; got != want {
+		t.Errorf("ToValue error = %s, want %s", got, want)
+	}
+
+	// The path to a cyclic value ends at its first repetition.
+	type node struct{ Next *node }
+	n := &node{}
+	n.Next = &node{Next: n}
+	m := map[string]interface{}{}
+	m["m"] = []interface{}{m}
+	for _, test := range []struct {
+		x    interface{}
+		want string
+	}{
+		{n, `.Next.Next: cyclic value of type *starlarkconv_test.node`},
+		{m, `["m"][0]: cyclic value of type map[string]interface {}`},
+	} {
+		_, err := starlarkconv.ToValue(thread, test.x)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("ToValue error = %v, want %s", err, test.want)
+		}
+	}
+}
+
+func TestFromValue(t *testing.T) {
+	thread := new(starlark.Thread)
+	predeclared := starlark.StringDict{
+		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+		"time":   time.Module,
+	}
+	eval := func(expr string) starlark.Value {
+		v, err := starlark.Eval(thread, "<expr>", expr, predeclared)
+		if err != nil {
+			t.Fatalf("eval %s: %v", expr, err)
+		}
+		return v
+	}
+
+	var config Config
+	src := `struct(id=1, name="x", tags=("t", "u"), limits={"cpu": 2}, Ratio=1, optional=3,
+		data="abc", timeout=time.parse_duration("2s"), size=1<<70, extra=[{"k": None}, 1.5, True], unknown=0)`
+	if err := starlarkconv.FromValue(eval(src), &config); err != nil {
+		t.Fatal(err)
+	}
+	three := 3
+	size, _ := new(big.Int).SetString("1180591620717411303424", 10)
+	want := Config{
+		Base:     Base{ID: 1},
+		Name:     "x",
+		Tags:     []string{"t", "u"},
+		Limits:   map[string]uint16{"cpu": 2},
+		Ratio:    1,
+		Optional: &three,
+		Data:     []byte("abc"),
+		Timeout:  2 * gotime.Second,
+		Size:     size,
+		Extra:    []interface{}{map[string]interface{}{"k": nil}, 1.5, true},
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("FromValue = %+v, want %+v", config, want)
+	}
+
+	// A dict may also populate a struct.
+	var base Base
+	if err := starlarkconv.FromValue(eval(`{"id": 2}`), &base); err != nil || base.ID != 2 {
+		t.Errorf("FromValue(dict) = %+v, %v", base, err)
+	}
+
+	// A variable of type starlark.Value receives the value itself.
+	var v starlark.Value
+	if err := starlarkconv.FromValue(eval(`[1]`), &v); err != nil || v.Type() != "list" {
+		t.Errorf("FromValue(Value) = %v, %v", v, err)
+	}
+
+	// Errors report the path to the failing element.
+	for _, test := range []struct {
+		src  string
+		ptr  interface{}
+		want string
+	}{
+		{`struct(tags=["a", 1])`, new(Config), `.tags[1]: got int, want string`},
+		{`struct(limits={"cpu": 1<<20})`, new(Config), `.limits["cpu"]: int 1048576 out of range for uint16`},
+		{`{"id": "one"}`, new(Base), `["id"]: got string, want int`},
+		{`[1, 2, 3]`, new([2]int), `got list of length 3, want [2]int`},
+		{`1.5`, new(int), `got float, want int`},
+		{`{1: [2]}`, new(map[int][]string), `[1][0]: got int, want string`},
+	} {
+		err := starlarkconv.FromValue(eval(test.src), test.ptr)
+		if err == nil {
+			t.Errorf("FromValue(%s) succeeded, want error %s", test.src, test.want)
+		} else if err.Error() != test.want {
+			t.Errorf("FromValue(%s) error = %s, want %s", test.src, err, test.want)
+		} else if _, ok := err.(*starlarkconv.Error); !ok {
+			t.Errorf("FromValue(%s) error has type %T", test.src, err)
+		}
+	}
+}
+
+func TestProto(t *testing.T) {
+	thread := new(starlark.Thread)
+	ts := timestamppb.New(gotime.Unix(100, 5))
+	v, err := starlarkconv.ToValue(thread, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, ok := v.(*starlarkproto.Message)
+	if !ok {
+		t.Fatalf("ToValue returned %s, want proto.Message", v.Type())
+	}
+	if secs, _ := msg.Attr("seconds"); secs.String() != "100" {
+		t.Errorf("seconds = %v, want 100", secs)
+	}
+
+	var got *timestamppb.Timestamp
+	if err := starlarkconv.FromValue(v, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(got, ts) {
+		t.Errorf("FromValue = %v, want %v", got, ts)
+	}
+}
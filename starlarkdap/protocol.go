@@ -0,0 +1,221 @@
+package starlarkdap
+
+// This file defines the wire format of the Debug Adapter Protocol:
+// JSON messages, each preceded by a Content-Length header.
+// Only the parts of the protocol used by the server are declared.
+// See https://microsoft.github.io/debug-adapter-protocol/specification.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"` // "request"
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"` // "response"
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"` // "event"
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// -- request arguments --
+
+type launchArguments struct {
+	Program     string `json:"program"`
+	StopOnEntry bool   `json:"stopOnEntry"`
+	NoDebug     bool   `json:"noDebug"`
+}
+
+type setBreakpointsArguments struct {
+	Source      source `json:"source"`
+	Breakpoints []struct {
+		Line int `json:"line"`
+	} `json:"breakpoints"`
+}
+
+type setExceptionBreakpointsArguments struct {
+	Filters []string `json:"filters"`
+}
+
+type frameArguments struct {
+	FrameID int `json:"frameId"`
+}
+
+type variablesArguments struct {
+	VariablesReference int `json:"variablesReference"`
+}
+
+type evaluateArguments struct {
+	Expression string `json:"expression"`
+	FrameID    int    `json:"frameId"`
+}
+
+// -- response and event bodies --
+
+type capabilities struct {
+	SupportsConfigurationDoneRequest bool                         `json:"supportsConfigurationDoneRequest"`
+	SupportsEvaluateForHovers        bool                         `json:"supportsEvaluateForHovers"`
+	SupportsExceptionInfoRequest     bool                         `json:"supportsExceptionInfoRequest"`
+	SupportsTerminateRequest         bool                         `json:"supportsTerminateRequest"`
+	ExceptionBreakpointFilters       []exceptionBreakpointsFilter `json:"exceptionBreakpointsFilters"`
+}
+
+type exceptionBreakpointsFilter struct {
+	Filter  string `json:"filter"`
+	Label   string `json:"label"`
+	Default bool   `json:"default"`
+}
+
+type source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type breakpoint struct {
+	Verified bool `json:"verified"`
+	Line     int  `json:"line"`
+}
+
+type thread struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type stackFrame struct {
+	ID               int     `json:"id"`
+	Name             string  `json:"name"`
+	Source           *source `json:"source,omitempty"`
+	Line             int     `json:"line"`
+	Column           int     `json:"column"`
+	PresentationHint string  `json:"presentationHint,omitempty"`
+}
+
+type scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type stoppedEvent struct {
+	Reason            string `json:"reason"`
+	Description       string `json:"description,omitempty"`
+	ThreadID          int    `json:"threadId"`
+	AllThreadsStopped bool   `json:"allThreadsStopped"`
+	Text              string `json:"text,omitempty"`
+}
+
+type outputEvent struct {
+	Category string `json:"category"`
+	Output   string `json:"output"`
+}
+
+// A conn reads requests from and writes responses and events to a
+// pair of streams, such as stdin and stdout.
+type conn struct {
+	in *bufio.Reader
+
+	mu  sync.Mutex // guards out and seq
+	out io.Writer
+	seq int
+}
+
+func newConn(in io.Reader, out io.Writer) *conn {
+	return &conn{in: bufio.NewReader(in), out: out}
+}
+
+// read reads the next request.
+func (c *conn) read() (*request, error) {
+	length := -1
+	for {
+		line, err := c.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break // end of header
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid Content-Length: %s", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message has no Content-Length")
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.in, data); err != nil {
+		return nil, err
+	}
+	req := new(request)
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, fmt.Errorf("invalid message: %v", err)
+	}
+	return req, nil
+}
+
+// respond sends a successful response to req.
+func (c *conn) respond(req *request, body interface{}) error {
+	return c.write(&response{Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+}
+
+// fail sends an error response to req.
+func (c *conn) fail(req *request, err error) error {
+	return c.write(&response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: err.Error()})
+}
+
+// event sends an event.
+func (c *conn) event(name string, body interface{}) error {
+	return c.write(&event{Type: "event", Event: name, Body: body})
+}
+
+func (c *conn) write(msg interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	switch msg := msg.(type) {
+	case *response:
+		msg.Seq = c.seq
+	case *event:
+		msg.Seq = c.seq
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.out, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+	_, err = c.out.Write(data)
+	return err
+}
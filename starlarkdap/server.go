@@ -0,0 +1,633 @@
+// Package starlarkdap implements a debugger for Starlark programs
+// that is driven by the Debug Adapter Protocol (DAP), so that editors
+// such as VS Code can set breakpoints, step through the program, and
+// inspect its variables.
+//
+// A Server debugs a single program, executed by a single thread.
+// It supports breakpoints by file and line, stepping in, over, and out
+// of function calls, pausing, stopping on errors, and inspection of
+// the local and global variables of each frame of the call stack.
+//
+// The server uses the starlark.Debugger interface, which disables
+// memoization of function calls while the program is being debugged.
+package starlarkdap // import "go.starlark.net/starlarkdap"
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// The DAP identifier of the program's only thread.
+const threadID = 1
+
+// The filter of exception breakpoints that stops on every error.
+const errorFilter = "error"
+
+// maxChildren is the maximum number of elements of a value
+// shown as its children in the variables view.
+const maxChildren = 1000
+
+// A Server is a Debug Adapter Protocol server for a debugging
+// session of one Starlark program.
+type Server struct {
+	// Program is the name of the file to execute if the launch
+	// request does not specify one.
+	Program string
+
+	// Options are the file options for the program.
+	// If nil, the default options are used.
+	Options *syntax.FileOptions
+
+	// Predeclared is the predeclared environment of the program.
+	Predeclared starlark.StringDict
+
+	// Load is the thread's module loader, if any (see starlark.Thread).
+	Load func(thread *starlark.Thread, module string) (starlark.StringDict, error)
+
+	conn *conn
+
+	mu          sync.Mutex
+	breakpoints map[string]map[int]bool // lines of breakpoints, by absolute file name
+	abs         map[string]string       // cache of absolute file names
+	stopOnError bool                    // stop when the program raises an error
+	launch      *launchArguments        // arguments of the launch request
+	configured  bool                    // configurationDone received
+	thread      *starlark.Thread        // the thread executing the program, once started
+	done        chan struct{}           // closed when the program finishes
+	terminating bool                    // the client asked to end the session
+
+	// Execution state.
+	step      stepMode      // how to continue after resuming
+	stepDepth int           // call stack depth at the time of the step request
+	pause     bool          // the client requested a pause
+	stopped   bool          // the program is suspended in the Debugger
+	resume    chan struct{} // closed to resume a suspended program
+	lastError error         // the error that caused the current stop, if any
+	vars      []interface{} // referents of variablesReferences while stopped
+}
+
+type stepMode int
+
+const (
+	run      stepMode = iota // run until a breakpoint
+	stepIn                   // stop at the next line
+	stepOver                 // stop at the next line of the same or a calling function
+	stepOut                  // stop at the next line of a calling function
+	entry                    // stop at the first line
+)
+
+// NewServer returns a new server that reads requests from in and
+// writes responses and events to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		conn:        newConn(in, out),
+		breakpoints: make(map[string]map[int]bool),
+		abs:         make(map[string]string),
+		done:        make(chan struct{}),
+	}
+}
+
+// Serve serves requests until the client ends the session or closes
+// the input stream. It reports whether the program ran to completion
+// without error.
+func (s *Server) Serve() (ok bool, err error) {
+	for {
+		req, err := s.conn.read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return false, err
+		}
+		end, err := s.handle(req)
+		if err != nil {
+			if err := s.conn.fail(req, err); err != nil {
+				return false, err
+			}
+		}
+		if end {
+			break
+		}
+	}
+
+	// End the program, if it is running.
+	s.mu.Lock()
+	s.terminating = true
+	thread := s.thread
+	if thread != nil {
+		thread.Cancel("debugging session ended")
+	}
+	s.resumeLocked(run)
+	s.mu.Unlock()
+	if thread == nil {
+		return false, nil
+	}
+	<-s.done
+	return s.lastError == nil, nil
+}
+
+// handle handles a request. It returns an error to report to the
+// client, and whether the session has ended.
+func (s *Server) handle(req *request) (end bool, err error) {
+	switch req.Command {
+	case "initialize":
+		err := s.conn.respond(req, &capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsEvaluateForHovers:        true,
+			SupportsExceptionInfoRequest:     true,
+			SupportsTerminateRequest:         true,
+			ExceptionBreakpointFilters: []exceptionBreakpointsFilter{
+				{Filter: errorFilter, Label: "Errors", Default: true},
+			},
+		})
+		if err != nil {
+			return false, err
+		}
+		s.mu.Lock()
+		s.stopOnError = true
+		s.mu.Unlock()
+		return false, s.conn.event("initialized", nil)
+
+	case "launch":
+		args := new(launchArguments)
+		if err := unmarshal(req, args); err != nil {
+			return false, err
+		}
+		if args.Program == "" {
+			args.Program = s.Program
+		}
+		if args.Program == "" {
+			return false, fmt.Errorf("no program to debug")
+		}
+		s.mu.Lock()
+		s.launch = args
+		s.mu.Unlock()
+		if err := s.conn.respond(req, nil); err != nil {
+			return false, err
+		}
+		s.maybeStart()
+		return false, nil
+
+	case "configurationDone":
+		s.mu.Lock()
+		s.configured = true
+		s.mu.Unlock()
+		if err := s.conn.respond(req, nil); err != nil {
+			return false, err
+		}
+		s.maybeStart()
+		return false, nil
+
+	case "setBreakpoints":
+		args := new(setBreakpointsArguments)
+		if err := unmarshal(req, args); err != nil {
+			return false, err
+		}
+		lines := make(map[int]bool)
+		breakpoints := []breakpoint{}
+		for _, b := range args.Breakpoints {
+			lines[b.Line] = true
+			breakpoints = append(breakpoints, breakpoint{Verified: true, Line: b.Line})
+		}
+		s.mu.Lock()
+		s.breakpoints[s.absLocked(args.Source.Path)] = lines
+		s.mu.Unlock()
+		return false, s.conn.respond(req, map[string]interface{}{"breakpoints": breakpoints})
+
+	case "setExceptionBreakpoints":
+		args := new(setExceptionBreakpointsArguments)
+		if err := unmarshal(req, args); err != nil {
+			return false, err
+		}
+		s.mu.Lock()
+		s.stopOnError = false
+		for _, filter := range args.Filters {
+			if filter == errorFilter {
+				s.stopOnError = true
+			}
+		}
+		s.mu.Unlock()
+		return false, s.conn.respond(req, nil)
+
+	case "threads":
+		return false, s.conn.respond(req, map[string]interface{}{
+			"threads": []thread{{ID: threadID, Name: "main"}},
+		})
+
+	case "stackTrace":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.stopped {
+			return false, errNotStopped
+		}
+		return false, s.conn.respond(req, s.stackTraceLocked())
+
+	case "scopes":
+		args := new(frameArguments)
+		if err := unmarshal(req, args); err != nil {
+			return false, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		fr, err := s.frameLocked(args.FrameID)
+		if err != nil {
+			return false, err
+		}
+		scopes := []scope{}
+		if _, ok := fr.Callable().(*starlark.Function); ok {
+			scopes = append(scopes,
+				scope{Name: "Locals", VariablesReference: s.refLocked(fr)},
+				scope{Name: "Globals", VariablesReference: s.refLocked(fr.Globals())})
+		}
+		return false, s.conn.respond(req, map[string]interface{}{"scopes": scopes})
+
+	case "variables":
+		args := new(variablesArguments)
+		if err := unmarshal(req, args); err != nil {
+			return false, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.stopped {
+			return false, errNotStopped
+		}
+		i := args.VariablesReference - 1
+		if i < 0 || i >= len(s.vars) {
+			return false, fmt.Errorf("invalid variables reference %d", args.VariablesReference)
+		}
+		return false, s.conn.respond(req, map[string]interface{}{"variables": s.variablesLocked(s.vars[i])})
+
+	case "evaluate":
+		args := new(evaluateArguments)
+		if err := unmarshal(req, args); err != nil {
+			return false, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		v, err := s.evaluateLocked(args)
+		if err != nil {
+			return false, err
+		}
+		return false, s.conn.respond(req, map[string]interface{}{
+			"result":             v.String(),
+			"type":               v.Type(),
+			"variablesReference": s.valueRefLocked(v),
+		})
+
+	case "exceptionInfo":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.stopped || s.lastError == nil {
+			return false, fmt.Errorf("not stopped at an error")
+		}
+		return false, s.conn.respond(req, map[string]interface{}{
+			"exceptionId": "error",
+			"description": s.lastError.Error(),
+			"breakMode":   "always",
+		})
+
+	case "continue", "next", "stepIn", "stepOut":
+		mode := map[string]stepMode{"continue": run, "next": stepOver, "stepIn": stepIn, "stepOut": stepOut}[req.Command]
+		s.mu.Lock()
+		ok := s.resumeLocked(mode)
+		s.mu.Unlock()
+		if !ok {
+			return false, errNotStopped
+		}
+		var body interface{}
+		if req.Command == "continue" {
+			body = map[string]interface{}{"allThreadsContinued": true}
+		}
+		return false, s.conn.respond(req, body)
+
+	case "pause":
+		s.mu.Lock()
+		s.pause = true
+		s.mu.Unlock()
+		return false, s.conn.respond(req, nil)
+
+	case "terminate":
+		s.mu.Lock()
+		s.terminating = true
+		if s.thread != nil {
+			s.thread.Cancel("terminated by debugger")
+		}
+		s.resumeLocked(run)
+		s.mu.Unlock()
+		return false, s.conn.respond(req, nil)
+
+	case "disconnect":
+		return true, s.conn.respond(req, nil)
+	}
+	return false, fmt.Errorf("unsupported request %q", req.Command)
+}
+
+var errNotStopped = errors.New("the program is not stopped")
+
+func unmarshal(req *request, args interface{}) error {
+	if len(req.Arguments) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(req.Arguments, args); err != nil {
+		return fmt.Errorf("invalid arguments to %s: %v", req.Command, err)
+	}
+	return nil
+}
+
+// maybeStart starts the program once it has been launched and configured.
+func (s *Server) maybeStart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.launch == nil || !s.configured || s.thread != nil {
+		return
+	}
+	args := s.launch
+	thread := &starlark.Thread{
+		Name: "main",
+		Load: s.Load,
+		Print: func(_ *starlark.Thread, msg string) {
+			s.conn.event("output", &outputEvent{Category: "stdout", Output: msg + "\n"})
+		},
+	}
+	if !args.NoDebug {
+		thread.SetDebugger((*debugger)(s))
+	}
+	if args.StopOnEntry {
+		s.step = entry
+	}
+	s.thread = thread
+
+	opts := s.Options
+	if opts == nil {
+		opts = &syntax.FileOptions{}
+	}
+	filename := s.absLocked(args.Program)
+	go func() {
+		defer close(s.done)
+		_, err := starlark.ExecFileOptions(opts, thread, filename, nil, s.Predeclared)
+
+		s.mu.Lock()
+		s.lastError = err
+		terminating := s.terminating
+		s.mu.Unlock()
+
+		exitCode := 0
+		if err != nil {
+			exitCode = 1
+			if !terminating {
+				msg := err.Error()
+				if evalErr, ok := err.(*starlark.EvalError); ok {
+					msg = evalErr.Backtrace()
+				}
+				s.conn.event("output", &outputEvent{Category: "stderr", Output: msg + "\n"})
+			}
+		}
+		s.conn.event("exited", map[string]interface{}{"exitCode": exitCode})
+		s.conn.event("terminated", nil)
+	}()
+}
+
+// absLocked returns the absolute form of a file name.
+func (s *Server) absLocked(filename string) string {
+	abs, ok := s.abs[filename]
+	if !ok {
+		abs = filename
+		if x, err := filepath.Abs(filename); err == nil {
+			abs = x
+		}
+		s.abs[filename] = abs
+	}
+	return abs
+}
+
+// A debugger is the starlark.Debugger interface of a Server.
+// Its methods are called on the program's goroutine.
+type debugger Server
+
+func (d *debugger) Line(thread *starlark.Thread) {
+	s := (*Server)(d)
+	s.mu.Lock()
+	depth := thread.CallStackDepth()
+	reason := ""
+	switch {
+	case s.terminating:
+	case s.pause:
+		reason = "pause"
+	case s.step == entry:
+		reason = "entry"
+	case s.step == stepIn,
+		s.step == stepOver && depth <= s.stepDepth,
+		s.step == stepOut && depth < s.stepDepth:
+		reason = "step"
+	default:
+		pos := thread.DebugFrame(0).Position()
+		if s.breakpoints[s.absLocked(pos.Filename())][int(pos.Line)] {
+			reason = "breakpoint"
+		}
+	}
+	if reason == "" {
+		s.mu.Unlock()
+		return
+	}
+	s.stopLocked(&stoppedEvent{Reason: reason})
+}
+
+func (d *debugger) Exception(thread *starlark.Thread, err error) {
+	s := (*Server)(d)
+	s.mu.Lock()
+	if s.terminating || !s.stopOnError {
+		s.mu.Unlock()
+		return
+	}
+	s.lastError = err
+	s.stopLocked(&stoppedEvent{Reason: "exception", Description: "Error", Text: err.Error()})
+}
+
+// stopLocked suspends the program until the client resumes it.
+// It is called with s.mu held, and releases it.
+func (s *Server) stopLocked(ev *stoppedEvent) {
+	resume := make(chan struct{})
+	s.stopped = true
+	s.resume = resume
+	s.pause = false
+	s.stepDepth = s.thread.CallStackDepth()
+	s.vars = nil
+	ev.ThreadID = threadID
+	ev.AllThreadsStopped = true
+	s.conn.event("stopped", ev)
+	s.mu.Unlock()
+
+	<-resume
+}
+
+// resumeLocked resumes a suspended program, and reports whether it was suspended.
+func (s *Server) resumeLocked(mode stepMode) bool {
+	if !s.stopped {
+		return false
+	}
+	s.stopped = false
+	s.step = mode
+	s.lastError = nil
+	s.vars = nil
+	close(s.resume)
+	return true
+}
+
+func (s *Server) stackTraceLocked() interface{} {
+	frames := []stackFrame{}
+	for depth := 0; depth < s.thread.CallStackDepth(); depth++ {
+		fr := s.thread.DebugFrame(depth)
+		pos := fr.Position()
+		sf := stackFrame{ID: depth + 1, Name: fr.Callable().Name()}
+		if _, ok := fr.Callable().(*starlark.Function); ok {
+			path := s.absLocked(pos.Filename())
+			sf.Source = &source{Name: filepath.Base(path), Path: path}
+			sf.Line = int(pos.Line)
+			sf.Column = int(pos.Col)
+		} else {
+			sf.PresentationHint = "subtle" // a built-in function
+		}
+		frames = append(frames, sf)
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+// frameLocked returns the frame with the specified DAP identifier.
+func (s *Server) frameLocked(id int) (starlark.DebugFrame, error) {
+	if !s.stopped {
+		return nil, errNotStopped
+	}
+	if id < 1 || id > s.thread.CallStackDepth() {
+		return nil, fmt.Errorf("invalid frame %d", id)
+	}
+	return s.thread.DebugFrame(id - 1), nil
+}
+
+// refLocked returns a new variablesReference for x, which is a
+// DebugFrame (for its locals), a StringDict, or a Value.
+func (s *Server) refLocked(x interface{}) int {
+	s.vars = append(s.vars, x)
+	return len(s.vars)
+}
+
+// valueRefLocked returns a variablesReference for the children of v,
+// or zero if it has none.
+func (s *Server) valueRefLocked(v starlark.Value) int {
+	if len(children(v, 1)) == 0 {
+		return 0
+	}
+	return s.refLocked(v)
+}
+
+func (s *Server) variablesLocked(x interface{}) []variable {
+	vars := []variable{}
+	add := func(name string, v starlark.Value) {
+		vars = append(vars, variable{
+			Name:               name,
+			Value:              v.String(),
+			Type:               v.Type(),
+			VariablesReference: s.valueRefLocked(v),
+		})
+	}
+	switch x := x.(type) {
+	case starlark.DebugFrame:
+		for i := 0; i < x.NumLocals(); i++ {
+			if b, v := x.Local(i); v != nil {
+				add(b.Name, v)
+			}
+		}
+	case starlark.StringDict:
+		for _, name := range x.Keys() {
+			add(name, x[name])
+		}
+	case starlark.Value:
+		for _, child := range children(x, maxChildren) {
+			add(child.name, child.value)
+		}
+	}
+	return vars
+}
+
+type child struct {
+	name  string
+	value starlark.Value
+}
+
+// children returns at most max named components of v:
+// the elements of a sequence, the entries of a mapping,
+// or the fields of a value with attributes.
+func children(v starlark.Value, max int) []child {
+	var children []child
+	switch v := v.(type) {
+	case starlark.IterableMapping:
+		for _, item := range v.Items() {
+			if len(children) == max {
+				break
+			}
+			children = append(children, child{item[0].String(), item[1]})
+		}
+	case starlark.Iterable:
+		iter := v.Iterate()
+		defer iter.Done()
+		var x starlark.Value
+		for i := 0; len(children) < max && iter.Next(&x); i++ {
+			children = append(children, child{fmt.Sprintf("[%d]", i), x})
+		}
+	case starlark.HasAttrs:
+		names := v.AttrNames()
+		sort.Strings(names)
+		for _, name := range names {
+			if len(children) == max {
+				break
+			}
+			// Omit methods and failing attributes.
+			if x, err := v.Attr(name); err == nil && x != nil {
+				if _, ok := x.(*starlark.Builtin); !ok {
+					children = append(children, child{name, x})
+				}
+			}
+		}
+	}
+	return children
+}
+
+// evaluateLocked evaluates an expression in the environment
+// of the specified frame, or of the innermost frame.
+func (s *Server) evaluateLocked(args *evaluateArguments) (starlark.Value, error) {
+	id := args.FrameID
+	if id == 0 {
+		id = 1
+	}
+	fr, err := s.frameLocked(id)
+	if err != nil {
+		return nil, err
+	}
+	env := make(starlark.StringDict)
+	for name, v := range s.Predeclared {
+		env[name] = v
+	}
+	for name, v := range fr.Globals() {
+		env[name] = v
+	}
+	if _, ok := fr.Callable().(*starlark.Function); ok {
+		for i := 0; i < fr.NumLocals(); i++ {
+			if b, v := fr.Local(i); v != nil {
+				env[b.Name] = v
+			}
+		}
+	}
+	opts := s.Options
+	if opts == nil {
+		opts = &syntax.FileOptions{}
+	}
+	// Use a separate thread, as the program's thread is suspended.
+	thread := &starlark.Thread{Name: "evaluate"}
+	return starlark.EvalOptions(opts, thread, "<evaluate>", args.Expression, env)
+}
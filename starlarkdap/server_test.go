@@ -0,0 +1,222 @@
+package starlarkdap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const program = `
+def f(x):
+    y = x + 1
+    return y
+
+a = f(1)
+print(a)
+b = a // 0
+`
+
+// A client is the test's end of a debugging session.
+type client struct {
+	t      *testing.T
+	in     *bufio.Reader
+	out    io.Writer
+	seq    int
+	output []string // output events received so far
+}
+
+// A message is a response or event received by the client.
+type message struct {
+	Type       string          `json:"type"`
+	Command    string          `json:"command"`
+	Event      string          `json:"event"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// call sends a request and returns the body of its response,
+// ignoring any events received meanwhile other than output.
+func (c *client) call(command string, args interface{}) json.RawMessage {
+	c.t.Helper()
+	c.seq++
+	data, err := json.Marshal(map[string]interface{}{
+		"seq":       c.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": args,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(c.out, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		c.t.Fatal(err)
+	}
+	msg := c.await(func(msg *message) bool { return msg.Type == "response" && msg.RequestSeq == c.seq })
+	if !msg.Success {
+		c.t.Fatalf("%s failed: %s", command, msg.Message)
+	}
+	return msg.Body
+}
+
+// awaitEvent returns the body of the next event with the specified name.
+func (c *client) awaitEvent(name string) json.RawMessage {
+	c.t.Helper()
+	return c.await(func(msg *message) bool { return msg.Type == "event" && msg.Event == name }).Body
+}
+
+func (c *client) await(match func(msg *message) bool) *message {
+	c.t.Helper()
+	for {
+		length := -1
+		for {
+			line, err := c.in.ReadString('\n')
+			if err != nil {
+				c.t.Fatal(err)
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				break
+			}
+			fmt.Sscanf(line, "Content-Length: %d", &length)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(c.in, data); err != nil {
+			c.t.Fatal(err)
+		}
+		msg := new(message)
+		if err := json.Unmarshal(data, msg); err != nil {
+			c.t.Fatal(err)
+		}
+		if msg.Type == "event" && msg.Event == "output" {
+			var body outputEvent
+			json.Unmarshal(msg.Body, &body)
+			c.output = append(c.output, body.Category+": "+body.Output)
+		}
+		if match(msg) {
+			return msg
+		}
+	}
+}
+
+func decode(t *testing.T, data json.RawMessage, x interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(data, x); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "prog.star")
+	if err := os.WriteFile(filename, []byte(program), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	server := NewServer(serverIn, serverOut)
+	type result struct {
+		ok  bool
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ok, err := server.Serve()
+		serverOut.Close()
+		done <- result{ok, err}
+	}()
+	c := &client{t: t, in: bufio.NewReader(clientIn), out: clientOut}
+
+	c.call("initialize", map[string]interface{}{"adapterID": "starlark"})
+	c.awaitEvent("initialized")
+	c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": filename},
+		"breakpoints": []map[string]int{{"line": 3}},
+	})
+	c.call("launch", map[string]interface{}{"program": filename})
+	c.call("configurationDone", nil)
+
+	// stopped returns the reason of the next stop,
+	// and a description of the call stack.
+	stopped := func() (string, string) {
+		t.Helper()
+		var ev stoppedEvent
+		decode(t, c.awaitEvent("stopped"), &ev)
+		var trace struct{ StackFrames []stackFrame }
+		decode(t, c.call("stackTrace", map[string]int{"threadId": threadID}), &trace)
+		var frames []string
+		for _, fr := range trace.StackFrames {
+			frames = append(frames, fmt.Sprintf("%s:%d", fr.Name, fr.Line))
+		}
+		return ev.Reason, strings.Join(frames, " ")
+	}
+	// locals returns a description of the local variables of the innermost frame.
+	locals := func() string {
+		t.Helper()
+		var scopes struct{ Scopes []scope }
+		decode(t, c.call("scopes", map[string]int{"frameId": 1}), &scopes)
+		if len(scopes.Scopes) != 2 {
+			t.Fatalf("got scopes %v, want Locals and Globals", scopes.Scopes)
+		}
+		var vars struct{ Variables []variable }
+		decode(t, c.call("variables", map[string]int{"variablesReference": scopes.Scopes[0].VariablesReference}), &vars)
+		var s []string
+		for _, v := range vars.Variables {
+			s = append(s, v.Name+"="+v.Value)
+		}
+		return strings.Join(s, " ")
+	}
+
+	if reason, stack := stopped(); reason != "breakpoint" || stack != "f:3 <toplevel>:6" {
+		t.Errorf("first stop: got %s at %s, want breakpoint at f:3 <toplevel>:6", reason, stack)
+	}
+	if got := locals(); got != "x=1" {
+		t.Errorf("locals at breakpoint: got %s, want x=1", got)
+	}
+
+	c.call("next", map[string]int{"threadId": threadID})
+	if reason, stack := stopped(); reason != "step" || stack != "f:4 <toplevel>:6" {
+		t.Errorf("after next: got %s at %s, want step at f:4 <toplevel>:6", reason, stack)
+	}
+	if got := locals(); got != "x=1 y=2" {
+		t.Errorf("locals after next: got %s, want x=1 y=2", got)
+	}
+	var eval struct{ Result string }
+	decode(t, c.call("evaluate", map[string]interface{}{"expression": "[x, y, x * y]", "frameId": 1}), &eval)
+	if eval.Result != "[1, 2, 2]" {
+		t.Errorf("evaluate: got %s, want [1, 2, 2]", eval.Result)
+	}
+
+	c.call("continue", map[string]int{"threadId": threadID})
+	if reason, stack := stopped(); reason != "exception" || stack != "<toplevel>:8" {
+		t.Errorf("after continue: got %s at %s, want exception at <toplevel>:8", reason, stack)
+	}
+	var info struct{ Description string }
+	decode(t, c.call("exceptionInfo", map[string]int{"threadId": threadID}), &info)
+	if info.Description != "floored division by zero" {
+		t.Errorf("exceptionInfo: got %q", info.Description)
+	}
+
+	c.call("continue", map[string]int{"threadId": threadID})
+	var exited struct{ ExitCode int }
+	decode(t, c.awaitEvent("exited"), &exited)
+	if exited.ExitCode != 1 {
+		t.Errorf("got exit code %d, want 1", exited.ExitCode)
+	}
+	c.awaitEvent("terminated")
+	if len(c.output) != 2 || c.output[0] != "stdout: 2\n" || !strings.HasPrefix(c.output[1], "stderr: Traceback") {
+		t.Errorf("got output %q, want print output and traceback", c.output)
+	}
+
+	c.call("disconnect", nil)
+	clientOut.Close()
+	if r := <-done; r.ok || r.err != nil {
+		t.Errorf("Serve returned (%t, %v), want (false, nil)", r.ok, r.err)
+	}
+}
@@ -0,0 +1,845 @@
+// Package starlarklsp implements a language server for Starlark,
+// driven by the Language Server Protocol (LSP), so that editors such
+// as VS Code can navigate and check Starlark files as they are edited.
+//
+// The server parses each open file, recovering from syntax errors,
+// and resolves it against a predeclared environment supplied by the
+// host application. It reports syntax and resolver errors as
+// diagnostics, and uses the resolver's bindings of identifiers to
+// provide go-to-definition, references within a file, hover
+// information including the docstrings of functions, completion of
+// names in scope and of the names a load statement may import, and
+// the outline of each file.
+//
+// The server works offline: it never executes Starlark code, and
+// reads only the files named by load statements.
+package starlarklsp // import "go.starlark.net/starlarklsp"
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+	"go.starlark.net/typecheck"
+)
+
+// maxLoadDepth is the maximum length of a chain of load statements
+// that the server follows to find the definition of a loaded name.
+const maxLoadDepth = 10
+
+// A Server is a Language Server Protocol server for Starlark files.
+type Server struct {
+	// Options are the file options of the files.
+	// If nil, the default options are used.
+	Options *syntax.FileOptions
+
+	// Predeclared is the predeclared environment of the files.
+	// Only the names and types of its values are used.
+	Predeclared starlark.StringDict
+
+	// ModuleFile returns the name of the file of the module loaded
+	// by the load statement load(module, ...) in the file from.
+	// If nil, the module name is interpreted as a file name
+	// relative to the directory of the loading file.
+	ModuleFile func(module, from string) string
+
+	conn     *conn
+	docs     map[string]*document // open documents, by URI
+	shutdown bool                 // shutdown request received
+}
+
+// NewServer returns a server that reads requests from in
+// and writes responses and notifications to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		conn: newConn(in, out),
+		docs: make(map[string]*document),
+	}
+}
+
+// Serve handles requests until the client sends the exit notification
+// or closes the connection. It returns an error if the connection fails,
+// or if the client exits without first requesting a shutdown.
+func (s *Server) Serve() error {
+	for {
+		req, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit without shutdown")
+			}
+			return nil
+		}
+		result, err := s.handle(req)
+		if req.ID == nil {
+			continue // a notification has no response
+		}
+		if err := s.conn.reply(req, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+// handle handles a request or notification.
+// It returns the result, or an error to report to the client.
+func (s *Server) handle(req *message) (interface{}, error) {
+	switch req.Method {
+	case "initialize":
+		var result initializeResult
+		result.Capabilities = serverCapabilities{
+			TextDocumentSync:       1,
+			DefinitionProvider:     true,
+			ReferencesProvider:     true,
+			HoverProvider:          true,
+			DocumentSymbolProvider: true,
+			CompletionProvider:     map[string]interface{}{},
+		}
+		result.ServerInfo.Name = "starlark-lsp"
+		return &result, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := decode(req, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Version, params.TextDocument.Text)
+
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := decode(req, &params); err != nil {
+			return nil, err
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			// With full synchronization, the last change is the whole text.
+			return nil, s.update(params.TextDocument.URI, params.TextDocument.Version, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := decode(req, &params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, s.conn.notify("textDocument/publishDiagnostics", &publishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []diagnostic{},
+		})
+
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := decode(req, &params); err != nil {
+			return nil, err
+		}
+		doc, id, err := s.identAt(params)
+		if err != nil || id == nil {
+			return nil, err
+		}
+		if doc, def := s.definition(doc, id, 0); def != nil {
+			return &location{URI: doc.uri, Range: doc.identRange(def)}, nil
+		}
+		return nil, nil
+
+	case "textDocument/references":
+		var params referenceParams
+		if err := decode(req, &params); err != nil {
+			return nil, err
+		}
+		doc, id, err := s.identAt(params.textDocumentPositionParams)
+		if err != nil || id == nil {
+			return nil, err
+		}
+		return doc.references(id, params.Context.IncludeDeclaration), nil
+
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := decode(req, &params); err != nil {
+			return nil, err
+		}
+		doc, id, err := s.identAt(params)
+		if err != nil || id == nil {
+			return nil, err
+		}
+		text := s.describe(doc, id)
+		if text == "" {
+			return nil, nil
+		}
+		return &hover{
+			Contents: markupContent{Kind: "markdown", Value: text},
+			Range:    doc.identRange(id),
+		}, nil
+
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := decode(req, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return s.complete(doc, doc.position(params.Position)), nil
+
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		if err := decode(req, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		symbols := []documentSymbol{}
+		if doc.file != nil {
+			symbols = doc.symbols(doc.file.Stmts, true, symbols)
+		}
+		return symbols, nil
+
+	case "initialized", "$/cancelRequest", "$/setTrace", "workspace/didChangeConfiguration":
+		return nil, nil
+	}
+	return nil, &rpcError{codeMethodNotFound, fmt.Sprintf("unsupported method %s", req.Method)}
+}
+
+// decode decodes the parameters of a request.
+func decode(req *message, params interface{}) error {
+	if err := json.Unmarshal(req.Params, params); err != nil {
+		return &rpcError{codeInvalidParams, fmt.Sprintf("invalid parameters for %s: %v", req.Method, err)}
+	}
+	return nil
+}
+
+// update analyzes the new text of a document and publishes its diagnostics.
+func (s *Server) update(uri string, version int, text string) error {
+	doc := s.analyze(uri, uriToFilename(uri), text)
+	doc.version = version
+	s.docs[uri] = doc
+	return s.conn.notify("textDocument/publishDiagnostics", &publishDiagnosticsParams{
+		URI:         uri,
+		Version:     version,
+		Diagnostics: doc.diagnostics,
+	})
+}
+
+// document returns the open document with the specified URI.
+func (s *Server) document(uri string) (*document, error) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, &rpcError{codeInvalidParams, fmt.Sprintf("document %s is not open", uri)}
+	}
+	return doc, nil
+}
+
+// identAt returns the document and the identifier at a position, if any.
+func (s *Server) identAt(params textDocumentPositionParams) (*document, *syntax.Ident, error) {
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, nil, err
+	}
+	if doc.file == nil {
+		return doc, nil, nil
+	}
+	pos := doc.position(params.Position)
+	var found *syntax.Ident
+	syntax.Walk(doc.file, func(n syntax.Node) bool {
+		if id, ok := n.(*syntax.Ident); ok && found == nil {
+			start, end := id.Span()
+			if start.Line == pos.Line && start.Col <= pos.Col && pos.Col <= end.Col {
+				found = id
+			}
+		}
+		return found == nil
+	})
+	return doc, found, nil
+}
+
+// analyze parses and resolves the text of a file.
+func (s *Server) analyze(uri, filename, text string) *document {
+	doc := &document{
+		uri:         uri,
+		filename:    filename,
+		lines:       strings.Split(text, "\n"),
+		defs:        make(map[*syntax.Ident]*syntax.DefStmt),
+		loads:       make(map[*syntax.Ident]loadName),
+		diagnostics: []diagnostic{},
+	}
+	opts := s.Options
+	if opts == nil {
+		opts = &syntax.FileOptions{}
+	}
+	f, err := opts.Parse(filename, text, syntax.RecoverErrors)
+	switch err := err.(type) {
+	case syntax.ErrorList:
+		for _, e := range err {
+			doc.report(e.Pos, e.Msg, "syntax")
+		}
+	case syntax.Error:
+		doc.report(err.Pos, err.Msg, "syntax")
+	case nil:
+	default:
+		doc.report(syntax.MakePosition(&filename, 1, 1), err.Error(), "syntax")
+	}
+	if f == nil {
+		return doc
+	}
+	doc.file = f
+	if err := resolve.File(f, s.Predeclared.Has, starlark.Universe.Has); err != nil {
+		for _, e := range err.(resolve.ErrorList) {
+			doc.report(e.Pos, e.Msg, "resolve")
+		}
+	}
+	if err := typecheck.File(f); err != nil {
+		for _, e := range err.(typecheck.ErrorList) {
+			doc.report(e.Pos, e.Msg, "typecheck")
+		}
+	}
+	syntax.Walk(f, func(n syntax.Node) bool {
+		switch n := n.(type) {
+		case *syntax.DefStmt:
+			doc.defs[n.Name] = n
+		case *syntax.LoadStmt:
+			for i := range n.To {
+				doc.loads[n.To[i]] = loadName{n, i}
+				doc.loads[n.From[i]] = loadName{n, i}
+			}
+		}
+		return true
+	})
+	return doc
+}
+
+// module returns the analyzed file of a module loaded by doc,
+// or nil if it cannot be read.
+func (s *Server) module(doc *document, module string) *document {
+	var filename string
+	if s.ModuleFile != nil {
+		filename = s.ModuleFile(module, doc.filename)
+	} else {
+		filename = filepath.Join(filepath.Dir(doc.filename), module)
+	}
+	uri := filenameToURI(filename)
+	if doc, ok := s.docs[uri]; ok {
+		return doc // prefer the text in the editor
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	return s.analyze(uri, filename, string(data))
+}
+
+// loaded returns the module that defines the name imported by a
+// load statement, and the identifier of its global definition there.
+func (s *Server) loaded(doc *document, load loadName, depth int) (*document, *syntax.Ident) {
+	mod := s.module(doc, load.stmt.Module.Value.(string))
+	if mod == nil || mod.file == nil {
+		return nil, nil
+	}
+	name := load.stmt.From[load.index].Name
+	for _, bind := range mod.file.Module.(*resolve.Module).Globals {
+		if bind.First.Name == name {
+			return s.definition(mod, bind.First, depth+1)
+		}
+	}
+	// A name loaded by the module itself (when loads are file-local).
+	for id, l := range mod.loads {
+		if id == l.stmt.To[l.index] && id.Name == name {
+			return s.definition(mod, id, depth+1)
+		}
+	}
+	return nil, nil
+}
+
+// definition returns the document and identifier of the definition
+// of the variable denoted by id, following load statements.
+func (s *Server) definition(doc *document, id *syntax.Ident, depth int) (*document, *syntax.Ident) {
+	if load, ok := doc.loads[id]; ok && depth < maxLoadDepth {
+		if mod, def := s.loaded(doc, load, depth); def != nil {
+			return mod, def
+		}
+	}
+	bind, _ := id.Binding.(*resolve.Binding)
+	if bind == nil || bind.First == nil {
+		return nil, nil
+	}
+	if load, ok := doc.loads[bind.First]; ok && depth < maxLoadDepth {
+		if mod, def := s.loaded(doc, load, depth); def != nil {
+			return mod, def
+		}
+	}
+	return doc, bind.First
+}
+
+// describe returns the Markdown description of an identifier:
+// its scope and name, or the header and docstring of a function,
+// followed by the module it is loaded from, if any.
+func (s *Server) describe(doc *document, id *syntax.Ident) string {
+	bind, _ := id.Binding.(*resolve.Binding)
+	load, loaded := doc.loads[id]
+	if !loaded && bind != nil && bind.First != nil {
+		load, loaded = doc.loads[bind.First]
+	}
+	if bind == nil && !loaded {
+		return ""
+	}
+
+	var buf strings.Builder
+	buf.WriteString("```python\n")
+	if mod, first := s.definition(doc, id, 0); first != nil && mod.defs[first] != nil {
+		def := mod.defs[first]
+		fmt.Fprintf(&buf, "%s\n```", mod.header(def))
+		if text := docstring(def); text != "" {
+			fmt.Fprintf(&buf, "\n\n%s", text)
+		}
+	} else {
+		scope := resolve.Global // of a name loaded from an unreadable module
+		if bind != nil {
+			scope = bind.Scope
+		}
+		fmt.Fprintf(&buf, "(%s) %s", scope, id.Name)
+		var v starlark.Value
+		switch scope {
+		case resolve.Predeclared:
+			v = s.Predeclared[id.Name]
+		case resolve.Universal:
+			v = starlark.Universe[id.Name]
+		}
+		if v != nil {
+			fmt.Fprintf(&buf, ": %s", v.Type())
+		}
+		buf.WriteString("\n```")
+	}
+	if loaded {
+		fmt.Fprintf(&buf, "\n\nLoaded from `%s`.", load.stmt.Module.Value)
+	}
+	return buf.String()
+}
+
+// complete returns the completions at a position:
+// the names in scope, or within a load statement,
+// the names the loaded module defines.
+func (s *Server) complete(doc *document, pos syntax.Position) []completionItem {
+	items := make(map[string]completionItem)
+	add := func(name string, item completionItem) {
+		if _, ok := items[name]; !ok && name != "" {
+			item.Label = name
+			items[name] = item
+		}
+	}
+	if doc.file != nil {
+		for _, stmt := range doc.file.Stmts {
+			load, ok := stmt.(*syntax.LoadStmt)
+			if !ok || !contains(load, pos) {
+				continue
+			}
+			mod := s.module(doc, load.Module.Value.(string))
+			if mod == nil || mod.file == nil {
+				return []completionItem{}
+			}
+			for _, bind := range mod.file.Module.(*resolve.Module).Globals {
+				if !strings.HasPrefix(bind.First.Name, "_") {
+					add(bind.First.Name, mod.completion(bind.First))
+				}
+			}
+			return sortItems(items)
+		}
+
+		// Variables of enclosing functions, innermost first.
+		var funcs []*resolve.Function
+		syntax.Walk(doc.file, func(n syntax.Node) bool {
+			switch n := n.(type) {
+			case *syntax.DefStmt:
+				if !contains(n, pos) {
+					return false
+				}
+				if fn, ok := n.Function.(*resolve.Function); ok {
+					funcs = append(funcs, fn)
+				}
+			case *syntax.LambdaExpr:
+				if !contains(n, pos) {
+					return false
+				}
+				if fn, ok := n.Function.(*resolve.Function); ok {
+					funcs = append(funcs, fn)
+				}
+			}
+			return true
+		})
+		for i := len(funcs) - 1; i >= 0; i-- {
+			for _, bind := range funcs[i].Locals {
+				add(bind.First.Name, doc.completion(bind.First))
+			}
+			for _, bind := range funcs[i].FreeVars {
+				add(bind.First.Name, doc.completion(bind.First))
+			}
+		}
+
+		// Loaded names and globals.
+		module := doc.file.Module.(*resolve.Module)
+		for _, bind := range module.Locals {
+			if _, ok := doc.loads[bind.First]; ok {
+				add(bind.First.Name, doc.completion(bind.First))
+			}
+		}
+		for _, bind := range module.Globals {
+			add(bind.First.Name, doc.completion(bind.First))
+		}
+	}
+	for _, env := range []starlark.StringDict{s.Predeclared, starlark.Universe} {
+		for name, v := range env {
+			kind := completionValue
+			if _, ok := v.(starlark.Callable); ok {
+				kind = completionFunction
+			} else if _, ok := v.(starlark.HasAttrs); ok {
+				kind = completionModule
+			}
+			add(name, completionItem{Kind: kind, Detail: v.Type()})
+		}
+	}
+	return sortItems(items)
+}
+
+func sortItems(items map[string]completionItem) []completionItem {
+	list := make([]completionItem, 0, len(items))
+	for _, item := range items {
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Label < list[j].Label })
+	return list
+}
+
+// contains reports whether a position lies within the span of a node.
+func contains(n syntax.Node, pos syntax.Position) bool {
+	if def, ok := n.(*syntax.DefStmt); ok && len(def.Body) == 0 {
+		return false
+	}
+	start, end := n.Span()
+	return !before(pos, start) && !before(end, pos)
+}
+
+// before reports whether p precedes q.
+func before(p, q syntax.Position) bool {
+	return p.Line < q.Line || p.Line == q.Line && p.Col < q.Col
+}
+
+// A loadName identifies a name imported by a load statement.
+type loadName struct {
+	stmt  *syntax.LoadStmt
+	index int // index of the name in stmt.From and stmt.To
+}
+
+// A document is an analyzed Starlark file.
+type document struct {
+	uri         string
+	filename    string
+	version     int
+	lines       []string
+	file        *syntax.File // the (partial) syntax tree, or nil
+	diagnostics []diagnostic
+	defs        map[*syntax.Ident]*syntax.DefStmt // function definitions, by name
+	loads       map[*syntax.Ident]loadName        // loaded names, by their From and To identifiers
+}
+
+// report records a diagnostic at a position.
+func (doc *document) report(pos syntax.Position, msg, source string) {
+	start := doc.lspPosition(pos)
+	end := start
+	end.Character++
+	doc.diagnostics = append(doc.diagnostics, diagnostic{
+		Range:    rng{Start: start, End: end},
+		Severity: 1,
+		Source:   source,
+		Message:  msg,
+	})
+}
+
+// references returns the locations in the document
+// of the identifiers that denote the same variable as id.
+func (doc *document) references(id *syntax.Ident, includeDeclaration bool) []location {
+	same := func(x *syntax.Ident) bool { return false }
+	if bind, ok := id.Binding.(*resolve.Binding); ok {
+		if bind.First != nil {
+			same = func(x *syntax.Ident) bool {
+				b, ok := x.Binding.(*resolve.Binding)
+				return ok && b.First == bind.First
+			}
+		} else {
+			same = func(x *syntax.Ident) bool {
+				b, ok := x.Binding.(*resolve.Binding)
+				return ok && b.First == nil && x.Name == id.Name
+			}
+		}
+	} else if load, ok := doc.loads[id]; ok {
+		to := load.stmt.To[load.index]
+		bind, _ := to.Binding.(*resolve.Binding)
+		if bind != nil && bind.First != nil {
+			return doc.references(bind.First, includeDeclaration)
+		}
+	}
+	locs := []location{}
+	seen := make(map[*syntax.Ident]bool)
+	syntax.Walk(doc.file, func(n syntax.Node) bool {
+		if x, ok := n.(*syntax.Ident); ok && !seen[x] && same(x) {
+			seen[x] = true
+			if includeDeclaration || x.Binding.(*resolve.Binding).First != x {
+				locs = append(locs, location{URI: doc.uri, Range: doc.identRange(x)})
+			}
+		}
+		return true
+	})
+	return locs
+}
+
+// completion returns the completion item for the variable defined by id.
+func (doc *document) completion(id *syntax.Ident) completionItem {
+	if def, ok := doc.defs[id]; ok {
+		item := completionItem{
+			Kind:   completionFunction,
+			Detail: doc.header(def),
+		}
+		if text := docstring(def); text != "" {
+			item.Documentation = &markupContent{Kind: "markdown", Value: text}
+		}
+		return item
+	}
+	if load, ok := doc.loads[id]; ok {
+		return completionItem{
+			Kind:   completionVariable,
+			Detail: fmt.Sprintf("loaded from %s", syntax.Quote(load.stmt.Module.Value.(string), false)),
+		}
+	}
+	return completionItem{Kind: completionVariable}
+}
+
+// symbols appends the symbols of the functions defined by stmts and,
+// if global, the global variables they assign, to list.
+func (doc *document) symbols(stmts []syntax.Stmt, global bool, list []documentSymbol) []documentSymbol {
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *syntax.DefStmt:
+			start, end := stmt.Span()
+			list = append(list, documentSymbol{
+				Name:           stmt.Name.Name,
+				Detail:         doc.header(stmt),
+				Kind:           symbolFunction,
+				Range:          rng{doc.lspPosition(start), doc.lspPosition(end)},
+				SelectionRange: doc.identRange(stmt.Name),
+				Children:       doc.symbols(stmt.Body, false, nil),
+			})
+		case *syntax.AssignStmt:
+			if !global {
+				continue
+			}
+			start, end := stmt.Span()
+			for _, id := range assigned(stmt.LHS, nil) {
+				if bind, ok := id.Binding.(*resolve.Binding); ok && bind.First == id {
+					list = append(list, documentSymbol{
+						Name:           id.Name,
+						Kind:           symbolVariable,
+						Range:          rng{doc.lspPosition(start), doc.lspPosition(end)},
+						SelectionRange: doc.identRange(id),
+					})
+				}
+			}
+		case *syntax.IfStmt:
+			list = doc.symbols(stmt.True, global, list)
+			list = doc.symbols(stmt.False, global, list)
+		case *syntax.ForStmt:
+			list = doc.symbols(stmt.Body, global, list)
+		case *syntax.WhileStmt:
+			list = doc.symbols(stmt.Body, global, list)
+		}
+	}
+	return list
+}
+
+// assigned appends the identifiers assigned by the left operand
+// of an assignment to ids.
+func assigned(lhs syntax.Expr, ids []*syntax.Ident) []*syntax.Ident {
+	switch lhs := lhs.(type) {
+	case *syntax.Ident:
+		ids = append(ids, lhs)
+	case *syntax.ParenExpr:
+		ids = assigned(lhs.X, ids)
+	case *syntax.ListExpr:
+		for _, x := range lhs.List {
+			ids = assigned(x, ids)
+		}
+	case *syntax.TupleExpr:
+		for _, x := range lhs.List {
+			ids = assigned(x, ids)
+		}
+	}
+	return ids
+}
+
+// header returns the text of the header of a function definition,
+// including any annotation of its result.
+func (doc *document) header(def *syntax.DefStmt) string {
+	end := def.Rparen
+	if def.ResultType != nil {
+		_, end = def.ResultType.Span()
+		end.Col-- // the last character of the annotation
+	}
+	return doc.source(def.Def, end)
+}
+
+// source returns the text of the document from start up to and
+// including the character at end, such as the header of a function.
+func (doc *document) source(start, end syntax.Position) string {
+	if start.Line < 1 || int(end.Line) > len(doc.lines) || end.Line < start.Line {
+		return ""
+	}
+	var buf strings.Builder
+	for line := start.Line; line <= end.Line; line++ {
+		text := doc.lines[line-1]
+		to := len(text)
+		if line == end.Line {
+			to = byteOffset(text, end.Col)
+		}
+		from := 0
+		if line == start.Line {
+			from = byteOffset(text, start.Col-1)
+		} else {
+			buf.WriteByte('\n')
+		}
+		if from <= to {
+			buf.WriteString(strings.TrimRight(text[from:to], "\r"))
+		}
+	}
+	return buf.String()
+}
+
+// docstring returns the docstring of a function,
+// with its indentation removed.
+func docstring(def *syntax.DefStmt) string {
+	if len(def.Body) == 0 {
+		return ""
+	}
+	stmt, ok := def.Body[0].(*syntax.ExprStmt)
+	if !ok {
+		return ""
+	}
+	lit, ok := stmt.X.(*syntax.Literal)
+	if !ok || lit.Token != syntax.STRING {
+		return ""
+	}
+	lines := strings.Split(strings.TrimSpace(lit.Value.(string)), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		if text := strings.TrimLeft(line, " \t"); text != "" {
+			if n := len(line) - len(text); indent < 0 || n < indent {
+				indent = n
+			}
+		}
+	}
+	for i := 1; i < len(lines); i++ {
+		if len(lines[i]) >= indent && indent > 0 {
+			lines[i] = lines[i][indent:]
+		} else {
+			lines[i] = strings.TrimLeft(lines[i], " \t")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// -- positions --
+
+// The Starlark scanner counts lines from 1 and columns in runes from 1,
+// whereas LSP counts both from 0, and columns in UTF-16 code units.
+
+// position converts an LSP position to a Starlark position.
+func (doc *document) position(p position) syntax.Position {
+	col := 1
+	if p.Line >= 0 && p.Line < len(doc.lines) {
+		units := 0
+		for _, r := range doc.lines[p.Line] {
+			if units >= p.Character {
+				break
+			}
+			units += utf16Len(r)
+			col++
+		}
+	}
+	return syntax.MakePosition(&doc.filename, int32(p.Line+1), int32(col))
+}
+
+// lspPosition converts a Starlark position to an LSP position.
+func (doc *document) lspPosition(pos syntax.Position) position {
+	p := position{Line: int(pos.Line) - 1}
+	if p.Line < 0 {
+		return position{}
+	}
+	if p.Line < len(doc.lines) {
+		col := int32(1)
+		for _, r := range doc.lines[p.Line] {
+			if col >= pos.Col {
+				break
+			}
+			p.Character += utf16Len(r)
+			col++
+		}
+	}
+	return p
+}
+
+// identRange returns the range of an identifier.
+func (doc *document) identRange(id *syntax.Ident) rng {
+	start, end := id.Span()
+	return rng{doc.lspPosition(start), doc.lspPosition(end)}
+}
+
+func utf16Len(r rune) int {
+	if utf16.IsSurrogate(r) || r < 0x10000 {
+		return 1
+	}
+	return 2
+}
+
+// byteOffset returns the offset in bytes of the first n runes of s.
+func byteOffset(s string, n int32) int {
+	i := 0
+	for ; n > 0 && i < len(s); n-- {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	return i
+}
+
+// -- URIs --
+
+// uriToFilename returns the name of the file denoted by a file URI,
+// or the URI itself if it does not denote a file.
+func uriToFilename(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// filenameToURI returns the file URI of a file name.
+func filenameToURI(filename string) string {
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(filename)}).String()
+}
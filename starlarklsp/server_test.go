@@ -0,0 +1,272 @@
+package starlarklsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+const lib = `
+def greet(name: str) -> str:
+    """Returns a greeting.
+
+    The greeting is polite.
+    """
+    return "hello " + name
+
+_private = 1
+`
+
+const program = `load("lib.star", "greet", hi = "greet")
+
+def f(x: int) -> str:
+    y = greet(x)
+    return y + host
+
+z = f("1") + undefined_name
+w = 1 +
+`
+
+// A client is the test's end of a session.
+type client struct {
+	t   *testing.T
+	in  *bufio.Reader
+	out io.Writer
+	id  int
+}
+
+// send sends a request, or a notification if id is zero.
+func (c *client) send(id int, method string, params interface{}) {
+	c.t.Helper()
+	msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+	if id != 0 {
+		msg["id"] = id
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(c.out, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// call sends a request and decodes the result of its response
+// into result, ignoring any notifications received meanwhile.
+func (c *client) call(method string, params, result interface{}) {
+	c.t.Helper()
+	c.id++
+	c.send(c.id, method, params)
+	for {
+		msg := c.read()
+		if msg.ID == nil || string(*msg.ID) != fmt.Sprint(c.id) {
+			continue
+		}
+		if msg.Error != nil {
+			c.t.Fatalf("%s failed: %s", method, msg.Error.Message)
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return
+	}
+}
+
+// awaitDiagnostics returns the next diagnostics received.
+func (c *client) awaitDiagnostics() []diagnostic {
+	c.t.Helper()
+	for {
+		msg := c.read()
+		if msg.Method == "textDocument/publishDiagnostics" {
+			var params publishDiagnosticsParams
+			if err := json.Unmarshal(msg.Params, &params); err != nil {
+				c.t.Fatal(err)
+			}
+			return params.Diagnostics
+		}
+	}
+}
+
+type clientMessage struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+	Result json.RawMessage  `json:"result"`
+	Error  *responseError   `json:"error"`
+}
+
+func (c *client) read() *clientMessage {
+	c.t.Helper()
+	length := -1
+	for {
+		line, err := c.in.ReadString('\n')
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		fmt.Sscanf(line, "Content-Length: %d", &length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.in, data); err != nil {
+		c.t.Fatal(err)
+	}
+	msg := new(clientMessage)
+	if err := json.Unmarshal(data, msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "lib.star"), []byte(lib), 0666); err != nil {
+		t.Fatal(err)
+	}
+	uri := filenameToURI(filepath.Join(dir, "main.star"))
+	libURI := filenameToURI(filepath.Join(dir, "lib.star"))
+
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	server := NewServer(serverIn, serverOut)
+	server.Predeclared = starlark.StringDict{"host": starlark.MakeInt(1)}
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve()
+		serverOut.Close()
+	}()
+	c := &client{t: t, in: bufio.NewReader(clientIn), out: clientOut}
+
+	c.call("initialize", map[string]interface{}{}, nil)
+	c.send(0, "initialized", map[string]interface{}{})
+	c.send(0, "textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "starlark", "version": 1, "text": program},
+	})
+	diagnostics := c.awaitDiagnostics()
+
+	// at returns the parameters of a request at a position in the program.
+	at := func(line, char int) map[string]interface{} {
+		return map[string]interface{}{
+			"textDocument": map[string]string{"uri": uri},
+			"position":     map[string]int{"line": line, "character": char},
+		}
+	}
+	// loc describes a location.
+	loc := func(l location) string {
+		file := "main"
+		if l.URI == libURI {
+			file = "lib"
+		}
+		return fmt.Sprintf("%s:%d:%d", file, l.Range.Start.Line, l.Range.Start.Character)
+	}
+
+	// definition
+	for _, test := range []struct {
+		line, char int
+		want       string
+	}{
+		{4, 11, "main:3:4"}, // y
+		{3, 14, "main:2:6"}, // x
+		{3, 8, "lib:1:4"},   // greet
+		{0, 20, "lib:1:4"},  // "greet" in load
+		{6, 4, "main:2:4"},  // f
+	} {
+		var got location
+		c.call("textDocument/definition", at(test.line, test.char), &got)
+		if loc(got) != test.want {
+			t.Errorf("definition at %d:%d: got %s, want %s", test.line, test.char, loc(got), test.want)
+		}
+	}
+
+	// diagnostics
+	var diags []string
+	for _, d := range diagnostics {
+		diags = append(diags, fmt.Sprintf("%d:%d: %s", d.Range.Start.Line, d.Range.Start.Character, d.Source))
+		if d.Source == "resolve" && d.Message != "undefined: undefined_name" {
+			t.Errorf("unexpected resolver error: %s", d.Message)
+		}
+	}
+	if got, want := strings.Join(diags, "; "), "7:7: syntax; 6:13: resolve; 6:6: typecheck"; got != want {
+		t.Errorf("diagnostics: got %s, want %s", got, want)
+	}
+
+	// references
+	params := at(2, 6) // x
+	params["context"] = map[string]bool{"includeDeclaration": true}
+	var refs []location
+	c.call("textDocument/references", params, &refs)
+	var got []string
+	for _, ref := range refs {
+		got = append(got, loc(ref))
+	}
+	if got, want := strings.Join(got, " "), "main:2:6 main:3:14"; got != want {
+		t.Errorf("references: got %s, want %s", got, want)
+	}
+
+	// hover
+	for _, test := range []struct {
+		line, char int
+		want       string
+	}{
+		{3, 9, "```python\ndef greet(name: str) -> str\n```\n\nReturns a greeting.\n\nThe greeting is polite.\n\nLoaded from `lib.star`."},
+		{6, 4, "```python\ndef f(x: int) -> str\n```"},
+		{4, 16, "```python\n(predeclared) host: int\n```"},
+		{3, 5, "```python\n(local) y\n```"},
+	} {
+		var h hover
+		c.call("textDocument/hover", at(test.line, test.char), &h)
+		if h.Contents.Value != test.want {
+			t.Errorf("hover at %d:%d: got %q, want %q", test.line, test.char, h.Contents.Value, test.want)
+		}
+	}
+
+	// completion
+	labels := func(line, char int) string {
+		var items []completionItem
+		c.call("textDocument/completion", at(line, char), &items)
+		var labels []string
+		for _, item := range items {
+			if starlark.Universe[item.Label] == nil {
+				labels = append(labels, item.Label)
+			}
+		}
+		return strings.Join(labels, " ")
+	}
+	if got, want := labels(4, 4), "f greet hi host x y z"; got != want {
+		t.Errorf("completion in f: got %s, want %s", got, want)
+	}
+	if got, want := labels(6, 0), "f greet hi host z"; got != want {
+		t.Errorf("completion at top level: got %s, want %s", got, want)
+	}
+	if got, want := labels(0, 20), "greet"; got != want {
+		t.Errorf("completion in load: got %s, want %s", got, want)
+	}
+
+	// document symbols
+	var symbols []documentSymbol
+	c.call("textDocument/documentSymbol", map[string]interface{}{"textDocument": map[string]string{"uri": uri}}, &symbols)
+	var names []string
+	for _, sym := range symbols {
+		names = append(names, fmt.Sprintf("%s:%d", sym.Name, sym.Kind))
+	}
+	if got, want := strings.Join(names, " "), "f:12 z:13"; got != want {
+		t.Errorf("symbols: got %s, want %s", got, want)
+	}
+
+	c.call("shutdown", nil, nil)
+	c.send(0, "exit", nil)
+	if err := <-done; err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+}
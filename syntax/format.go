@@ -0,0 +1,654 @@
+package syntax
+
+// This file defines Format, a printer of syntax trees back to source.
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLineWidth is the number of columns within which Format tries to
+// keep each line, by breaking calls, lists, and other bracketed
+// sequences across lines.
+const maxLineWidth = 79
+
+// Format returns the source code of the file in canonical form.
+//
+// Statements are indented by four spaces, with at most one blank line
+// between them. A bracketed sequence, such as the arguments of a call
+// or the elements of a list, dict, or tuple, is printed on one line
+// if it fits; otherwise it is printed one element per line, with a
+// trailing comma. A sequence also remains one element per line if its
+// closing bracket was on a line of its own, or if comments appear
+// within it. String literals use double quotes where possible.
+//
+// For the file's comments to be preserved, it must have been parsed
+// in RetainComments mode. Format is stable: formatting the result
+// again produces the same output.
+//
+// Format returns an error if the file contains a BadStmt or BadExpr,
+// as produced by parsing in RecoverErrors mode.
+func Format(f *File) ([]byte, error) {
+	var bad Node
+	Walk(f, func(n Node) bool {
+		switch n.(type) {
+		case *BadStmt, *BadExpr:
+			if bad == nil {
+				bad = n
+			}
+		}
+		return bad == nil
+	})
+	if bad != nil {
+		start, _ := bad.Span()
+		return nil, Error{Pos: start, Msg: "cannot format a syntax error"}
+	}
+
+	p := &printer{lineStart: true}
+	p.stmts(f.Stmts, nil)
+	if c := f.Comments(); c != nil && len(c.After) > 0 {
+		var prev Node
+		if len(f.Stmts) > 0 {
+			prev = f.Stmts[len(f.Stmts)-1]
+		}
+		p.lines(c.After, prev)
+	}
+	return p.buf.Bytes(), nil
+}
+
+// A printer accumulates formatted source code.
+//
+// In flat mode, it prints a sequence of syntax on a single line,
+// and fails if that would drop comments or break a sequence
+// whose layout must be preserved.
+type printer struct {
+	buf       bytes.Buffer
+	indent    int       // indentation level
+	col       int       // column of the next character, from 0
+	lineStart bool      // at the start of a line; indentation is pending
+	pending   []Comment // suffix comments to print at the end of the line
+	margin    int       // width of the text that follows the current expression
+
+	flat   bool // print everything on one line
+	root   Node // (flat mode) the node being flattened
+	failed bool // (flat mode) layout cannot be flattened
+}
+
+// text prints s, which is part of a single line unless it is a
+// multi-line string literal.
+func (p *printer) text(s string) {
+	if p.lineStart {
+		p.lineStart = false
+		for i := 0; i < p.indent; i++ {
+			p.buf.WriteString("    ")
+		}
+		p.col = 4 * p.indent
+	}
+	p.buf.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		p.col = utf8.RuneCountInString(s[i+1:])
+	} else {
+		p.col += utf8.RuneCountInString(s)
+	}
+}
+
+// newline ends the current line, after any pending suffix comments.
+func (p *printer) newline() {
+	for i, c := range p.pending {
+		if i == 0 {
+			p.text("  ")
+		} else {
+			p.text(" ")
+		}
+		p.text(strings.TrimRight(c.Text, " \t\r"))
+	}
+	p.pending = nil
+	p.buf.WriteByte('\n')
+	p.lineStart = true
+	p.col = 0
+}
+
+// blank prints an empty line, unless the previous line is empty.
+func (p *printer) blank() {
+	if b := p.buf.Bytes(); len(b) > 0 && !bytes.HasSuffix(b, []byte("\n\n")) {
+		p.buf.WriteByte('\n')
+	}
+}
+
+// fits reports whether s fits on the current line,
+// followed by margin more columns.
+func (p *printer) fits(s string, margin int) bool {
+	col := p.col
+	if p.lineStart {
+		col = 4 * p.indent
+	}
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	return col+utf8.RuneCountInString(s)+margin <= maxLineWidth
+}
+
+// flatten returns the single-line form of x. It reports false if x
+// cannot be printed on one line. If allowSuffix, x may have suffix
+// comments, which the caller must print.
+func flatten(x Node, allowSuffix bool) (string, bool) {
+	if c := x.Comments(); c != nil && (len(c.Before) > 0 || len(c.Suffix) > 0 && !allowSuffix) {
+		return "", false
+	}
+	q := &printer{flat: true, root: x}
+	q.node(x)
+	return q.buf.String(), !q.failed
+}
+
+// before prints the comments that precede n: on lines of their own
+// if n starts a line, or at the end of the line otherwise.
+func (p *printer) before(n Node) {
+	c := n.Comments()
+	if c == nil || len(c.Before) == 0 || n == p.root {
+		return
+	}
+	if p.flat {
+		p.failed = true
+	} else if p.lineStart {
+		for _, com := range c.Before {
+			p.text(strings.TrimRight(com.Text, " \t\r"))
+			p.newline()
+		}
+	} else {
+		p.pending = append(p.pending, c.Before...)
+	}
+}
+
+// suffix schedules the suffix comments of n for the end of the line.
+func (p *printer) suffix(n Node) {
+	c := n.Comments()
+	if c == nil || len(c.Suffix) == 0 || n == p.root {
+		return
+	}
+	if p.flat {
+		p.failed = true
+	} else {
+		p.pending = append(p.pending, c.Suffix...)
+	}
+}
+
+// lines prints whole-line comments, preserving single blank lines
+// between them and after the syntax prev, which may be nil.
+func (p *printer) lines(comments []Comment, prev Node) {
+	last := int32(0)
+	if prev != nil {
+		last = End(prev).Line
+	}
+	for _, c := range comments {
+		if last > 0 && c.Start.Line > last+1 {
+			p.blank()
+		}
+		p.text(strings.TrimRight(c.Text, " \t\r"))
+		p.newline()
+		last = c.Start.Line
+	}
+}
+
+// stmts prints a block of statements, followed by the tail comments
+// at the end of the last line.
+func (p *printer) stmts(stmts []Stmt, tail []Comment) {
+	for i, stmt := range stmts {
+		var prev Node
+		if i > 0 {
+			prev = stmts[i-1]
+			if startLine(stmt) > End(prev).Line+1 {
+				p.blank()
+			}
+		}
+		if c := stmt.Comments(); c != nil && len(c.Before) > 0 {
+			p.lines(c.Before, prev)
+			if last := c.Before[len(c.Before)-1].Start.Line; last > 0 && Start(stmt).Line > last+1 {
+				p.blank()
+			}
+		}
+		if i < len(stmts)-1 {
+			p.stmt(stmt, nil)
+		} else {
+			p.stmt(stmt, tail)
+		}
+	}
+}
+
+// startLine returns the first line of a statement, including its comments.
+func startLine(stmt Stmt) int32 {
+	if c := stmt.Comments(); c != nil && len(c.Before) > 0 {
+		return c.Before[0].Start.Line
+	}
+	return Start(stmt).Line
+}
+
+// block prints the suite of statements of a compound statement.
+func (p *printer) block(stmts []Stmt, tail []Comment) {
+	p.newline()
+	p.indent++
+	p.stmts(stmts, tail)
+	p.indent--
+}
+
+// stmt prints a statement, other than its preceding comments, and
+// ends its last line, after its suffix comments and the tail comments.
+//
+// The suffix comments of a compound statement follow its last line,
+// as that is where the parser finds them.
+func (p *printer) stmt(stmt Stmt, tail []Comment) {
+	if c := stmt.Comments(); c != nil && len(c.Suffix) > 0 {
+		tail = append(c.Suffix[:len(c.Suffix):len(c.Suffix)], tail...)
+	}
+	switch stmt := stmt.(type) {
+	case *ExprStmt:
+		p.expr(stmt.X)
+
+	case *AssignStmt:
+		p.expr(stmt.LHS)
+		p.text(" " + stmt.Op.String() + " ")
+		p.expr(stmt.RHS)
+
+	case *BranchStmt:
+		p.text(stmt.Token.String())
+
+	case *ReturnStmt:
+		p.text("return")
+		if stmt.Result != nil {
+			p.text(" ")
+			p.expr(stmt.Result)
+		}
+
+	case *LoadStmt:
+		p.text("load")
+		args := []Expr{stmt.Module}
+		for i, to := range stmt.To {
+			from := stmt.From[i]
+			lit := &Literal{Token: STRING, TokenPos: from.NamePos, Raw: Quote(from.Name, false)}
+			lit.commentsRef = from.commentsRef
+			if to == from || to.Name == from.Name {
+				args = append(args, lit)
+			} else {
+				args = append(args, &BinaryExpr{X: to, OpPos: to.NamePos, Op: EQ, Y: lit})
+			}
+		}
+		p.seq("(", ")", args, stmt.Rparen, items)
+
+	case *DefStmt:
+		p.text("def ")
+		p.expr(stmt.Name)
+		params := stmt.Params
+		if stmt.ParamTypes != nil {
+			params = make([]Expr, len(stmt.Params))
+			for i, param := range stmt.Params {
+				params[i] = param
+				if t := stmt.ParamTypes[i]; t != nil {
+					params[i] = &annotated{param: param, typ: t}
+				}
+			}
+		}
+		p.margin = 1 // ":"
+		if stmt.ResultType != nil {
+			result, _ := flatten(stmt.ResultType, false)
+			p.margin += len(" -> ") + len(result)
+		}
+		p.seq("(", ")", params, stmt.Rparen, items)
+		p.margin = 0
+		if stmt.ResultType != nil {
+			p.text(" -> ")
+			p.expr(stmt.ResultType)
+		}
+		p.text(":")
+		p.block(stmt.Body, tail)
+		return
+
+	case *ForStmt:
+		p.text("for ")
+		p.expr(stmt.Vars)
+		p.text(" in ")
+		p.expr(stmt.X)
+		p.text(":")
+		p.block(stmt.Body, tail)
+		return
+
+	case *WhileStmt:
+		p.text("while ")
+		p.expr(stmt.Cond)
+		p.text(":")
+		p.block(stmt.Body, tail)
+		return
+
+	case *IfStmt:
+		p.text("if ")
+		p.ifStmt(stmt, tail)
+		return
+
+	default:
+		panic(fmt.Sprintf("unexpected statement %T", stmt))
+	}
+	p.pending = append(p.pending, tail...)
+	p.newline()
+}
+
+// ifStmt prints an if statement after its "if" or "elif" keyword.
+func (p *printer) ifStmt(stmt *IfStmt, tail []Comment) {
+	p.expr(stmt.Cond)
+	p.text(":")
+	if len(stmt.False) == 0 {
+		p.block(stmt.True, tail)
+		return
+	}
+	p.block(stmt.True, nil)
+	if elif, ok := stmt.False[0].(*IfStmt); ok && len(stmt.False) == 1 && stmt.ElsePos.IsValid() && elif.If == stmt.ElsePos {
+		if c := elif.Comments(); c != nil && len(c.Before) > 0 {
+			p.lines(c.Before, nil)
+		}
+		p.text("elif ")
+		if c := elif.Comments(); c != nil && len(c.Suffix) > 0 {
+			tail = append(c.Suffix[:len(c.Suffix):len(c.Suffix)], tail...)
+		}
+		p.ifStmt(elif, tail)
+		return
+	}
+	p.text("else:")
+	p.block(stmt.False, tail)
+}
+
+// expr prints an expression, breaking its sequences across lines as needed.
+func (p *printer) expr(x Expr) {
+	p.node(x)
+}
+
+// node prints an expression, or a clause of a comprehension.
+func (p *printer) node(n Node) {
+	p.before(n)
+	switch n := n.(type) {
+	case *Ident:
+		p.text(n.Name)
+
+	case *Literal:
+		p.text(literal(n))
+
+	case *FStringExpr:
+		p.text(n.Raw)
+
+	case *ParenExpr:
+		p.seq("(", ")", []Expr{n.X}, n.Rparen, parens)
+
+	case *CallExpr:
+		p.expr(n.Fn)
+		p.seq("(", ")", n.Args, n.Rparen, items)
+
+	case *DotExpr:
+		p.expr(n.X)
+		p.text(".")
+		p.expr(n.Name)
+
+	case *IndexExpr:
+		p.expr(n.X)
+		p.text("[")
+		p.expr(n.Y)
+		p.text("]")
+
+	case *SliceExpr:
+		p.expr(n.X)
+		p.text("[")
+		if n.Lo != nil {
+			p.expr(n.Lo)
+		}
+		p.text(":")
+		if n.Hi != nil {
+			p.expr(n.Hi)
+		}
+		if n.Step != nil {
+			p.text(":")
+			p.expr(n.Step)
+		}
+		p.text("]")
+
+	case *ListExpr:
+		p.seq("[", "]", n.List, n.Rbrack, items)
+
+	case *DictExpr:
+		p.seq("{", "}", n.List, n.Rbrace, items)
+
+	case *DictEntry:
+		p.expr(n.Key)
+		p.text(": ")
+		p.expr(n.Value)
+
+	case *TupleExpr:
+		if n.Lparen.IsValid() || len(n.List) == 0 {
+			p.seq("(", ")", n.List, n.Rparen, tuple)
+		} else {
+			for i, x := range n.List {
+				if i > 0 {
+					p.text(", ")
+				}
+				p.expr(x)
+			}
+			if len(n.List) == 1 {
+				p.text(",")
+			}
+		}
+
+	case *UnaryExpr:
+		switch n.Op {
+		case NOT:
+			p.text("not ")
+		default:
+			p.text(n.Op.String())
+		}
+		if n.X != nil {
+			p.expr(n.X)
+		}
+
+	case *BinaryExpr:
+		p.expr(n.X)
+		if n.Op == EQ {
+			p.text("=") // named argument or parameter
+		} else {
+			p.text(" " + n.Op.String() + " ")
+		}
+		p.expr(n.Y)
+
+	case *CondExpr:
+		p.expr(n.True)
+		p.text(" if ")
+		p.expr(n.Cond)
+		p.text(" else ")
+		p.expr(n.False)
+
+	case *annotated:
+		switch param := n.param.(type) {
+		case *BinaryExpr:
+			p.expr(param.X)
+			p.text(": ")
+			p.expr(n.typ)
+			p.text(" = ")
+			p.expr(param.Y)
+		default:
+			p.expr(param)
+			p.text(": ")
+			p.expr(n.typ)
+		}
+
+	case *LambdaExpr:
+		p.text("lambda")
+		for i, param := range n.Params {
+			if i > 0 {
+				p.text(",")
+			}
+			p.text(" ")
+			p.expr(param)
+		}
+		p.text(": ")
+		p.expr(n.Body)
+
+	case *Comprehension:
+		if n.Curly {
+			p.text("{")
+		} else {
+			p.text("[")
+		}
+		p.expr(n.Body)
+		for _, clause := range n.Clauses {
+			p.text(" ")
+			p.node(clause)
+		}
+		if n.Curly {
+			p.text("}")
+		} else {
+			p.text("]")
+		}
+
+	case *ForClause:
+		p.text("for ")
+		p.expr(n.Vars)
+		p.text(" in ")
+		p.expr(n.X)
+
+	case *IfClause:
+		p.text("if ")
+		p.expr(n.Cond)
+
+	default:
+		panic(fmt.Sprintf("unexpected expression %T", n))
+	}
+	p.suffix(n)
+}
+
+// An annotated is a parameter of a def statement with its type
+// annotation, such as x: int = 0, which the printer treats as
+// a single element of the parameter list.
+type annotated struct {
+	commentsRef
+	param, typ Expr
+}
+
+func (x *annotated) Span() (start, end Position) {
+	start, end = x.param.Span()
+	if e := End(x.typ); end.isBefore(e) {
+		end = e
+	}
+	return start, end
+}
+
+func (*annotated) expr() {}
+
+// A sequenceKind describes the commas of a bracketed sequence.
+type sequenceKind int
+
+const (
+	parens sequenceKind = iota // a parenthesized expression: no commas
+	items                      // items with a trailing comma when on separate lines
+	tuple                      // as items, but with a trailing comma after a sole item
+)
+
+// seq prints a bracketed, comma-separated sequence whose closing
+// bracket is at position end. If it does not fit on one line, seq
+// prints each element on a line of its own.
+func (p *printer) seq(open, close string, elems []Expr, end Position, kind sequenceKind) {
+	margin := p.margin
+	p.margin = 0
+	defer func() { p.margin = margin }()
+
+	// A sequence whose closing bracket was on its own line stays that way.
+	multiline := len(elems) > 0 && end.Line > End(elems[len(elems)-1]).Line
+
+	if p.flat {
+		if multiline {
+			p.failed = true
+		}
+		p.text(open)
+		for i, x := range elems {
+			if i > 0 {
+				p.text(", ")
+			}
+			p.expr(x)
+		}
+		if kind == tuple && len(elems) == 1 {
+			p.text(",")
+		}
+		p.text(close)
+		return
+	}
+
+	if !multiline {
+		// Print the sequence on one line if it fits.
+		// The last element may have a suffix comment,
+		// which follows the closing bracket.
+		var buf strings.Builder
+		buf.WriteString(open)
+		ok := true
+		for i, x := range elems {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			s, ok1 := flatten(x, i == len(elems)-1)
+			buf.WriteString(s)
+			ok = ok && ok1
+		}
+		if kind == tuple && len(elems) == 1 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(close)
+		if ok && p.fits(buf.String(), margin) {
+			p.text(buf.String())
+			if len(elems) > 0 {
+				p.suffix(elems[len(elems)-1])
+			}
+			return
+		}
+	}
+
+	p.text(open)
+	p.indent++
+	for _, x := range elems {
+		p.newline()
+		if kind != parens {
+			p.margin = 1 // ","
+		}
+		p.expr(x)
+		p.margin = 0
+		if kind != parens {
+			p.text(",")
+		}
+	}
+	p.indent--
+	p.newline()
+	p.text(close)
+}
+
+// literal returns the source form of a literal,
+// preferring double quotes for strings.
+func literal(lit *Literal) string {
+	raw := lit.Raw
+	if raw == "" {
+		switch v := lit.Value.(type) {
+		case string:
+			return Quote(v, lit.Token == BYTES)
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+	if lit.Token != STRING && lit.Token != BYTES {
+		return raw
+	}
+	i := strings.IndexAny(raw, `'"`)
+	if i < 0 || raw[i] != '\'' {
+		return raw
+	}
+	prefix, quoted := raw[:i], raw[i:]
+	quote := "'"
+	if strings.HasPrefix(quoted, "'''") {
+		quote = "'''"
+	}
+	body := quoted[len(quote) : len(quoted)-len(quote)]
+	if strings.ContainsAny(body, `"\`) {
+		return raw
+	}
+	dquote := strings.Repeat(`"`, len(quote))
+	return prefix + dquote + body + dquote
+}
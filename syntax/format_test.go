@@ -0,0 +1,188 @@
+package syntax_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.starlark.net/syntax"
+)
+
+func TestFormat(t *testing.T) {
+	for _, test := range []struct {
+		src, want string
+	}{
+		// layout of statements
+		{"x=1;y = 2\n", "x = 1\ny = 2\n"},
+		{"def f( a,b = 1,*args,**kwargs ):\n  return a\n", "def f(a, b=1, *args, **kwargs):\n    return a\n"},
+		{"if a:\n pass\nelif b:\n pass\nelse:\n pass\n", "if a:\n    pass\nelif b:\n    pass\nelse:\n    pass\n"},
+		{"if a:\n pass\nelse:\n if b:\n  pass\n", "if a:\n    pass\nelse:\n    if b:\n        pass\n"},
+		{"for k,v in d.items():\n  x += k\n", "for k, v in d.items():\n    x += k\n"},
+		{"x = 1\n\n\n\ny = 2\n", "x = 1\n\ny = 2\n"},
+		{"def f():\n\n  x = 1\n\n  return x\n", "def f():\n    x = 1\n\n    return x\n"},
+		{"load('m.star', 'a', b='c')\n", `load("m.star", "a", b="c")` + "\n"},
+		{"def f(a:int, b=1, *c:str, d:list[int]=[], **e: set)->int|None:\n  pass\n", "def f(a: int, b=1, *c: str, d: list[int] = [], **e: set) -> int | None:\n    pass\n"},
+		{
+			"def f(aaaaaaaaaaaaaaaaaaaaaaaaa: int, bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb: str) -> list[str]:\n  pass\n",
+			"def f(\n    aaaaaaaaaaaaaaaaaaaaaaaaa: int,\n    bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb: str,\n) -> list[str]:\n    pass\n",
+		},
+		{"", ""},
+
+		// expressions
+		{"x = - a + (b*c) [1:2] [::3]\n", "x = -a + (b * c)[1:2][::3]\n"},
+		{"x = not a and b not in c\n", "x = not a and b not in c\n"},
+		{"x = a if b else c\n", "x = a if b else c\n"},
+		{"x = lambda: 1\ny = lambda a, *b: a\n", "x = lambda: 1\ny = lambda a, *b: a\n"},
+		{"x=f'{a!r:>5} {b+1}'\n", "x = f'{a!r:>5} {b+1}'\n"}, // f-strings are printed verbatim
+		{"x = [a for a in b if a]\ny = {k: v for k, v in d}\n", "x = [a for a in b if a]\ny = {k: v for k, v in d}\n"},
+		{"x = (1,)\ny = ()\nz = 1, 2\n", "x = (1,)\ny = ()\nz = 1, 2\n"},
+		{"x = {'a':1}\n", `x = {"a": 1}` + "\n"},
+		{`x = ['a', 'b"', r'\d', '''c''', b'd']` + "\n", `x = ["a", 'b"', r'\d', """c""", b"d"]` + "\n"},
+
+		// wrapping
+		{
+			"x = f(aaaaaaaaaaaaaaaaaaaaaaaaa, bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb, ccccccccccccccccccccccc)\n",
+			"x = f(\n    aaaaaaaaaaaaaaaaaaaaaaaaa,\n    bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb,\n    ccccccccccccccccccccccc,\n)\n",
+		},
+		{
+			"x = {'aaaaaaaaaaaaaaaaaaaaaaaaa': [1, 2, 3], 'bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb': [4, 5, 6]}\n",
+			"x = {\n    \"aaaaaaaaaaaaaaaaaaaaaaaaa\": [1, 2, 3],\n    \"bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb\": [4, 5, 6],\n}\n",
+		},
+		{
+			"def f(aaaaaaaaaaaaaaaaaaaaaaaaa, bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb, ccccccccccccc=1):\n  pass\n",
+			"def f(\n    aaaaaaaaaaaaaaaaaaaaaaaaa,\n    bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb,\n    ccccccccccccc=1,\n):\n    pass\n",
+		},
+		{"x = [\n  1, 2]\n", "x = [1, 2]\n"},
+		{"x = [1, 2,\n]\n", "x = [\n    1,\n    2,\n]\n"},
+		{"x = (\n  a + b\n)\n", "x = (\n    a + b\n)\n"},
+
+		// comments
+		{"# a\n\n# b\nx = 1  # c\n# d\n", "# a\n\n# b\nx = 1  # c\n# d\n"},
+		{"def f(a, b):  # c\n  pass\n", "def f(a, b):  # c\n    pass\n"},
+		{"if x:  # c\n  pass\n", "if x:  # c\n    pass\n"},
+		{"x = [1, # one\n  # two\n  2]\n", "x = [\n    1,  # one\n    # two\n    2,\n]\n"},
+		{"f(a, b)  # c\n", "f(a, b)  # c\n"},
+		{"def f(): return 1  # c\n", "def f():\n    return 1  # c\n"},
+	} {
+		opts := &syntax.FileOptions{FStrings: true}
+		f, err := opts.Parse("in.star", test.src, syntax.RetainComments)
+		if err != nil {
+			t.Errorf("parse %q: %v", test.src, err)
+			continue
+		}
+		out, err := syntax.Format(f)
+		if err != nil {
+			t.Errorf("Format(%q): %v", test.src, err)
+		} else if got := string(out); got != test.want {
+			t.Errorf("Format(%q) =\n%s\nwant:\n%s", test.src, got, test.want)
+		}
+	}
+}
+
+// TestFormatSyntaxError checks that Format rejects a file that
+// contains syntax errors, as parsed in RecoverErrors mode.
+func TestFormatSyntaxError(t *testing.T) {
+	f, _ := syntax.Parse("in.star", "x = 1\ny = 1 +\n", syntax.RecoverErrors)
+	if f == nil {
+		t.Fatal("Parse returned nil file")
+	}
+	const want = "in.star:2:1: cannot format a syntax error"
+	if _, err := syntax.Format(f); err == nil || err.Error() != want {
+		t.Errorf("Format: got error %v, want %s", err, want)
+	}
+}
+
+// TestFormatTestdata checks that formatting each chunk of the
+// Starlark test files preserves its syntax tree and comments,
+// and that formatting is stable.
+func TestFormatTestdata(t *testing.T) {
+	var files []string
+	for _, pattern := range []string{"../starlark/testdata/*.star", "../lib/*/testdata/*.star", "testdata/scan.star"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		t.Fatal("no test files")
+	}
+	opts := &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true, Recursion: true}
+	for _, filename := range files {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, chunk := range strings.Split(string(data), "\n---\n") {
+			name := fmt.Sprintf("%s chunk %d", filename, i)
+			f, err := opts.Parse(name, chunk, syntax.RetainComments)
+			if err != nil {
+				continue // chunk tests a syntax error
+			}
+			out, err := syntax.Format(f)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			g, err := opts.Parse(name, out, syntax.RetainComments)
+			if err != nil {
+				t.Errorf("%s: formatted output does not parse: %v\n%s", name, err, out)
+				continue
+			}
+			if x, y := dump(f), dump(g); x != y {
+				t.Errorf("%s: formatting changed the syntax tree:\n%s\nwant:\n%s", name, y, x)
+				continue
+			}
+			if out2, _ := syntax.Format(g); string(out2) != string(out) {
+				t.Errorf("%s: formatting is not stable:\n%s\nthen:\n%s", name, out, out2)
+			}
+		}
+	}
+}
+
+// dump describes a syntax tree, without positions, and with its
+// comments in order of appearance, ignoring the quoting of strings.
+func dump(f *syntax.File) string {
+	var buf strings.Builder
+	var comments []syntax.Comment
+	var depth int
+	syntax.Walk(f, func(n syntax.Node) bool {
+		if n == nil {
+			depth--
+			return true
+		}
+		fmt.Fprintf(&buf, "%s%s", strings.Repeat("  ", depth), reflect.TypeOf(n).Elem().Name())
+		switch n := n.(type) {
+		case *syntax.Ident:
+			fmt.Fprintf(&buf, " %s", n.Name)
+		case *syntax.Literal:
+			fmt.Fprintf(&buf, " %v", n.Value)
+		case *syntax.BinaryExpr:
+			fmt.Fprintf(&buf, " %s", n.Op)
+		case *syntax.UnaryExpr:
+			fmt.Fprintf(&buf, " %s", n.Op)
+		case *syntax.AssignStmt:
+			fmt.Fprintf(&buf, " %s", n.Op)
+		}
+		if c := n.Comments(); c != nil {
+			comments = append(comments, c.Before...)
+			comments = append(comments, c.Suffix...)
+			comments = append(comments, c.After...)
+		}
+		buf.WriteByte('\n')
+		depth++
+		return true
+	})
+	sort.Slice(comments, func(i, j int) bool {
+		x, y := comments[i].Start, comments[j].Start
+		return x.Line < y.Line || x.Line == y.Line && x.Col < y.Col
+	})
+	for _, c := range comments {
+		fmt.Fprintf(&buf, "%s\n", c.Text)
+	}
+	return buf.String()
+}
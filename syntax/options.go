@@ -21,15 +21,21 @@ import _ "unsafe" // for linkname
 // FileOptions parameter and the name suffix "Options", such as
 // [go.starlark.net/starlark.ExecFileOptions].
 type FileOptions struct {
+	// scanner
+	FStrings bool // allow f-string literals such as f"{x}"
+
 	// resolver
 	Set               bool // allow references to the 'set' built-in function
+	Catch             bool // allow references to the 'catch' built-in function
 	While             bool // allow 'while' statements
 	TopLevelControl   bool // allow if/for/while statements at top-level
 	GlobalReassign    bool // allow reassignment to top-level names
 	LoadBindsGlobally bool // load creates global not file-local bindings (deprecated)
 
 	// compiler
-	Recursion bool // disable recursion check for functions in this file
+	Recursion  bool // disable recursion check for functions in this file
+	CheckTypes bool // check the annotated parameter and result types of calls at run time
+	Optimize   bool // fold constants and form superinstructions in the compiled code
 }
 
 // TODO(adonovan): provide a canonical flag parser for FileOptions.
@@ -11,7 +11,10 @@ package syntax
 // package.  Verify that error positions are correct using the
 // chunkedfile mechanism.
 
-import "log"
+import (
+	"log"
+	"strings"
+)
 
 // Enable this flag to print the token stream and log.Fatal on the first error.
 const debug = false
@@ -21,6 +24,7 @@ type Mode uint
 
 const (
 	RetainComments Mode = 1 << iota // retain comments in AST; see Node.Comments
+	RecoverErrors                   // report all syntax errors, not just the first; see Parse
 )
 
 // Parse calls the Parse method of LegacyFileOptions().
@@ -38,20 +42,36 @@ func Parse(filename string, src interface{}, mode Mode) (f *File, err error) {
 // The type of the argument for the src parameter must be string,
 // []byte, io.Reader, or FilePortion.
 // If src == nil, Parse parses the file specified by filename.
+//
+// In RecoverErrors mode, Parse continues after a syntax error.
+// It replaces a statement containing an error by a BadStmt, and skips
+// the following lines up to the next statement that is indented no
+// further, or, if the error is in the condition of an if or while
+// statement or the operand of a for loop, replaces the expression by
+// a BadExpr and skips to the colon. If there were errors, Parse
+// returns the partial file, along with an ErrorList of all of them.
 func (opts *FileOptions) Parse(filename string, src interface{}, mode Mode) (f *File, err error) {
 	in, err := newScanner(filename, src, mode&RetainComments != 0)
 	if err != nil {
 		return nil, err
 	}
-	p := parser{options: opts, in: in}
+	in.fstrings = opts.FStrings
+	p := parser{options: opts, in: in, mode: mode}
 	defer p.in.recover(&err)
 
-	p.nextToken() // read first lookahead token
+	// read first lookahead token
+	if err := p.catch(func() { p.nextToken() }); err != nil {
+		p.report(*err)
+		p.sync(1, false)
+	}
 	f = p.parseFile()
 	if f != nil {
 		f.Path = filename
 	}
 	p.assignComments(f)
+	if p.errors != nil {
+		return f, p.errors
+	}
 	return f, nil
 }
 
@@ -76,6 +96,7 @@ func (opts *FileOptions) ParseCompoundStmt(filename string, readline func() ([]b
 		return nil, err
 	}
 
+	in.fstrings = opts.FStrings
 	p := parser{options: opts, in: in}
 	defer p.in.recover(&err)
 
@@ -114,6 +135,7 @@ func (opts *FileOptions) ParseExpr(filename string, src interface{}, mode Mode)
 	if err != nil {
 		return nil, err
 	}
+	in.fstrings = opts.FStrings
 	p := parser{options: opts, in: in}
 	defer p.in.recover(&err)
 
@@ -140,6 +162,8 @@ type parser struct {
 	in      *scanner
 	tok     Token
 	tokval  tokenValue
+	mode    Mode
+	errors  ErrorList // errors reported in RecoverErrors mode
 }
 
 // nextToken advances the scanner and returns the position of the
@@ -162,11 +186,118 @@ func (p *parser) parseFile() *File {
 			p.nextToken()
 			continue
 		}
-		stmts = p.parseStmt(stmts)
+		stmts = p.parseStmtOrBad(stmts)
 	}
 	return &File{Options: p.options, Stmts: stmts}
 }
 
+// parseStmtOrBad parses a statement. In RecoverErrors mode,
+// if the statement contains a syntax error, parseStmtOrBad reports it,
+// appends a BadStmt, and skips to the next statement.
+func (p *parser) parseStmtOrBad(stmts []Stmt) []Stmt {
+	if p.mode&RecoverErrors == 0 {
+		return p.parseStmt(stmts)
+	}
+	from := p.tokval.pos
+	level := len(p.in.indentstk)
+	if p.tok == INDENT {
+		level-- // an unexpected indentation starts this statement
+	}
+	if err := p.catch(func() { stmts = p.parseStmt(stmts) }); err != nil {
+		p.report(*err)
+		stmts = append(stmts, &BadStmt{From: from, To: err.Pos})
+		p.sync(level, p.tok == INDENT || p.tok == OUTDENT)
+	}
+	return stmts
+}
+
+// parseHeaderExpr calls parse to parse the expression in the header
+// of a compound statement, which is followed by a colon.
+// In RecoverErrors mode, if the expression contains a syntax error,
+// parseHeaderExpr reports it, skips to the colon, and returns a BadExpr.
+// If there is no colon on the line, it panics with the error.
+func (p *parser) parseHeaderExpr(parse func() Expr) Expr {
+	if p.mode&RecoverErrors == 0 {
+		return parse()
+	}
+	from := p.tokval.pos
+	var x Expr
+	err := p.catch(func() { x = parse() })
+	if err == nil {
+		return x
+	}
+	p.report(*err)
+	lastLine := err.Pos.Line
+	if from.Line > lastLine {
+		lastLine = from.Line
+	}
+	depth := 0
+	for !(p.tok == COLON && depth == 0) {
+		switch p.tok {
+		case LPAREN, LBRACK, LBRACE:
+			depth++
+		case RPAREN, RBRACK, RBRACE:
+			depth--
+		case NEWLINE, EOF:
+			panic(*err)
+		}
+		if p.tokval.pos.Line > lastLine {
+			panic(*err)
+		}
+		p.nextToken()
+	}
+	p.in.depth = 0 // forget any unclosed brackets
+	return &BadExpr{From: from, To: err.Pos}
+}
+
+// catch calls f. In RecoverErrors mode, it returns
+// the syntax error, if any, with which f panics.
+func (p *parser) catch(f func()) (err *Error) {
+	if p.mode&RecoverErrors != 0 {
+		defer func() {
+			switch e := recover().(type) {
+			case nil:
+			case Error:
+				err = &e
+			default:
+				panic(e)
+			}
+		}()
+	}
+	f()
+	return nil
+}
+
+// report records a syntax error, unless it was just reported.
+func (p *parser) report(err Error) {
+	if len(p.errors) == 0 || p.errors[len(p.errors)-1] != err {
+		p.errors = append(p.errors, err)
+	}
+}
+
+// sync skips the rest of a statement containing a syntax error, up to
+// the next line that is indented no further than the statement, whose
+// block is at the specified level of the indentation stack, and reads
+// the first token of that line. If rewind, the current line, whose
+// indentation the scanner has already read, is not skipped.
+func (p *parser) sync(level int, rewind bool) {
+	for {
+		pos := p.in.pos
+		err := p.catch(func() {
+			p.in.skipLines(level, rewind)
+			p.nextToken()
+		})
+		if err == nil {
+			return
+		}
+		p.report(*err)
+		rewind = false
+		if p.in.pos == pos {
+			p.in.skipLine() // ensure progress
+		}
+	}
+}
+
 func (p *parser) parseStmt(stmts []Stmt) []Stmt {
 	if p.tok == DEF {
 		return append(stmts, p.parseDefStmt())
@@ -184,23 +315,32 @@ func (p *parser) parseDefStmt() Stmt {
 	defpos := p.nextToken() // consume DEF
 	id := p.parseIdent()
 	lparen := p.consume(LPAREN)
-	params := p.parseParams()
+	params, types := p.parseParams(true)
 	rparen := p.consume(RPAREN)
+	var arrow Position
+	var result Expr
+	if p.tok == ARROW {
+		arrow = p.nextToken()
+		result = p.parseTest()
+	}
 	p.consume(COLON)
 	body := p.parseSuite()
 	return &DefStmt{
-		Def:    defpos,
-		Name:   id,
-		Lparen: lparen,
-		Params: params,
-		Rparen: rparen,
-		Body:   body,
+		Def:        defpos,
+		Name:       id,
+		Lparen:     lparen,
+		Params:     params,
+		ParamTypes: types,
+		Rparen:     rparen,
+		Arrow:      arrow,
+		ResultType: result,
+		Body:       body,
 	}
 }
 
 func (p *parser) parseIfStmt() Stmt {
 	ifpos := p.nextToken() // consume IF
-	cond := p.parseTest()
+	cond := p.parseHeaderExpr(p.parseTest)
 	p.consume(COLON)
 	body := p.parseSuite()
 	ifStmt := &IfStmt{
@@ -211,7 +351,7 @@ func (p *parser) parseIfStmt() Stmt {
 	tail := ifStmt
 	for p.tok == ELIF {
 		elifpos := p.nextToken() // consume ELIF
-		cond := p.parseTest()
+		cond := p.parseHeaderExpr(p.parseTest)
 		p.consume(COLON)
 		body := p.parseSuite()
 		elif := &IfStmt{
@@ -235,7 +375,7 @@ func (p *parser) parseForStmt() Stmt {
 	forpos := p.nextToken() // consume FOR
 	vars := p.parseForLoopVariables()
 	p.consume(IN)
-	x := p.parseExpr(false)
+	x := p.parseHeaderExpr(func() Expr { return p.parseExpr(false) })
 	p.consume(COLON)
 	body := p.parseSuite()
 	return &ForStmt{
@@ -248,7 +388,7 @@ func (p *parser) parseForStmt() Stmt {
 
 func (p *parser) parseWhileStmt() Stmt {
 	whilepos := p.nextToken() // consume WHILE
-	cond := p.parseTest()
+	cond := p.parseHeaderExpr(p.parseTest)
 	p.consume(COLON)
 	body := p.parseSuite()
 	return &WhileStmt{
@@ -411,7 +551,7 @@ func (p *parser) parseSuite() []Stmt {
 		p.consume(INDENT)
 		var stmts []Stmt
 		for p.tok != OUTDENT && p.tok != EOF {
-			stmts = p.parseStmt(stmts)
+			stmts = p.parseStmtOrBad(stmts)
 		}
 		p.consume(OUTDENT)
 		return stmts
@@ -443,12 +583,12 @@ func (p *parser) consume(t Token) Position {
 //
 //	|
 //
-// param = IDENT
+// param = IDENT [COLON test]
 //
-//	| IDENT EQ test
+//	| IDENT [COLON test] EQ test
 //	| STAR
-//	| STAR IDENT
-//	| STARSTAR IDENT
+//	| STAR IDENT [COLON test]
+//	| STARSTAR IDENT [COLON test]
 //
 // parseParams parses a parameter list.  The resulting expressions are of the form:
 //
@@ -457,8 +597,21 @@ func (p *parser) consume(t Token) Position {
 //	*Unary{Op: STAR}                                *
 //	*Unary{Op: STAR, X: *Ident}                     *args
 //	*Unary{Op: STARSTAR, X: *Ident}                 **kwargs
-func (p *parser) parseParams() []Expr {
-	var params []Expr
+//
+// If annotated, as in a def statement, each parameter other than a
+// bare * may have a type annotation, param ':' test, and parseParams
+// also returns the annotations, with nil for each parameter that
+// has none; the annotations are nil if no parameter has one.
+func (p *parser) parseParams(annotated bool) (params, types []Expr) {
+	var hasTypes bool
+	annotation := func() Expr {
+		if !annotated || p.tok != COLON {
+			return nil
+		}
+		p.nextToken()
+		hasTypes = true
+		return p.parseTest()
+	}
 	for p.tok != RPAREN && p.tok != COLON && p.tok != EOF {
 		if len(params) > 0 {
 			p.consume(COMMA)
@@ -471,21 +624,24 @@ func (p *parser) parseParams() []Expr {
 		if p.tok == STAR || p.tok == STARSTAR {
 			op := p.tok
 			pos := p.nextToken()
-			var x Expr
+			var x, t Expr
 			if op == STARSTAR || p.tok == IDENT {
 				x = p.parseIdent()
+				t = annotation()
 			}
 			params = append(params, &UnaryExpr{
 				OpPos: pos,
 				Op:    op,
 				X:     x,
 			})
+			types = append(types, t)
 			continue
 		}
 
 		// IDENT
 		// IDENT = test
 		id := p.parseIdent()
+		types = append(types, annotation())
 		if p.tok == EQ { // default value
 			eq := p.nextToken()
 			dflt := p.parseTest()
@@ -500,7 +656,10 @@ func (p *parser) parseParams() []Expr {
 
 		params = append(params, id)
 	}
-	return params
+	if !hasTypes {
+		types = nil
+	}
+	return params, types
 }
 
 // parseExpr parses an expression, possible consisting of a
@@ -574,7 +733,7 @@ func (p *parser) parseLambda(allowCond bool) Expr {
 	lambda := p.nextToken()
 	var params []Expr
 	if p.tok != COLON {
-		params = p.parseParams()
+		params, _ = p.parseParams(false)
 	}
 	p.consume(COLON)
 
@@ -800,7 +959,7 @@ func (p *parser) parseArgs() []Expr {
 
 // primary = IDENT
 //
-//	| INT | FLOAT | STRING | BYTES
+//	| INT | FLOAT | STRING | BYTES | FSTRING
 //	| '[' ...                    // list literal or comprehension
 //	| '{' ...                    // dict literal or comprehension
 //	| '(' ...                    // tuple or parenthesized expression
@@ -829,6 +988,9 @@ func (p *parser) parsePrimary() Expr {
 		pos := p.nextToken()
 		return &Literal{Token: tok, TokenPos: pos, Raw: raw, Value: val}
 
+	case FSTRING:
+		return p.parseFString()
+
 	case LBRACK:
 		return p.parseList()
 
@@ -1060,3 +1222,153 @@ func (p *parser) assignComments(n Node) {
 		}
 	}
 }
+
+// parseFString parses the f-string literal of the current token.
+// The scanner delimits the literal, and the parser divides its text
+// into literal parts and replacement fields, and parses the expression
+// of each field using a separate scanner.
+//
+//	fstring = 'f' quote {text | '{{' | '}}' | field} quote
+//	field   = '{' expr ['!' ('s' | 'r')] [':' spec] '}'
+func (p *parser) parseFString() Expr {
+	pos, raw := p.tokval.pos, p.tokval.raw
+	i := strings.IndexAny(raw, `'"`)
+	prefix, quote := raw[:i], raw[i:i+1]
+	if len(raw)-i >= 6 && raw[i+1] == raw[i] && raw[i+2] == raw[i] {
+		quote = raw[i : i+3]
+	}
+	errorf := func(k int, format string, args ...interface{}) {
+		p.in.errorf(pos.add(raw[:k]), "f-string: "+format, args...)
+	}
+
+	x := &FStringExpr{TokenPos: pos, Raw: raw}
+	var text strings.Builder // raw text of the current literal part
+	textStart := i + len(quote)
+	literal := func() {
+		// Decode escapes as in a triple-quoted string literal.
+		quoted := `"""` + text.String() + `"""`
+		if strings.Contains(prefix, "r") {
+			quoted = "r" + quoted
+		}
+		s, _, _, err := unquote(quoted)
+		if err != nil {
+			errorf(textStart, "%v", err)
+		}
+		x.Literals = append(x.Literals, s)
+		text.Reset()
+	}
+
+	end := len(raw) - len(quote)
+	for k := i + len(quote); k < end; {
+		switch c := raw[k]; {
+		case c == '{' && k+1 < end && raw[k+1] == '{', c == '}' && k+1 < end && raw[k+1] == '}':
+			text.WriteByte(c)
+			k += 2
+		case c == '}':
+			errorf(k, "single '}' is not allowed")
+		case c == '{':
+			literal()
+			var field *FStringField
+			field, k = p.parseFStringField(pos, raw, k, end, errorf)
+			x.Fields = append(x.Fields, field)
+			textStart = k
+		case c == '\\' && k+1 < end && raw[k+1] != '{' && raw[k+1] != '}':
+			text.WriteString(raw[k : k+2])
+			k += 2
+		default:
+			text.WriteByte(c)
+			k++
+		}
+	}
+	literal()
+	p.nextToken()
+	return x
+}
+
+// parseFStringField parses the replacement field of an f-string
+// that begins at raw[k] == '{' and returns it, with the offset of
+// the text that follows it. The text of the f-string ends at raw[end].
+func (p *parser) parseFStringField(pos Position, raw string, k, end int, errorf func(int, string, ...interface{})) (*FStringField, int) {
+	field := &FStringField{Lbrace: pos.add(raw[:k])}
+
+	// Find the end of the expression: the first '!', ':', or '}'
+	// outside brackets and string literals.
+	depth := 0
+	var inString byte // quote of the enclosing string literal, if any
+	j := k + 1
+scan:
+	for ; ; j++ {
+		if j >= end {
+			errorf(k, "expecting '}'")
+		}
+		c := raw[j]
+		if inString != 0 {
+			if c == inString {
+				inString = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"':
+			inString = c
+		case '(', '[', '{':
+			depth++
+		case ')', ']':
+			depth--
+		case '}':
+			if depth == 0 {
+				break scan
+			}
+			depth--
+		case '!':
+			if depth == 0 && (j+1 >= end || raw[j+1] != '=') {
+				break scan
+			}
+		case ':':
+			if depth == 0 {
+				break scan
+			}
+		case '#':
+			errorf(j, "expression part cannot include '#'")
+		case '\\':
+			errorf(j, "expression part cannot include a backslash")
+		}
+	}
+	expr := raw[k+1 : j]
+	if strings.TrimSpace(expr) == "" {
+		errorf(k, "empty expression not allowed")
+	}
+
+	// Parse the expression.
+	sub := parser{options: p.options, in: newFieldScanner(field.Lbrace.add("{"), expr)}
+	sub.nextToken()
+	field.X = sub.parseExpr(false)
+	if sub.tok != EOF {
+		sub.in.errorf(sub.tokval.pos, "got %#v, want '}'", sub.tok)
+	}
+
+	// Parse the conversion and format specification.
+	if raw[j] == '!' {
+		if j+1 >= end || raw[j+1] != 's' && raw[j+1] != 'r' {
+			errorf(j, "invalid conversion character: expected 's' or 'r'")
+		}
+		field.Conv = raw[j+1]
+		j += 2
+		if j >= end || raw[j] != ':' && raw[j] != '}' {
+			errorf(j, "expecting '}'")
+		}
+	}
+	if raw[j] == ':' {
+		spec := j + 1
+		for j = spec; j < end && raw[j] != '}'; j++ {
+			if raw[j] == '{' {
+				errorf(j, "nested replacement fields are not supported in format specifications")
+			}
+		}
+		if j >= end {
+			errorf(k, "expecting '}'")
+		}
+		field.Spec = raw[spec:j]
+	}
+	return field, j + 1
+}
@@ -132,6 +132,48 @@ func TestExprParseTrees(t *testing.T) {
 	}
 }
 
+func TestFStringParseTrees(t *testing.T) {
+	opts := &syntax.FileOptions{FStrings: true}
+	for _, test := range []struct {
+		input, want string
+	}{
+		{`f"a{x}b"`,
+			`(FStringExpr Raw=f"a{x}b" Literals=(a b) Fields=((FStringField X=x Spec=)))`},
+		{`f'{x!r:>5}{{}}'`,
+			`(FStringExpr Raw=f'{x!r:>5}{{}}' Literals=( {}) Fields=((FStringField X=x Conv=r Spec=>5)))`},
+		{`rf"\n{a[1] + f('}')}"`,
+			`(FStringExpr Raw=rf"\n{a[1] + f('}')}" Literals=(\n ) Fields=((FStringField X=(BinaryExpr X=(IndexExpr X=a Y=1) Op=+ Y=(CallExpr Fn=f Args=("}"))) Spec=)))`},
+		{`f"{x!=y}"`,
+			`(FStringExpr Raw=f"{x!=y}" Literals=( ) Fields=((FStringField X=(BinaryExpr X=x Op=!= Y=y) Spec=)))`},
+		{`f"""{
+  x}"""`,
+			`(FStringExpr Raw=f"""{
+  x}""" Literals=( ) Fields=((FStringField X=x Spec=)))`},
+		{`f"{}"`, `foo.star:1:3: f-string: empty expression not allowed`},
+		{`f"}"`, `foo.star:1:3: f-string: single '}' is not allowed`},
+		{`f"{x"`, `foo.star:1:3: f-string: expecting '}'`},
+		{`f"{x!a}"`, `foo.star:1:5: f-string: invalid conversion character: expected 's' or 'r'`},
+		{`f"{x:{y}}"`, `foo.star:1:6: f-string: nested replacement fields are not supported in format specifications`},
+		{`f"{x y}"`, `foo.star:1:6: got identifier, want '}'`},
+	} {
+		e, err := opts.ParseExpr("foo.star", test.input, 0)
+		var got string
+		if err != nil {
+			got = err.Error()
+		} else {
+			got = treeString(e)
+		}
+		if test.want != got {
+			t.Errorf("parse `%s` = %s, want %s", test.input, got, test.want)
+		}
+	}
+
+	// Without the option, the f prefix is an identifier.
+	if _, err := syntax.ParseExpr("foo.star", `f"{x}"`, 0); err == nil {
+		t.Errorf("f-string parsed without FStrings option")
+	}
+}
+
 func TestStmtParseTrees(t *testing.T) {
 	for _, test := range []struct {
 		input, want string
@@ -181,6 +223,8 @@ else:
 			`(DefStmt Name=f Params=(a b (BinaryExpr X=c Op== Y=d)) Body=((BranchStmt Token=pass)))`},
 		{`def f(a, b=c, d): pass`,
 			`(DefStmt Name=f Params=(a (BinaryExpr X=b Op== Y=c) d) Body=((BranchStmt Token=pass)))`}, // TODO(adonovan): fix this
+		{`def f(a: int, b, *c: str, d: list[int] = []) -> int | None: pass`,
+			`(DefStmt Name=f Params=(a b (UnaryExpr Op=* X=c) (BinaryExpr X=d Op== Y=(ListExpr))) ParamTypes=(int nil str (IndexExpr X=list Y=int)) ResultType=(BinaryExpr X=int Op=| Y=None) Body=((BranchStmt Token=pass)))`},
 		{`def f():
 	def g():
 		pass
@@ -416,6 +460,11 @@ func writeTree(out *bytes.Buffer, x reflect.Value) {
 					fmt.Fprintf(out, " %s", name)
 				}
 				continue
+			case reflect.Uint8:
+				if f.Uint() != 0 {
+					fmt.Fprintf(out, " %s=%c", name, f.Uint())
+				}
+				continue
 			}
 			fmt.Fprintf(out, " %s=", name)
 			writeTree(out, f)
@@ -485,3 +534,89 @@ func BenchmarkParse(b *testing.B) {
 		}
 	}
 }
+
+func TestRecoverErrors(t *testing.T) {
+	const src = `x = 1 +
+y = 2
+
+def f(a, b;
+    return a
+
+def g():
+    if z ==:
+        w = [1, 2 3]
+    return w
+      bad = indent
+q = "unterminated
+while x y:
+    pass
+`
+	f, err := syntax.Parse("in.star", src, syntax.RecoverErrors)
+	if f == nil {
+		t.Fatalf("Parse returned nil file (err=%v)", err)
+	}
+	list, ok := err.(syntax.ErrorList)
+	if !ok {
+		t.Fatalf("got error %v, want ErrorList", err)
+	}
+	var got []string
+	for _, err := range list {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		"in.star:1:8: got newline, want primary expression",
+		"in.star:4:12: got ';', want ','",
+		"in.star:8:12: got ':', want primary expression",
+		"in.star:9:20: got int literal, want ']'",
+		"in.star:11:7: got indent, want primary expression",
+		"in.star:12:5: unexpected newline in string",
+		"in.star:13:10: got identifier, want ':'",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Check the partial syntax tree.
+	var buf bytes.Buffer
+	for _, stmt := range f.Stmts {
+		writeTree(&buf, reflect.ValueOf(stmt))
+		buf.WriteByte('\n')
+	}
+	const wantTree = `(BadStmt)
+(AssignStmt Op== LHS=y RHS=2)
+(BadStmt)
+(DefStmt Name=g Body=((IfStmt Cond=(BadExpr) True=((BadStmt))) (ReturnStmt Result=w) (BadStmt)))
+(BadStmt)
+(BadStmt)
+`
+	if got := buf.String(); got != wantTree {
+		t.Errorf("got tree:\n%s\nwant:\n%s", got, wantTree)
+	}
+
+	// Without RecoverErrors, only the first error is reported.
+	if _, err := syntax.Parse("in.star", src, 0); err == nil || err.Error() != want[0] {
+		t.Errorf("got error %v, want %s", err, want[0])
+	}
+
+	// An inconsistent outdent after an error in a block ends the
+	// block, without a spurious error at the end of the file.
+	const src2 = "def f():\n    x = 1 +\n    y = 2\n  z = 3\nw = 4 +\n"
+	f, err = syntax.Parse("in.star", src2, syntax.RecoverErrors)
+	got = nil
+	if list, ok := err.(syntax.ErrorList); ok {
+		for _, err := range list {
+			got = append(got, err.Error())
+		}
+	}
+	want = []string{
+		"in.star:2:12: got newline, want primary expression",
+		"in.star:4:3: unindent does not match any outer indentation level",
+		"in.star:5:8: got newline, want primary expression",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if f == nil || len(f.Stmts) != 2 {
+		t.Errorf("got %v, want a def and a bad statement", f)
+	}
+}
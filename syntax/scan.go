@@ -30,11 +30,12 @@ const (
 	OUTDENT
 
 	// Tokens with values
-	IDENT  // x
-	INT    // 123
-	FLOAT  // 1.23e45
-	STRING // "foo" or 'foo' or '''foo''' or r'foo' or r"foo"
-	BYTES  // b"foo", etc
+	IDENT   // x
+	INT     // 123
+	FLOAT   // 1.23e45
+	STRING  // "foo" or 'foo' or '''foo''' or r'foo' or r"foo"
+	BYTES   // b"foo", etc
+	FSTRING // f"foo {x}" or rf"foo {x}"
 
 	// Punctuation
 	PLUS          // +
@@ -78,6 +79,7 @@ const (
 	LTLT_EQ       // <<=
 	GTGT_EQ       // >>=
 	STARSTAR      // **
+	ARROW         // ->
 
 	// Keywords
 	AND
@@ -126,7 +128,7 @@ func (tok Token) String() string { return tokenNames[tok] }
 // GoString is like String but quotes punctuation tokens.
 // Use Sprintf("%#v", tok) when constructing error messages.
 func (tok Token) GoString() string {
-	if tok >= PLUS && tok <= STARSTAR {
+	if tok >= PLUS && tok <= ARROW {
 		return "'" + tokenNames[tok] + "'"
 	}
 	return tokenNames[tok]
@@ -142,6 +144,7 @@ var tokenNames = [...]string{
 	INT:           "int literal",
 	FLOAT:         "float literal",
 	STRING:        "string literal",
+	FSTRING:       "f-string literal",
 	PLUS:          "+",
 	MINUS:         "-",
 	STAR:          "*",
@@ -183,6 +186,7 @@ var tokenNames = [...]string{
 	LTLT_EQ:       "<<=",
 	GTGT_EQ:       ">>=",
 	STARSTAR:      "**",
+	ARROW:         "->",
 	AND:           "and",
 	BREAK:         "break",
 	CONTINUE:      "continue",
@@ -290,7 +294,10 @@ type scanner struct {
 	indentstk      []int     // stack of indentation levels
 	dents          int       // number of saved INDENT (>0) or OUTDENT (<0) tokens to return
 	lineStart      bool      // after NEWLINE; convert spaces to indentation tokens
+	lineRest       []byte    // input at the start of the current line, if not REPL (for error recovery)
+	linePos        Position  // position of lineRest
 	keepComments   bool      // accumulate comments in slice
+	fstrings       bool      // recognize f-string literals
 	lineComments   []Comment // list of full line comments (if keepComments)
 	suffixComments []Comment // list of suffix comments (if keepComments)
 
@@ -319,6 +326,19 @@ func newScanner(filename string, src interface{}, keepComments bool) (*scanner,
 	return sc, nil
 }
 
+// newFieldScanner returns a scanner for the expression of a replacement
+// field of an f-string, whose text begins at pos. The scanner behaves
+// as if within parentheses, so the expression may span lines.
+func newFieldScanner(pos Position, text string) *scanner {
+	return &scanner{
+		rest:      []byte(text),
+		pos:       pos,
+		depth:     1,
+		indentstk: make([]int, 1),
+		fstrings:  true,
+	}
+}
+
 func readSource(filename string, src interface{}) ([]byte, error) {
 	switch src := src.(type) {
 	case string:
@@ -349,6 +369,12 @@ type Error struct {
 
 func (e Error) Error() string { return e.Pos.String() + ": " + e.Msg }
 
+// An ErrorList is a non-empty list of syntax errors,
+// reported by Parse in RecoverErrors mode.
+type ErrorList []Error // len > 0
+
+func (e ErrorList) Error() string { return e[0].Error() }
+
 // errorf is called to report an error.
 // errorf does not return: it panics.
 func (sc *scanner) error(pos Position, s string) {
@@ -396,6 +422,61 @@ func (sc *scanner) readLine() bool {
 	return false
 }
 
+// skipLines discards input, for recovery from a syntax error, up to
+// the start of the next line outside any brackets whose indentation
+// is no greater than that of the block at the specified level of the
+// indentation stack, which becomes the current block. If rewind, the
+// search starts at the current line, even if its indentation has been
+// read; otherwise it starts at the next line. If the scanner has
+// already left the block, as when the error was an inconsistent
+// outdent, the OUTDENT tokens that end the blocks it left are returned
+// first, so that the parser's view of the block structure is restored.
+func (sc *scanner) skipLines(level int, rewind bool) {
+	if level < len(sc.indentstk) {
+		sc.indentstk = sc.indentstk[:level]
+	}
+	indent := sc.indentstk[len(sc.indentstk)-1]
+	sc.depth = 0
+	sc.dents = len(sc.indentstk) - level // pending OUTDENTs, if any
+	if rewind && sc.lineRest != nil {
+		sc.rest, sc.pos = sc.lineRest, sc.linePos
+	} else if !sc.lineStart && sc.pos.Col > 1 {
+		sc.skipLine()
+	}
+	sc.lineStart = true
+	for !sc.eof() {
+		// Compute the indentation of the line, as nextToken does.
+		col := 0
+		i := 0
+	loop:
+		for ; i < len(sc.rest); i++ {
+			switch sc.rest[i] {
+			case ' ':
+				col++
+			case '\t':
+				const tab = 8
+				col += tab - col%tab
+			default:
+				break loop
+			}
+		}
+		blank := i == len(sc.rest) || strings.IndexByte("#\r\n", sc.rest[i]) >= 0
+		if !blank && col <= indent {
+			break
+		}
+		sc.skipLine()
+	}
+}
+
+// skipLine discards input up to the start of the next line.
+func (sc *scanner) skipLine() {
+	for !sc.eof() {
+		if sc.readRune() == '\n' {
+			break
+		}
+	}
+}
+
 // peekRune returns the next rune in the input without consuming it.
 // Newlines in Unix, DOS, or Mac format are treated as one rune, '\n'.
 func (sc *scanner) peekRune() rune {
@@ -520,6 +601,7 @@ start:
 	savedLineStart := sc.lineStart
 	if sc.lineStart {
 		sc.lineStart = false
+		sc.lineRest, sc.linePos = sc.rest, sc.pos
 		col := 0
 		for {
 			c = sc.peekRune()
@@ -694,6 +776,17 @@ start:
 			sc.readRune()
 			c = sc.peekRune()
 			return sc.scanString(val, c)
+		} else if sc.fstrings && c == 'f' && len(sc.rest) > 1 && (sc.rest[1] == '"' || sc.rest[1] == '\'') {
+			// f"..."
+			sc.readRune()
+			c = sc.peekRune()
+			return sc.scanString(val, c)
+		} else if sc.fstrings && (c == 'r' && len(sc.rest) > 2 && sc.rest[1] == 'f' || c == 'f' && len(sc.rest) > 2 && sc.rest[1] == 'r') && (sc.rest[2] == '"' || sc.rest[2] == '\'') {
+			// rf"..." or fr"..."
+			sc.readRune()
+			sc.readRune()
+			c = sc.peekRune()
+			return sc.scanString(val, c)
 		}
 
 		for isIdent(c) {
@@ -811,6 +904,10 @@ start:
 		case '+':
 			return PLUS
 		case '-':
+			if sc.peekRune() == '>' {
+				sc.readRune()
+				return ARROW
+			}
 			return MINUS
 		case '/':
 			if sc.peekRune() == '/' {
@@ -934,6 +1031,11 @@ func (sc *scanner) scanString(val *tokenValue, quote rune) Token {
 	}
 	val.raw = raw.String()
 
+	// The parser interprets the raw text of an f-string (see parseFString).
+	if prefix := val.raw[:strings.IndexAny(val.raw, `'"`)]; strings.Contains(prefix, "f") {
+		return FSTRING
+	}
+
 	s, _, isByte, err := unquote(val.raw)
 	if err != nil {
 		sc.error(start, err.Error())
@@ -90,6 +90,7 @@ type Stmt interface {
 }
 
 func (*AssignStmt) stmt() {}
+func (*BadStmt) stmt()    {}
 func (*BranchStmt) stmt() {}
 func (*DefStmt) stmt()    {}
 func (*ExprStmt) stmt()   {}
@@ -118,15 +119,35 @@ func (x *AssignStmt) Span() (start, end Position) {
 	return
 }
 
+// A BadStmt represents a statement containing a syntax error,
+// in a file parsed in RecoverErrors mode.
+type BadStmt struct {
+	commentsRef
+	From Position // start of the statement
+	To   Position // position of the error
+}
+
+func (x *BadStmt) Span() (start, end Position) {
+	return x.From, x.To
+}
+
 // A DefStmt represents a function definition.
+//
+// ParamTypes holds the optional type annotation of each parameter,
+// as in def f(x: int), or nil for a parameter without one;
+// ParamTypes is nil if no parameter has an annotation.
+// ResultType is the optional annotation of the result, as in -> int.
 type DefStmt struct {
 	commentsRef
-	Def    Position
-	Name   *Ident
-	Lparen Position
-	Params []Expr // param = ident | ident=expr | * | *ident | **ident
-	Rparen Position
-	Body   []Stmt
+	Def        Position
+	Name       *Ident
+	Lparen     Position
+	Params     []Expr // param = ident | ident=expr | * | *ident | **ident
+	ParamTypes []Expr // type annotation of each param, or nil
+	Rparen     Position
+	Arrow      Position // position of "->", if ResultType != nil
+	ResultType Expr     // may be nil
+	Body       []Stmt
 
 	Function interface{} // a *resolve.Function, set by resolver
 }
@@ -222,6 +243,7 @@ type Expr interface {
 	expr()
 }
 
+func (*BadExpr) expr()       {}
 func (*BinaryExpr) expr()    {}
 func (*CallExpr) expr()      {}
 func (*Comprehension) expr() {}
@@ -229,6 +251,7 @@ func (*CondExpr) expr()      {}
 func (*DictEntry) expr()     {}
 func (*DictExpr) expr()      {}
 func (*DotExpr) expr()       {}
+func (*FStringExpr) expr()   {}
 func (*Ident) expr()         {}
 func (*IndexExpr) expr()     {}
 func (*LambdaExpr) expr()    {}
@@ -239,6 +262,18 @@ func (*SliceExpr) expr()     {}
 func (*TupleExpr) expr()     {}
 func (*UnaryExpr) expr()     {}
 
+// A BadExpr represents an expression containing a syntax error,
+// in a file parsed in RecoverErrors mode.
+type BadExpr struct {
+	commentsRef
+	From Position // start of the expression
+	To   Position // position of the error
+}
+
+func (x *BadExpr) Span() (start, end Position) {
+	return x.From, x.To
+}
+
 // An Ident represents an identifier.
 type Ident struct {
 	commentsRef
@@ -265,6 +300,30 @@ func (x *Literal) Span() (start, end Position) {
 	return x.TokenPos, x.TokenPos.add(x.Raw)
 }
 
+// An FStringExpr represents an f-string literal such as f"{x}: {n:>5}",
+// which is permitted by the FStrings option. Its value is the
+// concatenation of its literal text and its formatted fields:
+// Literals[0] + Fields[0] + Literals[1] + ... + Literals[len(Fields)].
+type FStringExpr struct {
+	commentsRef
+	TokenPos Position
+	Raw      string          // uninterpreted text, including prefix and quotes
+	Literals []string        // literal text around the fields; len(Literals) == len(Fields)+1
+	Fields   []*FStringField // replacement fields
+}
+
+func (x *FStringExpr) Span() (start, end Position) {
+	return x.TokenPos, x.TokenPos.add(x.Raw)
+}
+
+// An FStringField is a replacement field {X!Conv:Spec} of an f-string.
+type FStringField struct {
+	Lbrace Position
+	X      Expr
+	Conv   byte   // conversion: 's' (str), 'r' (repr), or 0 (none)
+	Spec   string // format specification, or "" if none
+}
+
 // A ParenExpr represents a parenthesized expression: (X).
 type ParenExpr struct {
 	commentsRef
@@ -25,7 +25,7 @@ func Walk(n Node, f func(Node) bool) {
 	case *ExprStmt:
 		Walk(n.X, f)
 
-	case *BranchStmt:
+	case *BranchStmt, *BadStmt, *BadExpr:
 		// no-op
 
 	case *IfStmt:
@@ -39,8 +39,14 @@ func Walk(n Node, f func(Node) bool) {
 
 	case *DefStmt:
 		Walk(n.Name, f)
-		for _, param := range n.Params {
+		for i, param := range n.Params {
 			Walk(param, f)
+			if n.ParamTypes != nil && n.ParamTypes[i] != nil {
+				Walk(n.ParamTypes[i], f)
+			}
+		}
+		if n.ResultType != nil {
+			Walk(n.ResultType, f)
 		}
 		walkStmts(n.Body, f)
 
@@ -49,6 +55,10 @@ func Walk(n Node, f func(Node) bool) {
 		Walk(n.X, f)
 		walkStmts(n.Body, f)
 
+	case *WhileStmt:
+		Walk(n.Cond, f)
+		walkStmts(n.Body, f)
+
 	case *ReturnStmt:
 		if n.Result != nil {
 			Walk(n.Result, f)
@@ -66,6 +76,11 @@ func Walk(n Node, f func(Node) bool) {
 	case *Ident, *Literal:
 		// no-op
 
+	case *FStringExpr:
+		for _, field := range n.Fields {
+			Walk(field.X, f)
+		}
+
 	case *ListExpr:
 		for _, x := range n.List {
 			Walk(x, f)
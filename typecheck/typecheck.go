@@ -0,0 +1,778 @@
+// Package typecheck reports type errors in Starlark files,
+// using the type annotations of functions (see resolve.Type).
+//
+// The checker is gradual: it infers the types of expressions from
+// literals, operators, annotations, calls of annotated functions and
+// of built-in functions, and the values assigned to variables, and
+// gives the type any to everything else, such as predeclared names,
+// attributes, and unannotated parameters. A value of type any is
+// compatible with every type, and a value of a union type, such as
+// that of a variable assigned values of several types, is compatible
+// with a type that accepts any of its alternatives, as the checker
+// does not follow the flow of control. So the checker reports only
+// errors that the program would certainly encounter if it reached them:
+//
+//   - an argument to an annotated parameter, or a parameter's
+//     default value, whose type is not accepted by the annotation;
+//   - a returned value whose type is not accepted by the annotation
+//     of the function's result;
+//   - an arithmetic operation on operands of built-in types that
+//     do not support it, such as "a" + 1.
+//
+// The checker works on a syntax tree that has been resolved
+// by resolve.File, whose errors it does not repeat.
+package typecheck // import "go.starlark.net/typecheck"
+
+import (
+	"fmt"
+	"sort"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/syntax"
+)
+
+// An ErrorList is a non-empty list of type errors.
+type ErrorList []Error // len > 0
+
+func (e ErrorList) Error() string { return e[0].Error() }
+
+// An Error describes the nature and position of a type error.
+type Error struct {
+	Pos syntax.Position
+	Msg string
+}
+
+func (e Error) Error() string { return e.Pos.String() + ": " + e.Msg }
+
+// File checks the types of a resolved file.
+// If it finds errors, it returns an ErrorList.
+func File(file *syntax.File) error {
+	c := &checker{
+		assigns:  make(map[*syntax.Ident][]assignment),
+		defs:     make(map[*syntax.Ident]*syntax.DefStmt),
+		params:   make(map[*syntax.Ident]*resolve.Type),
+		varTypes: make(map[*syntax.Ident]*resolve.Type),
+		exprs:    make(map[syntax.Expr]*resolve.Type),
+	}
+	c.collect(file)
+	c.check(file)
+	if len(c.errors) > 0 {
+		sort.SliceStable(c.errors, func(i, j int) bool {
+			x, y := c.errors[i].Pos, c.errors[j].Pos
+			return x.Line < y.Line || x.Line == y.Line && x.Col < y.Col
+		})
+		return c.errors
+	}
+	return nil
+}
+
+type checker struct {
+	errors   ErrorList
+	assigns  map[*syntax.Ident][]assignment    // assignments to each variable, by first binding
+	defs     map[*syntax.Ident]*syntax.DefStmt // function definitions, by name
+	params   map[*syntax.Ident]*resolve.Type   // types of parameters, by name
+	varTypes map[*syntax.Ident]*resolve.Type   // inferred types of variables (nil while in progress)
+	exprs    map[syntax.Expr]*resolve.Type     // memoized types of expressions
+	stack    []syntax.Node                     // enclosing nodes, during check
+}
+
+// An assignment records a value assigned to a variable.
+type assignment struct {
+	rhs  syntax.Expr // the value, or nil if unknown
+	elem bool        // the value is an element of rhs, as in a for loop
+}
+
+func (c *checker) errorf(pos syntax.Position, format string, args ...interface{}) {
+	c.errors = append(c.errors, Error{pos, fmt.Sprintf(format, args...)})
+}
+
+// first returns the identifier of the first binding of the variable
+// denoted by id, or nil if id is not a variable of the file.
+func first(id *syntax.Ident) *syntax.Ident {
+	if bind, ok := id.Binding.(*resolve.Binding); ok {
+		return bind.First
+	}
+	return nil
+}
+
+// -- collection of assignments --
+
+// collect records the assignments, definitions, and parameters of a file.
+func (c *checker) collect(file *syntax.File) {
+	syntax.Walk(file, func(n syntax.Node) bool {
+		switch n := n.(type) {
+		case *syntax.AssignStmt:
+			if n.Op == syntax.EQ {
+				c.assign(n.LHS, n.RHS, false)
+			} else {
+				c.assign(n.LHS, nil, false) // augmented assignment
+			}
+		case *syntax.DefStmt:
+			c.defs[n.Name] = n
+			c.assign(n.Name, nil, false)
+			if fn, ok := n.Function.(*resolve.Function); ok {
+				c.collectParams(fn)
+			}
+		case *syntax.LambdaExpr:
+			if fn, ok := n.Function.(*resolve.Function); ok {
+				c.collectParams(fn)
+			}
+		case *syntax.ForStmt:
+			c.assign(n.Vars, n.X, true)
+		case *syntax.ForClause:
+			c.assign(n.Vars, n.X, true)
+		case *syntax.LoadStmt:
+			for _, id := range n.To {
+				c.assign(id, nil, false)
+			}
+		}
+		return true
+	})
+}
+
+// assign records the assignment of rhs (or an element of it) to lhs.
+func (c *checker) assign(lhs, rhs syntax.Expr, elem bool) {
+	switch lhs := lhs.(type) {
+	case *syntax.Ident:
+		if id := first(lhs); id != nil {
+			c.assigns[id] = append(c.assigns[id], assignment{rhs, elem})
+		}
+	case *syntax.ParenExpr:
+		c.assign(lhs.X, rhs, elem)
+	case *syntax.ListExpr:
+		c.assignElems(lhs.List, rhs, elem)
+	case *syntax.TupleExpr:
+		c.assignElems(lhs.List, rhs, elem)
+	}
+}
+
+func (c *checker) assignElems(lhs []syntax.Expr, rhs syntax.Expr, elem bool) {
+	if tuple, ok := rhs.(*syntax.TupleExpr); ok && !elem && len(tuple.List) == len(lhs) {
+		for i := range lhs {
+			c.assign(lhs[i], tuple.List[i], false)
+		}
+		return
+	}
+	for _, x := range lhs {
+		c.assign(x, nil, false)
+	}
+}
+
+// collectParams records the types of the parameters of a function.
+func (c *checker) collectParams(fn *resolve.Function) {
+	for _, p := range parameters(fn) {
+		t := p.typ
+		switch {
+		case p.kind == varargs:
+			t = &resolve.Type{Name: "tuple"}
+		case p.kind == kwargs && t != nil:
+			t = &resolve.Type{Name: "dict", Args: []*resolve.Type{{Name: "string"}, t}}
+		case p.kind == kwargs:
+			t = &resolve.Type{Name: "dict"}
+		case t == nil:
+			t = resolve.Any
+		}
+		c.params[p.name] = t
+	}
+}
+
+type paramKind int
+
+const (
+	positional paramKind = iota // may be passed by position or name
+	kwonly                      // keyword-only
+	varargs                     // *args
+	kwargs                      // **kwargs
+)
+
+type param struct {
+	name *syntax.Ident
+	dflt syntax.Expr   // default value, or nil
+	typ  *resolve.Type // annotated type, or nil
+	kind paramKind
+}
+
+// parameters returns the parameters of a function, in order of declaration.
+func parameters(fn *resolve.Function) []param {
+	var params []param
+	var star, starStar *param
+	seenStar := false
+	for _, p := range fn.Params {
+		switch p := p.(type) {
+		case *syntax.Ident:
+			params = append(params, param{name: p, kind: kind(seenStar)})
+		case *syntax.BinaryExpr:
+			params = append(params, param{name: p.X.(*syntax.Ident), dflt: p.Y, kind: kind(seenStar)})
+		case *syntax.UnaryExpr:
+			if p.Op == syntax.STARSTAR {
+				starStar = &param{name: p.X.(*syntax.Ident), kind: kwargs}
+			} else {
+				seenStar = true
+				if p.X != nil {
+					star = &param{name: p.X.(*syntax.Ident), kind: varargs}
+				}
+			}
+		}
+	}
+	// The types are in the order of Locals, with *args and **kwargs last.
+	if star != nil {
+		params = append(params, *star)
+	}
+	if starStar != nil {
+		params = append(params, *starStar)
+	}
+	if fn.ParamTypes != nil {
+		for i := range params {
+			params[i].typ = fn.ParamTypes[i]
+		}
+	}
+	return params
+}
+
+func kind(seenStar bool) paramKind {
+	if seenStar {
+		return kwonly
+	}
+	return positional
+}
+
+// -- inference --
+
+// varType returns the type of the variable whose first binding is id.
+func (c *checker) varType(id *syntax.Ident) *resolve.Type {
+	if t, ok := c.params[id]; ok {
+		return t
+	}
+	if t, ok := c.varTypes[id]; ok {
+		if t == nil {
+			return resolve.Any // a cycle, as in x = x + 1
+		}
+		return t
+	}
+	c.varTypes[id] = nil
+	var t *resolve.Type
+	for _, a := range c.assigns[id] {
+		var u *resolve.Type
+		switch {
+		case c.defs[id] != nil && a.rhs == nil:
+			u = &resolve.Type{Name: "function"}
+		case a.rhs == nil:
+			u = resolve.Any
+		case a.elem:
+			u = elemType(c.typeOf(a.rhs))
+		default:
+			u = c.typeOf(a.rhs)
+		}
+		if t == nil {
+			t = u
+		} else {
+			t = resolve.Union(t, u)
+		}
+	}
+	if t == nil {
+		t = resolve.Any
+	}
+	c.varTypes[id] = t
+	return t
+}
+
+// function returns the definition of the function called by fn,
+// if it is a variable bound only by a def statement.
+func (c *checker) function(fn syntax.Expr) *syntax.DefStmt {
+	id, ok := fn.(*syntax.Ident)
+	if !ok {
+		return nil
+	}
+	id = first(id)
+	if id == nil || len(c.assigns[id]) != 1 {
+		return nil
+	}
+	return c.defs[id]
+}
+
+// typeOf returns the type of an expression.
+func (c *checker) typeOf(e syntax.Expr) *resolve.Type {
+	if t, ok := c.exprs[e]; ok {
+		return t
+	}
+	t := c.infer(e)
+	c.exprs[e] = t
+	return t
+}
+
+func (c *checker) infer(e syntax.Expr) *resolve.Type {
+	switch e := e.(type) {
+	case *syntax.Literal:
+		switch e.Token {
+		case syntax.INT:
+			return named("int")
+		case syntax.FLOAT:
+			return named("float")
+		case syntax.STRING:
+			return named("string")
+		case syntax.BYTES:
+			return named("bytes")
+		}
+
+	case *syntax.Ident:
+		bind, ok := e.Binding.(*resolve.Binding)
+		if !ok {
+			break
+		}
+		switch bind.Scope {
+		case resolve.Universal:
+			switch e.Name {
+			case "None":
+				return named("NoneType")
+			case "True", "False":
+				return named("bool")
+			}
+			if _, ok := builtins[e.Name]; ok {
+				return named("builtin_function_or_method")
+			}
+		case resolve.Local, resolve.Cell, resolve.Free, resolve.Global:
+			if bind.First != nil {
+				return c.varType(bind.First)
+			}
+		}
+
+	case *syntax.FStringExpr:
+		return named("string")
+
+	case *syntax.ParenExpr:
+		return c.typeOf(e.X)
+
+	case *syntax.ListExpr:
+		return container("list", c.join(e.List))
+
+	case *syntax.TupleExpr:
+		t := &resolve.Type{Name: "tuple"}
+		for _, x := range e.List {
+			t.Args = append(t.Args, c.typeOf(x))
+		}
+		return t
+
+	case *syntax.DictExpr:
+		var keys, values []syntax.Expr
+		for _, entry := range e.List {
+			entry := entry.(*syntax.DictEntry)
+			keys = append(keys, entry.Key)
+			values = append(values, entry.Value)
+		}
+		k, v := c.join(keys), c.join(values)
+		if k == nil || v == nil {
+			return named("dict")
+		}
+		return &resolve.Type{Name: "dict", Args: []*resolve.Type{k, v}}
+
+	case *syntax.Comprehension:
+		if e.Curly {
+			return named("dict")
+		}
+		return named("list")
+
+	case *syntax.CondExpr:
+		return resolve.Union(c.typeOf(e.True), c.typeOf(e.False))
+
+	case *syntax.LambdaExpr:
+		return named("function")
+
+	case *syntax.UnaryExpr:
+		x := c.typeOf(e.X)
+		switch e.Op {
+		case syntax.NOT:
+			return named("bool")
+		case syntax.MINUS, syntax.PLUS:
+			if numeric(x) {
+				return x
+			}
+		case syntax.TILDE:
+			if x.Name == "int" {
+				return x
+			}
+		}
+
+	case *syntax.BinaryExpr:
+		return c.binary(e)
+
+	case *syntax.IndexExpr:
+		x := c.typeOf(e.X)
+		switch x.Name {
+		case "string", "bytes":
+			return named(x.Name)
+		case "list":
+			if len(x.Args) == 1 {
+				return x.Args[0]
+			}
+		case "dict":
+			if len(x.Args) == 2 {
+				return x.Args[1]
+			}
+		case "tuple":
+			if lit, ok := e.Y.(*syntax.Literal); ok && lit.Token == syntax.INT && len(x.Args) > 0 {
+				if i, ok := lit.Value.(int64); ok && i >= 0 && i < int64(len(x.Args)) {
+					return x.Args[i]
+				}
+			}
+		}
+
+	case *syntax.SliceExpr:
+		x := c.typeOf(e.X)
+		switch x.Name {
+		case "string", "bytes", "tuple":
+			return named(x.Name)
+		case "list":
+			return x
+		}
+
+	case *syntax.CallExpr:
+		if def := c.function(e.Fn); def != nil {
+			if fn, ok := def.Function.(*resolve.Function); ok && fn.ResultType != nil {
+				return fn.ResultType
+			}
+			break
+		}
+		if id, ok := e.Fn.(*syntax.Ident); ok {
+			if bind, ok := id.Binding.(*resolve.Binding); ok && bind.Scope == resolve.Universal {
+				if name := builtins[id.Name]; name != "" {
+					return named(name)
+				}
+			}
+		}
+	}
+	return resolve.Any
+}
+
+// join returns the union of the types of a non-empty list of
+// expressions, or nil if the list is empty or the union is any.
+func (c *checker) join(list []syntax.Expr) *resolve.Type {
+	var t *resolve.Type
+	for _, x := range list {
+		u := c.typeOf(x)
+		if t == nil {
+			t = u
+		} else {
+			t = resolve.Union(t, u)
+		}
+	}
+	if t != nil && t.Name == "any" {
+		return nil
+	}
+	return t
+}
+
+// binary returns the type of a binary operation.
+func (c *checker) binary(e *syntax.BinaryExpr) *resolve.Type {
+	x, y := c.typeOf(e.X), c.typeOf(e.Y)
+	switch e.Op {
+	case syntax.EQL, syntax.NEQ, syntax.LT, syntax.GT, syntax.LE, syntax.GE, syntax.IN, syntax.NOT_IN:
+		return named("bool")
+	case syntax.AND, syntax.OR:
+		return resolve.Union(x, y)
+	case syntax.PLUS:
+		switch {
+		case numeric(x) && numeric(y):
+			return arith(x, y)
+		case x.Name == y.Name && (x.Name == "string" || x.Name == "bytes"):
+			return named(x.Name)
+		case x.Name == "list" && y.Name == "list":
+			if x.Accepts(y) && y.Accepts(x) && len(x.Args) > 0 && len(y.Args) > 0 {
+				return x
+			}
+			return named("list")
+		case x.Name == "tuple" && y.Name == "tuple":
+			if len(x.Args) > 0 && len(y.Args) > 0 {
+				args := append(append([]*resolve.Type(nil), x.Args...), y.Args...)
+				return &resolve.Type{Name: "tuple", Args: args}
+			}
+			return named("tuple")
+		}
+	case syntax.MINUS:
+		if numeric(x) && numeric(y) {
+			return arith(x, y)
+		}
+	case syntax.STAR:
+		switch {
+		case numeric(x) && numeric(y):
+			return arith(x, y)
+		case x.Name == "int" && repeatable(y):
+			return named(y.Name)
+		case repeatable(x) && y.Name == "int":
+			return named(x.Name)
+		}
+	case syntax.SLASH:
+		if numeric(x) && numeric(y) {
+			return named("float")
+		}
+	case syntax.SLASHSLASH, syntax.PERCENT:
+		if numeric(x) && numeric(y) {
+			return arith(x, y)
+		}
+		if e.Op == syntax.PERCENT && x.Name == "string" {
+			return x
+		}
+		return resolve.Any
+	case syntax.PIPE:
+		if x.Name == y.Name && (x.Name == "int" || x.Name == "dict") {
+			return named(x.Name)
+		}
+		return resolve.Any
+	default: // & ^ << >>
+		if x.Name == "int" && y.Name == "int" {
+			return x
+		}
+		return resolve.Any
+	}
+
+	// The operation is not defined for these types.
+	if builtin(x) && builtin(y) {
+		c.errorf(e.OpPos, "unknown binary op: %s %s %s", x, e.Op, y)
+	}
+	return resolve.Any
+}
+
+func named(name string) *resolve.Type { return &resolve.Type{Name: name} }
+
+// container returns the type of a container with elements of type elem.
+func container(name string, elem *resolve.Type) *resolve.Type {
+	if elem == nil {
+		return named(name)
+	}
+	return &resolve.Type{Name: name, Args: []*resolve.Type{elem}}
+}
+
+func numeric(t *resolve.Type) bool { return t.Name == "int" || t.Name == "float" }
+
+func repeatable(t *resolve.Type) bool {
+	switch t.Name {
+	case "string", "bytes", "list", "tuple":
+		return true
+	}
+	return false
+}
+
+// arith returns the type of an arithmetic operation on numbers.
+func arith(x, y *resolve.Type) *resolve.Type {
+	if x.Name == "int" && y.Name == "int" {
+		return x
+	}
+	return named("float")
+}
+
+// builtin reports whether t is a built-in type whose operators
+// are known to the checker.
+func builtin(t *resolve.Type) bool {
+	switch t.Name {
+	case "int", "float", "string", "bytes", "bool", "NoneType", "list", "tuple", "dict":
+		return true
+	}
+	return false
+}
+
+// elemType returns the type of the elements of an iterable value of type t.
+func elemType(t *resolve.Type) *resolve.Type {
+	switch t.Name {
+	case "list", "set", "dict":
+		if len(t.Args) > 0 {
+			return t.Args[0]
+		}
+	case "range":
+		return named("int")
+	case "tuple":
+		if len(t.Args) > 0 {
+			u := t.Args[0]
+			for _, arg := range t.Args[1:] {
+				u = resolve.Union(u, arg)
+			}
+			return u
+		}
+	}
+	return resolve.Any
+}
+
+// builtins records the result types of the built-in functions
+// of the Starlark universe, or "" if it is not known.
+var builtins = map[string]string{
+	"abs":       "",
+	"any":       "bool",
+	"all":       "bool",
+	"bool":      "bool",
+	"bytes":     "bytes",
+	"chr":       "string",
+	"dict":      "dict",
+	"dir":       "list",
+	"enumerate": "list",
+	"fail":      "",
+	"float":     "float",
+	"getattr":   "",
+	"hasattr":   "bool",
+	"hash":      "int",
+	"int":       "int",
+	"len":       "int",
+	"list":      "list",
+	"max":       "",
+	"min":       "",
+	"ord":       "int",
+	"print":     "NoneType",
+	"range":     "range",
+	"repr":      "string",
+	"reversed":  "list",
+	"set":       "set",
+	"sorted":    "list",
+	"str":       "string",
+	"tuple":     "tuple",
+	"type":      "string",
+	"zip":       "list",
+}
+
+// -- checking --
+
+// check reports the type errors of a file.
+func (c *checker) check(file *syntax.File) {
+	syntax.Walk(file, func(n syntax.Node) bool {
+		if n == nil {
+			c.stack = c.stack[:len(c.stack)-1]
+			return true
+		}
+		c.stack = append(c.stack, n)
+		switch n := n.(type) {
+		case *syntax.DefStmt:
+			c.checkDefaults(n)
+		case *syntax.CallExpr:
+			c.checkCall(n)
+		case *syntax.ReturnStmt:
+			c.checkReturn(n)
+		case *syntax.BinaryExpr:
+			c.typeOf(n) // reports unknown operations
+		case *syntax.AssignStmt:
+			// An augmented assignment x op= y is checked like x op y,
+			// except that list += accepts any iterable.
+			if n.Op != syntax.EQ && !(n.Op == syntax.PLUS_EQ && c.typeOf(n.LHS).Name == "list") {
+				c.binary(&syntax.BinaryExpr{X: n.LHS, OpPos: n.OpPos, Op: n.Op - syntax.PLUS_EQ + syntax.PLUS, Y: n.RHS})
+			}
+		}
+		return true
+	})
+}
+
+// checkDefaults checks the default values of the parameters of a function.
+func (c *checker) checkDefaults(def *syntax.DefStmt) {
+	fn, ok := def.Function.(*resolve.Function)
+	if !ok {
+		return
+	}
+	for _, p := range parameters(fn) {
+		if p.dflt != nil && p.typ != nil {
+			if t := c.typeOf(p.dflt); !mayAccept(p.typ, t) {
+				start, _ := p.dflt.Span()
+				c.errorf(start, "function %s: for parameter %s: got %s, want %s", fn.Name, p.name.Name, t, p.typ)
+			}
+		}
+	}
+}
+
+// checkCall checks the arguments of a call of an annotated function.
+func (c *checker) checkCall(call *syntax.CallExpr) {
+	def := c.function(call.Fn)
+	if def == nil || def.ParamTypes == nil {
+		return
+	}
+	fn, ok := def.Function.(*resolve.Function)
+	if !ok {
+		return
+	}
+	params := parameters(fn)
+	var star, starStar *param
+	byName := make(map[string]*param)
+	var positionals []*param
+	for i := range params {
+		p := &params[i]
+		switch p.kind {
+		case positional:
+			positionals = append(positionals, p)
+			byName[p.name.Name] = p
+		case kwonly:
+			byName[p.name.Name] = p
+		case varargs:
+			star = p
+		case kwargs:
+			starStar = p
+		}
+	}
+
+	check := func(p *param, arg syntax.Expr) {
+		if p == nil || p.typ == nil {
+			return
+		}
+		if t := c.typeOf(arg); !mayAccept(p.typ, t) {
+			start, _ := arg.Span()
+			c.errorf(start, "function %s: for parameter %s: got %s, want %s", fn.Name, p.name.Name, t, p.typ)
+		}
+	}
+	i := 0 // index of positional argument
+	for _, arg := range call.Args {
+		switch arg := arg.(type) {
+		case *syntax.UnaryExpr: // *args or **kwargs
+			if arg.Op == syntax.STAR {
+				i = -1 // positions of subsequent arguments are unknown
+			}
+		case *syntax.BinaryExpr: // name=value
+			name := arg.X.(*syntax.Ident).Name
+			if p, ok := byName[name]; ok {
+				check(p, arg.Y)
+			} else {
+				check(starStar, arg.Y)
+			}
+		default:
+			if i < 0 {
+				continue
+			} else if i < len(positionals) {
+				check(positionals[i], arg)
+			} else {
+				check(star, arg)
+			}
+			i++
+		}
+	}
+}
+
+// checkReturn checks the result of a return statement
+// in a function with an annotated result.
+func (c *checker) checkReturn(ret *syntax.ReturnStmt) {
+	var fn *resolve.Function
+	for i := len(c.stack) - 1; i >= 0 && fn == nil; i-- {
+		switch n := c.stack[i].(type) {
+		case *syntax.DefStmt:
+			fn, _ = n.Function.(*resolve.Function)
+		case *syntax.LambdaExpr:
+			return
+		}
+	}
+	if fn == nil || fn.ResultType == nil {
+		return
+	}
+	t, pos := named("NoneType"), ret.Return
+	if ret.Result != nil {
+		t = c.typeOf(ret.Result)
+		pos, _ = ret.Result.Span()
+	}
+	if !mayAccept(fn.ResultType, t) {
+		c.errorf(pos, "function %s returns %s, want %s", fn.Name, t, fn.ResultType)
+	}
+}
+
+// mayAccept reports whether a variable of type t may hold a value of
+// type u, that is, whether t accepts u or, if u is a union, any of its
+// alternatives. The checker does not know which alternative of a union
+// a value has, for example after a reassignment or a check for None.
+func mayAccept(t, u *resolve.Type) bool {
+	if u.Name == "|" {
+		for _, alt := range u.Args {
+			if mayAccept(t, alt) {
+				return true
+			}
+		}
+		return false
+	}
+	return t.Accepts(u)
+}
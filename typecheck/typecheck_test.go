@@ -0,0 +1,42 @@
+package typecheck_test
+
+import (
+	"strings"
+	"testing"
+
+	"go.starlark.net/internal/chunkedfile"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarktest"
+	"go.starlark.net/syntax"
+	"go.starlark.net/typecheck"
+)
+
+func TestTypecheck(t *testing.T) {
+	filename := starlarktest.DataFile("typecheck", "testdata/typecheck.star")
+	for _, chunk := range chunkedfile.Read(filename, t) {
+		// A chunk may set options by containing e.g. "option:globalreassign".
+		opts := &syntax.FileOptions{
+			GlobalReassign:  strings.Contains(chunk.Source, "option:globalreassign"),
+			TopLevelControl: strings.Contains(chunk.Source, "option:toplevelcontrol"),
+		}
+		f, err := opts.Parse(filename, chunk.Source, 0)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if err := resolve.File(f, isPredeclared, starlark.Universe.Has); err != nil {
+			for _, err := range err.(resolve.ErrorList) {
+				chunk.GotError(int(err.Pos.Line), err.Msg)
+			}
+		}
+		if err := typecheck.File(f); err != nil {
+			for _, err := range err.(typecheck.ErrorList) {
+				chunk.GotError(int(err.Pos.Line), err.Msg)
+			}
+		}
+		chunk.Done()
+	}
+}
+
+func isPredeclared(name string) bool { return name == "unknown" }